@@ -1,5 +1,7 @@
 package dtos
 
+import "encoding/json"
+
 type PaginationParams struct {
 	Page  int `query:"page"`
 	Limit int `query:"limit"`
@@ -11,16 +13,47 @@ type SearchParams struct {
 	Limit int    `query:"limit"`
 }
 
+// ListOptions controls ordering, filtering and the fields returned by
+// directory listings and searches. Empty values keep the defaults.
+type ListOptions struct {
+	Sort           string `query:"sort"`
+	Order          string `query:"order"`
+	Type           string `query:"type"`
+	Mime           string `query:"mime"`
+	Ext            string `query:"ext"`
+	MinSize        *int64 `query:"min_size"`
+	MaxSize        *int64 `query:"max_size"`
+	ModifiedAfter  string `query:"modified_after"`
+	ModifiedBefore string `query:"modified_before"`
+	Fields         string `query:"fields"`
+	Cursor         string `query:"cursor"`
+
+	// FolderSizes replaces the size of folders with the total size of
+	// their contents and fills in their file and folder counts. Sorting
+	// and size filters still use the size of the folder entry itself.
+	FolderSizes bool `query:"folder_sizes"`
+}
+
 type FileSystemItem struct {
-	ID         string  `json:"id"`
-	Name       string  `json:"name"`
-	Path       string  `json:"path"`
-	Size       int64   `json:"size"`
-	IsDir      bool    `json:"is_dir"`
-	CreatedAt  *int64  `json:"created_at,omitempty"`
-	ModifiedAt *int64  `json:"modified_at,omitempty"`
-	MimeType   *string `json:"mime_type,omitempty"`
-	Etag       string  `json:"etag"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Size       int64    `json:"size"`
+	IsDir      bool     `json:"is_dir"`
+	CreatedAt  *int64   `json:"created_at,omitempty"`
+	ModifiedAt *int64   `json:"modified_at,omitempty"`
+	MimeType   *string  `json:"mime_type,omitempty"`
+	Etag       string   `json:"etag"`
+	Tags       []string `json:"tags,omitempty"`
+
+	// ContentMismatch flags files whose content contradicts their
+	// extension. MimeType then reflects the content.
+	ContentMismatch bool `json:"content_mismatch,omitempty"`
+
+	// FileCount and FolderCount are only set on folders when folder sizes
+	// were requested.
+	FileCount   *int64 `json:"file_count,omitempty"`
+	FolderCount *int64 `json:"folder_count,omitempty"`
 }
 
 type PaginatedItems struct {
@@ -31,13 +64,285 @@ type PaginatedItems struct {
 	TotalPages int32            `json:"total_pages"`
 	HasNext    bool             `json:"has_next"`
 	HasPrev    bool             `json:"has_prev"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+
+	// Fields, when set, limits each item to the named JSON fields.
+	Fields []string `json:"-"`
+}
+
+func (p PaginatedItems) MarshalJSON() ([]byte, error) {
+	type plain PaginatedItems
+	if len(p.Fields) == 0 {
+		return json.Marshal(plain(p))
+	}
+
+	items := make([]map[string]json.RawMessage, 0, len(p.Items))
+	for _, item := range p.Items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return nil, err
+		}
+		selected := make(map[string]json.RawMessage, len(p.Fields))
+		for _, field := range p.Fields {
+			if value, ok := all[field]; ok {
+				selected[field] = value
+			}
+		}
+		items = append(items, selected)
+	}
+
+	return json.Marshal(struct {
+		plain
+		Items []map[string]json.RawMessage `json:"items"`
+	}{plain(p), items})
+}
+
+// ContentSearchHit is a file whose content matched a full-text search.
+// Snippets are HTML-escaped excerpts with the matched words in <mark> tags.
+type ContentSearchHit struct {
+	FileSystemItem
+	Score    float64  `json:"score"`
+	Snippets []string `json:"snippets"`
+}
+
+type ContentSearchResults struct {
+	Items      []ContentSearchHit `json:"items"`
+	Query      string             `json:"query"`
+	Total      int32              `json:"total"`
+	Page       int32              `json:"page"`
+	Limit      int32              `json:"limit"`
+	TotalPages int32              `json:"total_pages"`
+	HasNext    bool               `json:"has_next"`
+	HasPrev    bool               `json:"has_prev"`
+}
+
+// CategoryUsage is the storage taken by one kind of file, such as images
+// or documents.
+type CategoryUsage struct {
+	Category string `json:"category"`
+	Size     int64  `json:"size"`
+	Files    int64  `json:"files"`
+}
+
+type FolderStats struct {
+	Path       string           `json:"path"`
+	Size       int64            `json:"size"`
+	Files      int64            `json:"files"`
+	Folders    int64            `json:"folders"`
+	Categories []CategoryUsage  `json:"categories"`
+	Largest    []FileSystemItem `json:"largest"`
+}
+
+// TreeOptions bound how much of a folder tree is returned.
+type TreeOptions struct {
+	Depth    int  `query:"depth"`
+	DirsOnly bool `query:"dirs_only"`
+	MaxNodes int  `query:"max_nodes"`
+}
+
+// TreeNode is a file or folder in a folder tree. FolderCount and FileCount
+// count the direct children of a folder whether or not they are included;
+// Expanded tells whether Children holds all of them.
+type TreeNode struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Path        string     `json:"path"`
+	IsDir       bool       `json:"is_dir"`
+	Size        int64      `json:"size"`
+	ModifiedAt  *int64     `json:"modified_at,omitempty"`
+	FolderCount int        `json:"folder_count,omitempty"`
+	FileCount   int        `json:"file_count,omitempty"`
+	Expanded    bool       `json:"expanded,omitempty"`
+	Children    []TreeNode `json:"children,omitempty"`
+}
+
+type FolderTree struct {
+	Root  TreeNode `json:"root"`
+	Nodes int      `json:"nodes"`
+	// Truncated is set when folders within the depth limit were left
+	// unexpanded because the node budget ran out.
+	Truncated bool `json:"truncated"`
+}
+
+// DiffOptions select the two sides of a diff. Each side is a file, or a
+// kept version of it when the version is set; B defaults to the live A.
+type DiffOptions struct {
+	A        string `query:"a"`
+	B        string `query:"b"`
+	AVersion int    `query:"a_version"`
+	BVersion int    `query:"b_version"`
+	Context  *int   `query:"context"`
+}
+
+type DiffSide struct {
+	Path    string `json:"path"`
+	Version int    `json:"version,omitempty"`
+	Size    int64  `json:"size"`
+}
+
+// DiffLine is a line of a hunk. Op is "context", "delete" or "add"; Text
+// has no line ending.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffHunk is a hunk of a unified diff. Starts are 1-based line numbers.
+type DiffHunk struct {
+	OldStart int        `json:"old_start"`
+	OldLines int        `json:"old_lines"`
+	NewStart int        `json:"new_start"`
+	NewLines int        `json:"new_lines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// FileDiff compares two text files. Binary files are only reported as
+// such.
+type FileDiff struct {
+	A         DiffSide   `json:"a"`
+	B         DiffSide   `json:"b"`
+	Identical bool       `json:"identical"`
+	Binary    bool       `json:"binary"`
+	Unified   string     `json:"unified"`
+	Hunks     []DiffHunk `json:"hunks"`
+}
+
+// FileVersion is an earlier version of a text file kept by the server.
+type FileVersion struct {
+	Version int   `json:"version"`
+	Size    int64 `json:"size"`
+	SavedAt int64 `json:"saved_at"`
+}
+
+// PreviewOptions select the lines of a file to preview. Without a range,
+// Markdown is rendered whole; Raw shows any file as source, unformatted.
+type PreviewOptions struct {
+	StartLine int  `query:"start_line"`
+	Lines     int  `query:"lines"`
+	Raw       bool `query:"raw"`
+}
+
+// PatchOptions place a partial write: at Offset, overwriting the bytes
+// there and extending the file past its end, or at the end with Append.
+type PatchOptions struct {
+	Offset *int64 `query:"offset"`
+	Append bool   `query:"append"`
+}
+
+// FilePreview is a text file rendered as HTML that is safe to insert into a
+// page. Kind is "markdown" for rendered Markdown and "code" for
+// highlighted lines, which are numbered from StartLine to EndLine of
+// TotalLines. Formatted tells whether JSON, YAML or TOML was pretty-printed
+// first; line numbers then refer to the formatted text.
+type FilePreview struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Kind       string `json:"kind"`
+	Language   string `json:"language"`
+	HTML       string `json:"html"`
+	Formatted  bool   `json:"formatted"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	TotalLines int    `json:"total_lines"`
+	HasMore    bool   `json:"has_more"`
+	Notice     string `json:"notice,omitempty"`
+}
+
+// ImageOptions describe a transformation of an image. Crop is
+// "x,y,width,height" in pixels of the upright source; Rotate turns the
+// image clockwise by a multiple of 90 degrees.
+type ImageOptions struct {
+	Width   int    `query:"w"`
+	Height  int    `query:"h"`
+	Fit     string `query:"fit"`
+	Format  string `query:"format"`
+	Quality int    `query:"q"`
+	Rotate  int    `query:"rotate"`
+	Crop    string `query:"crop"`
+}
+
+// TransformedImage describes the result of an image transformation.
+type TransformedImage struct {
+	ContentType string `json:"content_type"`
+	Etag        string `json:"etag"`
+	Size        int64  `json:"size"`
+	Cached      bool   `json:"cached"`
+}
+
+// LockRequest asks for a lock on a file or folder. Depth is "0" for the
+// item alone or "infinity" for everything below it as well; Timeout is in
+// seconds.
+type LockRequest struct {
+	Scope   string `json:"scope"`
+	Depth   string `json:"depth"`
+	Owner   string `json:"owner"`
+	Timeout int    `json:"timeout"`
+}
+
+// FileLock is a lock held on a file or folder. The token is only shown to
+// whoever took the lock.
+type FileLock struct {
+	Token     string `json:"token,omitempty"`
+	Path      string `json:"path"`
+	Scope     string `json:"scope"`
+	Depth     string `json:"depth"`
+	Owner     string `json:"owner,omitempty"`
+	Timeout   int64  `json:"timeout"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type StarredItem struct {
+	FileSystemItem
+	StarredAt int64 `json:"starred_at"`
+}
+
+type RecentItem struct {
+	FileSystemItem
+	Action string `json:"action"`
+	UsedAt int64  `json:"used_at"`
+}
+
+type TagsRequest struct {
+	Tags []string `json:"tags"`
 }
 
 type ErrorResponse struct {
-	Error     string  `json:"error"`
-	Timestamp string  `json:"timestamp"`
-	RequestID string  `json:"request_id"`
-	Debug     *string `json:"debug,omitempty"`
+	Error     string      `json:"error"`
+	Timestamp string      `json:"timestamp"`
+	RequestID string      `json:"request_id"`
+	Debug     *string     `json:"debug,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+
+	// Status is the HTTP status the error calls for, when it is more
+	// specific than the one its route uses for failures.
+	Status int `json:"-"`
+}
+
+// EditConflict details an edit that could not be merged with the changes
+// made since the version it was based on. Hunks cover the whole file in
+// order; when the base version is no longer known there are none.
+type EditConflict struct {
+	CurrentEtag string      `json:"current_etag"`
+	Hunks       []MergeHunk `json:"hunks,omitempty"`
+}
+
+// MergeHunk is a run of lines of a three-way merge. Lines holds the merged
+// lines unless Conflict is set; Base, Current and Submitted hold each
+// version's lines where they differ. Starts are 0-based line numbers.
+type MergeHunk struct {
+	Conflict       bool     `json:"conflict"`
+	Lines          []string `json:"lines,omitempty"`
+	Base           []string `json:"base,omitempty"`
+	Current        []string `json:"current,omitempty"`
+	Submitted      []string `json:"submitted,omitempty"`
+	BaseStart      int      `json:"base_start"`
+	CurrentStart   int      `json:"current_start"`
+	SubmittedStart int      `json:"submitted_start"`
 }
 
 type WebSocketMessage struct {
@@ -47,16 +352,56 @@ type WebSocketMessage struct {
 }
 
 type RenameRequest struct {
-	OldPath string `json:"old_path"`
-	NewPath string `json:"new_path"`
+	OldPath    string `json:"old_path"`
+	NewPath    string `json:"new_path"`
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 type MoveRequest struct {
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
+	OnConflict  string `json:"on_conflict,omitempty"`
+}
+
+type RenameByIDRequest struct {
+	Name       string `json:"name"`
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 type CopyRequest struct {
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
+	OnConflict  string `json:"on_conflict,omitempty"`
+}
+
+type JobStatus struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Status    string                 `json:"status"`
+	Processed int64                  `json:"processed"`
+	Total     int64                  `json:"total"`
+	Bytes     int64                  `json:"bytes"`
+	Error     *string                `json:"error,omitempty"`
+	Result    map[string]interface{} `json:"result,omitempty"`
+	CreatedAt int64                  `json:"created_at"`
+	UpdatedAt int64                  `json:"updated_at"`
+}
+
+type ExtractRequest struct {
+	Destination string `json:"destination"`
+}
+
+type SignURLRequest struct {
+	Path      string `json:"path"`
+	Method    string `json:"method"`
+	ExpiresIn int64  `json:"expires_in"`
+	BindIP    bool   `json:"bind_ip"`
+}
+
+type FolderUploadResult struct {
+	Path    string  `json:"path"`
+	Success bool    `json:"success"`
+	Size    int64   `json:"size_bytes"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Error   *string `json:"error,omitempty"`
 }
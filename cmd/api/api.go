@@ -7,6 +7,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/TungstenDevs/AxolotlDrive/config"
 	"github.com/TungstenDevs/AxolotlDrive/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
@@ -14,15 +15,17 @@ import (
 )
 
 type APIServer struct {
-	Host string
-	DB   *gorm.DB
-	App  *fiber.App
+	Host   string
+	DB     *gorm.DB
+	Config *config.Config
+	App    *fiber.App
 }
 
-func NewAPIServer(host string, db *gorm.DB) *APIServer {
+func NewAPIServer(host string, db *gorm.DB, cfg *config.Config) *APIServer {
 	return &APIServer{
-		Host: host,
-		DB:   db,
+		Host:   host,
+		DB:     db,
+		Config: cfg,
 		App: fiber.New(fiber.Config{
 			StrictRouting:         true,
 			CaseSensitive:         true,
@@ -36,10 +39,12 @@ func NewAPIServer(host string, db *gorm.DB) *APIServer {
 					"error": "Something went wrong",
 				})
 			},
-			BodyLimit:    30 * 1024 * 1024, // 30 MB
-			ReadTimeout:  30 * time.Second,
-			WriteTimeout: 30 * time.Second,
-			IdleTimeout:  120 * time.Second,
+			BodyLimit:         30 * 1024 * 1024, // 30 MB, larger bodies are streamed
+			StreamRequestBody: true,
+			RequestMethods:    routes.RequestMethods,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
 		}),
 	}
 }
@@ -48,7 +53,7 @@ func (s *APIServer) Start() error {
 
 	v1_router := s.App.Group("/api/v1")
 
-	routes.SetupRoutes(&v1_router, s.DB)
+	routes.SetupRoutes(&v1_router, s.DB, s.Config)
 
 	go s.listenForShutdown()
 
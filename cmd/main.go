@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 
 	"github.com/TungstenDevs/AxolotlDrive/cmd/api"
 	"github.com/TungstenDevs/AxolotlDrive/config"
 	"github.com/TungstenDevs/AxolotlDrive/db"
 	"github.com/TungstenDevs/AxolotlDrive/logger"
+	publicfiles "github.com/TungstenDevs/AxolotlDrive/services/public_files"
 	"github.com/rs/zerolog/log"
 )
 
@@ -18,6 +22,11 @@ func main() {
 	log.Info().Msg("Logger has been initialized properly")
 	log.Debug().Msg("Debug Mode is set to true")
 
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		reindex(config)
+		return
+	}
+
 	log.Debug().Msg("Trying to connect to database")
 	db := db.NewPostgresInstance(
 		config.DBHost,
@@ -31,7 +40,7 @@ func main() {
 
 	log.Debug().Msgf("Initial stuff done now moving to API")
 
-	apiServer := api.NewAPIServer(fmt.Sprintf(":%d", config.APPPort), dbInstance)
+	apiServer := api.NewAPIServer(fmt.Sprintf(":%d", config.APPPort), dbInstance, config)
 
 	log.Debug().Msg("Trying to start API server in main.go")
 
@@ -40,3 +49,17 @@ func main() {
 	}
 
 }
+
+// reindex rebuilds the filename and full-text search indexes of the public
+// directory without starting the server. Run it while the server is stopped.
+func reindex(config *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	service := publicfiles.NewPublicFilesService(config.PublicDir, nil)
+	indexed, removed, err := service.RebuildIndexes(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to rebuild search indexes")
+	}
+	log.Info().Msgf("Search indexes rebuilt: %d files indexed, %d entries removed", indexed, removed)
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +18,13 @@ type Config struct {
 	DBPass   string
 	DBName   string
 	LOGLevel string
+
+	PublicDir            string
+	StorageQuota         int64
+	BlockContentMismatch bool
+
+	URLSigningKeys        []string
+	URLSigningRotateHours int
 }
 
 func loadenv() {
@@ -42,6 +51,38 @@ func loadEnvIntWithKey(key string, fallback int) int {
 	return fallback
 }
 
+func loadEnvInt64WithKey(key string, fallback int64) int64 {
+	if val, ok := os.LookupEnv(key); ok {
+		var intVal int64
+		_, err := fmt.Sscanf(val, "%d", &intVal)
+		if err == nil {
+			return intVal
+		}
+	}
+	return fallback
+}
+
+func loadEnvBoolWithKey(key string, fallback bool) bool {
+	if val, ok := os.LookupEnv(key); ok {
+		if boolVal, err := strconv.ParseBool(val); err == nil {
+			return boolVal
+		}
+	}
+	return fallback
+}
+
+func loadEnvListWithKey(key string) []string {
+	var list []string
+	if val, ok := os.LookupEnv(key); ok {
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
 func NewConfig() *Config {
 	loadenv()
 	return &Config{
@@ -53,5 +94,12 @@ func NewConfig() *Config {
 		DBPass:   loadEnvWithKey("DB_PASS", "supersecretpassword_dev"),
 		DBName:   loadEnvWithKey("DB_NAME", "axolotldrive_dev"),
 		LOGLevel: loadEnvWithKey("LOG_LEVEL", "debug"),
+
+		PublicDir:            loadEnvWithKey("PUBLIC_DIR", "data/public"),
+		StorageQuota:         loadEnvInt64WithKey("STORAGE_QUOTA", 0),
+		BlockContentMismatch: loadEnvBoolWithKey("BLOCK_CONTENT_MISMATCH", false),
+
+		URLSigningKeys:        loadEnvListWithKey("URL_SIGNING_KEYS"),
+		URLSigningRotateHours: loadEnvIntWithKey("URL_SIGNING_ROTATE_HOURS", 24),
 	}
 }
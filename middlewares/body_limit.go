@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit rejects requests whose body exceeds max bytes. The server
+// streams large bodies instead of refusing them, so handlers that buffer the
+// whole body rely on this check. Bodies of unknown length (chunked) are read
+// here up to max and rejected past it. Routes for which skip returns true
+// read the body as a stream and enforce their own limits.
+func BodyLimit(max int, skip func(c *fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if skip != nil && skip(c) {
+			return c.Next()
+		}
+		length := c.Request().Header.ContentLength()
+		if length > max {
+			return tooLarge(c)
+		}
+		if stream := c.Context().RequestBodyStream(); length < 0 && stream != nil {
+			body, err := io.ReadAll(io.LimitReader(stream, int64(max)+1))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Failed to read request body",
+				})
+			}
+			if len(body) > max {
+				return tooLarge(c)
+			}
+			c.Request().SetBody(body)
+		}
+		return c.Next()
+	}
+}
+
+// tooLarge rejects the request and closes the connection, since the rest of
+// the body is left unread.
+func tooLarge(c *fiber.Ctx) error {
+	c.Context().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error": "Request body too large",
+	})
+}
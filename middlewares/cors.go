@@ -7,8 +7,9 @@ import (
 
 func CORS() fiber.Handler {
 	return cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "Content-Type,Authorization",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,DELETE,PATCH,OPTIONS,PROPFIND,PROPPATCH,MKCOL,COPY,MOVE,LOCK,UNLOCK",
+		AllowHeaders:  "Content-Type,Authorization,X-User-ID,If-Match,Lock-Token,If,Depth,Destination,Overwrite,Timeout",
+		ExposeHeaders: "Lock-Token,DAV,ETag",
 	})
 }
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/TungstenDevs/AxolotlDrive/services/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SignedURLLocal is set on requests authorized by a signed URL so that
+// session checks can let them through.
+const SignedURLLocal = "signed_url"
+
+// SignedURL verifies signatures minted by signer on wildcard routes. The
+// signed path is the unescaped wildcard parameter. Requests without a
+// signature are passed on untouched; an invalid or expired one is rejected.
+func SignedURL(signer *auth.URLSigner) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Query("sig") == "" {
+			return c.Next()
+		}
+
+		path, err := url.PathUnescape(strings.TrimPrefix(c.Params("*"), "/"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path"})
+		}
+
+		query := url.Values{}
+		for key, value := range c.Queries() {
+			query.Set(key, value)
+		}
+
+		if err := signer.Verify(c.Method(), path, query, c.IP()); err != nil {
+			message := "Invalid signature"
+			if errors.Is(err, auth.ErrSignatureExpired) {
+				message = "Signed URL has expired"
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": message})
+		}
+
+		c.Locals(SignedURLLocal, true)
+		return c.Next()
+	}
+}
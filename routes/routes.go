@@ -1,21 +1,35 @@
 package routes
 
 import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/TungstenDevs/AxolotlDrive/config"
 	"github.com/TungstenDevs/AxolotlDrive/middlewares"
 	"github.com/TungstenDevs/AxolotlDrive/services"
+	"github.com/TungstenDevs/AxolotlDrive/services/auth"
 	publicfiles "github.com/TungstenDevs/AxolotlDrive/services/public_files"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(app *fiber.Router, db *gorm.DB) {
+func SetupRoutes(app *fiber.Router, db *gorm.DB, cfg *config.Config) {
 	(*app).Use(middlewares.Recovery())
 	(*app).Use(middlewares.Logger())
 	(*app).Use(middlewares.CORS())
 	(*app).Use(middlewares.RateLimiter())
+	(*app).Use(middlewares.BodyLimit(30*1024*1024, isStreamingUpload))
 	(*app).Get("/healthz", func(c *fiber.Ctx) error {
 		return services.HealthCheck(c)
 	})
@@ -23,12 +37,39 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 	wsHub := publicfiles.NewWebSocketHub()
 	go wsHub.Run()
 
-	publicFilesService := publicfiles.NewPublicFilesService("data/public", wsHub)
+	publicFilesService := publicfiles.NewPublicFilesService(cfg.PublicDir, wsHub)
+	publicFilesService.SetStorageQuota(cfg.StorageQuota)
+	publicFilesService.SetBlockContentMismatch(cfg.BlockContentMismatch)
+	if removed, err := publicFilesService.SweepStagingFiles(); err != nil {
+		log.Warn().Err(err).Msg("Failed to sweep staging files")
+	} else if removed > 0 {
+		log.Info().Msgf("Removed %d leftover staging files", removed)
+	}
+	if names, docs, err := publicFilesService.LoadIndexes(); err != nil {
+		log.Warn().Err(err).Msg("Failed to load search indexes")
+	} else {
+		log.Info().Msgf("Loaded search indexes with %d entries and %d documents", names, docs)
+	}
+	if _, errResp := publicFilesService.Reindex(); errResp != nil {
+		log.Warn().Str("error", errResp.Error).Msg("Failed to start re-index")
+	}
+
+	urlSigner, err := auth.NewURLSigner(cfg.URLSigningKeys)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid URL_SIGNING_KEYS")
+	}
+	if len(cfg.URLSigningKeys) == 0 && cfg.URLSigningRotateHours > 0 {
+		go urlSigner.RunRotation(time.Duration(cfg.URLSigningRotateHours) * time.Hour)
+	}
 
 	(*app).Get("/files", func(c *fiber.Ctx) error {
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.ListItemsRoot(page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.ListItemsRoot(page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -39,20 +80,96 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		query := c.Query("q")
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.SearchItems(query, page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.SearchItems(query, page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
 		return c.JSON(items)
 	})
 
+	(*app).Get("/files/search/content", func(c *fiber.Ctx) error {
+		query := c.Query("q")
+		page := c.QueryInt("page", 1)
+		limit := c.QueryInt("limit", 20)
+		results, errResp := publicFilesService.SearchContent(query, page, limit)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(results)
+	})
+
+	(*app).Post("/files/search/reindex", func(c *fiber.Ctx) error {
+		result, errResp := publicFilesService.Reindex()
+		if errResp != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(errResp)
+		}
+		return c.Status(fiber.StatusAccepted).JSON(result)
+	})
+
+	(*app).Post("/files/sign", func(c *fiber.Ctx) error {
+		var req dtos.SignURLRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		path := strings.Trim(req.Path, "/")
+		if path == "" || strings.Contains(path, "..") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path"})
+		}
+
+		method := strings.ToUpper(req.Method)
+		if method == "" {
+			method = fiber.MethodGet
+		}
+		if method != fiber.MethodGet && method != fiber.MethodHead {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Only GET and HEAD URLs can be signed"})
+		}
+
+		ttl := time.Duration(req.ExpiresIn) * time.Second
+		if req.ExpiresIn == 0 {
+			ttl = time.Hour
+		}
+		if ttl <= 0 || ttl > auth.MaxSignedURLTTL {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in must be between 1 second and 7 days"})
+		}
+
+		clientIP := ""
+		if req.BindIP {
+			clientIP = c.IP()
+		}
+
+		query := urlSigner.Sign(method, path, ttl, clientIP)
+		expiresAt, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		base := strings.TrimSuffix(c.Path(), "/files/sign")
+
+		return c.JSON(fiber.Map{
+			"url":        base + "/files/download/" + strings.Join(segments, "/") + "?" + query.Encode(),
+			"path":       path,
+			"method":     method,
+			"expires_at": expiresAt,
+			"bound_ip":   clientIP,
+		})
+	})
+
 	// Specific routes first
-	(*app).Get("/files/download/*", func(c *fiber.Ctx) error {
-		path := strings.TrimPrefix(c.Params("*"), "/")
+	(*app).Get("/files/download/*", middlewares.SignedURL(urlSigner), func(c *fiber.Ctx) error {
+		path, err := url.PathUnescape(strings.TrimPrefix(c.Params("*"), "/"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path"})
+		}
 		data, errResp := publicFilesService.DownloadItem(path)
 		if errResp != nil {
 			return c.Status(fiber.StatusNotFound).JSON(errResp)
 		}
+		publicFilesService.RecordActivity(userID(c), path, publicfiles.ActivityDownload)
 		return c.Send(data)
 	})
 
@@ -65,11 +182,267 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		return c.JSON(files)
 	})
 
+	(*app).Get("/files/archive-list/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		page := c.QueryInt("page", 1)
+		limit := c.QueryInt("limit", 50)
+		items, errResp := publicFilesService.ListArchive(path, c.Query("dir"), page, limit)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(items)
+	})
+
+	(*app).Get("/files/archive-get/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		body, item, errResp := publicFilesService.OpenArchiveEntry(path, c.Query("entry"))
+		if errResp != nil {
+			return c.Status(fiber.StatusNotFound).JSON(errResp)
+		}
+		c.Attachment(item.Name)
+		c.Set(fiber.HeaderContentType, *item.MimeType)
+		c.Set(fiber.HeaderETag, item.Etag)
+		return c.SendStream(body, int(item.Size))
+	})
+
+	(*app).Get("/files/stats/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		stats, errResp := publicFilesService.FolderStats(path, c.QueryInt("top", 10))
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(stats)
+	})
+
+	(*app).Get("/files/diff", func(c *fiber.Ctx) error {
+		var opts dtos.DiffOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		diff, errResp := publicFilesService.DiffFiles(opts)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(diff)
+	})
+
+	(*app).Get("/files/versions/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		versions, errResp := publicFilesService.FileVersions(path)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(fiber.Map{"versions": versions})
+	})
+
+	(*app).Get("/files/image/*", middlewares.SignedURL(urlSigner), func(c *fiber.Ctx) error {
+		path, err := url.PathUnescape(strings.TrimPrefix(c.Params("*"), "/"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path"})
+		}
+		var opts dtos.ImageOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		body, result, errResp := publicFilesService.TransformImage(path, opts)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		c.Set(fiber.HeaderETag, result.Etag)
+		c.Set(fiber.HeaderCacheControl, "private, no-cache")
+		if c.Get(fiber.HeaderIfNoneMatch) == result.Etag {
+			body.Close()
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		c.Set(fiber.HeaderContentType, result.ContentType)
+		return c.SendStream(body, int(result.Size))
+	})
+
+	(*app).Get("/files/preview/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var opts dtos.PreviewOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		preview, errResp := publicFilesService.PreviewFile(path, opts)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(preview)
+	})
+
+	(*app).Get("/files/tree/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var opts dtos.TreeOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		tree, errResp := publicFilesService.FolderTree(path, opts)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(tree)
+	})
+
+	(*app).Get("/files/id/:id", func(c *fiber.Ctx) error {
+		item, errResp := publicFilesService.GetItemByID(c.Params("id"))
+		if errResp != nil {
+			return c.Status(fiber.StatusNotFound).JSON(errResp)
+		}
+		return c.JSON(item)
+	})
+
+	(*app).Post("/files/id/:id/move", func(c *fiber.Ctx) error {
+		var req dtos.MoveRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		result, errResp := publicFilesService.MoveItemByID(c.Params("id"), req.Destination, req.OnConflict, lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Post("/files/id/:id/rename", func(c *fiber.Ctx) error {
+		var req dtos.RenameByIDRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		result, errResp := publicFilesService.RenameItemByID(c.Params("id"), req.Name, req.OnConflict, lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Delete("/files/id/:id", func(c *fiber.Ctx) error {
+		result, errResp := publicFilesService.DeleteItemByID(c.Params("id"), lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusNotFound)).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Get("/files/starred", func(c *fiber.Ctx) error {
+		items, errResp := publicFilesService.StarredItems(userID(c))
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(fiber.Map{"items": items})
+	})
+
+	(*app).Put("/files/starred/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		result, errResp := publicFilesService.StarItem(userID(c), path)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Delete("/files/starred/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		result, errResp := publicFilesService.UnstarItem(userID(c), path)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Get("/files/recent", func(c *fiber.Ctx) error {
+		items, errResp := publicFilesService.RecentItems(userID(c), c.QueryInt("limit", 50))
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(fiber.Map{"items": items})
+	})
+
+	(*app).Get("/files/jobs/:id", func(c *fiber.Ctx) error {
+		job, errResp := publicFilesService.GetJob(c.Params("id"))
+		if errResp != nil {
+			return c.Status(fiber.StatusNotFound).JSON(errResp)
+		}
+		return c.JSON(job)
+	})
+
+	(*app).Delete("/files/jobs/:id", func(c *fiber.Ctx) error {
+		job, errResp := publicFilesService.CancelJob(c.Params("id"))
+		if errResp != nil {
+			return c.Status(fiber.StatusNotFound).JSON(errResp)
+		}
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	})
+
+	(*app).Get("/files/locks/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		locks, errResp := publicFilesService.ItemLocks(path)
+		if errResp != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+		}
+		return c.JSON(fiber.Map{"locks": locks})
+	})
+
+	(*app).Post("/files/lock/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var req dtos.LockRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+			}
+		}
+		if req.Owner == "" {
+			req.Owner = userID(c)
+		}
+		lock, errResp := publicFilesService.LockItem(path, req)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		c.Set("Lock-Token", "<"+lock.Token+">")
+		return c.JSON(lock)
+	})
+
+	(*app).Put("/files/lock/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var req dtos.LockRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+			}
+		}
+		tokens := lockTokens(c)
+		if len(tokens) != 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected one lock token in the Lock-Token header"})
+		}
+		lock, errResp := publicFilesService.RefreshLock(path, tokens[0], req.Timeout)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(lock)
+	})
+
+	(*app).Delete("/files/lock/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		tokens := lockTokens(c)
+		if len(tokens) != 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected one lock token in the Lock-Token header"})
+		}
+		result, errResp := publicFilesService.UnlockItem(path, tokens[0])
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
 	(*app).Get("/files/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.ListItems(path, page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.ListItems(path, page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -84,34 +457,80 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		}
 		f, _ := file.Open()
 		defer f.Close()
-		result, errResp := publicFilesService.UploadFile(path, f)
+		result, errResp := publicFilesService.UploadFile(path, f, c.Query("on_conflict", c.FormValue("on_conflict")), lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		if finalPath, ok := result["path"].(string); ok {
+			publicFilesService.RecordActivity(userID(c), finalPath, publicfiles.ActivityUpload)
 		}
 		return c.JSON(result)
 	})
 
+	(*app).Post("/files/extract/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var req dtos.ExtractRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+			}
+		}
+		result, errResp := publicFilesService.ExtractArchive(path, req.Destination, lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.Status(fiber.StatusAccepted).JSON(result)
+	})
+
 	(*app).Post("/files/mkdir/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
-		result, errResp := publicFilesService.CreateFolder(path)
+		result, errResp := publicFilesService.CreateFolder(path, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
 
 	(*app).Post("/files/create-file/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
-		result, errResp := publicFilesService.CreateFile(path)
+		result, errResp := publicFilesService.CreateFile(path, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
 
 	(*app).Delete("/files/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
-		result, errResp := publicFilesService.DeleteItem(path)
+		result, errResp := publicFilesService.DeleteItem(path, lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		return c.JSON(result)
+	})
+
+	(*app).Patch("/files/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var opts dtos.PatchOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		result, errResp := publicFilesService.PatchFile(path, requestBodyStream(c), opts, c.Get(fiber.HeaderIfMatch), lockTokens(c)...)
+		if errResp != nil {
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		c.Set(fiber.HeaderETag, result["etag"].(string))
+		publicFilesService.RecordActivity(userID(c), path, publicfiles.ActivityEdit)
+		return c.JSON(result)
+	})
+
+	(*app).Put("/files/tags/*", func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Params("*"), "/")
+		var req dtos.TagsRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		result, errResp := publicFilesService.SetTags(path, req.Tags)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -121,35 +540,40 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 	(*app).Put("/files/edit/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
 		content := string(c.Body())
-		result, errResp := publicFilesService.EditFile(path, content)
+		// The version the edit was made on, to merge with changes made since.
+		baseEtag := c.Get(fiber.HeaderIfMatch, c.Query("base_etag"))
+		result, errResp := publicFilesService.EditFileFrom(path, content, baseEtag, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
+		publicFilesService.RecordActivity(userID(c), path, publicfiles.ActivityEdit)
 		return c.JSON(result)
 	})
 
 	(*app).Post("/files/rename", func(c *fiber.Ctx) error {
 		var req struct {
-			OldPath string `json:"old_path"`
-			NewPath string `json:"new_path"`
+			OldPath    string `json:"old_path"`
+			NewPath    string `json:"new_path"`
+			OnConflict string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.RenameFile(req.OldPath, req.NewPath)
+		result, errResp := publicFilesService.RenameFile(req.OldPath, req.NewPath, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
 
 	(*app).Post("/files/rename-folder", func(c *fiber.Ctx) error {
 		var req struct {
-			OldPath string `json:"old_path"`
-			NewPath string `json:"new_path"`
+			OldPath    string `json:"old_path"`
+			NewPath    string `json:"new_path"`
+			OnConflict string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.RenameFolder(req.OldPath, req.NewPath)
+		result, errResp := publicFilesService.RenameFolder(req.OldPath, req.NewPath, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
@@ -158,11 +582,12 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.MoveFile(req.Source, req.Destination)
+		result, errResp := publicFilesService.MoveFile(req.Source, req.Destination, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
@@ -171,11 +596,12 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.MoveFolder(req.Source, req.Destination)
+		result, errResp := publicFilesService.MoveFolder(req.Source, req.Destination, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
@@ -184,11 +610,12 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.CopyFile(req.Source, req.Destination)
+		result, errResp := publicFilesService.CopyFile(req.Source, req.Destination, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
@@ -197,27 +624,96 @@ func SetupRoutes(app *fiber.Router, db *gorm.DB) {
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.CopyFolder(req.Source, req.Destination)
+		result, errResp := publicFilesService.CopyFolder(req.Source, req.Destination, req.OnConflict, lockTokens(c)...)
 		if errResp != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(errResp)
+			return c.Status(errorStatus(errResp, fiber.StatusBadRequest)).JSON(errResp)
+		}
+		if _, async := result["job_id"]; async {
+			return c.Status(fiber.StatusAccepted).JSON(result)
 		}
 		return c.JSON(result)
 	})
 
 	(*app).Post("/files/upload-folder/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
-		var files map[string][]byte
-		if err := c.BodyParser(&files); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		mediaType, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		if err != nil || mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected a multipart/form-data body"})
 		}
-		result, errResp := publicFilesService.UploadFolder(path, files)
+		result, errResp := publicFilesService.UploadFolder(path, multipart.NewReader(requestBodyStream(c), params["boundary"]), c.Query("on_conflict"), lockTokens(c)...)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
 		return c.JSON(result)
 	})
 
+	// WebDAV clients mount the drive at /webdav/.
+	for _, method := range append([]string{fiber.MethodOptions, fiber.MethodGet, fiber.MethodHead, fiber.MethodPut, fiber.MethodDelete}, publicfiles.WebDAVMethods...) {
+		(*app).Add(method, "/webdav/*", publicFilesService.ServeWebDAV)
+	}
+
 	(*app).Get("/ws/public_files", websocket.New(wsHub.HandleConnection))
 }
+
+// RequestMethods are the request methods the server has to accept for the
+// routes: those of HTTP and those WebDAV adds.
+var RequestMethods = append(slices.Clone(fiber.DefaultMethods), publicfiles.WebDAVMethods...)
+
+// errorStatus returns the status errResp asks for, or fallback.
+func errorStatus(errResp *dtos.ErrorResponse, fallback int) int {
+	if errResp.Status != 0 {
+		return errResp.Status
+	}
+	return fallback
+}
+
+// userID identifies the user for per-user state such as starred items. The
+// server has no accounts, so clients send a stable ID of their choosing.
+func userID(c *fiber.Ctx) string {
+	return c.Get("X-User-ID")
+}
+
+// ifHeaderToken matches the lock tokens of a WebDAV If header.
+var ifHeaderToken = regexp.MustCompile(`<(opaquelocktoken:[^>]+)>`)
+
+// lockTokens returns the lock tokens a request holds: those listed in the
+// Lock-Token header, with or without angle brackets, and those in an If
+// header as WebDAV clients send them.
+func lockTokens(c *fiber.Ctx) []string {
+	var tokens []string
+	for _, token := range strings.Split(c.Get("Lock-Token"), ",") {
+		if token = strings.Trim(strings.TrimSpace(token), "<>"); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	for _, m := range ifHeaderToken.FindAllStringSubmatch(c.Get("If"), -1) {
+		tokens = append(tokens, m[1])
+	}
+	return tokens
+}
+
+// isStreamingUpload reports whether the request body is consumed as a stream
+// by its handler rather than buffered.
+func isStreamingUpload(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodPost:
+		return strings.Contains(c.Path(), "/files/upload-folder/")
+	case fiber.MethodPatch:
+		return strings.Contains(c.Path(), "/files/")
+	case fiber.MethodPut:
+		return strings.Contains(c.Path(), "/webdav/")
+	}
+	return false
+}
+
+// requestBodyStream returns the request body without buffering it when the
+// server streams large bodies, and falls back to the buffered body otherwise.
+func requestBodyStream(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
 	"github.com/TungstenDevs/AxolotlDrive/middlewares"
+	"github.com/TungstenDevs/AxolotlDrive/services/auth"
 	publicfiles "github.com/TungstenDevs/AxolotlDrive/services/public_files"
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
@@ -52,7 +56,11 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 	(*app).Get("/files", func(c *fiber.Ctx) error {
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.ListItemsRoot(page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.ListItemsRoot(page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -63,7 +71,11 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		query := c.Query("q")
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.SearchItems(query, page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.SearchItems(query, page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -74,7 +86,11 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		path := strings.TrimPrefix(c.Params("*"), "/")
 		page := c.QueryInt("page", 1)
 		limit := c.QueryInt("limit", 50)
-		items, errResp := publicFilesService.ListItems(path, page, limit)
+		var opts dtos.ListOptions
+		if err := c.QueryParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid query parameters"})
+		}
+		items, errResp := publicFilesService.ListItems(path, page, limit, opts)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -98,7 +114,7 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		}
 		f, _ := file.Open()
 		defer f.Close()
-		result, errResp := publicFilesService.UploadFile(path, f)
+		result, errResp := publicFilesService.UploadFile(path, f, c.Query("on_conflict", c.FormValue("on_conflict")))
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -144,11 +160,12 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 
 	(*app).Post("/files/rename", func(c *fiber.Ctx) error {
 		var req struct {
-			OldPath string `json:"old_path"`
-			NewPath string `json:"new_path"`
+			OldPath    string `json:"old_path"`
+			NewPath    string `json:"new_path"`
+			OnConflict string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.RenameFile(req.OldPath, req.NewPath)
+		result, errResp := publicFilesService.RenameFile(req.OldPath, req.NewPath, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -159,9 +176,10 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.MoveFile(req.Source, req.Destination)
+		result, errResp := publicFilesService.MoveFile(req.Source, req.Destination, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -172,9 +190,10 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.CopyFile(req.Source, req.Destination)
+		result, errResp := publicFilesService.CopyFile(req.Source, req.Destination, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -183,11 +202,12 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 
 	(*app).Post("/files/rename-folder", func(c *fiber.Ctx) error {
 		var req struct {
-			OldPath string `json:"old_path"`
-			NewPath string `json:"new_path"`
+			OldPath    string `json:"old_path"`
+			NewPath    string `json:"new_path"`
+			OnConflict string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.RenameFolder(req.OldPath, req.NewPath)
+		result, errResp := publicFilesService.RenameFolder(req.OldPath, req.NewPath, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -198,9 +218,10 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.MoveFolder(req.Source, req.Destination)
+		result, errResp := publicFilesService.MoveFolder(req.Source, req.Destination, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -211,9 +232,10 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 		var req struct {
 			Source      string `json:"source"`
 			Destination string `json:"destination"`
+			OnConflict  string `json:"on_conflict"`
 		}
 		c.BodyParser(&req)
-		result, errResp := publicFilesService.CopyFolder(req.Source, req.Destination)
+		result, errResp := publicFilesService.CopyFolder(req.Source, req.Destination, req.OnConflict)
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -222,11 +244,11 @@ func setupTestRoutes(app *fiber.Router, publicFilesService *publicfiles.PublicFi
 
 	(*app).Post("/files/upload-folder/*", func(c *fiber.Ctx) error {
 		path := strings.TrimPrefix(c.Params("*"), "/")
-		var files map[string][]byte
-		if err := c.BodyParser(&files); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		mediaType, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		if err != nil || mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Expected a multipart/form-data body"})
 		}
-		result, errResp := publicFilesService.UploadFolder(path, files)
+		result, errResp := publicFilesService.UploadFolder(path, multipart.NewReader(bytes.NewReader(c.Body()), params["boundary"]), c.Query("on_conflict"))
 		if errResp != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(errResp)
 		}
@@ -446,14 +468,17 @@ func TestIntegration_CreateNestedStructure(t *testing.T) {
 func TestIntegration_UploadFolder(t *testing.T) {
 	app, _ := setupTestApp(t)
 
-	// Create JSON payload for upload-folder
-	payload := `{
-		"file1.txt": "SGVsbG8gV29ybGQh",
-		"file2.txt": "VGhpcyBpcyBhIHRlc3Q="
-	}` // Base64 encoded content
+	// Each part carries its path relative to the uploaded folder
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("files", "file1.txt")
+	part.Write([]byte("Hello World!"))
+	part, _ = writer.CreateFormFile("files", "nested/file2.txt")
+	part.Write([]byte("This is a test"))
+	writer.Close()
 
-	req, _ := http.NewRequest("POST", "/api/v1/files/upload-folder/test_folder", strings.NewReader(payload))
-	req.Header.Set("Content-Type", "application/json")
+	req, _ := http.NewRequest("POST", "/api/v1/files/upload-folder/test_folder", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	resp, _ := app.Test(req, -1)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
@@ -624,3 +649,56 @@ func TestIntegration_PathTraversalAttackMkdir(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
+
+func TestIntegration_SignedDownload(t *testing.T) {
+	signer, err := auth.NewURLSigner(nil)
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/api/v1/files/download/*", middlewares.SignedURL(signer), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"signed": c.Locals(middlewares.SignedURLLocal) != nil})
+	})
+
+	query := signer.Sign("GET", "docs/my report.pdf", time.Minute, "")
+	req, _ := http.NewRequest("GET", "/api/v1/files/download/docs/my%20report.pdf?"+query.Encode(), nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	assert.Equal(t, true, result["signed"])
+
+	req, _ = http.NewRequest("GET", "/api/v1/files/download/docs/other.pdf?"+query.Encode(), nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	expired := signer.Sign("GET", "docs/my report.pdf", -time.Minute, "")
+	req, _ = http.NewRequest("GET", "/api/v1/files/download/docs/my%20report.pdf?"+expired.Encode(), nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestIntegration_ListFilesSortedAndFiltered(t *testing.T) {
+	app, tmpDir := setupTestApp(t)
+
+	os.WriteFile(filepath.Join(tmpDir, "small.txt"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "large.txt"), make([]byte, 1000), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "tiny.txt"), make([]byte, 1), 0644)
+
+	req, _ := http.NewRequest("GET", "/api/v1/files?sort=size&order=desc&min_size=5&fields=name,size", nil)
+	resp, _ := app.Test(req, -1)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	assert.Equal(t, []map[string]interface{}{
+		{"name": "large.txt", "size": float64(1000)},
+		{"name": "small.txt", "size": float64(10)},
+	}, result.Items)
+
+	req, _ = http.NewRequest("GET", "/api/v1/files?sort=colour", nil)
+	resp, _ = app.Test(req, -1)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLSigner_SignAndVerify(t *testing.T) {
+	signer, err := NewURLSigner(nil)
+	assert.NoError(t, err)
+
+	q := signer.Sign("GET", "docs/report.pdf", time.Minute, "")
+	assert.NoError(t, signer.Verify("GET", "docs/report.pdf", q, "10.0.0.1"))
+	assert.NoError(t, signer.Verify("HEAD", "docs/report.pdf", q, "10.0.0.1"))
+
+	assert.ErrorIs(t, signer.Verify("GET", "docs/other.pdf", q, ""), ErrSignatureInvalid)
+	assert.ErrorIs(t, signer.Verify("DELETE", "docs/report.pdf", q, ""), ErrSignatureInvalid)
+
+	q.Set("expires", "9999999999")
+	assert.ErrorIs(t, signer.Verify("GET", "docs/report.pdf", q, ""), ErrSignatureInvalid)
+}
+
+func TestURLSigner_Expired(t *testing.T) {
+	signer, _ := NewURLSigner(nil)
+
+	q := signer.Sign("GET", "a.txt", -time.Second, "")
+	assert.ErrorIs(t, signer.Verify("GET", "a.txt", q, ""), ErrSignatureExpired)
+}
+
+func TestURLSigner_BoundToIP(t *testing.T) {
+	signer, _ := NewURLSigner(nil)
+
+	q := signer.Sign("GET", "a.txt", time.Minute, "192.168.1.5")
+	assert.NoError(t, signer.Verify("GET", "a.txt", q, "192.168.1.5"))
+	assert.ErrorIs(t, signer.Verify("GET", "a.txt", q, "192.168.1.6"), ErrSignatureInvalid)
+
+	q.Del("ip")
+	assert.ErrorIs(t, signer.Verify("GET", "a.txt", q, "192.168.1.6"), ErrSignatureInvalid)
+}
+
+func TestURLSigner_RotationKeepsRecentLinks(t *testing.T) {
+	signer, _ := NewURLSigner([]string{strings.Repeat("k", 32)})
+
+	q := signer.Sign("GET", "a.txt", time.Hour, "")
+	for i := 0; i < maxSigningKeys-1; i++ {
+		assert.NoError(t, signer.Rotate())
+	}
+	assert.NoError(t, signer.Verify("GET", "a.txt", q, ""))
+
+	assert.NoError(t, signer.Rotate())
+	assert.ErrorIs(t, signer.Verify("GET", "a.txt", q, ""), ErrSignatureInvalid)
+}
+
+func TestNewURLSigner_RejectsShortKeys(t *testing.T) {
+	_, err := NewURLSigner([]string{"short"})
+	assert.Error(t, err)
+}
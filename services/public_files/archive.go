@@ -0,0 +1,427 @@
+package publicfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	maxArchiveEntries = 10000
+	maxExtractedSize  = 10 * 1024 * 1024 * 1024
+)
+
+type archiveFormat int
+
+const (
+	archiveUnknown archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGz
+	archiveTarZst
+)
+
+var archiveSuffixes = []struct {
+	suffix string
+	format archiveFormat
+}{
+	{".tar.gz", archiveTarGz},
+	{".tgz", archiveTarGz},
+	{".tar.zst", archiveTarZst},
+	{".tzst", archiveTarZst},
+	{".tar", archiveTar},
+	{".zip", archiveZip},
+}
+
+func detectArchiveFormat(name string) (archiveFormat, string) {
+	lower := strings.ToLower(name)
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.format, name[:len(name)-len(s.suffix)]
+		}
+	}
+	return archiveUnknown, name
+}
+
+// newTarReader wraps r with the decompressor matching format. The returned
+// close function releases decoder resources and must always be called.
+func newTarReader(r io.Reader, format archiveFormat) (*tar.Reader, func(), error) {
+	switch format {
+	case archiveTar:
+		return tar.NewReader(r), func() {}, nil
+	case archiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		return tar.NewReader(gz), func() { gz.Close() }, nil
+	case archiveTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid zstd stream: %w", err)
+		}
+		return tar.NewReader(zr), zr.Close, nil
+	}
+	return nil, nil, fmt.Errorf("not a tar archive")
+}
+
+func (p *PublicFilesService) ExtractArchive(archivePath, destination string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	source, err := p.sanitizePathForRead(archivePath)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Archive not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Archive does not exist or is directory: %s", source)),
+		}
+	}
+
+	format, stem := detectArchiveFormat(source)
+	if format == archiveUnknown {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Unsupported archive format (expected zip, tar, tar.gz or tar.zst)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Unsupported archive: %s", filepath.Base(source))),
+		}
+	}
+
+	if destination == "" {
+		destination, _ = filepath.Rel(p.publicDir, stem)
+	}
+
+	destDir, err := p.sanitizePathForWrite(destination)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	if stat, err := os.Stat(destDir); err == nil && !stat.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Destination exists and is not a directory",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Destination is a file: %s", destDir)),
+		}
+	}
+	if errResp := p.checkLocks(destDir, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+
+	remaining, err := p.remainingQuota()
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to check storage quota: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	// The zip central directory is cheap to read, so obvious bombs and quota
+	// violations are rejected before a job is even started. Tar streams are
+	// checked while extracting.
+	if format == archiveZip {
+		if err := checkZipLimits(source, remaining); err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     err.Error(),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+	}
+
+	sourceRel, _ := filepath.Rel(p.publicDir, source)
+	destRel, _ := filepath.Rel(p.publicDir, destDir)
+
+	job := p.startJob("extract", func(job *Job) (map[string]interface{}, error) {
+		ex := &archiveExtractor{
+			p:         p,
+			job:       job,
+			destDir:   destDir,
+			destRel:   destRel,
+			remaining: remaining,
+		}
+		return ex.run(source, format)
+	})
+
+	return map[string]interface{}{
+		"success":     true,
+		"job_id":      job.ID,
+		"status":      jobStatusRunning,
+		"source":      strings.TrimPrefix(sourceRel, "/"),
+		"destination": strings.TrimPrefix(destRel, "/"),
+	}, nil
+}
+
+func checkZipLimits(source string, remaining int64) error {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	if len(zr.File) > maxArchiveEntries {
+		return fmt.Errorf("archive contains too many entries (maximum %d)", maxArchiveEntries)
+	}
+
+	var declared uint64
+	for _, f := range zr.File {
+		declared += f.UncompressedSize64
+	}
+	if declared > maxExtractedSize {
+		return fmt.Errorf("archive expands beyond the extraction limit (%.2f GB)", float64(maxExtractedSize)/1024/1024/1024)
+	}
+	if remaining >= 0 && declared > uint64(remaining) {
+		return fmt.Errorf("storage quota exceeded: archive expands to %d bytes, %d bytes available", declared, remaining)
+	}
+	return nil
+}
+
+type archiveExtractor struct {
+	p         *PublicFilesService
+	job       *Job
+	destDir   string
+	destRel   string
+	remaining int64
+
+	total   int64
+	entries int64
+	files   int64
+	dirs    int64
+	skipped []string
+	written int64
+	created []string
+}
+
+func (e *archiveExtractor) run(source string, format archiveFormat) (map[string]interface{}, error) {
+	if err := os.MkdirAll(e.destDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+
+	var err error
+	if format == archiveZip {
+		err = e.extractZip(source)
+	} else {
+		err = e.extractTar(source, format)
+	}
+	if err != nil {
+		e.cleanup()
+		return nil, err
+	}
+	e.p.fileChanged(e.destDir)
+
+	e.p.notifyWebSocket("archive_extracted", map[string]interface{}{
+		"path":        strings.TrimPrefix(e.destRel, "/"),
+		"files_count": e.files,
+		"bytes":       e.written,
+		"job_id":      e.job.ID,
+	})
+
+	return map[string]interface{}{
+		"destination": strings.TrimPrefix(e.destRel, "/"),
+		"files_count": e.files,
+		"dirs_count":  e.dirs,
+		"skipped":     e.skipped,
+		"bytes":       e.written,
+	}, nil
+}
+
+func (e *archiveExtractor) extractZip(source string) error {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	e.total = int64(len(zr.File))
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := e.entry(f.Name, true, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			if err := e.entry(f.Name, false, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open entry %q: %w", f.Name, err)
+		}
+		err = e.entry(f.Name, false, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTar(source string, format archiveFormat) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	tr, closeFn, err := newTarReader(f, format)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.entry(hdr.Name, true, nil)
+		case tar.TypeReg:
+			err = e.entry(hdr.Name, false, tr)
+		default:
+			// Links, devices and fifos are never materialized.
+			err = e.entry(hdr.Name, false, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// entry extracts a single archive member. A nil reader for a non-directory
+// marks an entry type that is skipped.
+func (e *archiveExtractor) entry(name string, isDir bool, r io.Reader) error {
+	if err := e.job.ctx.Err(); err != nil {
+		return err
+	}
+	e.entries++
+	if e.entries > maxArchiveEntries {
+		return fmt.Errorf("archive contains too many entries (maximum %d)", maxArchiveEntries)
+	}
+	defer e.p.reportProgress(e.job, e.entries, e.total, e.written)
+
+	trimmed := strings.TrimSuffix(name, "/")
+	if trimmed == "" || trimmed == "." {
+		return nil
+	}
+
+	for _, component := range strings.Split(trimmed, "/") {
+		if strings.HasPrefix(component, ".") && component != "." && component != ".." {
+			e.skipped = append(e.skipped, name)
+			return nil
+		}
+	}
+
+	if !isDir && r == nil {
+		e.skipped = append(e.skipped, name)
+		return nil
+	}
+
+	target, err := e.p.sanitizeChildPath(e.destDir, e.destRel, trimmed)
+	if err != nil {
+		return fmt.Errorf("unsafe entry name %q: %v", name, err)
+	}
+
+	e.recordMissingParents(target)
+	_, statErr := os.Lstat(target)
+	existed := statErr == nil
+
+	if isDir {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", name, err)
+		}
+		if !existed {
+			e.created = append(e.created, target)
+		}
+		e.dirs++
+		return nil
+	}
+
+	limit := int64(maxExtractedSize) - e.written
+	quotaBound := e.remaining >= 0 && e.remaining-e.written < limit
+	if quotaBound {
+		limit = e.remaining - e.written
+	}
+
+	n, err := e.p.writeStream(target, r, limit)
+	if errors.Is(err, errStreamTooLarge) {
+		if quotaBound {
+			return fmt.Errorf("storage quota exceeded while extracting %q", name)
+		}
+		return fmt.Errorf("archive expands beyond the extraction limit (%.2f GB)", float64(maxExtractedSize)/1024/1024/1024)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %q: %w", name, err)
+	}
+
+	if !existed {
+		e.created = append(e.created, target)
+	}
+	e.written += n
+	e.files++
+	return nil
+}
+
+// recordMissingParents remembers the directories between destDir and target
+// that do not exist yet, outermost first, so cleanup can remove them after
+// their contents.
+func (e *archiveExtractor) recordMissingParents(target string) {
+	var missing []string
+	for dir := filepath.Dir(target); len(dir) > len(e.destDir); dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		e.created = append(e.created, missing[i])
+	}
+}
+
+// cleanup removes the files and directories a failed extraction created so a
+// rejected archive does not leave partial content or consume quota. Paths that
+// existed before the extraction are left in place; directories are only
+// removed once empty.
+func (e *archiveExtractor) cleanup() {
+	for i := len(e.created) - 1; i >= 0; i-- {
+		os.Remove(e.created[i])
+	}
+}
@@ -0,0 +1,361 @@
+package publicfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+type archiveMember struct {
+	Name     string
+	Size     int64
+	IsDir    bool
+	Modified time.Time
+}
+
+// normalizeArchiveName turns a raw member name into a slash separated
+// relative path. Names that would escape the archive root are rejected so
+// they are neither listed nor served.
+func normalizeArchiveName(name string) (string, bool) {
+	name = strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "./")
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "", false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." || component == "." || component == "" || strings.Contains(component, "\x00") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// isHiddenArchiveName reports whether a normalized member name has a
+// hidden component. Such members are skipped on extraction, so they are
+// neither listed nor served either.
+func isHiddenArchiveName(name string) bool {
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchiveMembers lists an archive from any random access source, so the
+// same code serves local files and future remote storage backends. Zip
+// archives are listed from the central directory; tar streams are scanned.
+func readArchiveMembers(r io.ReaderAt, size int64, format archiveFormat) ([]archiveMember, error) {
+	var members []archiveMember
+
+	if format == archiveZip {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		if len(zr.File) > maxArchiveEntries {
+			return nil, fmt.Errorf("archive contains too many entries (maximum %d)", maxArchiveEntries)
+		}
+		for _, f := range zr.File {
+			members = append(members, archiveMember{
+				Name:     f.Name,
+				Size:     int64(f.UncompressedSize64),
+				IsDir:    f.Mode().IsDir(),
+				Modified: f.Modified,
+			})
+		}
+		return members, nil
+	}
+
+	tr, closeFn, err := newTarReader(io.NewSectionReader(r, 0, size), format)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if len(members) >= maxArchiveEntries {
+			return nil, fmt.Errorf("archive contains too many entries (maximum %d)", maxArchiveEntries)
+		}
+		members = append(members, archiveMember{
+			Name:     hdr.Name,
+			Size:     hdr.Size,
+			IsDir:    hdr.Typeflag == tar.TypeDir,
+			Modified: hdr.ModTime,
+		})
+	}
+}
+
+// openArchiveEntry returns a reader for a single member. The returned
+// closer releases the decompressor but not r itself.
+func openArchiveEntry(r io.ReaderAt, size int64, format archiveFormat, entry string) (io.ReadCloser, *archiveMember, error) {
+	if format == archiveZip {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		for _, f := range zr.File {
+			name, ok := normalizeArchiveName(f.Name)
+			if !ok || name != entry || !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open entry: %w", err)
+			}
+			// A header that understates the size must not serve more
+			// bytes than it declares.
+			limited := struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(rc, int64(f.UncompressedSize64)), rc}
+			return limited, &archiveMember{Name: name, Size: int64(f.UncompressedSize64), Modified: f.Modified}, nil
+		}
+		return nil, nil, os.ErrNotExist
+	}
+
+	tr, closeFn, err := newTarReader(io.NewSectionReader(r, 0, size), format)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			closeFn()
+			return nil, nil, os.ErrNotExist
+		}
+		if err != nil {
+			closeFn()
+			return nil, nil, fmt.Errorf("invalid tar archive: %w", err)
+		}
+		name, ok := normalizeArchiveName(hdr.Name)
+		if !ok || name != entry || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		rc := struct {
+			io.Reader
+			io.Closer
+		}{tr, closerFunc(closeFn)}
+		return rc, &archiveMember{Name: name, Size: hdr.Size, Modified: hdr.ModTime}, nil
+	}
+}
+
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+func (p *PublicFilesService) openArchive(archivePath string) (*os.File, os.FileInfo, archiveFormat, *dtos.ErrorResponse) {
+	source, err := p.sanitizePathForRead(archivePath)
+	if err != nil {
+		return nil, nil, archiveUnknown, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	format, _ := detectArchiveFormat(source)
+	if format == archiveUnknown {
+		return nil, nil, archiveUnknown, &dtos.ErrorResponse{
+			Error:     "Unsupported archive format (expected zip, tar, tar.gz or tar.zst)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Unsupported archive: %s", filepath.Base(source))),
+		}
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, nil, archiveUnknown, &dtos.ErrorResponse{
+			Error:     "Archive not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, archiveUnknown, &dtos.ErrorResponse{
+			Error:     "Archive not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Archive does not exist or is directory: %s", source)),
+		}
+	}
+
+	return f, info, format, nil
+}
+
+func (p *PublicFilesService) ListArchive(archivePath, dir string, pageVal, limitVal int) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+	f, info, format, errResp := p.openArchive(archivePath)
+	if errResp != nil {
+		return nil, errResp
+	}
+	defer f.Close()
+
+	members, err := readArchiveMembers(f, info.Size(), format)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read archive: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	prefix := ""
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		normalized, ok := normalizeArchiveName(dir)
+		if !ok {
+			return nil, &dtos.ErrorResponse{
+				Error:     "Invalid archive directory",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("Invalid dir: %s", dir)),
+			}
+		}
+		prefix = normalized + "/"
+	}
+
+	archiveRel, _ := filepath.Rel(p.publicDir, f.Name())
+	children := make(map[string]*dtos.FileSystemItem)
+
+	for _, member := range members {
+		name, ok := normalizeArchiveName(member.Name)
+		if !ok || isHiddenArchiveName(name) || !strings.HasPrefix(name, prefix) || name == strings.TrimSuffix(prefix, "/") {
+			continue
+		}
+
+		rest := strings.TrimPrefix(name, prefix)
+		childName, _, nested := strings.Cut(rest, "/")
+		childPath := prefix + childName
+		isDir := member.IsDir || nested
+
+		if existing, ok := children[childPath]; ok {
+			if isDir {
+				existing.IsDir = true
+			}
+			continue
+		}
+
+		item := &dtos.FileSystemItem{
+			ID:    p.generateUUID(archiveRel + "!" + childPath),
+			Name:  childName,
+			Path:  childPath,
+			IsDir: isDir,
+		}
+		if !nested {
+			modTime := member.Modified.Unix()
+			item.ModifiedAt = &modTime
+			if !isDir {
+				item.Size = member.Size
+				mimeType := mime.TypeByExtension(path.Ext(childName))
+				if mimeType == "" {
+					mimeType = "application/octet-stream"
+				}
+				item.MimeType = &mimeType
+			}
+		}
+		item.Etag = p.generateEtag(archiveRel+"!"+childPath, item.ModifiedAt, item.Size)
+		children[childPath] = item
+	}
+
+	items := make([]dtos.FileSystemItem, 0, len(children))
+	for _, item := range children {
+		items = append(items, *item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].IsDir != items[j].IsDir {
+			return items[i].IsDir
+		}
+		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+	})
+
+	return paginateItems(items, pageVal, limitVal, 100), nil
+}
+
+func (p *PublicFilesService) OpenArchiveEntry(archivePath, entry string) (io.ReadCloser, *dtos.FileSystemItem, *dtos.ErrorResponse) {
+	name, ok := normalizeArchiveName(entry)
+	if !ok || isHiddenArchiveName(name) {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     "Invalid archive entry",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Invalid entry: %s", entry)),
+		}
+	}
+
+	f, info, format, errResp := p.openArchive(archivePath)
+	if errResp != nil {
+		return nil, nil, errResp
+	}
+
+	rc, member, err := openArchiveEntry(f, info.Size(), format, name)
+	if err != nil {
+		f.Close()
+		if os.IsNotExist(err) {
+			return nil, nil, &dtos.ErrorResponse{
+				Error:     "Archive entry not found",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("Entry does not exist or is not a file: %s", name)),
+			}
+		}
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read archive: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	archiveRel, _ := filepath.Rel(p.publicDir, f.Name())
+	modTime := member.Modified.Unix()
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	body := struct {
+		io.Reader
+		io.Closer
+	}{rc, closerFunc(func() {
+		rc.Close()
+		f.Close()
+	})}
+
+	return body, &dtos.FileSystemItem{
+		ID:         p.generateUUID(archiveRel + "!" + name),
+		Name:       path.Base(name),
+		Path:       name,
+		Size:       member.Size,
+		ModifiedAt: &modTime,
+		MimeType:   &mimeType,
+		Etag:       p.generateEtag(archiveRel+"!"+name, &modTime, member.Size),
+	}, nil
+}
@@ -0,0 +1,288 @@
+package publicfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestZip(t *testing.T, path string, entries map[string]string) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		w.Write([]byte(content))
+	}
+	assert.NoError(t, zw.Close())
+}
+
+func writeTestTarGz(t *testing.T, path string, entries map[string]string) {
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range entries {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+}
+
+func waitForJob(t *testing.T, service *PublicFilesService, id string) *Job {
+	job, ok := service.jobs.get(id)
+	assert.True(t, ok)
+	<-job.done
+	return job
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	format, stem := detectArchiveFormat("backup.TAR.GZ")
+	assert.Equal(t, archiveTarGz, format)
+	assert.Equal(t, "backup", stem)
+
+	format, _ = detectArchiveFormat("notes.txt")
+	assert.Equal(t, archiveUnknown, format)
+}
+
+func TestExtractArchive_Zip(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestZip(t, filepath.Join(tmpDir, "project.zip"), map[string]string{
+		"README.md":   "hello",
+		"src/main.go": "package main",
+		".git/HEAD":   "ref",
+	})
+
+	result, errResp := service.ExtractArchive("project.zip", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, "project", result["destination"])
+
+	job := waitForJob(t, service, result["job_id"].(string))
+	status := job.snapshot()
+	assert.Equal(t, jobStatusCompleted, status.Status)
+	assert.Equal(t, int64(2), status.Result["files_count"])
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "project", "src", "main.go"))
+	assert.Equal(t, "package main", string(data))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "project", ".git", "HEAD"))
+}
+
+func TestExtractArchive_ZipSlip(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestZip(t, filepath.Join(tmpDir, "evil.zip"), map[string]string{
+		"ok.txt":           "fine",
+		"../../escape.txt": "pwned",
+	})
+
+	result, errResp := service.ExtractArchive("evil.zip", "out")
+	assert.Nil(t, errResp)
+
+	job := waitForJob(t, service, result["job_id"].(string))
+	status := job.snapshot()
+	assert.Equal(t, jobStatusFailed, status.Status)
+	assert.Contains(t, *status.Error, "unsafe entry name")
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(tmpDir), "escape.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "out", "ok.txt"))
+}
+
+func TestExtractArchive_FailureKeepsExistingFiles(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "out"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "out", "keep.txt"), []byte("mine"), 0644)
+
+	f, err := os.Create(filepath.Join(tmpDir, "evil.zip"))
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"keep.txt", "new/added.txt", "../../escape.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte("from archive"))
+	}
+	assert.NoError(t, zw.Close())
+	f.Close()
+
+	result, errResp := service.ExtractArchive("evil.zip", "out")
+	assert.Nil(t, errResp)
+
+	job := waitForJob(t, service, result["job_id"].(string))
+	assert.Equal(t, jobStatusFailed, job.snapshot().Status)
+	assert.FileExists(t, filepath.Join(tmpDir, "out", "keep.txt"))
+	assert.NoDirExists(t, filepath.Join(tmpDir, "out", "new"))
+}
+
+func TestExtractArchive_TarGz(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestTarGz(t, filepath.Join(tmpDir, "data.tar.gz"), map[string]string{
+		"a.txt":     "alpha",
+		"dir/b.txt": "beta",
+	})
+
+	result, errResp := service.ExtractArchive("data.tar.gz", "unpacked")
+	assert.Nil(t, errResp)
+
+	job := waitForJob(t, service, result["job_id"].(string))
+	assert.Equal(t, jobStatusCompleted, job.snapshot().Status)
+	assert.FileExists(t, filepath.Join(tmpDir, "unpacked", "a.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "unpacked", "dir", "b.txt"))
+}
+
+func TestExtractArchive_QuotaExceeded(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestTarGz(t, filepath.Join(tmpDir, "big.tar.gz"), map[string]string{
+		"big.txt": string(make([]byte, 64*1024)),
+	})
+	info, _ := os.Stat(filepath.Join(tmpDir, "big.tar.gz"))
+	service.SetStorageQuota(info.Size() + 1024)
+
+	result, errResp := service.ExtractArchive("big.tar.gz", "big")
+	assert.Nil(t, errResp)
+
+	job := waitForJob(t, service, result["job_id"].(string))
+	status := job.snapshot()
+	assert.Equal(t, jobStatusFailed, status.Status)
+	assert.Contains(t, *status.Error, "quota")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "big", "big.txt"))
+}
+
+func TestExtractArchive_UnsupportedFormat(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("text"), 0644)
+
+	_, errResp := service.ExtractArchive("notes.txt", "")
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "Unsupported archive format")
+}
+
+func TestGetJob_NotFound(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.GetJob("missing")
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "Job not found")
+}
+
+func TestListArchive(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestZip(t, filepath.Join(tmpDir, "bundle.zip"), map[string]string{
+		"top.txt":          "top",
+		"docs/guide.md":    "guide",
+		"docs/img/a.png":   "png",
+		"../../escape.txt": "x",
+		".axolotl/meta":    "x",
+		"docs/.env":        "x",
+	})
+
+	items, errResp := service.ListArchive("bundle.zip", "", 1, 10)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(2), items.Total)
+	assert.Equal(t, "docs", items.Items[0].Name)
+	assert.True(t, items.Items[0].IsDir)
+	assert.Equal(t, "top.txt", items.Items[1].Name)
+
+	items, errResp = service.ListArchive("bundle.zip", "docs", 1, 10)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(2), items.Total)
+	assert.Equal(t, "docs/img", items.Items[0].Path)
+	assert.Equal(t, "docs/guide.md", items.Items[1].Path)
+	assert.Equal(t, int64(5), items.Items[1].Size)
+
+	_, _, errResp = service.OpenArchiveEntry("bundle.zip", "docs/.env")
+	assert.NotNil(t, errResp)
+}
+
+func TestOpenArchiveEntry_TarGz(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	writeTestTarGz(t, filepath.Join(tmpDir, "logs.tgz"), map[string]string{
+		"2026/app.log": "line one",
+	})
+
+	body, item, errResp := service.OpenArchiveEntry("logs.tgz", "2026/app.log")
+	assert.Nil(t, errResp)
+	defer body.Close()
+
+	data, _ := io.ReadAll(body)
+	assert.Equal(t, "line one", string(data))
+	assert.Equal(t, "app.log", item.Name)
+
+	_, _, errResp = service.OpenArchiveEntry("logs.tgz", "../2026/app.log")
+	assert.NotNil(t, errResp)
+
+	_, _, errResp = service.OpenArchiveEntry("logs.tgz", "missing.log")
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "not found")
+}
+
+func TestListArchive_ZipTooManyEntries(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	entries := make(map[string]string, maxArchiveEntries+1)
+	for i := 0; i <= maxArchiveEntries; i++ {
+		entries[fmt.Sprintf("f%d.txt", i)] = ""
+	}
+	writeTestZip(t, filepath.Join(tmpDir, "many.zip"), entries)
+
+	_, errResp := service.ListArchive("many.zip", "", 1, 10)
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "too many entries")
+}
+
+func TestOpenArchiveEntry_ZipServesDeclaredSize(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	// The header claims 4 bytes for an entry that stores 11.
+	content := []byte("hello world")
+	f, err := os.Create(filepath.Join(tmpDir, "lying.zip"))
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "note.txt",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(len(content)),
+		UncompressedSize64: 4,
+	})
+	assert.NoError(t, err)
+	w.Write(content)
+	assert.NoError(t, zw.Close())
+	f.Close()
+
+	body, item, errResp := service.OpenArchiveEntry("lying.zip", "note.txt")
+	assert.Nil(t, errResp)
+	defer body.Close()
+
+	data, _ := io.ReadAll(body)
+	assert.Equal(t, "hell", string(data))
+	assert.Equal(t, int64(4), item.Size)
+}
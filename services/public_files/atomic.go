@@ -0,0 +1,191 @@
+package publicfiles
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stagingPrefix marks in-flight writes. The leading dot keeps staging files
+// out of listings, search results and the read/write path sanitizers.
+const stagingPrefix = ".axolotl-staging-"
+
+// createStagingFile opens a hidden temporary file in the directory of target
+// so that the final rename never crosses a filesystem boundary.
+func createStagingFile(target string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), stagingPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	return f, nil
+}
+
+// commitStagingFile flushes a staging file to disk and renames it over
+// target. Readers observe either the previous content or the complete new
+// content, never a partial write.
+func commitStagingFile(f *os.File, target string, perm os.FileMode) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(f.Name(), target); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	syncDir(filepath.Dir(target))
+	return nil
+}
+
+// discardStagingFile abandons an in-flight write, leaving target untouched.
+func discardStagingFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// syncDir persists a rename. Not every platform supports syncing
+// directories, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// createStagingDir creates a hidden directory next to target in which a
+// replacement for target is built. The replacement goes to stagedItem inside
+// it; commitStagedItem swaps it in and removes the directory.
+func createStagingDir(target string) (string, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(target), stagingPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	return dir, nil
+}
+
+func stagedItem(stagingDir string) string {
+	return filepath.Join(stagingDir, "new")
+}
+
+// commitStagedItem replaces target with the item staged in stagingDir and
+// removes the directory.
+func commitStagedItem(stagingDir, target string) error {
+	return swapIntoPlace(stagedItem(stagingDir), target, stagingDir)
+}
+
+// swapIntoPlace renames source over target. The previous target is moved
+// aside into stagingDir first and only deleted, together with stagingDir,
+// once source is in place, so a failed swap puts it back.
+func swapIntoPlace(source, target, stagingDir string) error {
+	previous := filepath.Join(stagingDir, "old")
+	if err := os.Rename(target, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move previous item aside: %w", err)
+	}
+	if err := os.Rename(source, target); err != nil {
+		os.Rename(previous, target)
+		return fmt.Errorf("failed to move item into place: %w", err)
+	}
+	os.RemoveAll(stagingDir)
+	syncDir(filepath.Dir(target))
+	return nil
+}
+
+func writeFileAtomic(target string, data []byte, perm os.FileMode) error {
+	f, err := createStagingFile(target)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		discardStagingFile(f)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return commitStagingFile(f, target, perm)
+}
+
+// writeStream copies r into target without buffering it in memory and
+// refuses to store more than limit bytes. The data is staged and only
+// replaces target once it has been written completely. When mismatches are
+// blocked, content that contradicts the extension of target is refused
+// before anything is written.
+func (p *PublicFilesService) writeStream(target string, r io.Reader, limit int64) (int64, error) {
+	src := io.Reader(&readErrorReader{io.LimitReader(r, limit+1)})
+	if p.blockMismatches {
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(src, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		head = head[:n]
+		if reason, mismatch := contentMismatch(filepath.Base(target), sniffContentType(head)); mismatch {
+			return 0, fmt.Errorf("%w: %s", errContentMismatch, reason)
+		}
+		src = io.MultiReader(bytes.NewReader(head), src)
+	}
+
+	f, err := createStagingFile(target)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, src)
+	if err == nil && n > limit {
+		err = errStreamTooLarge
+	}
+	if err != nil {
+		discardStagingFile(f)
+		return 0, err
+	}
+
+	if err := commitStagingFile(f, target, 0644); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SweepStagingFiles removes staging files and directories left behind by
+// writes that were interrupted by a crash or restart. It is meant to run once at startup,
+// before any request is served.
+func (p *PublicFilesService) SweepStagingFiles() (int, error) {
+	if _, err := os.Stat(p.publicDir); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	removed := 0
+	err := filepath.WalkDir(p.publicDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), stagingPrefix) {
+			return nil
+		}
+		if d.IsDir() {
+			if err := os.RemoveAll(path); err == nil {
+				removed++
+			}
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			if err := os.Remove(path); err == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
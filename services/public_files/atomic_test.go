@@ -0,0 +1,80 @@
+package publicfiles
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadFile_FailureKeepsPreviousContent(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	target := filepath.Join(tmpDir, "report.txt")
+	os.WriteFile(target, []byte("original"), 0644)
+
+	_, errResp := service.UploadFile("report.txt", &failingReader{data: []byte("partial")}, "")
+
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "Failed to read chunk")
+
+	data, _ := os.ReadFile(target)
+	assert.Equal(t, "original", string(data))
+
+	entries, _ := os.ReadDir(tmpDir)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	target := filepath.Join(tmpDir, "nested", "file.txt")
+
+	assert.NoError(t, writeFileAtomic(target, []byte("hello"), 0644))
+
+	data, _ := os.ReadFile(target)
+	assert.Equal(t, "hello", string(data))
+
+	info, _ := os.Stat(target)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	entries, _ := os.ReadDir(filepath.Dir(target))
+	assert.Len(t, entries, 1)
+}
+
+func TestSweepStagingFiles(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, stagingPrefix+"123"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "docs", stagingPrefix+"456"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "docs", "keep.txt"), []byte("x"), 0644)
+
+	removed, err := service.SweepStagingFiles()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.FileExists(t, filepath.Join(tmpDir, "docs", "keep.txt"))
+
+	filepath.WalkDir(tmpDir, func(path string, d os.DirEntry, err error) error {
+		assert.False(t, strings.HasPrefix(d.Name(), stagingPrefix))
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// itemID returns the persistent ID of the item at rel, which may use either
+// path separator.
+func (p *PublicFilesService) itemID(rel string) string {
+	id, err := p.catalog.id(filepath.ToSlash(rel))
+	if err != nil {
+		log.Warn().Err(err).Str("path", rel).Msg("Failed to persist item ID")
+	}
+	return id
+}
+
+// resolveID returns the current path of the item with the given ID.
+func (p *PublicFilesService) resolveID(id string) (string, *dtos.ErrorResponse) {
+	rel, ok := p.catalog.lookup(id)
+	if ok {
+		if _, err := os.Stat(filepath.Join(p.publicDir, filepath.FromSlash(rel))); err == nil {
+			return rel, nil
+		}
+	}
+	return "", itemNotFound(id)
+}
+
+func itemNotFound(id string) *dtos.ErrorResponse {
+	return &dtos.ErrorResponse{
+		Error:     "Item not found",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: uuid.New().String(),
+		Debug:     ptrString(fmt.Sprintf("No item with ID %s", id)),
+	}
+}
+
+// GetItemByID returns the file or folder with the given ID wherever it is
+// now.
+func (p *PublicFilesService) GetItemByID(id string) (*dtos.FileSystemItem, *dtos.ErrorResponse) {
+	rel, errResp := p.resolveID(id)
+	if errResp != nil {
+		return nil, errResp
+	}
+	item, ok := p.itemAt(rel)
+	if !ok {
+		return nil, itemNotFound(id)
+	}
+	return &item, nil
+}
+
+// MoveItemByID moves the item with the given ID to destination, like
+// MoveFile.
+func (p *PublicFilesService) MoveItemByID(id, destination, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	rel, errResp := p.resolveID(id)
+	if errResp != nil {
+		return nil, errResp
+	}
+	return p.MoveFile(rel, destination, onConflict, lockTokens...)
+}
+
+// RenameItemByID gives the item with the given ID a new name in its
+// current folder.
+func (p *PublicFilesService) RenameItemByID(id, name, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	rel, errResp := p.resolveID(id)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if name == "" || name == "." || strings.ContainsAny(name, "/\\") {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Invalid name",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Name must be a single path element: %q", name)),
+		}
+	}
+	return p.RenameFile(rel, path.Join(path.Dir(rel), name), onConflict, lockTokens...)
+}
+
+// DeleteItemByID deletes the item with the given ID, like DeleteItem.
+func (p *PublicFilesService) DeleteItemByID(id string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	rel, errResp := p.resolveID(id)
+	if errResp != nil {
+		return nil, errResp
+	}
+	return p.DeleteItem(rel, lockTokens...)
+}
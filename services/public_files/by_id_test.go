@@ -0,0 +1,139 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func listedID(t *testing.T, service *PublicFilesService, dir, name string) string {
+	result, errResp := service.ListItems(dir, 1, 100, dtos.ListOptions{})
+	if !assert.Nil(t, errResp) {
+		return ""
+	}
+	for _, item := range result.Items {
+		if item.Name == name {
+			return item.ID
+		}
+	}
+	t.Fatalf("%s not listed in %q", name, dir)
+	return ""
+}
+
+func TestItemIDs_SurviveRenameAndMove(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "docs", "a.txt"), []byte("a"), 0644)
+
+	folderID := listedID(t, service, "", "docs")
+	fileID := listedID(t, service, "docs", "a.txt")
+	assert.NotEqual(t, service.generateUUID("docs/a.txt"), fileID)
+
+	result, errResp := service.RenameFile("docs/a.txt", "docs/b.txt", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, fileID, result["id"])
+	_, errResp = service.MoveFolder("docs", "archive/docs", "")
+	assert.Nil(t, errResp)
+
+	assert.Equal(t, folderID, listedID(t, service, "archive", "docs"))
+	assert.Equal(t, fileID, listedID(t, service, "archive/docs", "b.txt"))
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	item, errResp := restarted.GetItemByID(fileID)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "archive/docs/b.txt", filepath.ToSlash(item.Path))
+	assert.Equal(t, fileID, item.ID)
+}
+
+func TestItemIDs_ByIDOperations(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "report.pdf"), []byte("x"), 0644)
+	id := listedID(t, service, "", "report.pdf")
+
+	_, errResp := service.MoveItemByID(id, "reports/report.pdf", "")
+	assert.Nil(t, errResp)
+	_, errResp = service.RenameItemByID(id, "q1.pdf", "")
+	assert.Nil(t, errResp)
+	_, errResp = service.RenameItemByID(id, "../q1.pdf", "")
+	assert.NotNil(t, errResp)
+
+	item, errResp := service.GetItemByID(id)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "reports/q1.pdf", filepath.ToSlash(item.Path))
+
+	_, errResp = service.DeleteItemByID(id)
+	assert.Nil(t, errResp)
+	_, errResp = service.GetItemByID(id)
+	assert.NotNil(t, errResp)
+	assert.Equal(t, "Item not found", errResp.Error)
+
+	os.WriteFile(filepath.Join(tmpDir, "reports", "q1.pdf"), []byte("new"), 0644)
+	assert.NotEqual(t, id, listedID(t, service, "reports", "q1.pdf"))
+}
+
+func TestCatalog_JournalAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	c := newCatalog(dir)
+
+	a, err := c.id("a")
+	assert.NoError(t, err)
+	b, err := c.id("dir/b")
+	assert.NoError(t, err)
+	c.move("dir", "moved")
+	c.removePrefix("a")
+	assert.FileExists(t, filepath.Join(dir, catalogJournalFile))
+	assert.NoFileExists(t, filepath.Join(dir, catalogFile))
+
+	reloaded := newCatalog(dir)
+	_, ok := reloaded.lookup(a)
+	assert.False(t, ok)
+	rel, ok := reloaded.lookup(b)
+	assert.True(t, ok)
+	assert.Equal(t, "moved/b", rel)
+
+	reloaded.mu.Lock()
+	assert.NoError(t, reloaded.compactLocked())
+	reloaded.mu.Unlock()
+	assert.NoFileExists(t, filepath.Join(dir, catalogJournalFile))
+
+	os.WriteFile(filepath.Join(dir, catalogJournalFile), []byte(`{"seq":5,"op":"id","path":"c","id":"c-id"}`+"\n"+`{"op":"id","pa`), 0644)
+	reloaded = newCatalog(dir)
+	rel, _ = reloaded.lookup(b)
+	assert.Equal(t, "moved/b", rel)
+	rel, _ = reloaded.lookup("c-id")
+	assert.Equal(t, "c", rel)
+}
+
+func TestCatalog_SnapshotSkipsSurvivingJournal(t *testing.T) {
+	dir := t.TempDir()
+	c := newCatalog(dir)
+
+	x, _ := c.id("x")
+	c.mu.Lock()
+	assert.NoError(t, c.compactLocked())
+	c.mu.Unlock()
+	c.move("x", "y")
+	c.id("x")
+	journal, err := os.ReadFile(filepath.Join(dir, catalogJournalFile))
+	assert.NoError(t, err)
+
+	// A crash between writing the snapshot and removing the journal.
+	c.mu.Lock()
+	assert.NoError(t, c.compactLocked())
+	c.mu.Unlock()
+	os.WriteFile(filepath.Join(dir, catalogJournalFile), journal, 0644)
+
+	reloaded := newCatalog(dir)
+	rel, ok := reloaded.lookup(x)
+	assert.True(t, ok)
+	assert.Equal(t, "y", rel)
+	id, _ := reloaded.id("x")
+	assert.NotEqual(t, x, id)
+}
@@ -0,0 +1,266 @@
+package publicfiles
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	catalogFile        = "catalog.json"
+	catalogJournalFile = "catalog.log"
+
+	// The journal is folded into the snapshot once it holds this many
+	// operations, or as many as the catalog has entries if that is more.
+	catalogCompactMin = 4096
+)
+
+// Journal operations.
+const (
+	catalogOpAssign = "id"
+	catalogOpMove   = "move"
+	catalogOpRemove = "remove"
+)
+
+// catalogOp is one line of the catalog journal. Seq numbers operations in
+// the order they were made.
+type catalogOp struct {
+	Seq  int64  `json:"seq"`
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	To   string `json:"to,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// catalogSnapshot is the stored catalog. It includes the operations up to
+// Seq, so journal entries up to Seq are not replayed over it.
+type catalogSnapshot struct {
+	Seq int64             `json:"seq"`
+	IDs map[string]string `json:"ids"`
+}
+
+// catalog assigns persistent IDs to files and folders. IDs are handed out on
+// first use and follow their item through renames and moves made through
+// the service, so state keyed by ID survives them. Items moved behind the
+// service's back get a new ID.
+//
+// The catalog is stored under the metadata directory as a JSON snapshot
+// from path to ID plus a journal of the operations since, so that handing
+// out an ID appends a line instead of rewriting the snapshot. It is loaded
+// on first use.
+type catalog struct {
+	dir  string
+	once sync.Once
+
+	mu      sync.RWMutex
+	ids     map[string]string
+	paths   map[string]string
+	seq     int64
+	journal int
+}
+
+func newCatalog(dir string) *catalog {
+	return &catalog{dir: dir, ids: make(map[string]string), paths: make(map[string]string)}
+}
+
+func (c *catalog) load() {
+	c.once.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join(c.dir, catalogFile))
+		if err == nil {
+			var snapshot catalogSnapshot
+			if err := json.Unmarshal(data, &snapshot); err != nil {
+				log.Warn().Err(err).Msg("Failed to parse catalog")
+			} else if snapshot.IDs != nil {
+				c.ids, c.seq = snapshot.IDs, snapshot.Seq
+			}
+		} else if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msg("Failed to read catalog")
+		}
+		for rel, id := range c.ids {
+			c.paths[id] = rel
+		}
+
+		f, err := os.Open(filepath.Join(c.dir, catalogJournalFile))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn().Err(err).Msg("Failed to read catalog journal")
+			}
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var op catalogOp
+			if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+				// A crash can leave a torn last line; everything before it
+				// is intact.
+				log.Warn().Err(err).Msg("Ignoring the rest of the catalog journal")
+				break
+			}
+			if op.Seq <= c.seq {
+				continue
+			}
+			c.applyLocked(op)
+			c.seq = op.Seq
+			c.journal++
+		}
+	})
+}
+
+func (c *catalog) applyLocked(op catalogOp) {
+	switch op.Op {
+	case catalogOpAssign:
+		if old, ok := c.ids[op.Path]; ok {
+			delete(c.paths, old)
+		}
+		c.ids[op.Path] = op.ID
+		c.paths[op.ID] = op.Path
+	case catalogOpMove:
+		moved := make(map[string]string)
+		for rel, id := range c.ids {
+			if underPrefix(rel, op.Path) {
+				moved[op.To+strings.TrimPrefix(rel, op.Path)] = id
+				delete(c.ids, rel)
+			}
+		}
+		for rel, id := range moved {
+			if old, ok := c.ids[rel]; ok {
+				delete(c.paths, old)
+			}
+			c.ids[rel] = id
+			c.paths[id] = rel
+		}
+	case catalogOpRemove:
+		for rel, id := range c.ids {
+			if underPrefix(rel, op.Path) {
+				delete(c.ids, rel)
+				delete(c.paths, id)
+			}
+		}
+	}
+}
+
+// id returns the ID of rel, assigning a new one if it has none yet. The ID
+// is returned even if it could not be persisted.
+func (c *catalog) id(rel string) (string, error) {
+	c.load()
+	c.mu.RLock()
+	id, ok := c.ids[rel]
+	c.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := c.ids[rel]; ok {
+		return id, nil
+	}
+	op := catalogOp{Op: catalogOpAssign, Path: rel, ID: uuid.NewString()}
+	return op.ID, c.commitLocked(op)
+}
+
+// lookup returns the current path of id.
+func (c *catalog) lookup(id string) (string, bool) {
+	c.load()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rel, ok := c.paths[id]
+	return rel, ok
+}
+
+// removePrefix forgets the IDs of prefix and of everything below it.
+func (c *catalog) removePrefix(prefix string) {
+	c.update(catalogOp{Op: catalogOpRemove, Path: prefix}, prefix)
+}
+
+// move re-keys the IDs at or below oldPrefix to newPrefix.
+func (c *catalog) move(oldPrefix, newPrefix string) {
+	c.update(catalogOp{Op: catalogOpMove, Path: oldPrefix, To: newPrefix}, oldPrefix)
+}
+
+// update applies op and journals it if any ID at or below prefix is
+// affected.
+func (c *catalog) update(op catalogOp, prefix string) {
+	c.load()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	affected := false
+	for rel := range c.ids {
+		if underPrefix(rel, prefix) {
+			affected = true
+			break
+		}
+	}
+	if !affected {
+		return
+	}
+	c.commitLocked(op)
+}
+
+// commitLocked numbers op, applies it and journals it.
+func (c *catalog) commitLocked(op catalogOp) error {
+	c.seq++
+	op.Seq = c.seq
+	c.applyLocked(op)
+	return c.appendLocked(op)
+}
+
+func (c *catalog) appendLocked(op catalogOp) error {
+	line, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(c.dir, catalogJournalFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to open catalog journal")
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to write catalog journal")
+		return err
+	}
+
+	c.journal++
+	if c.journal >= max(catalogCompactMin, len(c.ids)) {
+		return c.compactLocked()
+	}
+	return nil
+}
+
+// compactLocked writes a snapshot and starts a new journal. Moves do not
+// commute with later operations, so a journal that survives a crash in
+// between must not be replayed over the snapshot; the snapshot's sequence
+// number makes loading skip it.
+func (c *catalog) compactLocked() error {
+	data, err := json.Marshal(catalogSnapshot{Seq: c.seq, IDs: c.ids})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, catalogFile), data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to save catalog")
+		return err
+	}
+	if err := os.Remove(filepath.Join(c.dir, catalogJournalFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to reset catalog journal: %w", err)
+	}
+	c.journal = 0
+	return nil
+}
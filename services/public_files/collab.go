@@ -0,0 +1,593 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Collaborative editing lets several clients edit one of the editable text
+// files at the same time over the WebSocket hub. The server keeps the
+// authoritative copy of each open document and numbers its changes with
+// revisions. Clients send operations (see textop.go) based on the last
+// revision they saw; the server transforms them past the operations that
+// came in since, applies them and forwards them to the other participants.
+//
+// Client messages, all with a "path":
+//
+//	doc_join                                      open the document
+//	doc_leave                                     close it
+//	doc_op      {revision, ops}                   change it
+//	doc_cursor  {revision, position, anchor}      move the cursor or selection
+//
+// Server messages:
+//
+//	doc_joined  {revision, content, participants} the document as of revision
+//	doc_ack     {revision}                        the client's op became revision
+//	doc_op      {revision, ops, client_id}        another participant's op
+//	doc_cursor  {revision, client_id, position, anchor}
+//	doc_participant_joined / doc_participant_left {client_id}
+//	doc_saved   {revision, etag}                  the document was written to disk
+//	doc_moved   {to}                              the file was renamed or moved
+//	doc_closed  {reason}                          the file is gone
+//	doc_error   {error}                           a message was rejected or the
+//	                                              document could not be saved
+//
+// Documents are written through the normal edit path every
+// collabSaveInterval while they have unsaved changes, and when the last
+// participant leaves. While someone else holds a lock on the file, changes
+// stay unsaved and the session stays open until the lock is gone.
+
+const (
+	collabSaveInterval = 5 * time.Second
+
+	// maxCollabHistory is how many operations a document keeps to transform
+	// late operations against. Clients further behind have to rejoin.
+	maxCollabHistory = 1000
+)
+
+type collabManager struct {
+	p *PublicFilesService
+
+	mu       sync.Mutex
+	sessions map[string]*collabSession
+}
+
+// collabCursor is a participant's cursor; anchor is the other end of the
+// selection, equal to position when nothing is selected.
+type collabCursor struct {
+	position int
+	anchor   int
+}
+
+// collabSession is an open document. mu is taken after collabManager.mu or
+// saveMu and is never held while the file is written.
+type collabSession struct {
+	m *collabManager
+
+	// saveMu orders writes of the document to disk.
+	saveMu sync.Mutex
+
+	mu           sync.Mutex
+	path         string
+	text         []rune
+	base         int
+	history      []textOp
+	participants map[*Client]*collabCursor
+	dirty        bool
+	closed       bool
+	stop         chan struct{}
+
+	// saveBlocked is set once participants were told that the document
+	// cannot be saved because the file is locked.
+	saveBlocked bool
+}
+
+// docMessage is the data of a collaborative editing message.
+type docMessage struct {
+	Path     string        `json:"path"`
+	Revision int           `json:"revision"`
+	Ops      []interface{} `json:"ops"`
+	Position int           `json:"position"`
+	Anchor   *int          `json:"anchor"`
+}
+
+func newCollabManager(p *PublicFilesService) *collabManager {
+	return &collabManager{p: p, sessions: make(map[string]*collabSession)}
+}
+
+// register installs the collaborative editing messages on hub.
+func (m *collabManager) register(hub *WebSocketHub) {
+	hub.Handle("doc_join", m.handle(m.join))
+	hub.Handle("doc_leave", m.handle(m.leave))
+	hub.Handle("doc_op", m.handle(m.op))
+	hub.Handle("doc_cursor", m.handle(m.cursor))
+	hub.OnDisconnect(m.leaveAll)
+}
+
+// handle decodes the message data for fn and reports its errors back to the
+// client.
+func (m *collabManager) handle(fn func(*Client, docMessage) error) EventHandler {
+	return func(client *Client, msg dtos.WebSocketMessage) {
+		var data docMessage
+		raw, err := json.Marshal(msg.Data)
+		if err == nil {
+			err = json.Unmarshal(raw, &data)
+		}
+		if err == nil {
+			err = fn(client, data)
+		}
+		if err != nil {
+			sendDoc(client, "doc_error", map[string]interface{}{
+				"path":       data.Path,
+				"event_type": msg.EventType,
+				"error":      err.Error(),
+			})
+		}
+	}
+}
+
+func sendDoc(client *Client, eventType string, data map[string]interface{}) bool {
+	return client.trySend(dtos.WebSocketMessage{
+		EventType: eventType,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// resolve validates a document path the way EditFile does and returns the
+// absolute and relative path of the file.
+func (m *collabManager) resolve(path string) (string, string, error) {
+	file, err := m.p.sanitizePathForWrite(path)
+	if err != nil {
+		return "", "", err
+	}
+	ext := strings.TrimPrefix(filepath.Ext(file), ".")
+	if !allowedEditExtensions[ext] {
+		return "", "", fmt.Errorf("file type not editable: .%s", ext)
+	}
+	return file, m.p.relPath(file), nil
+}
+
+// locked fails when the document rel is locked. Participants hold no lock
+// tokens, so a checked-out file cannot be edited collaboratively.
+func (m *collabManager) locked(rel string) error {
+	if blocked := m.p.locks.blocking(rel, nil, false, false); len(blocked) > 0 {
+		return fmt.Errorf("file is locked: %s", rel)
+	}
+	return nil
+}
+
+// get returns the open session of the document rel, if any.
+func (m *collabManager) get(rel string) *collabSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[rel]
+}
+
+// participant returns the session of path that client has joined.
+func (m *collabManager) participant(client *Client, path string) (*collabSession, error) {
+	_, rel, err := m.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	s := m.get(rel)
+	if s != nil {
+		s.mu.Lock()
+		_, ok := s.participants[client]
+		s.mu.Unlock()
+		if ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("document not joined: %s", path)
+}
+
+func (m *collabManager) join(client *Client, data docMessage) error {
+	file, rel, err := m.resolve(data.Path)
+	if err != nil {
+		return err
+	}
+	if err := m.locked(rel); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.sessions[rel]
+	if s == nil {
+		if s, err = m.open(file, rel); err != nil {
+			return err
+		}
+		m.sessions[rel] = s
+		go s.autosave()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.participants[client]; !ok {
+		s.participants[client] = &collabCursor{}
+		s.broadcast(client, "doc_participant_joined", map[string]interface{}{"client_id": client.ID})
+	}
+	participants := make([]map[string]interface{}, 0, len(s.participants))
+	for other, cursor := range s.participants {
+		participants = append(participants, map[string]interface{}{
+			"client_id": other.ID,
+			"position":  cursor.position,
+			"anchor":    cursor.anchor,
+		})
+	}
+	sendDoc(client, "doc_joined", map[string]interface{}{
+		"path":         s.path,
+		"client_id":    client.ID,
+		"revision":     s.revision(),
+		"content":      string(s.text),
+		"participants": participants,
+	})
+	return nil
+}
+
+// open loads the document at file into a new session.
+func (m *collabManager) open(file, rel string) (*collabSession, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", rel)
+	}
+	if info.IsDir() || info.Size() > maxEditSize {
+		return nil, fmt.Errorf("file cannot be edited: %s", rel)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if !utf8.Valid(content) {
+		return nil, fmt.Errorf("file is not valid UTF-8 text: %s", rel)
+	}
+	return &collabSession{
+		m:            m,
+		path:         rel,
+		text:         []rune(string(content)),
+		participants: make(map[*Client]*collabCursor),
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+func (m *collabManager) leave(client *Client, data docMessage) error {
+	_, rel, err := m.resolve(data.Path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	s := m.sessions[rel]
+	idle := s != nil && m.leaveLocked(client, s)
+	m.mu.Unlock()
+	if idle {
+		m.closeIfIdle(s)
+	}
+	return nil
+}
+
+// leaveAll removes a disconnected client from every document it joined.
+func (m *collabManager) leaveAll(client *Client) {
+	var idle []*collabSession
+	m.mu.Lock()
+	for _, s := range m.sessions {
+		if m.leaveLocked(client, s) {
+			idle = append(idle, s)
+		}
+	}
+	m.mu.Unlock()
+	for _, s := range idle {
+		m.closeIfIdle(s)
+	}
+}
+
+// leaveLocked removes client from s and reports whether nobody is left.
+func (m *collabManager) leaveLocked(client *Client, s *collabSession) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.participants[client]; ok {
+		delete(s.participants, client)
+		s.broadcast(nil, "doc_participant_left", map[string]interface{}{"client_id": client.ID})
+	}
+	return len(s.participants) == 0
+}
+
+// closeIfIdle saves s and closes it unless someone joined meanwhile. The
+// session stays registered while it is saved, so that a client joining then
+// does not load the file without the last changes.
+func (m *collabManager) closeIfIdle(s *collabSession) {
+	s.save()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A session with unsaved changes was edited by someone who has left
+	// since; whoever left last saves and closes it.
+	if s.closed || s.dirty || len(s.participants) > 0 || m.sessions[s.path] != s {
+		return
+	}
+	s.closed = true
+	close(s.stop)
+	delete(m.sessions, s.path)
+}
+
+func (m *collabManager) op(client *Client, data docMessage) error {
+	s, err := m.participant(client, data.Path)
+	if err != nil {
+		return err
+	}
+	op, err := parseTextOp(data.Ops)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := m.locked(s.path); err != nil {
+		return err
+	}
+	if err := s.transformLocked(&op, data.Revision); err != nil {
+		return err
+	}
+	if err := s.applyLocked(op); err != nil {
+		return err
+	}
+	sendDoc(client, "doc_ack", map[string]interface{}{
+		"path":     s.path,
+		"revision": s.revision(),
+	})
+	s.broadcast(client, "doc_op", map[string]interface{}{
+		"path":      s.path,
+		"revision":  s.revision(),
+		"ops":       op.wire(),
+		"client_id": client.ID,
+	})
+	return nil
+}
+
+func (m *collabManager) cursor(client *Client, data docMessage) error {
+	s, err := m.participant(client, data.Path)
+	if err != nil {
+		return err
+	}
+	anchor := data.Position
+	if data.Anchor != nil {
+		anchor = *data.Anchor
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data.Revision < s.base || data.Revision > s.revision() {
+		return fmt.Errorf("revision %d is no longer available, rejoin the document", data.Revision)
+	}
+	for _, h := range s.history[data.Revision-s.base:] {
+		data.Position = h.transformPosition(data.Position)
+		anchor = h.transformPosition(anchor)
+	}
+	cursor := s.participants[client]
+	cursor.position = min(max(data.Position, 0), len(s.text))
+	cursor.anchor = min(max(anchor, 0), len(s.text))
+	s.broadcast(client, "doc_cursor", map[string]interface{}{
+		"path":      s.path,
+		"revision":  s.revision(),
+		"client_id": client.ID,
+		"position":  cursor.position,
+		"anchor":    cursor.anchor,
+	})
+	return nil
+}
+
+// removed closes the sessions of documents at or below rel, which no
+// longer exist.
+func (m *collabManager) removed(rel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, s := range m.sessions {
+		if !underPrefix(path, rel) {
+			continue
+		}
+		s.mu.Lock()
+		if !s.closed {
+			s.closed = true
+			close(s.stop)
+		}
+		s.broadcast(nil, "doc_closed", map[string]interface{}{"reason": "deleted"})
+		s.participants = nil
+		s.mu.Unlock()
+		delete(m.sessions, path)
+	}
+}
+
+// moved follows documents at or below oldRel to newRel.
+func (m *collabManager) moved(oldRel, newRel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, s := range m.sessions {
+		if !underPrefix(path, oldRel) {
+			continue
+		}
+		to := newRel + strings.TrimPrefix(path, oldRel)
+		s.mu.Lock()
+		s.broadcast(nil, "doc_moved", map[string]interface{}{"to": to})
+		s.path = to
+		s.mu.Unlock()
+		delete(m.sessions, path)
+		m.sessions[to] = s
+	}
+}
+
+func (s *collabSession) revision() int {
+	return s.base + len(s.history)
+}
+
+// broadcast sends a message to every participant but except. Participants
+// that cannot keep up are dropped, as they would miss operations.
+func (s *collabSession) broadcast(except *Client, eventType string, data map[string]interface{}) {
+	data["path"] = s.path
+	for client := range s.participants {
+		if client == except {
+			continue
+		}
+		if !sendDoc(client, eventType, data) {
+			log.Debug().Str("client", client.ID).Str("path", s.path).Msg("Dropping collaborator that fell behind")
+			delete(s.participants, client)
+		}
+	}
+}
+
+// transformLocked brings op, made at revision, up to the current revision.
+func (s *collabSession) transformLocked(op *textOp, revision int) error {
+	if revision < s.base || revision > s.revision() {
+		return fmt.Errorf("revision %d is no longer available, rejoin the document", revision)
+	}
+	for _, h := range s.history[revision-s.base:] {
+		transformed, _, err := transformTextOps(*op, h)
+		if err != nil {
+			return err
+		}
+		*op = transformed
+	}
+	return nil
+}
+
+// applyLocked applies op, made at the current revision, to the document.
+func (s *collabSession) applyLocked(op textOp) error {
+	text, err := op.apply(s.text)
+	if err != nil {
+		return err
+	}
+	if op.targetLen > op.baseLen && runesSize(text) > maxEditSize {
+		return fmt.Errorf("file content too large (maximum 10MB)")
+	}
+	s.text = text
+	s.history = append(s.history, op)
+	if drop := len(s.history) - maxCollabHistory; drop > 0 {
+		s.history = append([]textOp(nil), s.history[drop:]...)
+		s.base += drop
+	}
+	for _, cursor := range s.participants {
+		cursor.position = op.transformPosition(cursor.position)
+		cursor.anchor = op.transformPosition(cursor.anchor)
+	}
+	s.dirty = true
+	return nil
+}
+
+func runesSize(text []rune) int {
+	size := 0
+	for _, r := range text {
+		size += utf8.RuneLen(r)
+	}
+	return size
+}
+
+// replace sets the document to content on behalf of EditFile, whose caller
+// presented lockTokens. The participants receive the change as an operation
+// and the file is written right away.
+func (s *collabSession) replace(content string, lockTokens []string) (map[string]interface{}, *dtos.ErrorResponse) {
+	if !utf8.ValidString(content) {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File content must be UTF-8 text while the file is edited collaboratively",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString("Content is not valid UTF-8"),
+		}
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	file := filepath.Join(s.m.p.publicDir, filepath.FromSlash(s.path))
+	// Checked again under saveMu so no lock is taken between the check and
+	// the write.
+	if errResp := s.m.p.checkLocks(file, lockTokens, false, false); errResp != nil {
+		s.mu.Unlock()
+		return nil, errResp
+	}
+	op := diffTextOp(s.text, []rune(content))
+	if !op.isNoop() {
+		s.applyLocked(op)
+		s.broadcast(nil, "doc_op", map[string]interface{}{
+			"revision":  s.revision(),
+			"ops":       op.wire(),
+			"client_id": "",
+		})
+	}
+	s.dirty = false
+	s.saveBlocked = false
+	s.mu.Unlock()
+
+	result, errResp := s.m.p.writeEdit(file, content)
+	if errResp != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return result, errResp
+}
+
+// save writes the document to disk if it has unsaved changes. A file that
+// was locked since the changes were made is left alone; the changes stay
+// unsaved and the participants are told once.
+func (s *collabSession) save() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if !s.dirty || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if err := s.m.locked(s.path); err != nil {
+		if !s.saveBlocked {
+			s.saveBlocked = true
+			s.broadcast(nil, "doc_error", map[string]interface{}{
+				"path":  s.path,
+				"error": fmt.Sprintf("changes not saved: %v", err),
+			})
+		}
+		s.mu.Unlock()
+		return
+	}
+	content, revision, rel := string(s.text), s.revision(), s.path
+	s.dirty = false
+	s.saveBlocked = false
+	s.mu.Unlock()
+
+	result, errResp := s.m.p.writeEdit(filepath.Join(s.m.p.publicDir, filepath.FromSlash(rel)), content)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errResp != nil {
+		log.Warn().Str("path", rel).Str("error", errResp.Error).Msg("Failed to save collaborative document")
+		s.dirty = true
+		return
+	}
+	s.broadcast(nil, "doc_saved", map[string]interface{}{
+		"revision": revision,
+		"etag":     result["etag"],
+	})
+}
+
+// autosave saves the document periodically until the session closes.
+func (s *collabSession) autosave() {
+	ticker := time.NewTicker(collabSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.save()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustOp(t *testing.T, raw ...interface{}) textOp {
+	op, err := parseTextOp(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func TestTextOp_ApplyAndWire(t *testing.T) {
+	op := mustOp(t, 6.0, "brave ", -3.0, "new", 7.0)
+	doc, err := op.apply([]rune("hello old world!"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello brave new world!", string(doc))
+	assert.Equal(t, []interface{}{6, "brave new", -3, 7}, op.wire())
+
+	_, err = op.apply([]rune("too short"))
+	assert.ErrorIs(t, err, errOpLength)
+	_, err = parseTextOp([]interface{}{1.5})
+	assert.Error(t, err)
+}
+
+func TestTransformTextOps_Converges(t *testing.T) {
+	doc := []rune("héllo world")
+	cases := [][2]textOp{
+		{mustOp(t, 5.0, ",", 6.0), mustOp(t, 11.0, "!")},
+		{mustOp(t, 2.0, -3.0, 6.0), mustOp(t, 3.0, -4.0, 4.0)},
+		{mustOp(t, 5.0, "A", 6.0), mustOp(t, 5.0, "B", 6.0)},
+		{mustOp(t, -11.0, "new"), mustOp(t, 6.0, "big ", 5.0)},
+	}
+	for _, c := range cases {
+		a, b := c[0], c[1]
+		aPrime, bPrime, err := transformTextOps(a, b)
+		assert.NoError(t, err)
+		afterA, _ := a.apply(doc)
+		afterB, _ := b.apply(doc)
+		ab, err := bPrime.apply(afterA)
+		assert.NoError(t, err)
+		ba, err := aPrime.apply(afterB)
+		assert.NoError(t, err)
+		assert.Equal(t, string(ab), string(ba))
+	}
+
+	aPrime, _, _ := transformTextOps(cases[2][0], cases[2][1])
+	afterB, _ := cases[2][1].apply(doc)
+	result, _ := aPrime.apply(afterB)
+	assert.Equal(t, "hélloAB world", string(result))
+}
+
+func TestTextOp_TransformPositionAndDiff(t *testing.T) {
+	op := mustOp(t, 2.0, "xyz", -2.0, 4.0)
+	assert.Equal(t, 1, op.transformPosition(1))
+	assert.Equal(t, 5, op.transformPosition(2))
+	assert.Equal(t, 5, op.transformPosition(3))
+	assert.Equal(t, 6, op.transformPosition(5))
+
+	from, to := []rune("the quick fox"), []rune("the slow fox")
+	diff := diffTextOp(from, to)
+	result, err := diff.apply(from)
+	assert.NoError(t, err)
+	assert.Equal(t, string(to), string(result))
+	same := diffTextOp(from, from)
+	assert.True(t, same.isNoop())
+}
+
+func testClient(id string) *Client {
+	return &Client{ID: id, Send: make(chan interface{}, 64), Subs: make(map[string]bool)}
+}
+
+// nextDoc returns the next message queued for client, skipping other event
+// types.
+func nextDoc(t *testing.T, client *Client, eventType string) map[string]interface{} {
+	for {
+		select {
+		case msg := <-client.Send:
+			m := msg.(dtos.WebSocketMessage)
+			if m.EventType == eventType {
+				return m.Data.(map[string]interface{})
+			}
+		default:
+			t.Fatalf("no %s message for %s", eventType, client.ID)
+			return nil
+		}
+	}
+}
+
+func TestCollab_ConcurrentEditsConverge(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "notes.md"), []byte("hello world"), 0644)
+
+	m := service.collab
+	alice, bob := testClient("alice"), testClient("bob")
+	assert.NoError(t, m.join(alice, docMessage{Path: "notes.md"}))
+	assert.NoError(t, m.join(bob, docMessage{Path: "notes.md"}))
+	joined := nextDoc(t, bob, "doc_joined")
+	assert.Equal(t, "hello world", joined["content"])
+	assert.Equal(t, 0, joined["revision"])
+
+	// Both edit revision 0 at once.
+	assert.NoError(t, m.op(alice, docMessage{Path: "notes.md", Revision: 0, Ops: []interface{}{5.0, ",", 6.0}}))
+	assert.NoError(t, m.op(bob, docMessage{Path: "notes.md", Revision: 0, Ops: []interface{}{11.0, "!"}}))
+	assert.Equal(t, 1, nextDoc(t, alice, "doc_ack")["revision"])
+	forwarded := nextDoc(t, alice, "doc_op")
+	assert.Equal(t, []interface{}{12, "!"}, forwarded["ops"])
+	assert.Equal(t, "bob", forwarded["client_id"])
+
+	assert.NoError(t, m.cursor(bob, docMessage{Path: "notes.md", Revision: 0, Position: 11}))
+	assert.Equal(t, 13, nextDoc(t, alice, "doc_cursor")["position"])
+
+	assert.Error(t, m.op(bob, docMessage{Path: "notes.md", Revision: 7, Ops: []interface{}{13.0}}))
+	assert.Error(t, m.op(testClient("eve"), docMessage{Path: "notes.md", Ops: []interface{}{"x", 11.0}}))
+	assert.Error(t, m.join(bob, docMessage{Path: "image.png"}))
+
+	assert.NoError(t, m.leave(alice, docMessage{Path: "notes.md"}))
+	assert.NoError(t, m.leave(bob, docMessage{Path: "notes.md"}))
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "notes.md"))
+	assert.Equal(t, "hello, world!", string(content))
+	assert.Nil(t, m.get("notes.md"))
+}
+
+func TestCollab_EditFileGoesThroughSession(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "todo.txt"), []byte("milk\neggs\n"), 0644)
+
+	m := service.collab
+	alice := testClient("alice")
+	assert.NoError(t, m.join(alice, docMessage{Path: "todo.txt"}))
+	assert.NoError(t, m.op(alice, docMessage{Path: "todo.txt", Revision: 0, Ops: []interface{}{10.0, "bread\n"}}))
+
+	_, errResp := service.EditFile("todo.txt", "milk\neggs\nbread\ntea\n")
+	assert.Nil(t, errResp)
+	assert.Equal(t, []interface{}{16, "tea\n"}, nextDoc(t, alice, "doc_op")["ops"])
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "todo.txt"))
+	assert.Equal(t, "milk\neggs\nbread\ntea\n", string(content))
+
+	_, errResp = service.RenameFile("todo.txt", "list.txt", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, "list.txt", nextDoc(t, alice, "doc_moved")["to"])
+	assert.NoError(t, m.op(alice, docMessage{Path: "list.txt", Revision: 2, Ops: []interface{}{-5.0, 15.0}}))
+
+	_, errResp = service.DeleteItem("list.txt")
+	assert.Nil(t, errResp)
+	assert.Equal(t, "deleted", nextDoc(t, alice, "doc_closed")["reason"])
+	assert.Nil(t, m.get("list.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "list.txt"))
+}
+
+func TestCollab_LockedFileIsNotSaved(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "plan.md"), []byte("draft"), 0644)
+
+	m := service.collab
+	alice := testClient("alice")
+	assert.NoError(t, m.join(alice, docMessage{Path: "plan.md"}))
+	assert.NoError(t, m.op(alice, docMessage{Path: "plan.md", Revision: 0, Ops: []interface{}{5.0, "!"}}))
+
+	lock, errResp := service.LockItem("plan.md", dtos.LockRequest{Owner: "bob"})
+	assert.Nil(t, errResp)
+
+	s := m.get("plan.md")
+	s.save()
+	assert.Contains(t, nextDoc(t, alice, "doc_error")["error"], "locked")
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "plan.md"))
+	assert.Equal(t, "draft", string(content))
+
+	// The lock holder can still replace the document.
+	_, errResp = service.EditFile("plan.md", "final", lock.Token)
+	assert.Nil(t, errResp)
+	_, errResp = service.EditFile("plan.md", "other")
+	assert.NotNil(t, errResp)
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "plan.md"))
+	assert.Equal(t, "final", string(content))
+}
@@ -0,0 +1,259 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+// Conflict modes accepted through the on_conflict option.
+const (
+	ConflictFail      = "fail"
+	ConflictOverwrite = "overwrite"
+	ConflictKeepBoth  = "keep-both"
+	ConflictSkip      = "skip"
+	ConflictMerge     = "merge"
+)
+
+const maxKeepBothAttempts = 10000
+
+// parseConflictMode validates a client supplied mode. An empty mode selects
+// fallback, which keeps each operation's historical behaviour.
+func parseConflictMode(mode, fallback string, allowMerge bool) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		return fallback, nil
+	}
+
+	switch mode {
+	case ConflictFail, ConflictOverwrite, ConflictKeepBoth, ConflictSkip:
+		return mode, nil
+	case ConflictMerge:
+		if allowMerge {
+			return mode, nil
+		}
+		return "", fmt.Errorf("on_conflict=merge is only supported for folders")
+	}
+	return "", fmt.Errorf("invalid on_conflict value: %s (expected fail, overwrite, keep-both, skip or merge)", mode)
+}
+
+// resolveConflict decides where an item should be written when target may
+// already exist. It returns the final path and whether the operation should
+// be skipped entirely. In the modes that must not replace anything, the
+// final path is claimed with a placeholder (see claimName), which the caller
+// releases with releaseClaim if the operation fails.
+func resolveConflict(target string, sourceIsDir bool, mode, kind string) (string, bool, error) {
+	for {
+		info, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			if !claimsName(mode) {
+				return target, false, nil
+			}
+			err = claimName(target, sourceIsDir)
+			if err == nil {
+				return target, false, nil
+			}
+			if os.IsExist(err) {
+				// Something appeared since the check; decide again.
+				continue
+			}
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("failed to inspect destination: %v", err)
+		}
+
+		switch mode {
+		case ConflictSkip:
+			return target, true, nil
+		case ConflictOverwrite:
+			return target, false, nil
+		case ConflictKeepBoth:
+			candidate, err := availableName(target, sourceIsDir)
+			if err != nil {
+				return "", false, err
+			}
+			return candidate, false, nil
+		case ConflictMerge:
+			if sourceIsDir && info.IsDir() {
+				return target, false, nil
+			}
+			return "", false, fmt.Errorf("Cannot merge into existing file")
+		}
+		return "", false, fmt.Errorf("Destination %s already exists", kind)
+	}
+}
+
+// claimsName reports whether mode claims the final name before writing,
+// because it must not replace an item that appears in the meantime.
+func claimsName(mode string) bool {
+	return mode != ConflictOverwrite && mode != ConflictMerge
+}
+
+// claimName atomically creates an empty placeholder of the source's kind at
+// target, failing with an error satisfying os.IsExist when the name is
+// taken. Renaming the finished item over the placeholder replaces it.
+func claimName(target string, isDir bool) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if isDir {
+		return os.Mkdir(target, 0755)
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// releaseClaim removes the placeholder claimName left at target for an
+// operation that failed. Anything that is no longer empty is kept.
+func releaseClaim(target string) {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return
+	}
+	if info.IsDir() || (info.Mode().IsRegular() && info.Size() == 0) {
+		os.Remove(target)
+	}
+}
+
+// prepareDestination applies onConflict to a rename, move or copy of source
+// to destination. Existing destinations fail unless the caller asked for
+// another mode; merge is only accepted when a folder is involved.
+func (p *PublicFilesService) prepareDestination(source, destination string, sourceIsDir bool, onConflict string) (string, string, bool, *dtos.ErrorResponse) {
+	mode, err := parseConflictMode(onConflict, ConflictFail, sourceIsDir)
+	if err == nil {
+		err = checkNotNested(source, destination)
+	}
+
+	final, skip := destination, false
+	if err == nil {
+		kind := "file"
+		if sourceIsDir {
+			kind = "folder"
+		}
+		final, skip, err = resolveConflict(destination, sourceIsDir, mode, kind)
+	}
+
+	if err != nil {
+		return "", "", false, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%v: %s -> %s", err, source, destination)),
+		}
+	}
+	return final, mode, skip, nil
+}
+
+// skippedResult is returned when on_conflict=skip left an existing item
+// untouched.
+func (p *PublicFilesService) skippedResult(target string) map[string]interface{} {
+	rel, _ := filepath.Rel(p.publicDir, target)
+	return map[string]interface{}{
+		"success":    true,
+		"skipped":    true,
+		"message":    "Destination already exists, skipped",
+		"final_path": strings.TrimPrefix(rel, "/"),
+	}
+}
+
+// availableName claims the first free "name (n).ext" sibling of target.
+func availableName(target string, isDir bool) (string, error) {
+	dir, base := filepath.Split(target)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		stem, ext = base, ""
+	}
+
+	for i := 1; i <= maxKeepBothAttempts; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		err := claimName(candidate, isDir)
+		if err == nil {
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to claim %s: %v", filepath.Base(candidate), err)
+		}
+	}
+	return "", fmt.Errorf("no free name available for %s", base)
+}
+
+// checkNotNested rejects operations that would place a folder inside
+// itself, which would otherwise recurse forever or destroy the source.
+func checkNotNested(source, destination string) error {
+	if source == destination {
+		return fmt.Errorf("Source and destination are the same")
+	}
+	if strings.HasPrefix(destination, source+string(filepath.Separator)) {
+		return fmt.Errorf("Cannot place a folder inside itself")
+	}
+	return nil
+}
+
+// moveIntoPlace renames source to target. With overwrite an existing target
+// is replaced; with merge directory contents are combined recursively and
+// colliding files are overwritten. In the other modes target is the
+// placeholder resolveConflict claimed.
+func moveIntoPlace(source, target, mode string) error {
+	srcInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return os.Rename(source, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if mode == ConflictMerge && srcInfo.IsDir() && dstInfo.IsDir() {
+		return mergeDirectories(source, target)
+	}
+
+	// Renaming a file over a file replaces it atomically, and so does
+	// renaming a folder over the empty placeholder folder of a claimed name,
+	// which os.Rename refuses. Other replacements involving a folder move
+	// the old target aside first and put it back if the rename fails.
+	if claimsName(mode) {
+		if err := syscall.Rename(source, target); err != nil {
+			return &os.LinkError{Op: "rename", Old: source, New: target, Err: err}
+		}
+		return nil
+	}
+	if !srcInfo.IsDir() && !dstInfo.IsDir() {
+		return os.Rename(source, target)
+	}
+	stagingDir, err := createStagingDir(target)
+	if err != nil {
+		return err
+	}
+	if err := swapIntoPlace(source, target, stagingDir); err != nil {
+		// Only removed if the previous target was put back.
+		os.Remove(stagingDir)
+		return err
+	}
+	return nil
+}
+
+func mergeDirectories(source, target string) error {
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := moveIntoPlace(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name()), ConflictMerge); err != nil {
+			return err
+		}
+	}
+	return os.Remove(source)
+}
@@ -0,0 +1,241 @@
+package publicfiles
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadFile_KeepBoth(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "report.pdf"), []byte("v1"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "report (1).pdf"), []byte("v2"), 0644)
+
+	result, errResp := service.UploadFile("report.pdf", bytes.NewReader([]byte("v3")), ConflictKeepBoth)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "report (2).pdf", result["final_path"])
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "report.pdf"))
+	assert.Equal(t, "v1", string(data))
+	data, _ = os.ReadFile(filepath.Join(tmpDir, "report (2).pdf"))
+	assert.Equal(t, "v3", string(data))
+}
+
+func TestUploadFile_SkipAndFail(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("original"), 0644)
+
+	result, errResp := service.UploadFile("notes.txt", bytes.NewReader([]byte("new")), ConflictSkip)
+	assert.Nil(t, errResp)
+	assert.Equal(t, true, result["skipped"])
+
+	_, errResp = service.UploadFile("notes.txt", bytes.NewReader([]byte("new")), ConflictFail)
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "already exists")
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "notes.txt"))
+	assert.Equal(t, "original", string(data))
+}
+
+func TestUploadFile_InvalidConflictMode(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.UploadFile("a.txt", bytes.NewReader([]byte("x")), "replace")
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "invalid on_conflict")
+
+	_, errResp = service.UploadFile("a.txt", bytes.NewReader([]byte("x")), ConflictMerge)
+	assert.NotNil(t, errResp)
+}
+
+func TestMoveFile_Overwrite(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("old"), 0644)
+
+	_, errResp := service.MoveFile("a.txt", "b.txt", "")
+	assert.NotNil(t, errResp)
+
+	result, errResp := service.MoveFile("a.txt", "b.txt", ConflictOverwrite)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "b.txt", result["final_path"])
+	assert.NoFileExists(t, filepath.Join(tmpDir, "a.txt"))
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "b.txt"))
+	assert.Equal(t, "new", string(data))
+}
+
+func TestMoveFolder_Merge(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "src", "sub"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "src", "shared.txt"), []byte("from src"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "src", "sub", "only-src.txt"), []byte("s"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "dst", "sub"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "dst", "shared.txt"), []byte("from dst"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "dst", "sub", "only-dst.txt"), []byte("d"), 0644)
+
+	_, errResp := service.MoveFolder("src", "dst", ConflictMerge)
+	assert.Nil(t, errResp)
+
+	assert.NoDirExists(t, filepath.Join(tmpDir, "src"))
+	assert.FileExists(t, filepath.Join(tmpDir, "dst", "sub", "only-src.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "dst", "sub", "only-dst.txt"))
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "dst", "shared.txt"))
+	assert.Equal(t, "from src", string(data))
+}
+
+func TestCopyFolder_KeepBothAndNested(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "photos"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "photos", "a.jpg"), []byte("jpg"), 0644)
+
+	result, errResp := service.CopyFolder("photos", "photos", ConflictKeepBoth)
+	assert.NotNil(t, errResp)
+	assert.Nil(t, result)
+
+	_, errResp = service.CopyFolder("photos", "photos/inner", "")
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "inside itself")
+
+	os.MkdirAll(filepath.Join(tmpDir, "backup"), 0755)
+	result, errResp = service.CopyFolder("photos", "backup", ConflictKeepBoth)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "backup (1)", result["final_path"])
+	assert.FileExists(t, filepath.Join(tmpDir, "backup (1)", "a.jpg"))
+}
+
+func TestCopyFile_Skip(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("b"), 0644)
+
+	result, errResp := service.CopyFile("a.txt", "b.txt", ConflictSkip)
+	assert.Nil(t, errResp)
+	assert.Equal(t, true, result["skipped"])
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "b.txt"))
+	assert.Equal(t, "b", string(data))
+}
+
+func TestUploadFolder_KeepBoth(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "folder"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "folder", "a.txt"), []byte("old"), 0644)
+
+	files := newFolderUpload(t, map[string]string{"a.txt": "new"})
+	result, errResp := service.UploadFolder("folder", files, ConflictKeepBoth)
+	assert.Nil(t, errResp)
+	assert.Equal(t, true, result["success"])
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "folder", "a (1).txt"))
+	assert.Equal(t, "new", string(data))
+}
+
+func TestAvailableName(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	name, err := availableName(filepath.Join(tmpDir, ".bashrc"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, ".bashrc (1)", filepath.Base(name))
+
+	name, err = availableName(filepath.Join(tmpDir, "archive.tar.gz"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "archive.tar (1).gz", filepath.Base(name))
+
+	// Names are claimed, so the next caller gets the next one.
+	name, err = availableName(filepath.Join(tmpDir, "archive.tar.gz"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "archive.tar (2).gz", filepath.Base(name))
+}
+
+func TestUploadFile_ConcurrentKeepBoth(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "report.txt"), []byte("original"), 0644)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errResp := service.UploadFile("report.txt", strings.NewReader(fmt.Sprintf("upload %d", i)), ConflictKeepBoth)
+			assert.Nil(t, errResp)
+		}()
+	}
+	wg.Wait()
+
+	names, _ := filepath.Glob(filepath.Join(tmpDir, "report*"))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		data, _ := os.ReadFile(name)
+		seen[string(data)] = true
+	}
+	assert.Len(t, names, 21)
+	assert.Len(t, seen, 21)
+}
+
+func TestUploadFile_FailedUploadReleasesName(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetStorageQuota(10)
+
+	_, errResp := service.UploadFile("big.txt", strings.NewReader(strings.Repeat("x", 100)), ConflictFail)
+	assert.NotNil(t, errResp)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "big.txt"))
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644)
+	_, errResp = service.UploadFile("a.txt", strings.NewReader(strings.Repeat("x", 100)), ConflictKeepBoth)
+	assert.NotNil(t, errResp)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "a (1).txt"))
+}
+
+func TestMoveFolder_OverwriteSwapsFolders(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.MkdirAll(filepath.Join(tmpDir, "src"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "src", "new.txt"), []byte("new"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "dst"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "dst", "old.txt"), []byte("old"), 0644)
+
+	_, errResp := service.MoveFolder("src", "dst", ConflictOverwrite)
+	assert.Nil(t, errResp)
+	assert.FileExists(t, filepath.Join(tmpDir, "dst", "new.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "dst", "old.txt"))
+	assert.NoDirExists(t, filepath.Join(tmpDir, "src"))
+	staging, _ := filepath.Glob(filepath.Join(tmpDir, stagingPrefix+"*"))
+	assert.Empty(t, staging)
+}
+
+func TestSwapIntoPlace_FailureRestoresTarget(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	target := filepath.Join(tmpDir, "dst")
+	os.MkdirAll(target, 0755)
+	os.WriteFile(filepath.Join(target, "keep.txt"), []byte("keep"), 0644)
+
+	stagingDir, err := createStagingDir(target)
+	assert.NoError(t, err)
+	err = swapIntoPlace(filepath.Join(tmpDir, "missing"), target, stagingDir)
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(target, "keep.txt"))
+}
@@ -0,0 +1,363 @@
+package publicfiles
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxIndexedFileSize skips files too large to be worth holding in the
+	// index; they can still be found by name.
+	maxIndexedFileSize = 5 * 1024 * 1024
+
+	minTermLength = 2
+	maxTermLength = 64
+
+	contentIndexFile      = "content-index.gob"
+	contentIndexSaveDelay = 10 * time.Second
+
+	// BM25 parameters, using the usual defaults.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// isIndexable reports whether name has one of the text formats that are
+// also editable in place.
+func isIndexable(name string) bool {
+	return allowedEditExtensions[strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")]
+}
+
+// scanTokens calls fn for each word of text with its byte offsets and its
+// lowercase form. Words are runs of letters and digits; words shorter or
+// longer than the term limits are skipped.
+func scanTokens(text string, fn func(start, end int, term string)) {
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if n := utf8.RuneCountInString(text[start:end]); n >= minTermLength && n <= maxTermLength {
+			fn(start, end, strings.ToLower(text[start:end]))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+	}
+	flush(len(text))
+}
+
+// queryTerms returns the distinct terms of a search query in order.
+func queryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	scanTokens(query, func(_, _ int, term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	})
+	return terms
+}
+
+// indexedDoc is the indexed state of one file. Size and ModTime decide
+// whether a re-index has to read the file again.
+type indexedDoc struct {
+	Size    int64
+	ModTime int64
+	Length  int
+	Terms   map[string]int
+}
+
+func newIndexedDoc(content []byte, info os.FileInfo) *indexedDoc {
+	doc := &indexedDoc{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Terms:   make(map[string]int),
+	}
+	scanTokens(string(content), func(_, _ int, term string) {
+		doc.Terms[term]++
+		doc.Length++
+	})
+	return doc
+}
+
+func (d *indexedDoc) current(info os.FileInfo) bool {
+	return d.Size == info.Size() && d.ModTime == info.ModTime().UnixNano()
+}
+
+// contentIndex is an in-memory inverted index over file contents, keyed by
+// the slash-separated path relative to the public directory.
+type contentIndex struct {
+	mu          sync.RWMutex
+	docs        map[string]*indexedDoc
+	postings    map[string]map[string]int
+	totalLength int64
+
+	// path is where snapshots are written; empty disables persistence.
+	path      string
+	saveTimer *time.Timer
+}
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{
+		docs:     make(map[string]*indexedDoc),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+func (c *contentIndex) get(rel string) (*indexedDoc, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	doc, ok := c.docs[rel]
+	return doc, ok
+}
+
+// paths returns the indexed paths at or below prefix; an empty prefix
+// matches every path.
+func (c *contentIndex) paths(prefix string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var paths []string
+	for rel := range c.docs {
+		if underPrefix(rel, prefix) {
+			paths = append(paths, rel)
+		}
+	}
+	return paths
+}
+
+func underPrefix(rel, prefix string) bool {
+	return prefix == "" || rel == prefix || strings.HasPrefix(rel, prefix+"/")
+}
+
+func (c *contentIndex) put(rel string, doc *indexedDoc) {
+	c.mu.Lock()
+	c.removeLocked(rel)
+	c.addLocked(rel, doc)
+	c.mu.Unlock()
+	c.changed()
+}
+
+func (c *contentIndex) addLocked(rel string, doc *indexedDoc) {
+	c.docs[rel] = doc
+	c.totalLength += int64(doc.Length)
+	for term, tf := range doc.Terms {
+		postings, ok := c.postings[term]
+		if !ok {
+			postings = make(map[string]int)
+			c.postings[term] = postings
+		}
+		postings[rel] = tf
+	}
+}
+
+func (c *contentIndex) removeLocked(rel string) bool {
+	doc, ok := c.docs[rel]
+	if !ok {
+		return false
+	}
+	delete(c.docs, rel)
+	c.totalLength -= int64(doc.Length)
+	for term := range doc.Terms {
+		postings := c.postings[term]
+		delete(postings, rel)
+		if len(postings) == 0 {
+			delete(c.postings, term)
+		}
+	}
+	return true
+}
+
+func (c *contentIndex) remove(rel string) {
+	c.mu.Lock()
+	removed := c.removeLocked(rel)
+	c.mu.Unlock()
+	if removed {
+		c.changed()
+	}
+}
+
+// removePrefix drops the document at prefix and every document below it.
+func (c *contentIndex) removePrefix(prefix string) {
+	c.mu.Lock()
+	removed := false
+	for rel := range c.docs {
+		if underPrefix(rel, prefix) {
+			removed = c.removeLocked(rel) || removed
+		}
+	}
+	c.mu.Unlock()
+	if removed {
+		c.changed()
+	}
+}
+
+// move re-keys the documents at or below oldPrefix to newPrefix without
+// reading the files again. Documents whose new name is no longer indexable
+// are dropped.
+func (c *contentIndex) move(oldPrefix, newPrefix string) {
+	c.mu.Lock()
+	moved := make(map[string]*indexedDoc)
+	changed := false
+	for rel, doc := range c.docs {
+		if underPrefix(rel, oldPrefix) {
+			if newRel := newPrefix + strings.TrimPrefix(rel, oldPrefix); isIndexable(newRel) {
+				moved[newRel] = doc
+			}
+			changed = c.removeLocked(rel) || changed
+		}
+	}
+	for rel, doc := range moved {
+		c.removeLocked(rel)
+		c.addLocked(rel, doc)
+	}
+	c.mu.Unlock()
+	if changed {
+		c.changed()
+	}
+}
+
+type contentMatch struct {
+	path  string
+	score float64
+}
+
+// search returns the documents containing every term, ranked by BM25.
+func (c *contentIndex) search(terms []string) []contentMatch {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(terms) == 0 || len(c.docs) == 0 {
+		return nil
+	}
+
+	lists := make([]map[string]int, len(terms))
+	for i, term := range terms {
+		postings, ok := c.postings[term]
+		if !ok {
+			return nil
+		}
+		lists[i] = postings
+	}
+	// Walk the rarest term and probe the others.
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	n := float64(len(c.docs))
+	avgLength := float64(c.totalLength) / n
+	if avgLength == 0 {
+		avgLength = 1
+	}
+	idf := make([]float64, len(lists))
+	for i, postings := range lists {
+		df := float64(len(postings))
+		idf[i] = math.Log(1 + (n-df+0.5)/(df+0.5))
+	}
+
+	var matches []contentMatch
+	for rel := range lists[0] {
+		doc := c.docs[rel]
+		norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.Length)/avgLength)
+		score := 0.0
+		for i, postings := range lists {
+			tf, ok := postings[rel]
+			if !ok {
+				score = -1
+				break
+			}
+			score += idf[i] * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+		}
+		if score >= 0 {
+			matches = append(matches, contentMatch{path: rel, score: score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].path < matches[j].path
+	})
+	return matches
+}
+
+// changed schedules a snapshot once writes have settled.
+func (c *contentIndex) changed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || c.saveTimer != nil {
+		return
+	}
+	c.saveTimer = time.AfterFunc(contentIndexSaveDelay, func() {
+		c.mu.Lock()
+		c.saveTimer = nil
+		c.mu.Unlock()
+		c.save()
+	})
+}
+
+// save writes a snapshot of the documents. Postings are rebuilt on load.
+func (c *contentIndex) save() error {
+	c.mu.RLock()
+	path := c.path
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(c.docs)
+	c.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode content index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// load replaces the index with the snapshot at path and persists future
+// changes there. A missing snapshot leaves an empty index.
+func (c *contentIndex) load(path string) error {
+	docs := make(map[string]*indexedDoc)
+	f, err := os.Open(path)
+	if err == nil {
+		err = gob.NewDecoder(f).Decode(&docs)
+		f.Close()
+		if err != nil {
+			docs = make(map[string]*indexedDoc)
+			err = fmt.Errorf("discarding unreadable content index: %w", err)
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.path = path
+	c.docs = make(map[string]*indexedDoc, len(docs))
+	c.postings = make(map[string]map[string]int)
+	c.totalLength = 0
+	for rel, doc := range docs {
+		if doc.Terms == nil {
+			doc.Terms = make(map[string]int)
+		}
+		c.addLocked(rel, doc)
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package publicfiles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func contentPaths(hits []contentMatch) []string {
+	paths := make([]string, len(hits))
+	for i, hit := range hits {
+		paths[i] = hit.path
+	}
+	return paths
+}
+
+func TestScanTokens(t *testing.T) {
+	var terms []string
+	scanTokens("Hello, wörld! a snake_case x2 ", func(start, end int, term string) {
+		terms = append(terms, term)
+	})
+	assert.Equal(t, []string{"hello", "wörld", "snake", "case", "x2"}, terms)
+}
+
+func TestSearchContent_IndexesOnWrite(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.UploadFile("notes.md", strings.NewReader("The quick brown fox jumps over the lazy dog."), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("docs/other.txt", strings.NewReader("A fox in a box."), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("image.png", strings.NewReader("fox"), "")
+	assert.Nil(t, errResp)
+
+	results, errResp := service.SearchContent("fox", 1, 10)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(2), results.Total)
+
+	results, errResp = service.SearchContent("quick FOX", 1, 10)
+	assert.Nil(t, errResp)
+	assert.Len(t, results.Items, 1)
+	assert.Equal(t, "notes.md", results.Items[0].Path)
+	assert.Equal(t, []string{"The <mark>quick</mark> brown <mark>fox</mark> jumps over the lazy dog."}, results.Items[0].Snippets)
+
+	_, errResp = service.EditFile("notes.md", "Nothing to see here.")
+	assert.Nil(t, errResp)
+	results, _ = service.SearchContent("quick", 1, 10)
+	assert.Equal(t, int32(0), results.Total)
+
+	_, errResp = service.MoveFolder("docs", "archive", "")
+	assert.Nil(t, errResp)
+	results, _ = service.SearchContent("box", 1, 10)
+	assert.Len(t, results.Items, 1)
+	assert.Equal(t, "archive/other.txt", results.Items[0].Path)
+
+	_, errResp = service.DeleteItem("archive")
+	assert.Nil(t, errResp)
+	results, _ = service.SearchContent("box", 1, 10)
+	assert.Equal(t, int32(0), results.Total)
+}
+
+func TestSearchContent_RanksByRelevance(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.WriteFile(filepath.Join(tmpDir, "once.txt"), []byte("golang is mentioned once among many other unrelated words here"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "often.txt"), []byte("golang golang golang"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "never.txt"), []byte("nothing relevant"), 0644)
+	_, _, err := service.syncIndexes(context.Background(), tmpDir, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"often.txt", "once.txt"}, contentPaths(service.content.search([]string{"golang"})))
+}
+
+func TestSearchContent_RejectsEmptyQuery(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.SearchContent("a !", 1, 10)
+	assert.NotNil(t, errResp)
+}
+
+func TestSearchContent_PastLastPage(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	_, errResp := service.UploadFile("notes.md", strings.NewReader("fox"), "")
+	assert.Nil(t, errResp)
+
+	results, errResp := service.SearchContent("fox", 21474838, 100)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(1), results.Total)
+	assert.Empty(t, results.Items)
+}
+
+func TestSearchContent_RenameToUnindexableName(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	_, errResp := service.UploadFile("notes.md", strings.NewReader("fox"), "")
+	assert.Nil(t, errResp)
+
+	_, errResp = service.RenameFile("notes.md", "notes.png", "")
+	assert.Nil(t, errResp)
+	results, errResp := service.SearchContent("fox", 1, 10)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(0), results.Total)
+}
+
+func TestBuildSnippets(t *testing.T) {
+	text := strings.Repeat("filler ", 40) + "<needle> here " + strings.Repeat("padding ", 40) + "needle again"
+	snippets := buildSnippets(text, []string{"needle"})
+
+	assert.Len(t, snippets, 2)
+	assert.True(t, strings.HasPrefix(snippets[0], "…"))
+	assert.Contains(t, snippets[0], "&lt;<mark>needle</mark>&gt; here")
+	assert.True(t, strings.HasSuffix(snippets[1], "<mark>needle</mark> again"))
+}
+
+func TestRebuildIndexes_PersistsAndSyncs(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "a.go"), []byte("package alpha"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, ".hidden"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, ".hidden", "b.go"), []byte("package alpha"), 0644)
+
+	service := NewPublicFilesService(tmpDir, nil)
+	indexed, removed, err := service.RebuildIndexes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, indexed)
+	assert.Equal(t, 0, removed)
+	assert.FileExists(t, filepath.Join(tmpDir, metaDirName, contentIndexFile))
+
+	os.Remove(filepath.Join(tmpDir, "a.go"))
+	os.WriteFile(filepath.Join(tmpDir, "c.py"), []byte("alpha = 1"), 0644)
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	_, docs, err := restarted.LoadIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, docs)
+
+	indexed, removed, err = restarted.syncIndexes(context.Background(), tmpDir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, indexed)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, []string{"c.py"}, contentPaths(restarted.content.search([]string{"alpha"})))
+}
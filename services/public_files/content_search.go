@@ -0,0 +1,188 @@
+package publicfiles
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxSnippets   = 3
+	snippetRadius = 80
+)
+
+// indexFile adds or refreshes a single file. Files that are not indexable
+// (anymore) are dropped from the index.
+func (p *PublicFilesService) indexFile(abs string, info os.FileInfo) {
+	rel := p.relPath(abs)
+	if !info.Mode().IsRegular() || !isIndexable(abs) || info.Size() > maxIndexedFileSize {
+		p.content.remove(rel)
+		return
+	}
+	content, err := os.ReadFile(abs)
+	if err != nil {
+		log.Warn().Err(err).Str("path", rel).Msg("Failed to index file")
+		return
+	}
+	p.content.put(rel, newIndexedDoc(content, info))
+}
+
+// SearchContent finds text files containing every word of query, ranked by
+// relevance. Each hit carries up to three snippets with the matched words
+// wrapped in <mark>; the rest of the snippet text is HTML-escaped.
+func (p *PublicFilesService) SearchContent(query string, pageVal, limitVal int) (*dtos.ContentSearchResults, *dtos.ErrorResponse) {
+	if len(query) > maxSearchLength {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Search query too long (max %d characters)", maxSearchLength),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Query length: %d", len(query))),
+		}
+	}
+
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Search query must contain a word of at least %d characters", minTermLength),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Query: %q", query)),
+		}
+	}
+
+	page, limit := normalizePage(pageVal, limitVal, 100)
+	matches := p.content.search(terms)
+
+	total := int32(len(matches))
+	start := (page - 1) * limit
+	end := start + limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	hits := make([]dtos.ContentSearchHit, 0, end-start)
+	for _, match := range matches[start:end] {
+		abs := filepath.Join(p.publicDir, filepath.FromSlash(match.path))
+		info, err := os.Stat(abs)
+		if err != nil {
+			p.content.remove(match.path)
+			continue
+		}
+		content, err := os.ReadFile(abs)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, dtos.ContentSearchHit{
+			FileSystemItem: p.indexItem(filepath.Dir(abs), &dirEntry{
+				name:     info.Name(),
+				size:     info.Size(),
+				modified: info.ModTime().Unix(),
+				modTime:  info.ModTime(),
+			}),
+			Score:    match.score,
+			Snippets: buildSnippets(string(content), terms),
+		})
+	}
+
+	totalPages := (total + limit - 1) / limit
+	return &dtos.ContentSearchResults{
+		Items:      hits,
+		Query:      query,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+		HasNext:    page < totalPages,
+		HasPrev:    page > 1,
+	}, nil
+}
+
+// buildSnippets cuts up to maxSnippets excerpts around the occurrences of
+// terms in text. Excerpts never overlap; occurrences close together share
+// one excerpt.
+func buildSnippets(text string, terms []string) []string {
+	want := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		want[term] = true
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	scanTokens(text, func(start, end int, term string) {
+		if want[term] {
+			spans = append(spans, span{start, end})
+		}
+	})
+
+	snippets := []string{}
+	prevEnd := 0
+	for i := 0; i < len(spans) && len(snippets) < maxSnippets; {
+		from := max(snapStart(text, spans[i].start-snippetRadius), prevEnd)
+		to := snapEnd(text, spans[i].end+snippetRadius)
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		pos := from
+		for ; i < len(spans) && spans[i].start < to; i++ {
+			if spans[i].end > to {
+				to = spans[i].end
+			}
+			b.WriteString(html.EscapeString(text[pos:spans[i].start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[spans[i].start:spans[i].end]))
+			b.WriteString("</mark>")
+			pos = spans[i].end
+		}
+		b.WriteString(html.EscapeString(text[pos:to]))
+		if to < len(text) {
+			b.WriteString("…")
+		}
+
+		snippets = append(snippets, strings.Join(strings.Fields(b.String()), " "))
+		prevEnd = to
+	}
+	return snippets
+}
+
+// snapStart moves i to a rune boundary, preferring the start of a word
+// shortly after it.
+func snapStart(text string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	for i < len(text) && !utf8.RuneStart(text[i]) {
+		i++
+	}
+	if j := strings.IndexAny(text[i:min(i+16, len(text))], " \t\n"); j >= 0 {
+		return i + j + 1
+	}
+	return i
+}
+
+// snapEnd moves i to a rune boundary, preferring the end of a word shortly
+// before it.
+func snapEnd(text string, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	if j := strings.LastIndexAny(text[max(i-16, 0):i], " \t\n"); j >= 0 {
+		return max(i-16, 0) + j
+	}
+	return i
+}
@@ -0,0 +1,205 @@
+package publicfiles
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// copyWorkers bounds how many files a folder copy writes concurrently.
+	copyWorkers = 8
+
+	// copyChunkSize is how much data is copied between cancellation checks.
+	// Each chunk still goes through copy_file_range where available.
+	copyChunkSize = 64 * 1024 * 1024
+
+	// Folder copies above either threshold run as a background job.
+	asyncCopyFiles = 1000
+	asyncCopyBytes = 1024 * 1024 * 1024
+)
+
+// copyFile copies src to dst through a staging file. The file system is asked
+// for a reflink first; otherwise the data is streamed, which the kernel turns
+// into copy_file_range when both ends are regular files. Permission bits and
+// the modification time of src are preserved.
+func copyFile(ctx context.Context, src, dst string, info os.FileInfo) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := createStagingFile(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := copyContents(ctx, out, in, info.Size())
+	if err != nil {
+		discardStagingFile(out)
+		return 0, err
+	}
+
+	// A zero access time leaves it untouched.
+	if err := os.Chtimes(out.Name(), time.Time{}, info.ModTime()); err != nil {
+		discardStagingFile(out)
+		return 0, fmt.Errorf("failed to preserve modification time: %w", err)
+	}
+
+	if err := commitStagingFile(out, dst, info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func copyContents(ctx context.Context, out, in *os.File, size int64) (int64, error) {
+	if size > 0 && cloneFile(out, in) == nil {
+		return size, nil
+	}
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.CopyN(out, in, copyChunkSize)
+		written += n
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+type copyEntry struct {
+	src  string
+	dst  string
+	info os.FileInfo
+}
+
+// copyPlan is the result of scanning a source folder before copying it.
+type copyPlan struct {
+	dirs  []copyEntry
+	files []copyEntry
+	bytes int64
+}
+
+// planCopy walks src and records what has to be created below dst. Symlinks,
+// devices and in-flight staging files are not copied.
+func planCopy(src, dst string) (*copyPlan, error) {
+	plan := &copyPlan{}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := copyEntry{src: path, dst: filepath.Join(dst, rel), info: info}
+		switch {
+		case d.IsDir():
+			plan.dirs = append(plan.dirs, entry)
+		case d.Type().IsRegular() && !strings.HasPrefix(d.Name(), stagingPrefix):
+			plan.files = append(plan.files, entry)
+			plan.bytes += info.Size()
+		}
+		return nil
+	})
+	return plan, err
+}
+
+func (c *copyPlan) large() bool {
+	return len(c.files) > asyncCopyFiles || c.bytes > asyncCopyBytes
+}
+
+// run executes the plan with a bounded pool of workers. progress, if set, is
+// called after every file with the running totals. When the copy fails or ctx
+// is cancelled, everything the copy created is removed again.
+func (c *copyPlan) run(ctx context.Context, progress func(files, bytes int64)) error {
+	var createdDirs []copyEntry
+	for _, dir := range c.dirs {
+		if _, err := os.Lstat(dir.dst); os.IsNotExist(err) {
+			createdDirs = append(createdDirs, dir)
+		}
+		if err := os.MkdirAll(dir.dst, dir.info.Mode().Perm()|0700); err != nil {
+			removeCreated(nil, createdDirs)
+			return err
+		}
+	}
+
+	var (
+		mu           sync.Mutex
+		createdFiles []string
+		filesDone    atomic.Int64
+		bytesDone    atomic.Int64
+	)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(copyWorkers)
+	for _, file := range c.files {
+		if gctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			_, statErr := os.Lstat(file.dst)
+			n, err := copyFile(gctx, file.src, file.dst, file.info)
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", filepath.Base(file.src), err)
+			}
+			if os.IsNotExist(statErr) {
+				mu.Lock()
+				createdFiles = append(createdFiles, file.dst)
+				mu.Unlock()
+			}
+			files, bytes := filesDone.Add(1), bytesDone.Add(n)
+			if progress != nil {
+				progress(files, bytes)
+			}
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		removeCreated(createdFiles, createdDirs)
+		return err
+	}
+
+	// Directory times change while their children are written, so they are
+	// restored last and deepest first. Folders that already existed in a
+	// merge keep their own times.
+	for i := len(createdDirs) - 1; i >= 0; i-- {
+		os.Chtimes(createdDirs[i].dst, time.Time{}, createdDirs[i].info.ModTime())
+	}
+	return nil
+}
+
+func removeCreated(files []string, dirs []copyEntry) {
+	for _, f := range files {
+		os.Remove(f)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i].dst)
+	}
+}
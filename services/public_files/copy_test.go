@@ -0,0 +1,159 @@
+package publicfiles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyFile_PreservesMetadata(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	src := filepath.Join(tmpDir, "run.sh")
+	os.WriteFile(src, []byte("#!/bin/sh\necho hi\n"), 0755)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(src, mtime, mtime)
+
+	result, errResp := service.CopyFile("run.sh", "bin/run.sh", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(18), result["bytes_copied"])
+
+	info, err := os.Stat(filepath.Join(tmpDir, "bin", "run.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(mtime))
+}
+
+func TestCopyFolder_PreservesTimesAndSkipsSymlinks(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	nested := filepath.Join(tmpDir, "src", "nested")
+	os.MkdirAll(nested, 0755)
+	os.WriteFile(filepath.Join(nested, "a.txt"), []byte("a"), 0644)
+	os.Symlink("/etc/passwd", filepath.Join(tmpDir, "src", "link"))
+	mtime := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(nested, "a.txt"), mtime, mtime)
+	os.Chtimes(nested, mtime, mtime)
+
+	result, errResp := service.CopyFolder("src", "dst", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, 1, result["files_count"])
+
+	info, _ := os.Stat(filepath.Join(tmpDir, "dst", "nested", "a.txt"))
+	assert.True(t, info.ModTime().Equal(mtime))
+	info, _ = os.Stat(filepath.Join(tmpDir, "dst", "nested"))
+	assert.True(t, info.ModTime().Equal(mtime))
+
+	_, err := os.Lstat(filepath.Join(tmpDir, "dst", "link"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCopyFolder_OverwriteReplacesAfterCopy(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "src"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "src", "new.txt"), []byte("new"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "dst"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "dst", "old.txt"), []byte("old"), 0644)
+
+	_, errResp := service.CopyFolder("src", "dst", ConflictOverwrite)
+	assert.Nil(t, errResp)
+	assert.FileExists(t, filepath.Join(tmpDir, "dst", "new.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "dst", "old.txt"))
+
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), stagingPrefix)
+	}
+}
+
+func TestCommitStagedItem_RestoresTargetOnFailure(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	target := filepath.Join(tmpDir, "dst")
+	os.MkdirAll(target, 0755)
+	os.WriteFile(filepath.Join(target, "keep.txt"), []byte("keep"), 0644)
+
+	stagingDir, err := createStagingDir(target)
+	assert.NoError(t, err)
+
+	// Nothing was staged, so the swap fails and the target must survive.
+	assert.Error(t, commitStagedItem(stagingDir, target))
+	assert.FileExists(t, filepath.Join(target, "keep.txt"))
+}
+
+func TestCopyPlan_CancelledRemovesPartialCopy(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		os.WriteFile(filepath.Join(src, name), []byte(name), 0644)
+	}
+
+	plan, err := planCopy(src, filepath.Join(tmpDir, "dst"))
+	assert.NoError(t, err)
+	assert.Len(t, plan.files, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = plan.run(ctx, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "dst"))
+}
+
+func TestCopyPlan_ReportsProgress(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(src, string(rune('a'+i))+".txt"), []byte("12345"), 0644)
+	}
+
+	plan, err := planCopy(src, filepath.Join(tmpDir, "dst"))
+	assert.NoError(t, err)
+
+	var (
+		mu       sync.Mutex
+		calls    int
+		maxBytes int64
+	)
+	err = plan.run(context.Background(), func(files, bytes int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if bytes > maxBytes {
+			maxBytes = bytes
+		}
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 20, calls)
+	assert.Equal(t, int64(100), maxBytes)
+}
+
+func TestCancelJob(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	job := service.startJob("test", func(job *Job) (map[string]interface{}, error) {
+		<-job.ctx.Done()
+		return nil, job.ctx.Err()
+	})
+
+	_, errResp := service.CancelJob(job.ID)
+	assert.Nil(t, errResp)
+	<-job.done
+	assert.Equal(t, jobStatusCancelled, job.snapshot().Status)
+
+	_, errResp = service.CancelJob("missing")
+	assert.NotNil(t, errResp)
+}
@@ -0,0 +1,132 @@
+package publicfiles
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+)
+
+// sortKey is the position of an item in a listing order. Folders always come
+// first; the remaining fields are compared in order and reversed for desc.
+// name is unique within the result set and makes the order total.
+type sortKey struct {
+	dir  bool
+	num  int64
+	str  string
+	name string
+}
+
+func (q *listQuery) keyOf(isDir bool, name, unique string, size, modified int64) sortKey {
+	key := sortKey{dir: isDir, str: strings.ToLower(name), name: unique}
+	switch q.sort {
+	case sortSize:
+		key.num = size
+	case sortModified:
+		key.num = modified
+	case sortType:
+		key.str = strings.ToLower(filepath.Ext(name)) + "\x00" + key.str
+	}
+	return key
+}
+
+func (q *listQuery) itemKey(item *dtos.FileSystemItem, unique string) sortKey {
+	return q.keyOf(item.IsDir, item.Name, unique, item.Size, derefInt64(item.ModifiedAt))
+}
+
+// compare returns a negative number when a sorts before b.
+func (q *listQuery) compare(a, b sortKey) int {
+	if a.dir != b.dir {
+		if a.dir {
+			return -1
+		}
+		return 1
+	}
+	cmp := compareInt64(a.num, b.num)
+	if cmp == 0 {
+		cmp = strings.Compare(a.str, b.str)
+	}
+	if cmp == 0 {
+		cmp = comparePaths(a.name, b.name)
+	}
+	if q.desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// comparePaths orders slash separated paths the way a depth first walk
+// over sorted directory entries visits them. Plain names compare as
+// strings.
+func comparePaths(a, b string) int {
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if cmp := strings.Compare(as[i], bs[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// listCursor is the opaque continuation token handed to clients. It records
+// the key of the last item returned, so inserting or deleting other items
+// between requests neither repeats nor skips existing ones.
+type listCursor struct {
+	Scope string `json:"s"`
+	Query string `json:"q"`
+	Dir   bool   `json:"d,omitempty"`
+	Num   int64  `json:"n,omitempty"`
+	Str   string `json:"t,omitempty"`
+	Name  string `json:"k"`
+}
+
+// fingerprint identifies the ordering and filters a cursor was issued for.
+func (q *listQuery) fingerprint() string {
+	return fmt.Sprintf("%s|%t|%t|%t|%v|%v|%v|%v|%v|%v",
+		q.sort, q.desc, q.onlyDirs, q.onlyFiles, q.mimes, q.exts,
+		ptrValue(q.minSize), ptrValue(q.maxSize), ptrValue(q.modifiedAfter), ptrValue(q.modifiedBefore))
+}
+
+func ptrValue(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+func (q *listQuery) encodeCursor(scope string, key sortKey) string {
+	data, _ := json.Marshal(listCursor{
+		Scope: scope,
+		Query: q.fingerprint(),
+		Dir:   key.dir,
+		Num:   key.num,
+		Str:   key.str,
+		Name:  key.name,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor validates that a cursor belongs to scope and to the current
+// ordering and filters.
+func (q *listQuery) decodeCursor(scope, token string) (*sortKey, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if c.Scope != scope || c.Query != q.fingerprint() {
+		return nil, fmt.Errorf("cursor does not match this listing; restart without a cursor")
+	}
+	return &sortKey{dir: c.Dir, num: c.Num, str: c.Str, name: c.Name}, nil
+}
@@ -0,0 +1,113 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListItems_CursorStableUnderInserts(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	for i := 0; i < 25; i++ {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("img_%03d.jpg", i*2)), []byte("x"), 0644)
+	}
+
+	first, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.NotEmpty(t, first.NextCursor)
+	assert.Equal(t, "img_018.jpg", first.Items[9].Name)
+
+	// One insert before the cursor position and one after it.
+	os.WriteFile(filepath.Join(tmpDir, "img_001.jpg"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "img_021.jpg"), []byte("x"), 0644)
+
+	seen := itemNames(first)
+	cursor := first.NextCursor
+	for cursor != "" {
+		page, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{Cursor: cursor})
+		assert.Nil(t, errResp)
+		seen = append(seen, itemNames(page)...)
+		cursor = page.NextCursor
+	}
+
+	assert.Len(t, seen, 26)
+	assert.NotContains(t, seen, "img_001.jpg")
+	assert.Contains(t, seen, "img_021.jpg")
+	assert.Equal(t, "img_048.jpg", seen[len(seen)-1])
+}
+
+func TestListItems_CursorWithSortAndFilter(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	for i := 0; i < 15; i++ {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%02d.txt", i)), make([]byte, i), 0644)
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%02d.png", i)), make([]byte, i), 0644)
+	}
+
+	opts := dtos.ListOptions{Sort: "size", Order: "desc", Ext: "txt"}
+	first, errResp := service.ListItemsRoot(1, 10, opts)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(15), first.Total)
+	assert.Equal(t, "f14.txt", first.Items[0].Name)
+
+	opts.Cursor = first.NextCursor
+	second, errResp := service.ListItemsRoot(1, 10, opts)
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"f04.txt", "f03.txt", "f02.txt", "f01.txt", "f00.txt"}, itemNames(second))
+	assert.False(t, second.HasNext)
+	assert.Empty(t, second.NextCursor)
+
+	_, errResp = service.ListItemsRoot(1, 10, dtos.ListOptions{Sort: "name", Cursor: first.NextCursor})
+	assert.NotNil(t, errResp)
+	assert.Contains(t, errResp.Error, "cursor does not match")
+
+	_, errResp = service.ListItemsRoot(1, 10, dtos.ListOptions{Cursor: "not-a-cursor"})
+	assert.NotNil(t, errResp)
+}
+
+func TestSearchItems_Cursor(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	var expected []string
+	for _, dir := range []string{"a", "a/b", "a-c", "z"} {
+		os.MkdirAll(filepath.Join(tmpDir, dir), 0755)
+		for i := 0; i < 6; i++ {
+			rel := filepath.Join(dir, fmt.Sprintf("photo%d.jpg", i))
+			os.WriteFile(filepath.Join(tmpDir, rel), []byte("x"), 0644)
+			expected = append(expected, rel)
+		}
+	}
+	os.MkdirAll(filepath.Join(tmpDir, ".hidden"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, ".hidden", "photo9.jpg"), []byte("x"), 0644)
+
+	var seen []string
+	page, errResp := service.SearchItems("photo", 1, 10, dtos.ListOptions{})
+	for {
+		assert.Nil(t, errResp)
+		for _, item := range page.Items {
+			seen = append(seen, item.Path)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		page, errResp = service.SearchItems("photo", 1, 10, dtos.ListOptions{Cursor: page.NextCursor})
+	}
+
+	assert.ElementsMatch(t, expected, seen)
+	assert.Len(t, seen, 24)
+}
+
+func TestComparePaths(t *testing.T) {
+	assert.Less(t, comparePaths("a", "a/b"), 0)
+	assert.Less(t, comparePaths("a/b/c.txt", "a-c"), 0)
+	assert.Greater(t, comparePaths("b", "a/z"), 0)
+	assert.Equal(t, 0, comparePaths("a/b", "a/b"))
+}
@@ -0,0 +1,307 @@
+package publicfiles
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDiffSize        = 2 * 1024 * 1024
+	defaultDiffContext = 3
+	maxDiffContext     = 100
+
+	// binarySniffLen is how far binary detection looks for a NUL byte, as
+	// git does.
+	binarySniffLen = 8000
+)
+
+// isBinary reports whether content is not UTF-8 text.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) >= 0 || !utf8.Valid(content)
+}
+
+// FileVersions lists the earlier versions kept of a text file, oldest
+// first. The numbers can be passed to DiffFiles.
+func (p *PublicFilesService) FileVersions(path string) ([]dtos.FileVersion, *dtos.ErrorResponse) {
+	file, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	kept := p.versions.list(p.relPath(file))
+	versions := make([]dtos.FileVersion, 0, len(kept))
+	for _, v := range kept {
+		versions = append(versions, dtos.FileVersion{Version: v.number, Size: v.size, SavedAt: v.saved})
+	}
+	return versions, nil
+}
+
+// readDiffSide loads one side of a diff: the file at path, or its kept
+// version when version is set.
+func (p *PublicFilesService) readDiffSide(path string, version int) (dtos.DiffSide, []byte, *dtos.ErrorResponse) {
+	file, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return dtos.DiffSide{}, nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	side := dtos.DiffSide{Path: p.relPath(file), Version: version}
+
+	var content []byte
+	if version > 0 {
+		kept, ok := p.versions.byNumber(side.Path, version)
+		if !ok {
+			return side, nil, &dtos.ErrorResponse{
+				Error:     "Version not found",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("No version %d of %s", version, side.Path)),
+				Status:    http.StatusNotFound,
+			}
+		}
+		content = kept
+	} else {
+		info, err := os.Stat(file)
+		if err != nil {
+			return side, nil, &dtos.ErrorResponse{
+				Error:     "File not found",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+				Status:    http.StatusNotFound,
+			}
+		}
+		if info.IsDir() {
+			return side, nil, &dtos.ErrorResponse{
+				Error:     "Path is a directory",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("Cannot diff directory: %s", file)),
+			}
+		}
+		if info.Size() <= maxDiffSize {
+			if content, err = os.ReadFile(file); err != nil {
+				return side, nil, &dtos.ErrorResponse{
+					Error:     fmt.Sprintf("Failed to read file: %v", err),
+					Timestamp: time.Now().UTC().Format(time.RFC3339),
+					RequestID: uuid.New().String(),
+					Debug:     ptrString(err.Error()),
+				}
+			}
+		}
+		side.Size = info.Size()
+	}
+	if version > 0 {
+		side.Size = int64(len(content))
+	}
+	if side.Size > maxDiffSize {
+		return side, nil, &dtos.ErrorResponse{
+			Error:     "File too large to diff (maximum 2MB)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%s is %d bytes", side.Path, side.Size)),
+		}
+	}
+	return side, content, nil
+}
+
+// DiffFiles compares two text files, or versions of them, line by line and
+// returns the differences as a unified diff and as hunks.
+func (p *PublicFilesService) DiffFiles(opts dtos.DiffOptions) (*dtos.FileDiff, *dtos.ErrorResponse) {
+	if opts.A == "" {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Parameter a is required",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString("Missing parameter: a"),
+		}
+	}
+	if opts.B == "" {
+		opts.B = opts.A
+	}
+	context := defaultDiffContext
+	if opts.Context != nil {
+		context = min(max(*opts.Context, 0), maxDiffContext)
+	}
+
+	a, aContent, errResp := p.readDiffSide(opts.A, opts.AVersion)
+	if errResp != nil {
+		return nil, errResp
+	}
+	b, bContent, errResp := p.readDiffSide(opts.B, opts.BVersion)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	diff := &dtos.FileDiff{A: a, B: b, Hunks: []dtos.DiffHunk{}}
+	oldName, newName := diffName("a/", a), diffName("b/", b)
+	switch {
+	case bytes.Equal(aContent, bContent):
+		diff.Identical = true
+		return diff, nil
+	case isBinary(aContent) || isBinary(bContent):
+		diff.Binary = true
+		diff.Unified = fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+		return diff, nil
+	}
+
+	aLines, bLines := splitLines(string(aContent)), splitLines(string(bContent))
+	match, err := matchLines(aLines, bLines)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Files differ too much to diff",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	var unified strings.Builder
+	fmt.Fprintf(&unified, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range diffHunks(aLines, bLines, match, context) {
+		diff.Hunks = append(diff.Hunks, h.hunk)
+		fmt.Fprintf(&unified, "@@ -%s +%s @@\n", hunkRange(h.hunk.OldStart, h.hunk.OldLines), hunkRange(h.hunk.NewStart, h.hunk.NewLines))
+		for i, line := range h.hunk.Lines {
+			prefix := " "
+			switch line.Op {
+			case diffDelete:
+				prefix = "-"
+			case diffAdd:
+				prefix = "+"
+			}
+			unified.WriteString(prefix + line.Text + "\n")
+			if h.noNewline[i] {
+				unified.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	diff.Unified = unified.String()
+	return diff, nil
+}
+
+func diffName(prefix string, side dtos.DiffSide) string {
+	if side.Version > 0 {
+		return fmt.Sprintf("%s%s\t(version %d)", prefix, side.Path, side.Version)
+	}
+	return prefix + side.Path
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Ops of a DiffLine.
+const (
+	diffContext = "context"
+	diffDelete  = "delete"
+	diffAdd     = "add"
+)
+
+// lineEdit is a step of the edit script turning a into b, at line a of the
+// old file and line b of the new one.
+type lineEdit struct {
+	op   string
+	a, b int
+}
+
+type diffHunk struct {
+	hunk dtos.DiffHunk
+	// noNewline marks the last lines of files that lack a line ending.
+	noNewline []bool
+}
+
+// diffHunks groups the changes between aLines and bLines into hunks with up
+// to context unchanged lines around them. Hunks whose context would touch
+// are joined.
+func diffHunks(aLines, bLines []string, match []int, context int) []diffHunk {
+	var edits []lineEdit
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && match[i] < 0:
+			edits = append(edits, lineEdit{diffDelete, i, j})
+			i++
+		case j < len(bLines) && (i == len(aLines) || j < match[i]):
+			edits = append(edits, lineEdit{diffAdd, i, j})
+			j++
+		default:
+			edits = append(edits, lineEdit{diffContext, i, j})
+			i++
+			j++
+		}
+	}
+
+	var hunks []diffHunk
+	prevEnd := 0
+	for k := 0; k < len(edits); {
+		if edits[k].op == diffContext {
+			k++
+			continue
+		}
+		start := max(k-context, prevEnd)
+		end := k
+		for {
+			for end < len(edits) && edits[end].op != diffContext {
+				end++
+			}
+			run := 0
+			for end+run < len(edits) && edits[end+run].op == diffContext {
+				run++
+			}
+			if end+run == len(edits) || run > 2*context {
+				end += min(run, context)
+				break
+			}
+			end += run
+		}
+
+		h := diffHunk{hunk: dtos.DiffHunk{OldStart: edits[start].a + 1, NewStart: edits[start].b + 1}}
+		for _, e := range edits[start:end] {
+			var line string
+			switch e.op {
+			case diffDelete:
+				line = aLines[e.a]
+				h.hunk.OldLines++
+			case diffAdd:
+				line = bLines[e.b]
+				h.hunk.NewLines++
+			default:
+				line = aLines[e.a]
+				h.hunk.OldLines++
+				h.hunk.NewLines++
+			}
+			// Only the last line of a file can lack a line ending.
+			text, hasNewline := strings.CutSuffix(line, "\n")
+			h.hunk.Lines = append(h.hunk.Lines, dtos.DiffLine{Op: e.op, Text: text})
+			h.noNewline = append(h.noNewline, !hasNewline)
+		}
+		// An empty side starts at the line before, as in diff -u.
+		if h.hunk.OldLines == 0 {
+			h.hunk.OldStart--
+		}
+		if h.hunk.NewLines == 0 {
+			h.hunk.NewStart--
+		}
+		hunks = append(hunks, h)
+		prevEnd, k = end, end
+	}
+	return hunks
+}
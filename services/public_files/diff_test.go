@@ -0,0 +1,107 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffFiles_UnifiedAndHunks(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	var old, changed strings.Builder
+	for i := 1; i <= 20; i++ {
+		line := "line " + string(rune('a'+i-1)) + "\n"
+		old.WriteString(line)
+		switch i {
+		case 2:
+			changed.WriteString("line B\n")
+		case 18:
+		default:
+			changed.WriteString(line)
+		}
+	}
+	changed.WriteString("tail")
+	os.WriteFile(filepath.Join(tmpDir, "old.conf"), []byte(old.String()), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "new.conf"), []byte(changed.String()), 0644)
+
+	zero := 0
+	diff, errResp := service.DiffFiles(dtos.DiffOptions{A: "old.conf", B: "new.conf", Context: &zero})
+	assert.Nil(t, errResp)
+	assert.False(t, diff.Identical)
+	assert.Equal(t, "--- a/old.conf\n+++ b/new.conf\n"+
+		"@@ -2 +2 @@\n-line b\n+line B\n"+
+		"@@ -18 +17,0 @@\n-line r\n"+
+		"@@ -20,0 +20 @@\n+tail\n\\ No newline at end of file\n", diff.Unified)
+	assert.Len(t, diff.Hunks, 3)
+	assert.Equal(t, dtos.DiffLine{Op: "add", Text: "line B"}, diff.Hunks[0].Lines[1])
+
+	diff, errResp = service.DiffFiles(dtos.DiffOptions{A: "old.conf", B: "new.conf"})
+	assert.Nil(t, errResp)
+	assert.Len(t, diff.Hunks, 2)
+	assert.Equal(t, 1, diff.Hunks[0].OldStart)
+	assert.Equal(t, 5, diff.Hunks[0].OldLines)
+	assert.Equal(t, 15, diff.Hunks[1].OldStart)
+
+	diff, errResp = service.DiffFiles(dtos.DiffOptions{A: "old.conf"})
+	assert.Nil(t, errResp)
+	assert.True(t, diff.Identical)
+}
+
+func TestDiffFiles_VersionsAndBinary(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "app.yaml"), []byte("port: 80\n"), 0644)
+
+	_, errResp := service.EditFile("app.yaml", "port: 8080\n")
+	assert.Nil(t, errResp)
+	_, errResp = service.EditFile("app.yaml", "port: 8080\nhost: example\n")
+	assert.Nil(t, errResp)
+
+	versions, errResp := service.FileVersions("app.yaml")
+	assert.Nil(t, errResp)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, 1, versions[0].Version)
+		assert.Equal(t, int64(9), versions[0].Size)
+	}
+
+	diff, errResp := service.DiffFiles(dtos.DiffOptions{A: "app.yaml", AVersion: 1})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "--- a/app.yaml\t(version 1)\n+++ b/app.yaml\n@@ -1 +1,2 @@\n-port: 80\n+port: 8080\n+host: example\n", diff.Unified)
+
+	_, errResp = service.DiffFiles(dtos.DiffOptions{A: "app.yaml", AVersion: 9})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, 404, errResp.Status)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.bin"), []byte("ab\x00cd"), 0644)
+	diff, errResp = service.DiffFiles(dtos.DiffOptions{A: "a.bin", B: "app.yaml"})
+	assert.Nil(t, errResp)
+	assert.True(t, diff.Binary)
+	assert.Empty(t, diff.Hunks)
+
+	os.WriteFile(filepath.Join(tmpDir, "big.txt"), make([]byte, maxDiffSize+1), 0644)
+	_, errResp = service.DiffFiles(dtos.DiffOptions{A: "big.txt", B: "app.yaml"})
+	assert.NotNil(t, errResp)
+}
+
+func TestVersionStore_KeepsNewest(t *testing.T) {
+	store := newVersionStore(t.TempDir())
+	for i := 0; i < maxVersionsPerFile+5; i++ {
+		assert.NoError(t, store.save("doc.txt", string(rune('a'+i)), []byte{byte(i)}))
+	}
+	assert.NoError(t, store.save("doc.txt", "b", []byte("again")))
+
+	versions := store.list("doc.txt")
+	assert.Len(t, versions, maxVersionsPerFile)
+	assert.Equal(t, maxVersionsPerFile+6, versions[len(versions)-1].number)
+	content, ok := store.get("doc.txt", `"b"`)
+	assert.True(t, ok)
+	assert.Equal(t, "again", string(content))
+	_, ok = store.byNumber("doc.txt", 1)
+	assert.False(t, ok)
+}
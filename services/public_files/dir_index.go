@@ -0,0 +1,191 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+)
+
+const (
+	// dirIndexTTL bounds how stale cached sizes and modification times may
+	// get. Adding, removing or renaming entries changes the directory's own
+	// mtime and invalidates the index immediately.
+	dirIndexTTL = 30 * time.Second
+
+	maxCachedDirs = 128
+)
+
+// dirEntry is the compact form of a directory entry kept in the index.
+// FileSystemItems are only built for the entries of the requested page.
+type dirEntry struct {
+	name     string
+	isDir    bool
+	size     int64
+	modified int64
+	// modTime is the full modification time, which etags are built from.
+	modTime time.Time
+}
+
+// stub carries just enough of the entry e at rel for listQuery.match.
+func (p *PublicFilesService) stub(rel string, e *dirEntry) *dtos.FileSystemItem {
+	item := &dtos.FileSystemItem{Name: e.name, IsDir: e.isDir, Size: e.size, ModifiedAt: &e.modified}
+	if !e.isDir {
+		mimeType, _ := p.contentType(rel)
+		item.MimeType = &mimeType
+	}
+	return item
+}
+
+type dirIndex struct {
+	dirModTime time.Time
+	loadedAt   time.Time
+	entries    []dirEntry
+
+	mu     sync.Mutex
+	orders map[string][]int
+}
+
+// order returns the entry positions sorted for q, computing each ordering
+// once per index.
+func (d *dirIndex) order(q *listQuery) []int {
+	name := q.sort
+	if q.desc {
+		name += "|desc"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if order, ok := d.orders[name]; ok {
+		return order
+	}
+
+	keys := make([]sortKey, len(d.entries))
+	for i, e := range d.entries {
+		keys[i] = q.keyOf(e.isDir, e.name, e.name, e.size, e.modified)
+	}
+	order := make([]int, len(d.entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return q.compare(keys[order[i]], keys[order[j]]) < 0
+	})
+
+	d.orders[name] = order
+	return order
+}
+
+type dirIndexCache struct {
+	mu   sync.Mutex
+	dirs map[string]*dirIndex
+}
+
+func newDirIndexCache() *dirIndexCache {
+	return &dirIndexCache{dirs: make(map[string]*dirIndex)}
+}
+
+// get returns the index of dir, rebuilding it when the directory changed or
+// the cached copy expired.
+func (c *dirIndexCache) get(dir string, info os.FileInfo) (*dirIndex, error) {
+	c.mu.Lock()
+	idx, ok := c.dirs[dir]
+	c.mu.Unlock()
+	if ok && idx.dirModTime.Equal(info.ModTime()) && time.Since(idx.loadedAt) < dirIndexTTL {
+		return idx, nil
+	}
+
+	idx, err := loadDirIndex(dir, info)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.dirs) >= maxCachedDirs {
+		var oldest string
+		for path, existing := range c.dirs {
+			if oldest == "" || existing.loadedAt.Before(c.dirs[oldest].loadedAt) {
+				oldest = path
+			}
+		}
+		delete(c.dirs, oldest)
+	}
+	c.dirs[dir] = idx
+	return idx, nil
+}
+
+func (c *dirIndexCache) invalidate(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.dirs, dir)
+}
+
+func loadDirIndex(dir string, info os.FileInfo) (*dirIndex, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &dirIndex{
+		dirModTime: info.ModTime(),
+		loadedAt:   time.Now(),
+		entries:    make([]dirEntry, 0, len(entries)),
+		orders:     make(map[string][]int),
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		entryInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		idx.entries = append(idx.entries, dirEntry{
+			name:     name,
+			isDir:    entryInfo.IsDir(),
+			size:     entryInfo.Size(),
+			modified: entryInfo.ModTime().Unix(),
+			modTime:  entryInfo.ModTime(),
+		})
+	}
+	return idx, nil
+}
+
+// indexItem builds the full FileSystemItem for an index entry of dir.
+func (p *PublicFilesService) indexItem(dir string, e *dirEntry) dtos.FileSystemItem {
+	filePath := filepath.Join(dir, e.name)
+	relPath, _ := filepath.Rel(p.publicDir, filePath)
+	modTime := e.modified
+	etagTime := e.modTime
+	if etagTime.IsZero() {
+		// Entries from the name index only keep whole seconds.
+		if info, err := os.Stat(filePath); err == nil {
+			etagTime = info.ModTime()
+		} else {
+			etagTime = time.Unix(e.modified, 0)
+		}
+	}
+
+	item := dtos.FileSystemItem{
+		ID:         p.itemID(relPath),
+		Name:       e.name,
+		Path:       relPath,
+		Size:       e.size,
+		IsDir:      e.isDir,
+		ModifiedAt: &modTime,
+		Etag:       p.fileEtag(filePath, etagTime, e.size),
+		Tags:       p.tags.get(filepath.ToSlash(relPath)),
+	}
+	if !e.isDir {
+		mimeType, mismatch := p.contentType(filepath.ToSlash(relPath))
+		item.MimeType = &mimeType
+		item.ContentMismatch = mismatch
+	}
+	return item
+}
@@ -0,0 +1,389 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	favoritesFile      = "favorites.json"
+	favoritesSaveDelay = 5 * time.Second
+	maxStarredItems    = 500
+	maxRecentItems     = 50
+	maxUserIDLength    = 128
+)
+
+// Actions recorded in the recent feed.
+const (
+	ActivityUpload   = "upload"
+	ActivityEdit     = "edit"
+	ActivityDownload = "download"
+)
+
+type starRecord struct {
+	ID        string `json:"id"`
+	StarredAt int64  `json:"starred_at"`
+}
+
+type recentRecord struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	UsedAt int64  `json:"used_at"`
+}
+
+type userActivity struct {
+	Starred []starRecord   `json:"starred,omitempty"`
+	Recent  []recentRecord `json:"recent,omitempty"`
+}
+
+// activityStore keeps each user's starred items and recently used files,
+// keyed by catalog ID so that both follow renames and moves. Entries whose
+// item was deleted are dropped when they are next listed. Stars are saved
+// right away; the recent feed changes on every download and is saved once
+// writes settle.
+type activityStore struct {
+	path string
+	once sync.Once
+
+	mu        sync.Mutex
+	users     map[string]*userActivity
+	saveTimer *time.Timer
+}
+
+func newActivityStore(path string) *activityStore {
+	return &activityStore{path: path, users: make(map[string]*userActivity)}
+}
+
+func (s *activityStore) load() {
+	s.once.Do(func() {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn().Err(err).Msg("Failed to read favorites")
+			}
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := json.Unmarshal(data, &s.users); err != nil {
+			log.Warn().Err(err).Msg("Failed to parse favorites")
+			s.users = make(map[string]*userActivity)
+		}
+	})
+}
+
+func (s *activityStore) userLocked(user string) *userActivity {
+	u := s.users[user]
+	if u == nil {
+		u = &userActivity{}
+		s.users[user] = u
+	}
+	return u
+}
+
+func (s *activityStore) star(user, id string, now int64) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.userLocked(user)
+	for _, r := range u.Starred {
+		if r.ID == id {
+			return nil
+		}
+	}
+	if len(u.Starred) >= maxStarredItems {
+		return fmt.Errorf("too many starred items (maximum %d)", maxStarredItems)
+	}
+	u.Starred = append([]starRecord{{ID: id, StarredAt: now}}, u.Starred...)
+	return s.saveLocked()
+}
+
+func (s *activityStore) unstar(user, id string) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.userLocked(user)
+	for i, r := range u.Starred {
+		if r.ID == id {
+			u.Starred = append(u.Starred[:i], u.Starred[i+1:]...)
+			return s.saveLocked()
+		}
+	}
+	return nil
+}
+
+// touch moves id to the front of the user's recent feed.
+func (s *activityStore) touch(user, id, action string, now int64) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.userLocked(user)
+	recent := []recentRecord{{ID: id, Action: action, UsedAt: now}}
+	for _, r := range u.Recent {
+		if r.ID != id && len(recent) < maxRecentItems {
+			recent = append(recent, r)
+		}
+	}
+	u.Recent = recent
+	s.changedLocked()
+}
+
+// starred and recent return copies of the user's lists after dropping the
+// IDs for which live reports false.
+func (s *activityStore) starred(user string, live func(id string) bool) []starRecord {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.userLocked(user)
+	kept := u.Starred[:0]
+	for _, r := range u.Starred {
+		if live(r.ID) {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) != len(u.Starred) {
+		u.Starred = kept
+		s.changedLocked()
+	}
+	return append([]starRecord(nil), kept...)
+}
+
+func (s *activityStore) recent(user string, live func(id string) bool) []recentRecord {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.userLocked(user)
+	kept := u.Recent[:0]
+	for _, r := range u.Recent {
+		if live(r.ID) {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) != len(u.Recent) {
+		u.Recent = kept
+		s.changedLocked()
+	}
+	return append([]recentRecord(nil), kept...)
+}
+
+// changedLocked schedules a save once writes have settled.
+func (s *activityStore) changedLocked() {
+	if s.saveTimer != nil {
+		return
+	}
+	s.saveTimer = time.AfterFunc(favoritesSaveDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.saveTimer = nil
+		s.saveLocked()
+	})
+}
+
+func (s *activityStore) saveLocked() error {
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to save favorites")
+		return err
+	}
+	return nil
+}
+
+// validUserID accepts the user IDs sent by clients. The empty ID stands for
+// anonymous clients, which share one set of favorites.
+func validUserID(user string) error {
+	if len(user) > maxUserIDLength {
+		return fmt.Errorf("user ID too long (maximum %d characters)", maxUserIDLength)
+	}
+	for _, r := range user {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("invalid character %q in user ID", r)
+		}
+	}
+	return nil
+}
+
+// itemAt builds the FileSystemItem for rel, or reports false if it no
+// longer exists.
+func (p *PublicFilesService) itemAt(rel string) (dtos.FileSystemItem, bool) {
+	abs := filepath.Join(p.publicDir, filepath.FromSlash(rel))
+	info, err := os.Stat(abs)
+	if err != nil {
+		return dtos.FileSystemItem{}, false
+	}
+	return p.indexItem(filepath.Dir(abs), &dirEntry{
+		name:     info.Name(),
+		isDir:    info.IsDir(),
+		size:     info.Size(),
+		modified: info.ModTime().Unix(),
+		modTime:  info.ModTime(),
+	}), true
+}
+
+// liveID reports whether the item with catalog ID id still exists.
+func (p *PublicFilesService) liveID(id string) bool {
+	rel, ok := p.catalog.lookup(id)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(p.publicDir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// StarItem adds a file or folder to the user's starred items.
+func (p *PublicFilesService) StarItem(user, path string) (map[string]interface{}, *dtos.ErrorResponse) {
+	return p.setStarred(user, path, true)
+}
+
+// UnstarItem removes a file or folder from the user's starred items.
+func (p *PublicFilesService) UnstarItem(user, path string) (map[string]interface{}, *dtos.ErrorResponse) {
+	return p.setStarred(user, path, false)
+}
+
+func (p *PublicFilesService) setStarred(user, path string, starred bool) (map[string]interface{}, *dtos.ErrorResponse) {
+	if err := validUserID(user); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	rel := p.relPath(target)
+	if rel == "" {
+		return nil, &dtos.ErrorResponse{
+			Error:     "The root folder cannot be starred",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Path resolves to the public directory: %q", path)),
+		}
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File or directory not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	id, err := p.catalog.id(rel)
+	if err == nil {
+		if starred {
+			err = p.favorites.star(user, id, time.Now().Unix())
+		} else {
+			err = p.favorites.unstar(user, id)
+		}
+	}
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to update starred items: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	return map[string]interface{}{
+		"success": true,
+		"path":    rel,
+		"starred": starred,
+	}, nil
+}
+
+// StarredItems lists the user's starred files and folders, most recently
+// starred first, at their current paths.
+func (p *PublicFilesService) StarredItems(user string) ([]dtos.StarredItem, *dtos.ErrorResponse) {
+	if err := validUserID(user); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	items := []dtos.StarredItem{}
+	for _, r := range p.favorites.starred(user, p.liveID) {
+		rel, _ := p.catalog.lookup(r.ID)
+		if item, ok := p.itemAt(rel); ok {
+			items = append(items, dtos.StarredItem{FileSystemItem: item, StarredAt: r.StarredAt})
+		}
+	}
+	return items, nil
+}
+
+// RecordActivity adds path to the user's recent feed. It is best effort:
+// invalid users and paths are ignored.
+func (p *PublicFilesService) RecordActivity(user, path, action string) {
+	if validUserID(user) != nil {
+		return
+	}
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return
+	}
+	rel := p.relPath(target)
+	if rel == "" {
+		return
+	}
+	id, err := p.catalog.id(rel)
+	if err != nil {
+		return
+	}
+	p.favorites.touch(user, id, action, time.Now().Unix())
+}
+
+// RecentItems lists the files the user recently uploaded, edited or
+// downloaded, most recent first.
+func (p *PublicFilesService) RecentItems(user string, limit int) ([]dtos.RecentItem, *dtos.ErrorResponse) {
+	if err := validUserID(user); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if limit <= 0 || limit > maxRecentItems {
+		limit = maxRecentItems
+	}
+
+	items := []dtos.RecentItem{}
+	for _, r := range p.favorites.recent(user, p.liveID) {
+		if len(items) == limit {
+			break
+		}
+		rel, _ := p.catalog.lookup(r.ID)
+		if item, ok := p.itemAt(rel); ok {
+			items = append(items, dtos.RecentItem{FileSystemItem: item, Action: r.Action, UsedAt: r.UsedAt})
+		}
+	}
+	return items, nil
+}
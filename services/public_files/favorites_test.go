@@ -0,0 +1,89 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func starredPaths(t *testing.T, service *PublicFilesService, user string) []string {
+	items, errResp := service.StarredItems(user)
+	assert.Nil(t, errResp)
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = filepath.ToSlash(item.Path)
+	}
+	return paths
+}
+
+func TestStarItem_FollowsMovesAndDeletes(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "projects", "alpha"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "projects", "alpha", "plan.md"), []byte("plan"), 0644)
+
+	_, errResp := service.StarItem("ana", "projects/alpha")
+	assert.Nil(t, errResp)
+	_, errResp = service.StarItem("ana", "projects/alpha/plan.md")
+	assert.Nil(t, errResp)
+	_, errResp = service.StarItem("ana", "projects/alpha")
+	assert.Nil(t, errResp)
+	_, errResp = service.StarItem("ana", "missing")
+	assert.NotNil(t, errResp)
+
+	assert.Equal(t, []string{"projects/alpha/plan.md", "projects/alpha"}, starredPaths(t, service, "ana"))
+	assert.Empty(t, starredPaths(t, service, "ben"))
+
+	_, errResp = service.MoveFolder("projects/alpha", "archive", "")
+	assert.Nil(t, errResp)
+	_, errResp = service.RenameFile("archive/plan.md", "archive/roadmap.md", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"archive/roadmap.md", "archive"}, starredPaths(t, service, "ana"))
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	assert.Equal(t, []string{"archive/roadmap.md", "archive"}, starredPaths(t, restarted, "ana"))
+
+	_, errResp = service.UnstarItem("ana", "archive")
+	assert.Nil(t, errResp)
+	_, errResp = service.DeleteItem("archive/roadmap.md")
+	assert.Nil(t, errResp)
+	os.WriteFile(filepath.Join(tmpDir, "archive", "roadmap.md"), []byte("new"), 0644)
+	assert.Empty(t, starredPaths(t, service, "ana"))
+}
+
+func TestRecentItems(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.UploadFile("a.txt", strings.NewReader("a"), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("b.txt", strings.NewReader("b"), "")
+	assert.Nil(t, errResp)
+
+	service.RecordActivity("ana", "a.txt", ActivityUpload)
+	service.RecordActivity("ana", "b.txt", ActivityUpload)
+	service.RecordActivity("ana", "a.txt", ActivityDownload)
+	service.RecordActivity("ana", "../etc/passwd", ActivityDownload)
+
+	items, errResp := service.RecentItems("ana", 10)
+	assert.Nil(t, errResp)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, "a.txt", items[0].Path)
+		assert.Equal(t, ActivityDownload, items[0].Action)
+		assert.Equal(t, "b.txt", items[1].Path)
+	}
+
+	_, errResp = service.RenameFile("b.txt", "c.txt", "")
+	assert.Nil(t, errResp)
+	items, _ = service.RecentItems("ana", 1)
+	assert.Len(t, items, 1)
+	items, _ = service.RecentItems("ana", 10)
+	assert.Equal(t, "c.txt", items[1].Path)
+
+	_, errResp = service.RecentItems("bad\nuser", 10)
+	assert.NotNil(t, errResp)
+}
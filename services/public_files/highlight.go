@@ -0,0 +1,543 @@
+package publicfiles
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token classes of highlighted code. They are emitted as tok-<class> CSS
+// classes.
+const (
+	tokKeyword = "keyword"
+	tokLiteral = "literal"
+	tokString  = "string"
+	tokComment = "comment"
+	tokNumber  = "number"
+	tokKey     = "key"
+	tokTag     = "tag"
+	tokAttr    = "attr"
+)
+
+// language describes just enough of a language's syntax for highlighting.
+type language struct {
+	name         string
+	keywords     map[string]bool
+	literals     map[string]bool
+	caseless     bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	// multiline quotes may span lines, like Go raw strings.
+	multiline string
+	// tripleQuotes enables Python style """ and ''' strings.
+	tripleQuotes bool
+	// keyStyle marks keys of configuration formats: ':' for YAML and JSON,
+	// '=' for TOML and INI.
+	keyStyle byte
+	// sections highlights [section] headers at the start of a line.
+	sections bool
+	markup   bool
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cFamilyComments = [2]string{"/*", "*/"}
+
+	langGo = &language{
+		name:         "go",
+		keywords:     wordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+		literals:     wordSet("true false nil iota"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'`",
+		multiline:    "`",
+	}
+	langJavaScript = &language{
+		name:         "javascript",
+		keywords:     wordSet("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while with yield"),
+		literals:     wordSet("true false null undefined NaN Infinity"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'`",
+		multiline:    "`",
+	}
+	langTypeScript = &language{
+		name:         "typescript",
+		keywords:     wordSet("abstract any as async await boolean break case catch class const constructor continue declare default delete do else enum export extends finally for from function if implements import in infer instanceof interface is keyof let namespace never new number object of private protected public readonly return static string super switch this throw try type typeof unknown var void while yield"),
+		literals:     wordSet("true false null undefined NaN Infinity"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'`",
+		multiline:    "`",
+	}
+	langPython = &language{
+		name:         "python",
+		keywords:     wordSet("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield match case"),
+		literals:     wordSet("True False None self"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		tripleQuotes: true,
+	}
+	langRust = &language{
+		name:         "rust",
+		keywords:     wordSet("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return static struct super trait type unsafe use where while Self self"),
+		literals:     wordSet("true false None Some Ok Err"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"",
+	}
+	langJava = &language{
+		name:         "java",
+		keywords:     wordSet("abstract assert boolean break byte case catch char class const continue default do double else enum extends final finally float for goto if implements import instanceof int interface long native new package private protected public record return short static strictfp super switch synchronized this throw throws transient try var void volatile while"),
+		literals:     wordSet("true false null"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'",
+	}
+	langC = &language{
+		name:         "c",
+		keywords:     wordSet("auto break case char const continue default do double else enum extern float for goto if inline int long register restrict return short signed sizeof static struct switch typedef union unsigned void volatile while #include #define #ifdef #ifndef #endif #if #else #elif #pragma #undef"),
+		literals:     wordSet("NULL true false"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'",
+	}
+	langCPP = &language{
+		name:         "cpp",
+		keywords:     wordSet("alignas alignof auto bool break case catch char class const constexpr const_cast continue decltype default delete do double dynamic_cast else enum explicit export extern float for friend goto if inline int long mutable namespace new noexcept operator private protected public register reinterpret_cast return short signed sizeof static static_assert static_cast struct switch template this throw try typedef typename union unsigned using virtual void volatile while #include #define #ifdef #ifndef #endif #if #else #elif #pragma #undef"),
+		literals:     wordSet("true false nullptr NULL"),
+		lineComments: []string{"//"},
+		blockComment: cFamilyComments,
+		quotes:       "\"'",
+	}
+	langSQL = &language{
+		name:         "sql",
+		keywords:     wordSet("add all alter and as asc begin between by case check column commit constraint create cross database default delete desc distinct drop else end exists foreign from full group having if in index inner insert into is join key left like limit not null offset on or order outer primary references right rollback select set table then transaction union unique update values view when where with"),
+		literals:     wordSet("true false null"),
+		caseless:     true,
+		lineComments: []string{"--"},
+		blockComment: cFamilyComments,
+		quotes:       "'\"",
+	}
+	langCSS = &language{
+		name:         "css",
+		keywords:     wordSet("@media @import @font-face @keyframes @supports @layer !important"),
+		blockComment: cFamilyComments,
+		quotes:       "\"'",
+	}
+	langJSON = &language{
+		name:     "json",
+		literals: wordSet("true false null"),
+		quotes:   "\"",
+		keyStyle: ':',
+	}
+	langYAML = &language{
+		name:         "yaml",
+		literals:     wordSet("true false null yes no on off ~"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keyStyle:     ':',
+	}
+	langTOML = &language{
+		name:         "toml",
+		literals:     wordSet("true false inf nan"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		tripleQuotes: true,
+		keyStyle:     '=',
+		sections:     true,
+	}
+	langINI = &language{
+		name:         "ini",
+		literals:     wordSet("true false yes no on off"),
+		lineComments: []string{"#", ";"},
+		quotes:       "\"'",
+		keyStyle:     '=',
+		sections:     true,
+	}
+	langMarkup    = &language{name: "markup", markup: true}
+	langPlainText = &language{name: "plaintext"}
+	langMarkdown  = &language{name: "markdown"}
+	langCSV       = &language{name: "csv"}
+)
+
+// languagesByExt covers allowedEditExtensions.
+var languagesByExt = map[string]*language{
+	"go":   langGo,
+	"js":   langJavaScript,
+	"jsx":  langJavaScript,
+	"ts":   langTypeScript,
+	"tsx":  langTypeScript,
+	"py":   langPython,
+	"rs":   langRust,
+	"java": langJava,
+	"c":    langC,
+	"h":    langC,
+	"cpp":  langCPP,
+	"hpp":  langCPP,
+	"sql":  langSQL,
+	"css":  langCSS,
+	"json": langJSON,
+	"yaml": langYAML,
+	"yml":  langYAML,
+	"toml": langTOML,
+	"ini":  langINI,
+	"env":  langINI,
+	"html": langMarkup,
+	"xml":  langMarkup,
+	"md":   langMarkdown,
+	"csv":  langCSV,
+	"txt":  langPlainText,
+}
+
+// languageByName finds a language by the name used in Markdown code fences.
+func languageByName(name string) *language {
+	name = strings.ToLower(name)
+	if lang, ok := languagesByExt[name]; ok {
+		return lang
+	}
+	for _, lang := range languagesByExt {
+		if lang.name == name {
+			return lang
+		}
+	}
+	switch name {
+	case "golang":
+		return langGo
+	case "python3":
+		return langPython
+	case "c++":
+		return langCPP
+	case "sh", "bash", "shell":
+		return langPlainText
+	}
+	return langPlainText
+}
+
+type token struct {
+	class string
+	text  string
+}
+
+// tokenize splits src into tokens. Text outside any token class has an
+// empty class.
+func (l *language) tokenize(src string) []token {
+	// Tokens are slices of src; emit extends the last one when the class
+	// repeats.
+	var tokens []token
+	offset := 0
+	emit := func(class string, n int) {
+		if n == 0 {
+			return
+		}
+		if k := len(tokens); k > 0 && tokens[k-1].class == class {
+			tokens[k-1].text = src[offset-len(tokens[k-1].text) : offset+n]
+		} else {
+			tokens = append(tokens, token{class, src[offset : offset+n]})
+		}
+		offset += n
+	}
+	if l.markup {
+		for _, tok := range tokenizeMarkup(src) {
+			emit(tok.class, len(tok.text))
+		}
+		return tokens
+	}
+
+	lineStart := true
+	for i := 0; i < len(src); {
+		rest := src[i:]
+		c := src[i]
+
+		if l.blockComment[0] != "" && strings.HasPrefix(rest, l.blockComment[0]) {
+			end := strings.Index(rest[len(l.blockComment[0]):], l.blockComment[1])
+			n := len(rest)
+			if end >= 0 {
+				n = len(l.blockComment[0]) + end + len(l.blockComment[1])
+			}
+			emit(tokComment, n)
+			i += n
+			lineStart = false
+			continue
+		}
+		if hasAnyPrefix(rest, l.lineComments) {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			emit(tokComment, n)
+			i += n
+			continue
+		}
+		if l.sections && lineStart && c == '[' {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			if end := strings.LastIndexByte(rest[:n], ']'); end > 0 {
+				emit(tokTag, end+1)
+				i += end + 1
+				lineStart = false
+				continue
+			}
+		}
+		if strings.IndexByte(l.quotes, c) >= 0 {
+			n := l.stringLen(rest)
+			class := tokString
+			if l.keyStyle != 0 && followedBy(rest[n:], l.keyStyle) {
+				class = tokKey
+			}
+			emit(class, n)
+			i += n
+			lineStart = false
+			continue
+		}
+		if c >= '0' && c <= '9' || c == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9' {
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			emit(tokNumber, n)
+			i += n
+			lineStart = false
+			continue
+		}
+		if isWordStart(rest) {
+			n := wordLen(rest)
+			word := rest[:n]
+			lookup := word
+			if l.caseless {
+				lookup = strings.ToLower(word)
+			}
+			switch {
+			case l.keyStyle != 0 && lineStart && followedBy(rest[n:], l.keyStyle):
+				emit(tokKey, n)
+			case l.keywords[lookup]:
+				emit(tokKeyword, n)
+			case l.literals[lookup]:
+				emit(tokLiteral, n)
+			default:
+				emit("", n)
+			}
+			i += n
+			lineStart = false
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		emit("", size)
+		i += size
+		switch c {
+		case '\n':
+			lineStart = true
+		case ' ', '\t', '-':
+			// Indentation and YAML list markers keep the line start.
+		default:
+			lineStart = false
+		}
+	}
+	return tokens
+}
+
+// stringLen returns the length of the string literal at the start of s,
+// stopping at the end of the line unless the quote allows more.
+func (l *language) stringLen(s string) int {
+	q := s[0]
+	if l.tripleQuotes && len(s) >= 3 && s[1] == q && s[2] == q {
+		if end := strings.Index(s[3:], s[:3]); end >= 0 {
+			return 3 + end + 3
+		}
+		return len(s)
+	}
+	multiline := strings.IndexByte(l.multiline, q) >= 0
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if q != '`' {
+				i++
+			}
+		case '\n':
+			if !multiline {
+				return i
+			}
+		case q:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// followedBy reports whether s continues with sep after optional spaces.
+func followedBy(s string, sep byte) bool {
+	s = strings.TrimLeft(s, " \t")
+	return s != "" && s[0] == sep
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isWordStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || r == '#' || r == '@' || r == '!' && strings.HasPrefix(s, "!important") || unicode.IsLetter(r)
+}
+
+// wordLen returns the length of the identifier at the start of s. A leading
+// '#', '@' or '!' is part of it, for preprocessor directives and CSS
+// at-rules.
+func wordLen(s string) int {
+	n := 0
+	for i, r := range s {
+		ok := r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) ||
+			i == 0 && (r == '#' || r == '@' || r == '!') ||
+			r == '-' && i > 0 && s[0] == '@'
+		if !ok {
+			break
+		}
+		n = i + utf8.RuneLen(r)
+	}
+	if n == 0 {
+		_, n = utf8.DecodeRuneInString(s)
+	}
+	return n
+}
+
+// tokenizeMarkup splits HTML or XML into comments, tags, attribute names
+// and values.
+func tokenizeMarkup(src string) []token {
+	var tokens []token
+	for len(src) > 0 {
+		switch {
+		case strings.HasPrefix(src, "<!--"):
+			n := strings.Index(src, "-->")
+			if n < 0 {
+				n = len(src)
+			} else {
+				n += 3
+			}
+			tokens = append(tokens, token{tokComment, src[:n]})
+			src = src[n:]
+		case src[0] == '<':
+			end := strings.IndexByte(src, '>')
+			if end < 0 {
+				end = len(src) - 1
+			}
+			tokens = append(tokens, tokenizeTag(src[:end+1])...)
+			src = src[end+1:]
+		default:
+			n := strings.IndexByte(src, '<')
+			if n < 0 {
+				n = len(src)
+			}
+			tokens = append(tokens, token{"", src[:n]})
+			src = src[n:]
+		}
+	}
+	return tokens
+}
+
+// tokenizeTag splits a tag into its name, attribute names and values.
+func tokenizeTag(tag string) []token {
+	n := 1
+	for n < len(tag) && (tag[n] == '/' || tag[n] == '?' || tag[n] == '!') {
+		n++
+	}
+	for n < len(tag) && tag[n] != ' ' && tag[n] != '\t' && tag[n] != '\n' && tag[n] != '>' && tag[n] != '/' {
+		n++
+	}
+	tokens := []token{{tokTag, tag[:n]}}
+	rest := tag[n:]
+	for len(rest) > 0 {
+		switch c := rest[0]; {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(rest[1:], c)
+			if end < 0 {
+				end = len(rest) - 2
+			}
+			tokens = append(tokens, token{tokString, rest[:end+2]})
+			rest = rest[end+2:]
+		case isWordByte(c) || c == '-' || c == ':':
+			end := 1
+			for end < len(rest) && (isWordByte(rest[end]) || rest[end] == '-' || rest[end] == ':') {
+				end++
+			}
+			tokens = append(tokens, token{tokAttr, rest[:end]})
+			rest = rest[end:]
+		case c == '>' || c == '/' && len(rest) == 2:
+			tokens = append(tokens, token{tokTag, rest})
+			rest = ""
+		default:
+			tokens = append(tokens, token{"", rest[:1]})
+			rest = rest[1:]
+		}
+	}
+	return tokens
+}
+
+// highlightLines renders src as escaped HTML, one string per line, with
+// tokens wrapped in spans. Tokens that span lines are closed and reopened
+// at line ends so that every line stands alone.
+func highlightLines(src string, lang *language) []string {
+	var lines []string
+	var line strings.Builder
+	for _, tok := range lang.tokenize(src) {
+		parts := strings.Split(tok.text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, line.String())
+				line.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if tok.class == "" {
+				line.WriteString(html.EscapeString(part))
+				continue
+			}
+			line.WriteString(`<span class="tok-` + tok.class + `">`)
+			line.WriteString(html.EscapeString(part))
+			line.WriteString("</span>")
+		}
+	}
+	return append(lines, line.String())
+}
+
+// highlightBlock renders lines as a highlighted code block, numbering them
+// from firstLine.
+func highlightBlock(lines []string, lang *language, firstLine int) string {
+	var b strings.Builder
+	b.WriteString(`<pre class="preview-code language-` + lang.name + `"><code>`)
+	if len(lines) > 0 {
+		for i, line := range highlightLines(strings.Join(lines, "\n"), lang) {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(`<span class="line" data-line="`)
+			b.WriteString(strconv.Itoa(firstLine + i))
+			b.WriteString(`">`)
+			b.WriteString(line)
+			b.WriteString("</span>")
+		}
+	}
+	b.WriteString("</code></pre>")
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package publicfiles
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// The hooks below keep the service's derived state in step with the tree.
+// Every operation that changes files calls one of them after it succeeded,
+// with absolute paths inside the public directory.
+
+// fileChanged is called after abs was created or rewritten. Folders are
+// synced recursively, which also drops entries for files they no longer
+// contain. Parent folders created along the way are indexed too.
+func (p *PublicFilesService) fileChanged(abs string) {
+	info, err := os.Stat(abs)
+	if err != nil {
+		p.fileRemoved(abs)
+		return
+	}
+
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		rel := p.relPath(dir)
+		if rel == "" || p.names.has(rel) {
+			break
+		}
+		if dirInfo, err := os.Stat(dir); err == nil {
+			p.names.put(rel, true, dirInfo.Size(), dirInfo.ModTime().Unix())
+		}
+	}
+
+	if info.IsDir() {
+		p.syncIndexes(context.Background(), abs, nil)
+		return
+	}
+	rel := p.relPath(abs)
+	p.names.put(rel, false, info.Size(), info.ModTime().Unix())
+	p.names.setMimeType(rel, sniffFile(abs))
+	p.indexFile(abs, info)
+}
+
+// fileRemoved is called after abs and everything below it was deleted.
+func (p *PublicFilesService) fileRemoved(abs string) {
+	rel := p.relPath(abs)
+	p.names.removePrefix(rel)
+	p.content.removePrefix(rel)
+	p.tags.removePrefix(rel)
+	p.catalog.removePrefix(rel)
+	p.versions.removePrefix(rel)
+	p.locks.removePrefix(rel)
+	p.props.removePrefix(rel)
+	p.collab.removed(rel)
+}
+
+// fileMoved is called after oldAbs was renamed to newAbs. Unless the move
+// merged into an existing folder, whatever was at newAbs has been replaced.
+func (p *PublicFilesService) fileMoved(oldAbs, newAbs string, merged bool) {
+	oldRel, newRel := p.relPath(oldAbs), p.relPath(newAbs)
+	if !merged {
+		p.names.removePrefix(newRel)
+		p.content.removePrefix(newRel)
+		p.tags.removePrefix(newRel)
+		p.catalog.removePrefix(newRel)
+		p.versions.removePrefix(newRel)
+		p.locks.removePrefix(newRel)
+		p.props.removePrefix(newRel)
+		p.collab.removed(newRel)
+	}
+	p.names.move(oldRel, newRel)
+	p.content.move(oldRel, newRel)
+	p.tags.move(oldRel, newRel)
+	p.catalog.move(oldRel, newRel)
+	p.versions.move(oldRel, newRel)
+	p.locks.move(oldRel, newRel)
+	p.props.move(oldRel, newRel)
+	p.collab.moved(oldRel, newRel)
+}
@@ -0,0 +1,679 @@
+package publicfiles
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	imageCacheDir = "image-cache"
+
+	// maxImageSourceSize and maxImageSourcePixels bound what is decoded.
+	// The pixel count is checked from the header before decoding, so that
+	// a small file cannot expand into gigabytes of pixels.
+	maxImageSourceSize   = 100 * 1024 * 1024
+	maxImageSourcePixels = 50_000_000
+
+	maxImageDimension  = 4096
+	defaultJPEGQuality = 82
+
+	// maxImageCacheSize is how much transformed images may take on disk
+	// before the least recently used are removed.
+	maxImageCacheSize       = 512 * 1024 * 1024
+	imageCachePruneInterval = time.Minute
+
+	// imageTransformVersion is part of every cache key, to be bumped when
+	// the output for the same parameters changes.
+	imageTransformVersion = 1
+)
+
+var imageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// imageCache keeps transformed images on disk, keyed by their source etag
+// and parameters. Transforms of the same key run once, and no more run at
+// a time than there are CPUs, up to two, to bound memory.
+type imageCache struct {
+	dir   string
+	group singleflight.Group
+	sem   chan struct{}
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func newImageCache(dir string) *imageCache {
+	return &imageCache{dir: dir, sem: make(chan struct{}, min(runtime.NumCPU(), 2))}
+}
+
+func (c *imageCache) path(key, format string) string {
+	return filepath.Join(c.dir, key[:2], key+"."+format)
+}
+
+// prune removes the least recently used images while the cache is larger
+// than maxImageCacheSize, at most once per imageCachePruneInterval.
+func (c *imageCache) prune() {
+	c.mu.Lock()
+	if time.Since(c.lastPrune) < imageCachePruneInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastPrune = time.Now()
+	c.mu.Unlock()
+
+	type cached struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cached
+	var total int64
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cached{path, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if total <= maxImageCacheSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= maxImageCacheSize*8/10 {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+}
+
+// imageParams are validated transform parameters.
+type imageParams struct {
+	width, height int
+	fit           string
+	format        string
+	quality       int
+	rotate        int
+	crop          image.Rectangle
+}
+
+func (ip imageParams) key(etag string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%d|%d|%s|%s|%d|%d|%v",
+		imageTransformVersion, normalizeEtag(etag), ip.width, ip.height, ip.fit, ip.format, ip.quality, ip.rotate, ip.crop)))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseImageOptions(opts dtos.ImageOptions) (imageParams, error) {
+	ip := imageParams{width: opts.Width, height: opts.Height, quality: opts.Quality}
+	if ip.width < 0 || ip.height < 0 || ip.width > maxImageDimension || ip.height > maxImageDimension {
+		return ip, fmt.Errorf("w and h must be between 1 and %d", maxImageDimension)
+	}
+
+	switch fit := strings.ToLower(opts.Fit); fit {
+	case "", "contain":
+		ip.fit = "contain"
+	case "cover":
+		ip.fit = "cover"
+	default:
+		return ip, fmt.Errorf("unknown fit %q: use cover or contain", opts.Fit)
+	}
+
+	switch format := strings.ToLower(opts.Format); format {
+	case "", "jpeg", "png", "webp":
+		ip.format = format
+	case "jpg":
+		ip.format = "jpeg"
+	default:
+		return ip, fmt.Errorf("unknown format %q: use jpeg, png or webp", opts.Format)
+	}
+
+	if ip.quality < 0 || ip.quality > 100 {
+		return ip, fmt.Errorf("q must be between 1 and 100, or 0 for the default")
+	}
+
+	if opts.Rotate%90 != 0 {
+		return ip, fmt.Errorf("rotate must be a multiple of 90 degrees")
+	}
+	ip.rotate = (opts.Rotate%360 + 360) % 360
+
+	if opts.Crop != "" {
+		parts := strings.Split(opts.Crop, ",")
+		ok := len(parts) == 4
+		var v [4]int
+		for i := 0; ok && i < 4; i++ {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+			v[i] = n
+			ok = err == nil && n >= 0 && (i < 2 || n > 0)
+		}
+		if !ok {
+			return ip, fmt.Errorf("crop must be x,y,width,height in pixels")
+		}
+		ip.crop = image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	}
+	return ip, nil
+}
+
+// TransformImage returns the JPEG, PNG or GIF image at path resized, cropped,
+// rotated or converted to JPEG, PNG or WebP as opts ask, and upright
+// according to its EXIF orientation.
+// Images are scaled to fit within w×h ("contain") or to fill it and are
+// cropped to it ("cover"), never enlarged. A crop in source pixels and a
+// clockwise rotation apply first. WebP output is lossless and ignores q.
+// Results are cached on disk by source etag and parameters.
+func (p *PublicFilesService) TransformImage(path string, opts dtos.ImageOptions) (io.ReadCloser, *dtos.TransformedImage, *dtos.ErrorResponse) {
+	params, err := parseImageOptions(opts)
+	if err != nil {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Invalid image parameters: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	file, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+			Status:    http.StatusNotFound,
+		}
+	}
+	if info.Size() > maxImageSourceSize {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     "Image too large to transform (maximum 100MB)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%s is %d bytes", file, info.Size())),
+			Status:    http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	// The source format decides the default output format, so it is read
+	// before the cache is looked at.
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	defer f.Close()
+	config, sourceFormat, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     "File is not a supported image (JPEG, PNG or GIF)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+			Status:    http.StatusUnsupportedMediaType,
+		}
+	}
+	if int64(config.Width)*int64(config.Height) > maxImageSourcePixels {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     "Image has too many pixels to transform (maximum 50 megapixels)",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%s is %dx%d", file, config.Width, config.Height)),
+			Status:    http.StatusRequestEntityTooLarge,
+		}
+	}
+	if params.format == "" {
+		params.format = "png"
+		if sourceFormat == "jpeg" {
+			params.format = "jpeg"
+		}
+	}
+	if params.format == "jpeg" && params.quality == 0 {
+		params.quality = defaultJPEGQuality
+	}
+	if params.format != "jpeg" {
+		params.quality = 0
+	}
+
+	key := params.key(p.fileEtag(file, info.ModTime(), info.Size()))
+	result := &dtos.TransformedImage{
+		ContentType: imageContentTypes[params.format],
+		Etag:        `"img-` + key[:32] + `"`,
+	}
+	cachePath := p.images.path(key, params.format)
+
+	if cached, err := os.Open(cachePath); err == nil {
+		if stat, err := cached.Stat(); err == nil {
+			now := time.Now()
+			os.Chtimes(cachePath, now, now)
+			result.Size = stat.Size()
+			result.Cached = true
+			return cached, result, nil
+		}
+		cached.Close()
+	}
+
+	_, err, _ = p.images.group.Do(key, func() (interface{}, error) {
+		if _, err := os.Stat(cachePath); err == nil {
+			return nil, nil
+		}
+		p.images.sem <- struct{}{}
+		defer func() { <-p.images.sem }()
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		encoded, err := transformImage(f, sourceFormat, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(cachePath, encoded, 0644); err != nil {
+			return nil, err
+		}
+		go p.images.prune()
+		return nil, nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("path", file).Msg("Failed to transform image")
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to transform image: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+			Status:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	out, err := os.Open(cachePath)
+	if err != nil {
+		return nil, nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read transformed image: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if stat, err := out.Stat(); err == nil {
+		result.Size = stat.Size()
+	}
+	return out, result, nil
+}
+
+// transformImage decodes the image in r and applies params to it.
+func transformImage(r io.ReadSeeker, sourceFormat string, params imageParams) ([]byte, error) {
+	orientation := 1
+	head := make([]byte, 256*1024)
+	n, _ := io.ReadFull(r, head)
+	switch sourceFormat {
+	case "jpeg":
+		orientation = jpegOrientation(head[:n])
+	case "png":
+		orientation = pngOrientation(head[:n])
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	decoded, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	img := toRGBA(decoded)
+	img = orient(img, orientation)
+	if !params.crop.Empty() {
+		area := params.crop.Intersect(img.Rect)
+		if area.Empty() {
+			return nil, fmt.Errorf("crop %v is outside the %dx%d image", params.crop, img.Rect.Dx(), img.Rect.Dy())
+		}
+		img = cropRGBA(img, area)
+	}
+	switch params.rotate {
+	case 90:
+		img = orient(img, 6)
+	case 180:
+		img = orient(img, 3)
+	case 270:
+		img = orient(img, 8)
+	}
+	img = fitImage(img, params.width, params.height, params.fit)
+
+	var out bytes.Buffer
+	switch params.format {
+	case "jpeg":
+		// JPEG has no alpha; transparent areas become white.
+		flat := image.NewRGBA(img.Rect)
+		draw.Draw(flat, flat.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Rect, img, img.Rect.Min, draw.Over)
+		err = jpeg.Encode(&out, flat, &jpeg.Options{Quality: params.quality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&out, img)
+	case "webp":
+		err = encodeWebP(&out, img)
+	}
+	return out.Bytes(), err
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Rect, img, b.Min, draw.Src)
+	return out
+}
+
+func cropRGBA(img *image.RGBA, area image.Rectangle) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(out, out.Rect, img, area.Min, draw.Src)
+	return out
+}
+
+// orient applies an EXIF orientation: 2 to 4 mirror or turn the image
+// half way, 5 to 8 swap its axes.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// source maps a pixel of the result to the pixel of img it shows.
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2:
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3:
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4:
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5:
+		source = func(x, y int) (int, int) { return y, x }
+	case 6:
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7:
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8:
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(out.Pix[out.PixOffset(x, y):][:4], img.Pix[img.PixOffset(sx, sy):][:4])
+		}
+	}
+	return out
+}
+
+// fitImage scales img down to fit within width×height, either whole
+// ("contain") or covering the box with the excess cropped evenly
+// ("cover"). A zero side follows the aspect ratio.
+func fitImage(img *image.RGBA, width, height int, fit string) *image.RGBA {
+	sw, sh := img.Rect.Dx(), img.Rect.Dy()
+	if width == 0 && height == 0 {
+		return img
+	}
+	scaleX, scaleY := float64(width)/float64(sw), float64(height)/float64(sh)
+	var scale float64
+	switch {
+	case width == 0:
+		scale = scaleY
+	case height == 0:
+		scale = scaleX
+	case fit == "cover":
+		scale = math.Max(scaleX, scaleY)
+	default:
+		scale = math.Min(scaleX, scaleY)
+	}
+	scale = math.Min(scale, 1)
+
+	if fit == "cover" && width > 0 && height > 0 {
+		cw := min(sw, int(math.Round(float64(width)/scale)))
+		ch := min(sh, int(math.Round(float64(height)/scale)))
+		if cw < sw || ch < sh {
+			img = cropRGBA(img, image.Rect((sw-cw)/2, (sh-ch)/2, (sw-cw)/2+cw, (sh-ch)/2+ch))
+			sw, sh = cw, ch
+		}
+	}
+	tw := max(1, int(math.Round(float64(sw)*scale)))
+	th := max(1, int(math.Round(float64(sh)*scale)))
+	if tw == sw && th == sh {
+		return img
+	}
+	return resample(img, tw, th)
+}
+
+// resampleWeights are the filter taps of an output pixel, from start.
+type resampleWeights struct {
+	start   int
+	weights []float32
+}
+
+// catmullRom is the Catmull-Rom cubic, a sharp filter with support 2.
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// makeWeights computes the taps scaling srcLen pixels to dstLen. The filter
+// widens with the scale when shrinking, so that every source pixel counts.
+func makeWeights(srcLen, dstLen int) []resampleWeights {
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := math.Max(scale, 1)
+	support := 2 * filterScale
+	out := make([]resampleWeights, dstLen)
+	for i := range out {
+		center := (float64(i) + 0.5) * scale
+		lo := max(0, int(math.Floor(center-support)))
+		hi := min(srcLen, int(math.Ceil(center+support)))
+		w := resampleWeights{start: lo, weights: make([]float32, hi-lo)}
+		var sum float64
+		for j := lo; j < hi; j++ {
+			v := catmullRom((float64(j) + 0.5 - center) / filterScale)
+			w.weights[j-lo] = float32(v)
+			sum += v
+		}
+		if sum != 0 {
+			for j := range w.weights {
+				w.weights[j] /= float32(sum)
+			}
+		}
+		out[i] = w
+	}
+	return out
+}
+
+// resample scales img to width×height in two passes, horizontally and then
+// vertically. The intermediate rows hold 8.8 fixed point values.
+func resample(img *image.RGBA, width, height int) *image.RGBA {
+	sh := img.Rect.Dy()
+	xWeights, yWeights := makeWeights(img.Rect.Dx(), width), makeWeights(sh, height)
+
+	mid := make([]uint16, width*sh*4)
+	for y := 0; y < sh; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x, w := range xWeights {
+			var acc [4]float32
+			for k, weight := range w.weights {
+				p := row[(w.start+k)*4:]
+				acc[0] += float32(p[0]) * weight
+				acc[1] += float32(p[1]) * weight
+				acc[2] += float32(p[2]) * weight
+				acc[3] += float32(p[3]) * weight
+			}
+			o := (y*width + x) * 4
+			for c := range acc {
+				mid[o+c] = uint16(min(max(acc[c]*256, 0), 65535))
+			}
+		}
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, w := range yWeights {
+		for x := 0; x < width; x++ {
+			var acc [4]float32
+			for k, weight := range w.weights {
+				o := ((w.start+k)*width + x) * 4
+				acc[0] += float32(mid[o]) * weight
+				acc[1] += float32(mid[o+1]) * weight
+				acc[2] += float32(mid[o+2]) * weight
+				acc[3] += float32(mid[o+3]) * weight
+			}
+			p := out.Pix[y*out.Stride+x*4:]
+			a := min(max(acc[3]/256+0.5, 0), 255)
+			p[3] = uint8(a)
+			// Premultiplied colors cannot exceed alpha.
+			for c := 0; c < 3; c++ {
+				p[c] = uint8(min(max(acc[c]/256+0.5, 0), a))
+			}
+		}
+	}
+	return out
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG from its header
+// segments, 1 when there is none.
+func jpegOrientation(head []byte) int {
+	if len(head) < 4 || head[0] != 0xff || head[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(head); {
+		if head[i] != 0xff {
+			return 1
+		}
+		marker := head[i+1]
+		switch {
+		case marker == 0xff:
+			i++
+			continue
+		case marker == 0xda || marker == 0xd9:
+			// Image data starts; metadata comes before it.
+			return 1
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(head[i+2:]))
+		if length < 2 || i+2+length > len(head) {
+			return 1
+		}
+		segment := head[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// pngOrientation reads the orientation from a PNG eXIf chunk.
+func pngOrientation(head []byte) int {
+	if !bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")) {
+		return 1
+	}
+	for i := 8; i+8 <= len(head); {
+		length := int(binary.BigEndian.Uint32(head[i:]))
+		kind := string(head[i+4 : i+8])
+		if kind == "IDAT" || length < 0 || i+8+length > len(head) {
+			return 1
+		}
+		if kind == "eXIf" {
+			return tiffOrientation(head[i+8 : i+8+length])
+		}
+		i += 12 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds the Orientation tag in the first IFD of EXIF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		e := ifd + 2 + 12*k
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) != 0x0112 {
+			continue
+		}
+		// The tag is a SHORT stored in the first bytes of the value field.
+		if order.Uint16(tiff[e+2:]) != 3 {
+			return 1
+		}
+		if v := int(order.Uint16(tiff[e+8:])); v >= 1 && v <= 8 {
+			return v
+		}
+		return 1
+	}
+	return 1
+}
@@ -0,0 +1,238 @@
+package publicfiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+// twoToneImage is red on its left half and blue on its right half.
+func twoToneImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func readTransformed(t *testing.T, body io.ReadCloser) (image.Image, string) {
+	t.Helper()
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	img, format, err := image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	return img, format
+}
+
+func TestParseImageOptions(t *testing.T) {
+	params, err := parseImageOptions(dtos.ImageOptions{Width: 10, Format: "JPG", Rotate: -90, Crop: "1, 2,3,4"})
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", params.format)
+	assert.Equal(t, "contain", params.fit)
+	assert.Equal(t, 270, params.rotate)
+	assert.Equal(t, image.Rect(1, 2, 4, 6), params.crop)
+
+	for _, opts := range []dtos.ImageOptions{
+		{Width: -1},
+		{Height: maxImageDimension + 1},
+		{Fit: "stretch"},
+		{Format: "bmp"},
+		{Quality: 101},
+		{Rotate: 45},
+		{Crop: "1,2,3"},
+		{Crop: "0,0,0,5"},
+		{Crop: "-1,0,5,5"},
+	} {
+		_, err := parseImageOptions(opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
+
+func TestTransformImage_FitAndCache(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, twoToneImage(400, 200)))
+	os.WriteFile(filepath.Join(tmpDir, "wide.png"), buf.Bytes(), 0644)
+
+	body, result, errResp := service.TransformImage("wide.png", dtos.ImageOptions{Width: 100})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "image/png", result.ContentType)
+	assert.False(t, result.Cached)
+	img, format := readTransformed(t, body)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+
+	body, again, errResp := service.TransformImage("wide.png", dtos.ImageOptions{Width: 100})
+	assert.Nil(t, errResp)
+	body.Close()
+	assert.True(t, again.Cached)
+	assert.Equal(t, result.Etag, again.Etag)
+
+	body, _, errResp = service.TransformImage("wide.png", dtos.ImageOptions{Width: 50, Height: 50, Fit: "cover", Format: "jpeg"})
+	assert.Nil(t, errResp)
+	img, format = readTransformed(t, body)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), img.Bounds())
+
+	// Images are never enlarged.
+	body, _, errResp = service.TransformImage("wide.png", dtos.ImageOptions{Width: 1000, Height: 1000})
+	assert.Nil(t, errResp)
+	img, _ = readTransformed(t, body)
+	assert.Equal(t, image.Rect(0, 0, 400, 200), img.Bounds())
+}
+
+func TestTransformImage_CropRotateAndWebP(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, twoToneImage(40, 20)))
+	os.WriteFile(filepath.Join(tmpDir, "tone.png"), buf.Bytes(), 0644)
+
+	body, _, errResp := service.TransformImage("tone.png", dtos.ImageOptions{Crop: "0,0,20,20", Rotate: 90})
+	assert.Nil(t, errResp)
+	img, _ := readTransformed(t, body)
+	assert.Equal(t, image.Rect(0, 0, 20, 20), img.Bounds())
+	r, _, b, _ := img.At(10, 10).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	assert.Equal(t, uint32(0), b)
+
+	body, result, errResp := service.TransformImage("tone.png", dtos.ImageOptions{Format: "webp"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "image/webp", result.ContentType)
+	data, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal(t, "RIFF", string(data[:4]))
+	assert.Equal(t, "WEBPVP8L", string(data[8:16]))
+	bits := binary.LittleEndian.Uint32(data[21:])
+	assert.Equal(t, uint32(40), bits&0x3fff+1)
+	assert.Equal(t, uint32(20), bits>>14&0x3fff+1)
+
+	_, _, errResp = service.TransformImage("tone.png", dtos.ImageOptions{Crop: "100,100,5,5"})
+	assert.NotNil(t, errResp)
+}
+
+func TestEncodeWebP_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rng.Read(noise.Pix)
+	gradient := image.NewNRGBA(image.Rect(0, 0, 600, 3))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 3; y++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(x * 7), uint8(y * 90), 255})
+		}
+	}
+	flat := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	for i := range flat.Pix {
+		flat.Pix[i] = 0x80
+	}
+
+	for name, src := range map[string]image.Image{
+		"noise":    noise,
+		"gradient": gradient,
+		"flat":     flat,
+		"tone":     twoToneImage(40, 20),
+		"pixel":    twoToneImage(1, 1),
+	} {
+		var buf bytes.Buffer
+		assert.NoError(t, encodeWebP(&buf, src), name)
+		img, err := webp.Decode(&buf)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, src.Bounds(), img.Bounds(), name)
+		mismatches := 0
+		for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+			for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
+				if color.NRGBAModel.Convert(src.At(x, y)) != color.NRGBAModel.Convert(img.At(x, y)) {
+					mismatches++
+				}
+			}
+		}
+		assert.Zero(t, mismatches, name)
+	}
+}
+
+// withOrientation inserts an EXIF segment with the given orientation after
+// the start of a JPEG.
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestTransformImage_ExifOrientation(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, twoToneImage(40, 20), &jpeg.Options{Quality: 95}))
+	photo := withOrientation(buf.Bytes(), 6)
+	assert.Equal(t, 6, jpegOrientation(photo))
+	os.WriteFile(filepath.Join(tmpDir, "photo.jpg"), photo, 0644)
+
+	body, result, errResp := service.TransformImage("photo.jpg", dtos.ImageOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "image/jpeg", result.ContentType)
+	img, _ := readTransformed(t, body)
+	// Turned clockwise, the red left half is on top.
+	assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+	r, _, b, _ := img.At(10, 5).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(10, 35).RGBA()
+	assert.Greater(t, b, r)
+}
+
+func TestTransformImage_RejectsBombsAndOtherFiles(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	// A PNG header claiming 20000×20000 pixels, with no data.
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], 20000)
+	binary.BigEndian.PutUint32(ihdr[8:], 20000)
+	ihdr[12], ihdr[13] = 8, 6
+	bomb := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	bomb = append(bomb, ihdr...)
+	bomb = binary.BigEndian.AppendUint32(bomb, crc32.ChecksumIEEE(ihdr))
+	os.WriteFile(filepath.Join(tmpDir, "bomb.png"), bomb, 0644)
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("not an image"), 0644)
+
+	_, _, errResp := service.TransformImage("bomb.png", dtos.ImageOptions{Width: 100})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, errResp.Status)
+
+	_, _, errResp = service.TransformImage("notes.txt", dtos.ImageOptions{})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusUnsupportedMediaType, errResp.Status)
+
+	_, _, errResp = service.TransformImage("missing.png", dtos.ImageOptions{})
+	assert.NotNil(t, errResp)
+}
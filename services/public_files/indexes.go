@@ -0,0 +1,201 @@
+package publicfiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// syncIndexes brings the name and content index entries at or below root in
+// line with the disk in a single walk. Unchanged files are not read again.
+// progress, when set, is called with the number of entries examined so far.
+// It returns how many files were (re)indexed and how many entries were
+// dropped.
+func (p *PublicFilesService) syncIndexes(ctx context.Context, root string, progress func(examined int64)) (indexed, removed int, err error) {
+	prefix := p.relPath(root)
+	seenNames := make(map[string]bool)
+	seenContent := make(map[string]bool)
+	var examined int64
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		examined++
+		if progress != nil {
+			progress(examined)
+		}
+
+		rel := p.relPath(path)
+		if rel != "" {
+			seenNames[rel] = true
+			p.names.put(rel, d.IsDir(), info.Size(), info.ModTime().Unix())
+			if !d.IsDir() && p.names.mimeType(rel) == "" {
+				p.names.setMimeType(rel, sniffFile(path))
+			}
+		}
+
+		if d.IsDir() || !isIndexable(d.Name()) || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		seenContent[rel] = true
+		if doc, ok := p.content.get(rel); ok && doc.current(info) {
+			return nil
+		}
+		p.indexFile(path, info)
+		indexed++
+		return nil
+	})
+	if err != nil {
+		return indexed, removed, err
+	}
+
+	for _, rel := range p.names.paths(prefix) {
+		if !seenNames[rel] {
+			p.names.remove(rel)
+			p.catalog.removePrefix(rel)
+			removed++
+		}
+	}
+	for _, rel := range p.content.paths(prefix) {
+		if !seenContent[rel] {
+			p.content.remove(rel)
+		}
+	}
+	if prefix == "" {
+		p.names.markBuilt()
+	}
+	return indexed, removed, nil
+}
+
+// ensureNameIndex builds the name index with a full walk unless it was
+// loaded from a snapshot or built before. Concurrent callers wait for the
+// same walk.
+func (p *PublicFilesService) ensureNameIndex() error {
+	if p.names.isBuilt() {
+		return nil
+	}
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+	if p.names.isBuilt() {
+		return nil
+	}
+	if err := p.ensurePublicDir(); err != nil {
+		return err
+	}
+	_, _, err := p.syncIndexes(context.Background(), p.publicDir, nil)
+	return err
+}
+
+// LoadIndexes restores the name and content indexes from their last
+// snapshots and enables persistence. It returns the number of entries
+// loaded; call Reindex afterwards to pick up changes made while the service
+// was down.
+func (p *PublicFilesService) LoadIndexes() (names, docs int, err error) {
+	if err := p.ensurePublicDir(); err != nil {
+		return 0, 0, err
+	}
+	err = errors.Join(
+		p.names.load(p.metaPath(nameIndexFile)),
+		p.content.load(p.metaPath(contentIndexFile)),
+	)
+	return len(p.names.paths("")), len(p.content.paths("")), err
+}
+
+func (p *PublicFilesService) saveIndexes() error {
+	return errors.Join(p.names.save(), p.content.save())
+}
+
+// RebuildIndexes synchronously re-indexes the whole tree and writes the
+// snapshots. It backs the offline reindex command.
+func (p *PublicFilesService) RebuildIndexes(ctx context.Context) (indexed, removed int, err error) {
+	if _, _, err := p.LoadIndexes(); err != nil {
+		log.Warn().Err(err).Msg("Rebuilding indexes from scratch")
+	}
+	if err := p.ensureMetaDir(); err != nil {
+		return 0, 0, err
+	}
+	indexed, removed, err = p.syncIndexes(ctx, p.publicDir, nil)
+	if err != nil {
+		return indexed, removed, err
+	}
+	return indexed, removed, p.saveIndexes()
+}
+
+// Reindex starts a background job that reconciles the name and content
+// indexes with the disk, picking up changes made outside the API. While a
+// re-index is running, its job is returned instead of starting another one.
+func (p *PublicFilesService) Reindex() (map[string]interface{}, *dtos.ErrorResponse) {
+	if err := p.ensureMetaDir(); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to prepare index directory: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.reindexMu.Lock()
+	defer p.reindexMu.Unlock()
+
+	if job := p.reindexJob; job != nil && job.snapshot().Status == jobStatusRunning {
+		return map[string]interface{}{
+			"success": true,
+			"job_id":  job.ID,
+			"status":  jobStatusRunning,
+		}, nil
+	}
+
+	job := p.startJob("reindex", func(job *Job) (map[string]interface{}, error) {
+		p.syncMu.Lock()
+		defer p.syncMu.Unlock()
+
+		indexed, removed, err := p.syncIndexes(job.ctx, p.publicDir, func(examined int64) {
+			p.reportProgress(job, examined, 0, 0)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := p.saveIndexes(); err != nil {
+			log.Warn().Err(err).Msg("Failed to save indexes")
+		}
+		return map[string]interface{}{
+			"indexed":   indexed,
+			"removed":   removed,
+			"entries":   len(p.names.paths("")),
+			"documents": len(p.content.paths("")),
+		}, nil
+	})
+	p.reindexJob = job
+
+	return map[string]interface{}{
+		"success": true,
+		"job_id":  job.ID,
+		"status":  jobStatusRunning,
+	}, nil
+}
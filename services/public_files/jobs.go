@@ -0,0 +1,189 @@
+package publicfiles
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+const (
+	jobStatusRunning   = "running"
+	jobStatusCompleted = "completed"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+
+	jobProgressInterval = 500 * time.Millisecond
+	jobRetention        = 1 * time.Hour
+)
+
+// Job is a long running operation executed in the background. Progress is
+// pushed over the WebSocket hub and can be polled through GetJob. Work
+// functions should stop early once ctx is cancelled through CancelJob.
+type Job struct {
+	ID   string
+	Type string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.RWMutex
+	status     string
+	processed  int64
+	total      int64
+	bytes      int64
+	errMsg     string
+	result     map[string]interface{}
+	createdAt  int64
+	updatedAt  int64
+	lastNotify time.Time
+	done       chan struct{}
+}
+
+type jobManager struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{jobs: make(map[string]*Job)}
+}
+
+func (m *jobManager) get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+func (m *jobManager) add(job *Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-jobRetention).Unix()
+	for id, existing := range m.jobs {
+		existing.mu.RLock()
+		finished := existing.status != jobStatusRunning && existing.updatedAt < cutoff
+		existing.mu.RUnlock()
+		if finished {
+			delete(m.jobs, id)
+		}
+	}
+	m.jobs[job.ID] = job
+}
+
+func (j *Job) snapshot() *dtos.JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	status := &dtos.JobStatus{
+		ID:        j.ID,
+		Type:      j.Type,
+		Status:    j.status,
+		Processed: j.processed,
+		Total:     j.total,
+		Bytes:     j.bytes,
+		Result:    j.result,
+		CreatedAt: j.createdAt,
+		UpdatedAt: j.updatedAt,
+	}
+	if j.errMsg != "" {
+		status.Error = ptrString(j.errMsg)
+	}
+	return status
+}
+
+// startJob registers a job and runs fn in its own goroutine. The returned job
+// is already visible through GetJob when startJob returns.
+func (p *PublicFilesService) startJob(jobType string, fn func(job *Job) (map[string]interface{}, error)) *Job {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        uuid.New().String(),
+		Type:      jobType,
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    jobStatusRunning,
+		createdAt: now.Unix(),
+		updatedAt: now.Unix(),
+		done:      make(chan struct{}),
+	}
+	p.jobs.add(job)
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+
+		result, err := fn(job)
+
+		job.mu.Lock()
+		job.updatedAt = time.Now().Unix()
+		if err != nil && ctx.Err() != nil {
+			job.status = jobStatusCancelled
+			job.errMsg = "Job was cancelled"
+		} else if err != nil {
+			job.status = jobStatusFailed
+			job.errMsg = err.Error()
+		} else {
+			job.status = jobStatusCompleted
+			job.result = result
+		}
+		status := job.status
+		job.mu.Unlock()
+
+		p.notifyWebSocket("job_"+status, job.snapshot())
+	}()
+
+	return job
+}
+
+// reportProgress updates the job counters and broadcasts them, throttled so
+// that jobs touching many small entries do not flood the hub.
+func (p *PublicFilesService) reportProgress(job *Job, processed, total, bytes int64) {
+	job.mu.Lock()
+	job.processed = processed
+	job.total = total
+	job.bytes = bytes
+	job.updatedAt = time.Now().Unix()
+	notify := time.Since(job.lastNotify) >= jobProgressInterval
+	if notify {
+		job.lastNotify = time.Now()
+	}
+	job.mu.Unlock()
+
+	if notify {
+		p.notifyWebSocket("job_progress", job.snapshot())
+	}
+}
+
+func (p *PublicFilesService) GetJob(id string) (*dtos.JobStatus, *dtos.ErrorResponse) {
+	job, ok := p.jobs.get(id)
+	if !ok {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Job not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Unknown job id: %s", id)),
+		}
+	}
+	return job.snapshot(), nil
+}
+
+// CancelJob asks a running job to stop. Jobs clean up the partial output
+// they produced before reporting the cancelled status.
+func (p *PublicFilesService) CancelJob(id string) (*dtos.JobStatus, *dtos.ErrorResponse) {
+	job, ok := p.jobs.get(id)
+	if !ok {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Job not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Unknown job id: %s", id)),
+		}
+	}
+	job.cancel()
+	return job.snapshot(), nil
+}
@@ -0,0 +1,225 @@
+package publicfiles
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+)
+
+const (
+	sortName     = "name"
+	sortSize     = "size"
+	sortModified = "modified"
+	sortType     = "type"
+)
+
+var itemFields = map[string]bool{
+	"id":               true,
+	"name":             true,
+	"path":             true,
+	"size":             true,
+	"is_dir":           true,
+	"created_at":       true,
+	"modified_at":      true,
+	"mime_type":        true,
+	"etag":             true,
+	"tags":             true,
+	"content_mismatch": true,
+	"file_count":       true,
+	"folder_count":     true,
+}
+
+// listQuery is the validated form of dtos.ListOptions.
+type listQuery struct {
+	sort           string
+	desc           bool
+	onlyDirs       bool
+	onlyFiles      bool
+	mimes          []string
+	exts           []string
+	minSize        *int64
+	maxSize        *int64
+	modifiedAfter  *int64
+	modifiedBefore *int64
+	fields         []string
+	folderSizes    bool
+}
+
+func parseListOptions(opts dtos.ListOptions) (*listQuery, error) {
+	q := &listQuery{}
+
+	switch s := strings.ToLower(opts.Sort); s {
+	case "", sortName, sortSize, sortModified, sortType:
+		q.sort = s
+	default:
+		return nil, fmt.Errorf("invalid sort: %s (expected name, size, modified or type)", opts.Sort)
+	}
+
+	switch strings.ToLower(opts.Order) {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s (expected asc or desc)", opts.Order)
+	}
+
+	switch strings.ToLower(opts.Type) {
+	case "":
+	case "file":
+		q.onlyFiles = true
+	case "dir", "folder":
+		q.onlyDirs = true
+	default:
+		return nil, fmt.Errorf("invalid type: %s (expected file or dir)", opts.Type)
+	}
+
+	for _, m := range splitList(opts.Mime) {
+		q.mimes = append(q.mimes, strings.ToLower(m))
+	}
+	for _, ext := range splitList(opts.Ext) {
+		q.exts = append(q.exts, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+
+	if opts.MinSize != nil && *opts.MinSize < 0 || opts.MaxSize != nil && *opts.MaxSize < 0 {
+		return nil, fmt.Errorf("size filters must not be negative")
+	}
+	q.minSize, q.maxSize = opts.MinSize, opts.MaxSize
+
+	var err error
+	if q.modifiedAfter, err = parseTimeFilter("modified_after", opts.ModifiedAfter); err != nil {
+		return nil, err
+	}
+	if q.modifiedBefore, err = parseTimeFilter("modified_before", opts.ModifiedBefore); err != nil {
+		return nil, err
+	}
+
+	for _, field := range splitList(opts.Fields) {
+		if !itemFields[field] {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		q.fields = append(q.fields, field)
+	}
+	q.folderSizes = opts.FolderSizes
+
+	return q, nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseTimeFilter accepts a Unix timestamp in seconds or an RFC 3339 date.
+func parseTimeFilter(name, value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return &ts, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: expected a Unix timestamp or RFC 3339 date", name)
+	}
+	ts := t.Unix()
+	return &ts, nil
+}
+
+// filtered reports whether q narrows the result set at all.
+func (q *listQuery) filtered() bool {
+	return q.onlyDirs || q.onlyFiles || len(q.mimes) > 0 || len(q.exts) > 0 ||
+		q.minSize != nil || q.maxSize != nil || q.modifiedAfter != nil || q.modifiedBefore != nil
+}
+
+func (q *listQuery) match(item *dtos.FileSystemItem) bool {
+	if q.onlyDirs && !item.IsDir || q.onlyFiles && item.IsDir {
+		return false
+	}
+
+	if len(q.mimes) > 0 {
+		if item.MimeType == nil {
+			return false
+		}
+		mimeType := strings.ToLower(*item.MimeType)
+		if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+			mimeType = strings.TrimSpace(mimeType[:i])
+		}
+		if !matchesAny(q.mimes, func(pattern string) bool {
+			if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+				return strings.HasPrefix(mimeType, prefix+"/")
+			}
+			return mimeType == pattern
+		}) {
+			return false
+		}
+	}
+
+	if len(q.exts) > 0 {
+		ext := strings.ToLower(filepath.Ext(item.Name))
+		if item.IsDir || !matchesAny(q.exts, func(e string) bool { return e == ext }) {
+			return false
+		}
+	}
+
+	if q.minSize != nil && item.Size < *q.minSize || q.maxSize != nil && item.Size > *q.maxSize {
+		return false
+	}
+
+	if q.modifiedAfter != nil || q.modifiedBefore != nil {
+		if item.ModifiedAt == nil {
+			return false
+		}
+		if q.modifiedAfter != nil && *item.ModifiedAt < *q.modifiedAfter {
+			return false
+		}
+		if q.modifiedBefore != nil && *item.ModifiedAt > *q.modifiedBefore {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesAny(list []string, fn func(string) bool) bool {
+	for _, item := range list {
+		if fn(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortItems orders folders before files and then by the requested key,
+// falling back to the lowercase name and the path for ties.
+func (q *listQuery) sortItems(items []dtos.FileSystemItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return q.compare(q.itemKey(&items[i], items[i].Path), q.itemKey(&items[j], items[j].Path)) < 0
+	})
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func derefInt64(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
@@ -0,0 +1,102 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupListingDir(t *testing.T) (*PublicFilesService, string) {
+	tmpDir := setupTestDir(t)
+	os.MkdirAll(filepath.Join(tmpDir, "photos"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "b.png"), make([]byte, 300), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "c.JPG"), make([]byte, 200), 0644)
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(tmpDir, "a.txt"), old, old)
+	return NewPublicFilesService(tmpDir, nil), tmpDir
+}
+
+func itemNames(items *dtos.PaginatedItems) []string {
+	names := []string{}
+	for _, item := range items.Items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestListItems_SortBySizeDesc(t *testing.T) {
+	service, _ := setupListingDir(t)
+
+	items, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{Sort: "size", Order: "desc"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"photos", "b.png", "c.JPG", "a.txt"}, itemNames(items))
+}
+
+func TestListItems_Filters(t *testing.T) {
+	service, _ := setupListingDir(t)
+
+	items, _ := service.ListItemsRoot(1, 10, dtos.ListOptions{Mime: "image/*"})
+	assert.Equal(t, []string{"b.png", "c.JPG"}, itemNames(items))
+
+	items, _ = service.ListItemsRoot(1, 10, dtos.ListOptions{Ext: "jpg,txt"})
+	assert.Equal(t, []string{"a.txt", "c.JPG"}, itemNames(items))
+
+	items, _ = service.ListItemsRoot(1, 10, dtos.ListOptions{Type: "dir"})
+	assert.Equal(t, []string{"photos"}, itemNames(items))
+
+	minSize, maxSize := int64(150), int64(250)
+	items, _ = service.ListItemsRoot(1, 10, dtos.ListOptions{MinSize: &minSize, MaxSize: &maxSize})
+	assert.Equal(t, []string{"c.JPG"}, itemNames(items))
+
+	items, _ = service.ListItemsRoot(1, 10, dtos.ListOptions{Type: "file", ModifiedBefore: "2021-01-01T00:00:00Z"})
+	assert.Equal(t, []string{"a.txt"}, itemNames(items))
+}
+
+func TestListItems_InvalidOptions(t *testing.T) {
+	service, _ := setupListingDir(t)
+
+	for _, opts := range []dtos.ListOptions{
+		{Sort: "color"},
+		{Order: "sideways"},
+		{Type: "link"},
+		{Fields: "name,secret"},
+		{ModifiedAfter: "yesterday"},
+	} {
+		_, errResp := service.ListItemsRoot(1, 10, opts)
+		assert.NotNil(t, errResp, "%+v", opts)
+	}
+}
+
+func TestListItems_Fields(t *testing.T) {
+	service, _ := setupListingDir(t)
+
+	items, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{Type: "file", Fields: "name,size"})
+	assert.Nil(t, errResp)
+
+	data, err := json.Marshal(items)
+	assert.NoError(t, err)
+
+	var decoded struct {
+		Items []map[string]interface{} `json:"items"`
+		Total int                      `json:"total"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, 3, decoded.Total)
+	assert.Equal(t, map[string]interface{}{"name": "a.txt", "size": float64(100)}, decoded.Items[0])
+}
+
+func TestSearchItems_SortAndFilter(t *testing.T) {
+	service, tmpDir := setupListingDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "photos", "d.png"), make([]byte, 50), 0644)
+
+	items, errResp := service.SearchItems(".", 1, 10, dtos.ListOptions{Sort: "size", Mime: "image/png"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"d.png", "b.png"}, itemNames(items))
+}
@@ -0,0 +1,615 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Locks check a file or folder out so that nobody else changes it, for files
+// such as spreadsheets that cannot be merged. They follow the WebDAV model:
+// a lock is exclusive or shared, covers its item alone (depth 0) or
+// everything below it too (depth infinity), names an owner for display and
+// expires unless it is refreshed within its timeout. Whoever presents the
+// lock's token holds it.
+//
+// While an item is locked, changing it takes the tokens of the exclusive
+// locks that cover it, and one token per item whose shared locks cover it.
+// Adding an item to a folder or taking one out also takes the folder's
+// locks, whatever their depth, and deleting or moving a folder takes the
+// locks of everything below it. Locks follow their item through renames and
+// moves and go away when it is deleted. The methods that change files take
+// the tokens their caller holds as trailing lockTokens.
+
+const (
+	locksFile          = "locks.json"
+	defaultLockTimeout = time.Hour
+	maxLockTimeout     = 7 * 24 * time.Hour
+	maxLockOwnerLength = 256
+
+	LockScopeExclusive = "exclusive"
+	LockScopeShared    = "shared"
+
+	LockDepthZero     = "0"
+	LockDepthInfinity = "infinity"
+
+	lockTokenPrefix = "opaquelocktoken:"
+)
+
+var (
+	errLockConflict = errors.New("the item is locked")
+	errLockNotFound = errors.New("no such lock on the item")
+)
+
+type fileLock struct {
+	Token   string    `json:"token"`
+	Path    string    `json:"path"`
+	Scope   string    `json:"scope"`
+	Deep    bool      `json:"deep"`
+	Owner   string    `json:"owner,omitempty"`
+	Timeout int64     `json:"timeout"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// covers reports whether l applies to rel.
+func (l *fileLock) covers(rel string) bool {
+	return l.Path == rel || l.Deep && underPrefix(rel, l.Path)
+}
+
+func (l *fileLock) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// info returns the lock as shown to clients, with the token only when
+// withToken is set.
+func (l *fileLock) info(withToken bool) dtos.FileLock {
+	depth := LockDepthZero
+	if l.Deep {
+		depth = LockDepthInfinity
+	}
+	out := dtos.FileLock{
+		Path:      l.Path,
+		Scope:     l.Scope,
+		Depth:     depth,
+		Owner:     l.Owner,
+		Timeout:   l.Timeout,
+		CreatedAt: l.Created.Unix(),
+		ExpiresAt: l.Expires.Unix(),
+	}
+	if withToken {
+		out.Token = l.Token
+	}
+	return out
+}
+
+// lockStore keeps the locks by token in a JSON file under the metadata
+// directory, so that check-outs survive a restart. It is loaded on first
+// use. Expired locks are ignored until expire collects them.
+type lockStore struct {
+	path string
+	once sync.Once
+
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+func newLockStore(path string) *lockStore {
+	return &lockStore{path: path, locks: make(map[string]*fileLock)}
+}
+
+func (s *lockStore) load() {
+	s.once.Do(func() {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn().Err(err).Msg("Failed to read locks")
+			}
+			return
+		}
+		var locks []*fileLock
+		if err := json.Unmarshal(data, &locks); err != nil {
+			log.Warn().Err(err).Msg("Failed to parse locks")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, l := range locks {
+			s.locks[l.Token] = l
+		}
+	})
+}
+
+// lock takes a new lock on rel. It fails with errLockConflict and the locks
+// in the way if rel or, for a deep lock, anything below it is locked in a
+// way that does not allow sharing.
+func (s *lockStore) lock(rel, scope string, deep bool, owner string, timeout time.Duration) (*fileLock, []fileLock, error) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var conflicts []fileLock
+	for _, l := range s.locks {
+		if l.expired(now) {
+			continue
+		}
+		overlaps := l.covers(rel) || deep && underPrefix(l.Path, rel)
+		if overlaps && (l.Scope == LockScopeExclusive || scope == LockScopeExclusive) {
+			conflicts = append(conflicts, *l)
+		}
+	}
+	if len(conflicts) > 0 {
+		sortLocks(conflicts)
+		return nil, conflicts, errLockConflict
+	}
+
+	l := &fileLock{
+		Token:   lockTokenPrefix + uuid.New().String(),
+		Path:    rel,
+		Scope:   scope,
+		Deep:    deep,
+		Owner:   owner,
+		Timeout: int64(timeout / time.Second),
+		Created: now,
+		Expires: now.Add(timeout),
+	}
+	s.locks[l.Token] = l
+	if err := s.saveLocked(); err != nil {
+		delete(s.locks, l.Token)
+		return nil, nil, err
+	}
+	return l, nil, nil
+}
+
+// refresh restarts the timeout of the lock with token, which has to apply
+// to rel.
+func (s *lockStore) refresh(rel, token string, timeout time.Duration) (*fileLock, error) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.locks[token]
+	now := time.Now()
+	if l == nil || l.expired(now) || !l.covers(rel) {
+		return nil, errLockNotFound
+	}
+	l.Timeout = int64(timeout / time.Second)
+	l.Expires = now.Add(timeout)
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// unlock removes the lock with token, which has to apply to rel.
+func (s *lockStore) unlock(rel, token string) (*fileLock, error) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.locks[token]
+	if l == nil || l.expired(time.Now()) || !l.covers(rel) {
+		return nil, errLockNotFound
+	}
+	delete(s.locks, token)
+	if err := s.saveLocked(); err != nil {
+		s.locks[token] = l
+		return nil, err
+	}
+	return l, nil
+}
+
+// holds reports whether token names a live lock that covers rel.
+func (s *lockStore) holds(token, rel string) bool {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.locks[token]
+	return l != nil && !l.expired(time.Now()) && l.covers(rel)
+}
+
+// list returns the live locks that cover rel or lie below it.
+func (s *lockStore) list(rel string) []fileLock {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	locks := []fileLock{}
+	for _, l := range s.locks {
+		if !l.expired(now) && (l.covers(rel) || underPrefix(l.Path, rel)) {
+			locks = append(locks, *l)
+		}
+	}
+	sortLocks(locks)
+	return locks
+}
+
+// blocking returns the live locks that keep a change to rel from going
+// ahead with tokens. members is set when the change adds rel to its folder
+// or takes it out, and subtree when it also changes everything below rel.
+func (s *lockStore) blocking(rel string, tokens []string, members, subtree bool) []fileLock {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.locks) == 0 {
+		return nil
+	}
+	held := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		held[token] = true
+	}
+	parent := path.Dir(rel)
+	if parent == "." {
+		parent = ""
+	}
+	now := time.Now()
+
+	var blocked []fileLock
+	// Shared locks are satisfied per item by any one of their tokens.
+	shared := make(map[string][]fileLock)
+	sharedHeld := make(map[string]bool)
+	for _, l := range s.locks {
+		if l.expired(now) {
+			continue
+		}
+		applies := l.covers(rel) ||
+			members && l.Path == parent && rel != "" ||
+			subtree && underPrefix(l.Path, rel)
+		if !applies {
+			continue
+		}
+		switch {
+		case l.Scope == LockScopeShared:
+			shared[l.Path] = append(shared[l.Path], *l)
+			if held[l.Token] {
+				sharedHeld[l.Path] = true
+			}
+		case !held[l.Token]:
+			blocked = append(blocked, *l)
+		}
+	}
+	for item, locks := range shared {
+		if !sharedHeld[item] {
+			blocked = append(blocked, locks...)
+		}
+	}
+	sortLocks(blocked)
+	return blocked
+}
+
+// expire removes the locks whose timeout ran out and returns them.
+func (s *lockStore) expire() []fileLock {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var expired []fileLock
+	for token, l := range s.locks {
+		if l.expired(now) {
+			expired = append(expired, *l)
+			delete(s.locks, token)
+		}
+	}
+	if len(expired) > 0 {
+		s.saveLocked()
+	}
+	sortLocks(expired)
+	return expired
+}
+
+// removePrefix drops the locks of prefix and of everything below it.
+func (s *lockStore) removePrefix(prefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for token, l := range s.locks {
+		if underPrefix(l.Path, prefix) {
+			delete(s.locks, token)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+// move re-keys the locks at or below oldPrefix to newPrefix.
+func (s *lockStore) move(oldPrefix, newPrefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, l := range s.locks {
+		if underPrefix(l.Path, oldPrefix) {
+			l.Path = newPrefix + strings.TrimPrefix(l.Path, oldPrefix)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+func (s *lockStore) saveLocked() error {
+	locks := make([]*fileLock, 0, len(s.locks))
+	for _, l := range s.locks {
+		locks = append(locks, l)
+	}
+	data, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to save locks")
+		return err
+	}
+	return nil
+}
+
+func sortLocks(locks []fileLock) {
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].Path != locks[j].Path {
+			return locks[i].Path < locks[j].Path
+		}
+		return locks[i].Created.Before(locks[j].Created)
+	})
+}
+
+func lockInfos(locks []fileLock) []dtos.FileLock {
+	infos := make([]dtos.FileLock, len(locks))
+	for i := range locks {
+		infos[i] = locks[i].info(false)
+	}
+	return infos
+}
+
+// expireLocks drops the locks whose timeout ran out and tells the clients.
+func (p *PublicFilesService) expireLocks() {
+	for _, l := range p.locks.expire() {
+		p.notifyWebSocket("item_unlocked", map[string]interface{}{
+			"path":   l.Path,
+			"lock":   l.info(false),
+			"reason": "expired",
+		})
+	}
+}
+
+// checkLocks refuses a change to abs with 423 Locked unless lockTokens hold
+// the locks on it; members and subtree are as for lockStore.blocking.
+func (p *PublicFilesService) checkLocks(abs string, lockTokens []string, members, subtree bool) *dtos.ErrorResponse {
+	p.expireLocks()
+	rel := p.relPath(abs)
+	blocked := p.locks.blocking(rel, lockTokens, members, subtree)
+	if len(blocked) == 0 {
+		return nil
+	}
+	return lockedError(rel, blocked)
+}
+
+func lockedError(rel string, blocked []fileLock) *dtos.ErrorResponse {
+	owner := ""
+	if blocked[0].Owner != "" {
+		owner = fmt.Sprintf(" by %s", blocked[0].Owner)
+	}
+	return &dtos.ErrorResponse{
+		Error:     fmt.Sprintf("%s is locked%s", blocked[0].Path, owner),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: uuid.New().String(),
+		Debug:     ptrString(fmt.Sprintf("%d lock(s) without a token prevent changing %q", len(blocked), rel)),
+		Details:   lockInfos(blocked),
+		Status:    http.StatusLocked,
+	}
+}
+
+// lockTimeout turns a timeout in seconds into a duration. Zero picks the
+// default and timeouts above the maximum are shortened to it.
+func lockTimeout(seconds int) (time.Duration, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("invalid lock timeout: %d", seconds)
+	}
+	if seconds == 0 {
+		return defaultLockTimeout, nil
+	}
+	return min(time.Duration(seconds)*time.Second, maxLockTimeout), nil
+}
+
+// LockItem locks a file or folder for whoever holds the returned token.
+// A lock that conflicts with existing ones fails with 423 Locked and the
+// locks in the way, without their tokens.
+func (p *PublicFilesService) LockItem(path string, req dtos.LockRequest) (*dtos.FileLock, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Target does not exist: %s", target)),
+			Status:    http.StatusNotFound,
+		}
+	}
+	rel := p.relPath(target)
+	if rel == "" {
+		return nil, &dtos.ErrorResponse{
+			Error:     "The root folder cannot be locked",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Path resolves to the public directory: %q", path)),
+		}
+	}
+
+	scope := strings.ToLower(req.Scope)
+	if scope == "" {
+		scope = LockScopeExclusive
+	}
+	depth := strings.ToLower(req.Depth)
+	if depth == "" {
+		depth = LockDepthInfinity
+	}
+	timeout, err := lockTimeout(req.Timeout)
+	switch {
+	case err != nil:
+	case scope != LockScopeExclusive && scope != LockScopeShared:
+		err = fmt.Errorf("invalid lock scope: %s (expected exclusive or shared)", req.Scope)
+	case depth != LockDepthZero && depth != LockDepthInfinity:
+		err = fmt.Errorf("invalid lock depth: %s (expected 0 or infinity)", req.Depth)
+	case len(req.Owner) > maxLockOwnerLength:
+		err = fmt.Errorf("lock owner too long (maximum %d characters)", maxLockOwnerLength)
+	}
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.expireLocks()
+	// Depth only means something for folders.
+	deep := depth == LockDepthInfinity && info.IsDir()
+	l, conflicts, err := p.locks.lock(rel, scope, deep, req.Owner, timeout)
+	if errors.Is(err, errLockConflict) {
+		return nil, lockedError(rel, conflicts)
+	}
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to save lock: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.notifyWebSocket("item_locked", map[string]interface{}{
+		"path": rel,
+		"lock": l.info(false),
+	})
+	result := l.info(true)
+	return &result, nil
+}
+
+// RefreshLock restarts the timeout of the lock with token on path, using
+// timeout seconds or the default.
+func (p *PublicFilesService) RefreshLock(path, token string, timeout int) (*dtos.FileLock, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	duration, err := lockTimeout(timeout)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.expireLocks()
+	rel := p.relPath(target)
+	l, err := p.locks.refresh(rel, token, duration)
+	if err != nil {
+		return nil, lockFailure(rel, err, http.StatusPreconditionFailed)
+	}
+
+	p.notifyWebSocket("lock_refreshed", map[string]interface{}{
+		"path": l.Path,
+		"lock": l.info(false),
+	})
+	result := l.info(true)
+	return &result, nil
+}
+
+// UnlockItem releases the lock with token on path.
+func (p *PublicFilesService) UnlockItem(path, token string) (map[string]interface{}, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.expireLocks()
+	rel := p.relPath(target)
+	l, err := p.locks.unlock(rel, token)
+	if err != nil {
+		return nil, lockFailure(rel, err, http.StatusConflict)
+	}
+
+	p.notifyWebSocket("item_unlocked", map[string]interface{}{
+		"path":   l.Path,
+		"lock":   l.info(false),
+		"reason": "unlocked",
+	})
+	return map[string]interface{}{
+		"success": true,
+		"path":    l.Path,
+	}, nil
+}
+
+// lockFailure reports a refresh or unlock that failed, with status when the
+// token does not name a lock on rel.
+func lockFailure(rel string, err error, status int) *dtos.ErrorResponse {
+	if errors.Is(err, errLockNotFound) {
+		return &dtos.ErrorResponse{
+			Error:     "The lock token does not match a lock on the item",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("No live lock with the given token covers %q", rel)),
+			Status:    status,
+		}
+	}
+	return &dtos.ErrorResponse{
+		Error:     fmt.Sprintf("Failed to save locks: %v", err),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: uuid.New().String(),
+		Debug:     ptrString(err.Error()),
+	}
+}
+
+// ItemLocks lists the locks on path, on the folders above it that cover it
+// and on everything below it, without their tokens.
+func (p *PublicFilesService) ItemLocks(path string) ([]dtos.FileLock, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	p.expireLocks()
+	return lockInfos(p.locks.list(p.relPath(target))), nil
+}
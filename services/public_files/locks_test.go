@@ -0,0 +1,188 @@
+package publicfiles
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocks_ExclusiveLockBlocksOthers(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "plan.txt"), []byte("v1"), 0644)
+
+	lock, errResp := service.LockItem("plan.txt", dtos.LockRequest{Owner: "alice"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, LockScopeExclusive, lock.Scope)
+	assert.Contains(t, lock.Token, lockTokenPrefix)
+	assert.Equal(t, int64(defaultLockTimeout/time.Second), lock.Timeout)
+
+	_, errResp = service.EditFile("plan.txt", "v2")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	assert.Contains(t, errResp.Error, "alice")
+	_, errResp = service.UploadFile("plan.txt", bytes.NewReader([]byte("v2")), "")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.RenameFile("plan.txt", "other.txt", "")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.DeleteItem("plan.txt", "opaquelocktoken:wrong")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.LockItem("plan.txt", dtos.LockRequest{Scope: LockScopeShared})
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+
+	_, errResp = service.EditFile("plan.txt", "v2", lock.Token)
+	assert.Nil(t, errResp)
+
+	// Others see the lock but not its token.
+	locks, errResp := service.ItemLocks("plan.txt")
+	assert.Nil(t, errResp)
+	assert.Len(t, locks, 1)
+	assert.Empty(t, locks[0].Token)
+	assert.Equal(t, "alice", locks[0].Owner)
+
+	_, errResp = service.UnlockItem("plan.txt", "opaquelocktoken:wrong")
+	assert.Equal(t, http.StatusConflict, errResp.Status)
+	_, errResp = service.UnlockItem("plan.txt", lock.Token)
+	assert.Nil(t, errResp)
+	_, errResp = service.DeleteItem("plan.txt")
+	assert.Nil(t, errResp)
+}
+
+func TestLocks_SharedLocks(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "budget.csv"), []byte("a,b"), 0644)
+
+	first, errResp := service.LockItem("budget.csv", dtos.LockRequest{Scope: "shared"})
+	assert.Nil(t, errResp)
+	second, errResp := service.LockItem("budget.csv", dtos.LockRequest{Scope: "shared"})
+	assert.Nil(t, errResp)
+	_, errResp = service.LockItem("budget.csv", dtos.LockRequest{})
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	assert.Len(t, errResp.Details, 2)
+
+	_, errResp = service.EditFile("budget.csv", "c,d")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	// Any one of the shared tokens will do.
+	_, errResp = service.EditFile("budget.csv", "c,d", second.Token)
+	assert.Nil(t, errResp)
+	_, errResp = service.EditFile("budget.csv", "e,f", first.Token)
+	assert.Nil(t, errResp)
+}
+
+func TestLocks_FolderDepth(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.MkdirAll(filepath.Join(tmpDir, "cad", "parts"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "cad", "parts", "gear.txt"), []byte("gear"), 0644)
+
+	deep, errResp := service.LockItem("cad", dtos.LockRequest{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, LockDepthInfinity, deep.Depth)
+	_, errResp = service.EditFile("cad/parts/gear.txt", "cog")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.CreateFile("cad/parts/new.txt")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.MoveFile("cad/parts/gear.txt", "gear.txt", "")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.LockItem("cad/parts/gear.txt", dtos.LockRequest{})
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.EditFile("cad/parts/gear.txt", "cog", deep.Token)
+	assert.Nil(t, errResp)
+	service.UnlockItem("cad", deep.Token)
+
+	// A depth 0 lock guards the folder's members, not their content.
+	shallow, errResp := service.LockItem("cad/parts", dtos.LockRequest{Depth: "0"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, LockDepthZero, shallow.Depth)
+	_, errResp = service.EditFile("cad/parts/gear.txt", "wheel")
+	assert.Nil(t, errResp)
+	_, errResp = service.CreateFile("cad/parts/new.txt")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.CreateFile("cad/parts/new.txt", shallow.Token)
+	assert.Nil(t, errResp)
+	service.UnlockItem("cad/parts", shallow.Token)
+
+	// Deleting a folder takes the locks of everything in it.
+	file, errResp := service.LockItem("cad/parts/gear.txt", dtos.LockRequest{})
+	assert.Nil(t, errResp)
+	_, errResp = service.LockItem("cad", dtos.LockRequest{})
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.DeleteItem("cad")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.DeleteItem("cad", file.Token)
+	assert.Nil(t, errResp)
+	locks, _ := service.ItemLocks("")
+	assert.Empty(t, locks)
+}
+
+func TestLocks_FollowMovesAndPersist(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "model.txt"), []byte("m"), 0644)
+
+	lock, errResp := service.LockItem("model.txt", dtos.LockRequest{Timeout: 60})
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(60), lock.Timeout)
+	_, errResp = service.RenameFile("model.txt", "model-v2.txt", "", lock.Token)
+	assert.Nil(t, errResp)
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	locks, errResp := restarted.ItemLocks("model-v2.txt")
+	assert.Nil(t, errResp)
+	assert.Len(t, locks, 1)
+	assert.Equal(t, "model-v2.txt", locks[0].Path)
+	_, errResp = restarted.EditFile("model-v2.txt", "n")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+
+	refreshed, errResp := restarted.RefreshLock("model-v2.txt", lock.Token, 120)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(120), refreshed.Timeout)
+	_, errResp = restarted.RefreshLock("model-v2.txt", "opaquelocktoken:wrong", 0)
+	assert.Equal(t, http.StatusPreconditionFailed, errResp.Status)
+}
+
+func TestLocks_Expire(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "sheet.txt"), []byte("s"), 0644)
+
+	lock, errResp := service.LockItem("sheet.txt", dtos.LockRequest{})
+	assert.Nil(t, errResp)
+	service.locks.mu.Lock()
+	service.locks.locks[lock.Token].Expires = time.Now().Add(-time.Second)
+	service.locks.mu.Unlock()
+
+	_, errResp = service.EditFile("sheet.txt", "t")
+	assert.Nil(t, errResp)
+	_, errResp = service.RefreshLock("sheet.txt", lock.Token, 0)
+	assert.NotNil(t, errResp)
+	assert.Empty(t, service.locks.locks)
+}
+
+func TestLocks_InvalidRequests(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644)
+
+	for _, req := range []dtos.LockRequest{
+		{Scope: "everyone"},
+		{Depth: "1"},
+		{Timeout: -1},
+	} {
+		_, errResp := service.LockItem("a.txt", req)
+		assert.NotNil(t, errResp, "%+v", req)
+	}
+	_, errResp := service.LockItem("", dtos.LockRequest{})
+	assert.NotNil(t, errResp)
+
+	lock, errResp := service.LockItem("a.txt", dtos.LockRequest{Timeout: int(maxLockTimeout/time.Second) + 1})
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(maxLockTimeout/time.Second), lock.Timeout)
+}
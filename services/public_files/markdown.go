@@ -0,0 +1,883 @@
+package publicfiles
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The Markdown renderer covers CommonMark's common constructs and the GitHub
+// extensions people write in READMEs: tables, task lists, strikethrough and
+// bare links. It is safe by construction rather than by sanitizing its
+// output: all source text is escaped, raw HTML is shown as text, the only
+// tags are the ones it writes, and link and image URLs are limited to
+// safe schemes.
+
+// maxInlineSpan bounds how far ahead an inline construct looks for its
+// end, so that unclosed delimiters cost linear time rather than quadratic.
+const maxInlineSpan = 2048
+
+var (
+	atxHeadingRe    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))??(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreakRe = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	setextH1Re      = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	setextH2Re      = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	tableDelimRe    = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+type markdownRenderer struct {
+	b          strings.Builder
+	headingIDs map[string]int
+}
+
+// renderMarkdown renders Markdown source as HTML.
+func renderMarkdown(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		lines[i] = expandLeadingTabs(line)
+	}
+	r := &markdownRenderer{headingIDs: make(map[string]int)}
+	r.blocks(lines, false)
+	return r.b.String()
+}
+
+func expandLeadingTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// stripIndent removes up to n leading spaces.
+func stripIndent(line string, n int) string {
+	return line[min(indentOf(line), n):]
+}
+
+// fenceOf returns the fence of a fenced code block opening or closing line.
+func fenceOf(line string) (fence string, info string, ok bool) {
+	if indentOf(line) > 3 {
+		return "", "", false
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+		return "", "", false
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == trimmed[0] {
+		n++
+	}
+	info = strings.TrimSpace(trimmed[n:])
+	if trimmed[0] == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+	return trimmed[:n], info, true
+}
+
+// listMarker describes the marker starting a list item.
+type listMarker struct {
+	ordered bool
+	// delim is the bullet character, or '.' or ')' after a number.
+	delim byte
+	start int
+	// offset is the column the item's content starts at.
+	offset int
+	empty  bool
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	indent := indentOf(line)
+	if indent > 3 {
+		return listMarker{}, false
+	}
+	rest := line[indent:]
+	var m listMarker
+	var width int
+	switch {
+	case rest != "" && strings.IndexByte("-*+", rest[0]) >= 0:
+		m.delim, width = rest[0], 1
+	default:
+		digits := 0
+		for digits < len(rest) && digits < 9 && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits >= len(rest) || rest[digits] != '.' && rest[digits] != ')' {
+			return listMarker{}, false
+		}
+		m.ordered, m.delim, width = true, rest[digits], digits+1
+		m.start, _ = strconv.Atoi(rest[:digits])
+	}
+	after := rest[width:]
+	if isBlank(after) {
+		m.offset, m.empty = indent+width+1, true
+		return m, true
+	}
+	spaces := indentOf(after)
+	if spaces == 0 {
+		return listMarker{}, false
+	}
+	if spaces > 4 {
+		// The content is indented code; the item starts one space in.
+		spaces = 1
+	}
+	m.offset = indent + width + spaces
+	return m, true
+}
+
+// startsBlock reports whether line begins a block that interrupts a
+// paragraph.
+func startsBlock(line string) bool {
+	if _, _, ok := fenceOf(line); ok {
+		return true
+	}
+	if atxHeadingRe.MatchString(line) || thematicBreakRe.MatchString(line) {
+		return true
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if indentOf(line) <= 3 && strings.HasPrefix(trimmed, ">") {
+		return true
+	}
+	m, ok := parseListMarker(line)
+	return ok && !m.empty && (!m.ordered || m.start == 1)
+}
+
+// blocks renders a sequence of block level lines. In a tight list item,
+// paragraphs are not wrapped in <p>.
+func (r *markdownRenderer) blocks(lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case indentOf(line) >= 4:
+			var code []string
+			for i < len(lines) && (indentOf(lines[i]) >= 4 || isBlank(lines[i])) {
+				code = append(code, stripIndent(lines[i], 4))
+				i++
+			}
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			r.codeBlock(strings.Join(code, "\n"), "")
+
+		default:
+			if fence, info, ok := fenceOf(line); ok {
+				indent := indentOf(line)
+				var code []string
+				for i++; i < len(lines); i++ {
+					if closing, rest, ok := fenceOf(lines[i]); ok && rest == "" &&
+						closing[0] == fence[0] && len(closing) >= len(fence) {
+						i++
+						break
+					}
+					code = append(code, stripIndent(lines[i], indent))
+				}
+				lang, _, _ := strings.Cut(info, " ")
+				r.codeBlock(strings.Join(code, "\n"), lang)
+				continue
+			}
+			if m := atxHeadingRe.FindStringSubmatch(line); m != nil {
+				r.heading(len(m[1]), strings.TrimSpace(m[2]))
+				i++
+				continue
+			}
+			if thematicBreakRe.MatchString(line) {
+				r.b.WriteString("<hr>\n")
+				i++
+				continue
+			}
+			if strings.HasPrefix(strings.TrimLeft(line, " "), ">") {
+				i = r.blockquote(lines, i)
+				continue
+			}
+			if _, ok := parseListMarker(line); ok {
+				i = r.list(lines, i)
+				continue
+			}
+			if i+1 < len(lines) && strings.Contains(line, "|") && tableDelimRe.MatchString(lines[i+1]) {
+				i = r.table(lines, i)
+				continue
+			}
+			i = r.paragraph(lines, i, tight)
+		}
+	}
+}
+
+func (r *markdownRenderer) codeBlock(code, lang string) {
+	if lang == "" {
+		r.b.WriteString("<pre><code>")
+		r.b.WriteString(html.EscapeString(code))
+		r.b.WriteString("</code></pre>\n")
+		return
+	}
+	l := languageByName(lang)
+	r.b.WriteString(`<pre><code class="language-` + l.name + `">`)
+	r.b.WriteString(strings.Join(highlightLines(code, l), "\n"))
+	r.b.WriteString("</code></pre>\n")
+}
+
+func (r *markdownRenderer) heading(level int, text string) {
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag + ` id="` + r.headingID(text) + `">`)
+	r.b.WriteString(r.inline(text))
+	r.b.WriteString("</" + tag + ">\n")
+}
+
+// headingID derives a unique anchor from a heading, the way GitHub does,
+// prefixed so that it cannot clobber the page's own element IDs.
+func (r *markdownRenderer) headingID(text string) string {
+	var slug strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-':
+			slug.WriteRune(c)
+		case c == ' ':
+			slug.WriteByte('-')
+		}
+	}
+	id := slug.String()
+	if n := r.headingIDs[id]; n > 0 {
+		r.headingIDs[id] = n + 1
+		id += "-" + strconv.Itoa(n)
+	} else {
+		r.headingIDs[id] = 1
+	}
+	return "user-content-" + html.EscapeString(id)
+}
+
+func (r *markdownRenderer) blockquote(lines []string, i int) int {
+	var inner []string
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case indentOf(line) <= 3 && strings.HasPrefix(trimmed, ">"):
+			trimmed = strings.TrimPrefix(trimmed[1:], " ")
+			inner = append(inner, trimmed)
+		case !isBlank(line) && len(inner) > 0 && !isBlank(inner[len(inner)-1]) && !startsBlock(line):
+			// A lazy continuation of the quoted paragraph.
+			inner = append(inner, line)
+		default:
+			r.writeBlockquote(inner)
+			return i
+		}
+		i++
+	}
+	r.writeBlockquote(inner)
+	return i
+}
+
+func (r *markdownRenderer) writeBlockquote(inner []string) {
+	r.b.WriteString("<blockquote>\n")
+	r.blocks(inner, false)
+	r.b.WriteString("</blockquote>\n")
+}
+
+func (r *markdownRenderer) list(lines []string, i int) int {
+	first, _ := parseListMarker(lines[i])
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m, ok := parseListMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.delim != first.delim {
+			break
+		}
+		item := []string{""}
+		if !m.empty {
+			item[0] = lines[i][m.offset:]
+		}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				item = append(item, "")
+				continue
+			}
+			if indentOf(line) >= m.offset {
+				item = append(item, line[m.offset:])
+				continue
+			}
+			prev := item[len(item)-1]
+			if !isBlank(prev) && !startsBlock(line) {
+				if _, ok := parseListMarker(line); !ok {
+					item = append(item, strings.TrimLeft(line, " "))
+					continue
+				}
+			}
+			break
+		}
+		// Blank lines before the next item make the list loose, as do blank
+		// lines between the blocks of an item.
+		trailing := 0
+		for len(item)-trailing > 1 && isBlank(item[len(item)-1-trailing]) {
+			trailing++
+		}
+		if trailing > 0 && i < len(lines) {
+			if next, ok := parseListMarker(lines[i]); ok && next.ordered == first.ordered && next.delim == first.delim {
+				loose = true
+			}
+		}
+		item = item[:len(item)-trailing]
+		for _, line := range item[1:] {
+			if isBlank(line) {
+				loose = true
+			}
+		}
+		items = append(items, item)
+	}
+
+	tag := "ul"
+	if first.ordered {
+		tag = "ol"
+	}
+	r.b.WriteString("<" + tag)
+	if first.ordered && first.start != 1 {
+		r.b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+	}
+	r.b.WriteString(">\n")
+	for _, item := range items {
+		checked, isTask := taskMarker(item[0])
+		if isTask && !first.ordered {
+			item[0] = item[0][4:]
+			r.b.WriteString(`<li class="task-list-item"><input type="checkbox" disabled`)
+			if checked {
+				r.b.WriteString(" checked")
+			}
+			r.b.WriteString("> ")
+		} else {
+			r.b.WriteString("<li>")
+		}
+		r.blocks(item, !loose)
+		r.b.WriteString("</li>\n")
+	}
+	r.b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// taskMarker reports whether an item starts with a task list checkbox.
+func taskMarker(line string) (checked bool, ok bool) {
+	if len(line) < 4 || line[0] != '[' || line[2] != ']' || line[3] != ' ' {
+		return false, false
+	}
+	switch line[1] {
+	case ' ':
+		return false, true
+	case 'x', 'X':
+		return true, true
+	}
+	return false, false
+}
+
+func (r *markdownRenderer) table(lines []string, i int) int {
+	header := splitTableRow(lines[i])
+	var aligns []string
+	for _, cell := range splitTableRow(lines[i+1]) {
+		cell = strings.TrimSpace(cell)
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns = append(aligns, "center")
+		case right:
+			aligns = append(aligns, "right")
+		case left:
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	if len(aligns) != len(header) {
+		return r.paragraph(lines, i, false)
+	}
+
+	r.b.WriteString("<table>\n<thead>\n")
+	r.tableRow("th", header, aligns)
+	r.b.WriteString("</thead>\n")
+	i += 2
+	if i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		r.b.WriteString("<tbody>\n")
+		for ; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+			r.tableRow("td", splitTableRow(lines[i]), aligns)
+		}
+		r.b.WriteString("</tbody>\n")
+	}
+	r.b.WriteString("</table>\n")
+	return i
+}
+
+func (r *markdownRenderer) tableRow(tag string, cells, aligns []string) {
+	r.b.WriteString("<tr>\n")
+	for c, align := range aligns {
+		r.b.WriteString("<" + tag)
+		if align != "" {
+			r.b.WriteString(` style="text-align:` + align + `"`)
+		}
+		r.b.WriteString(">")
+		if c < len(cells) {
+			r.b.WriteString(r.inline(strings.TrimSpace(cells[c])))
+		}
+		r.b.WriteString("</" + tag + ">\n")
+	}
+	r.b.WriteString("</tr>\n")
+}
+
+// splitTableRow splits a table row at pipes that are neither escaped nor in
+// a code span.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start, inCode := 0, false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '`':
+			inCode = !inCode
+		case '|':
+			if !inCode {
+				cells = append(cells, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(cells, line[start:])
+}
+
+func (r *markdownRenderer) paragraph(lines []string, i int, tight bool) int {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) || len(text) > 0 && startsBlock(line) && !setextH2Re.MatchString(line) {
+			break
+		}
+		if len(text) > 0 && (setextH1Re.MatchString(line) || setextH2Re.MatchString(line)) {
+			level := 1
+			if strings.Contains(line, "-") {
+				level = 2
+			}
+			r.heading(level, strings.Join(text, "\n"))
+			return i + 1
+		}
+		if len(text) > 0 && i+1 < len(lines) && strings.Contains(line, "|") && tableDelimRe.MatchString(lines[i+1]) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+	content := r.inline(strings.TrimRight(strings.Join(text, "\n"), " "))
+	if tight {
+		r.b.WriteString(content)
+		return i
+	}
+	r.b.WriteString("<p>" + content + "</p>\n")
+	return i
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+// inline renders the inline content of a block.
+func (r *markdownRenderer) inline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == ' ' && strings.HasPrefix(s[i:], "  \n"):
+			b.WriteString("<br>\n")
+			i += 3
+			continue
+		case c == '`':
+			if code, n, ok := codeSpan(s[i:]); ok {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n
+				continue
+			}
+			n := delimRun(s[i:], '`')
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			if out, n, ok := r.link(s[i+1:], true); ok {
+				b.WriteString(out)
+				i += 1 + n
+				continue
+			}
+		case c == '[':
+			if out, n, ok := r.link(s[i:], false); ok {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexAny(s[i:min(len(s), i+maxInlineSpan)], "> \n"); end > 0 && s[i+end] == '>' {
+				target := s[i+1 : i+end]
+				if href, ok := autolinkTarget(target); ok {
+					b.WriteString(linkTag(href) + html.EscapeString(target) + "</a>")
+					i += end + 1
+					continue
+				}
+			}
+		case c == '*' || c == '_' || c == '~':
+			if out, n, ok := r.emphasis(s, i); ok {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+			n := delimRun(s[i:], c)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		case c == 'h' && (i == 0 || !isWordByte(s[i-1])) &&
+			(strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://")):
+			n := bareURLLen(s[i:])
+			b.WriteString(linkTag(s[i:i+n]) + html.EscapeString(s[i:i+n]) + "</a>")
+			i += n
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+func delimRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// codeSpan parses the code span at the start of s: a backtick run, the code
+// and a closing run of the same length.
+func codeSpan(s string) (code string, n int, ok bool) {
+	open := delimRun(s, '`')
+	s = s[:min(len(s), maxInlineSpan)]
+	for i := open; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := delimRun(s[i:], '`')
+		if run == open {
+			code = strings.ReplaceAll(s[open:i], "\n", " ")
+			if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			return code, i + run, true
+		}
+		i += run
+	}
+	return "", 0, false
+}
+
+// emphasis parses the emphasis, strong emphasis or strikethrough opening at
+// s[i], returning the rendered HTML and the length consumed.
+func (r *markdownRenderer) emphasis(s string, i int) (string, int, bool) {
+	c := s[i]
+	run := delimRun(s[i:], c)
+	if i+run >= len(s) || unicode.IsSpace(rune(s[i+run])) {
+		return "", 0, false
+	}
+	// Underscores inside words are not emphasis.
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", 0, false
+	}
+
+	type form struct {
+		delim      int
+		open, shut string
+	}
+	var forms []form
+	switch {
+	case c == '~':
+		if run != 2 {
+			return "", 0, false
+		}
+		forms = []form{{2, "<del>", "</del>"}}
+	case run >= 3:
+		forms = []form{{3, "<em><strong>", "</strong></em>"}, {2, "<strong>", "</strong>"}, {1, "<em>", "</em>"}}
+	case run == 2:
+		forms = []form{{2, "<strong>", "</strong>"}}
+	default:
+		forms = []form{{1, "<em>", "</em>"}}
+	}
+	for _, f := range forms {
+		start := i + f.delim
+		if end, ok := closingDelim(s, start, c, f.delim); ok {
+			return f.open + r.inline(s[start:end]) + f.shut, end + f.delim - i, true
+		}
+	}
+	return "", 0, false
+}
+
+// closingDelim finds where a run of n delimiters c opened before start is
+// closed. A closing run follows a non-space character; of a run of three,
+// the last n characters close.
+func closingDelim(s string, start int, c byte, n int) (int, bool) {
+	s = s[:min(len(s), start+maxInlineSpan)]
+	for i := start; i < len(s); {
+		switch s[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			if _, m, ok := codeSpan(s[i:]); ok {
+				i += m
+				continue
+			}
+		case c:
+			run := delimRun(s[i:], c)
+			closes := i > start && !unicode.IsSpace(rune(s[i-1])) && (run == n || run == 3 && n < 3)
+			if c == '_' && i+run < len(s) && isWordByte(s[i+run]) {
+				closes = false
+			}
+			if closes {
+				return i + run - n, true
+			}
+			i += run
+			continue
+		}
+		i++
+	}
+	return 0, false
+}
+
+// link parses a link or, with image set, an image at the start of s,
+// written [text](destination "title").
+func (r *markdownRenderer) link(s string, image bool) (string, int, bool) {
+	s = s[:min(len(s), maxInlineSpan)]
+	depth := 0
+	closeText := -1
+	for i := 0; i < len(s) && closeText < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			if _, n, ok := codeSpan(s[i:]); ok {
+				i += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeText = i
+			}
+		}
+	}
+	if closeText < 0 || closeText+1 >= len(s) || s[closeText+1] != '(' {
+		return "", 0, false
+	}
+	text := s[1:closeText]
+
+	rest := s[closeText+2:]
+	pos := len(rest) - len(strings.TrimLeft(rest, " \n"))
+	var dest string
+	if pos < len(rest) && rest[pos] == '<' {
+		end := strings.IndexAny(rest[pos:], ">\n")
+		if end < 0 || rest[pos+end] != '>' {
+			return "", 0, false
+		}
+		dest = rest[pos+1 : pos+end]
+		pos += end + 1
+	} else {
+		parens, start := 0, pos
+	scan:
+		for ; pos < len(rest); pos++ {
+			switch rest[pos] {
+			case '\\':
+				pos++
+			case '(':
+				parens++
+			case ')':
+				if parens == 0 {
+					break scan
+				}
+				parens--
+			case ' ', '\n':
+				break scan
+			}
+		}
+		if pos > len(rest) {
+			pos = len(rest)
+		}
+		dest = rest[start:pos]
+	}
+
+	var title string
+	pos += len(rest[pos:]) - len(strings.TrimLeft(rest[pos:], " \n"))
+	if pos < len(rest) && strings.IndexByte(`"'(`, rest[pos]) >= 0 {
+		closer := rest[pos]
+		if closer == '(' {
+			closer = ')'
+		}
+		end := pos + 1
+		for end < len(rest) && rest[end] != closer {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return "", 0, false
+		}
+		title = rest[pos+1 : end]
+		pos = end + 1
+		pos += len(rest[pos:]) - len(strings.TrimLeft(rest[pos:], " \n"))
+	}
+	if pos >= len(rest) || rest[pos] != ')' {
+		return "", 0, false
+	}
+	n := closeText + 2 + pos + 1
+
+	href, ok := safeURL(unescapeMarkdown(dest), image)
+	var b strings.Builder
+	if image {
+		if !ok {
+			// Drop the image but keep its description.
+			return html.EscapeString(unescapeMarkdown(text)), n, true
+		}
+		b.WriteString(`<img src="` + html.EscapeString(href) + `" alt="` + html.EscapeString(unescapeMarkdown(text)) + `"`)
+		if title != "" {
+			b.WriteString(` title="` + html.EscapeString(unescapeMarkdown(title)) + `"`)
+		}
+		b.WriteString(` loading="lazy">`)
+		return b.String(), n, true
+	}
+	if !ok {
+		return r.inline(text), n, true
+	}
+	b.WriteString(`<a href="` + html.EscapeString(href) + `"`)
+	if title != "" {
+		b.WriteString(` title="` + html.EscapeString(unescapeMarkdown(title)) + `"`)
+	}
+	b.WriteString(` rel="nofollow noopener noreferrer">`)
+	b.WriteString(r.inline(text))
+	b.WriteString("</a>")
+	return b.String(), n, true
+}
+
+// unescapeMarkdown removes backslash escapes.
+func unescapeMarkdown(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// safeURL vets a link destination. Relative URLs are allowed, and absolute
+// ones only with schemes that cannot run script: http, https and, for
+// links, mailto. Browsers ignore control characters and whitespace in
+// schemes, so URLs containing any are refused.
+func safeURL(raw string, image bool) (string, bool) {
+	for _, c := range raw {
+		if c < 0x20 || c == 0x7f {
+			return "", false
+		}
+	}
+	raw = strings.TrimSpace(raw)
+	colon := strings.IndexByte(raw, ':')
+	if colon < 0 || strings.IndexAny(raw[:colon], "/?#") >= 0 {
+		return raw, true
+	}
+	switch strings.ToLower(raw[:colon]) {
+	case "http", "https":
+		return raw, true
+	case "mailto":
+		return raw, !image
+	}
+	return "", false
+}
+
+// autolinkTarget reports whether an <autolink> holds a URL or an email
+// address, returning the link for it.
+func autolinkTarget(target string) (string, bool) {
+	if target == "" || strings.ContainsAny(target, " <>\n") {
+		return "", false
+	}
+	if strings.Contains(target, ":") {
+		return safeURL(target, false)
+	}
+	if at := strings.IndexByte(target, '@'); at > 0 && strings.Contains(target[at:], ".") {
+		return "mailto:" + target, true
+	}
+	return "", false
+}
+
+func linkTag(href string) string {
+	return `<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer">`
+}
+
+// bareURLLen returns the length of the bare URL at the start of s, without
+// trailing punctuation and unbalanced closing parentheses. Like whitespace,
+// control characters end the URL.
+func bareURLLen(s string) int {
+	n := strings.IndexFunc(s, func(c rune) bool {
+		return c <= ' ' || c == 0x7f || c == '<'
+	})
+	if n < 0 {
+		n = len(s)
+	}
+	n = min(n, maxInlineSpan)
+	for n > 0 {
+		switch s[n-1] {
+		case '.', ',', ':', ';', '!', '?', '"', '\'', '*', '_', '~':
+			n--
+			continue
+		case ')':
+			if strings.Count(s[:n], "(") < strings.Count(s[:n], ")") {
+				n--
+				continue
+			}
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,363 @@
+package publicfiles
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+// maxMergeEdits bounds the number of line insertions and deletions a diff
+// may need. Versions further apart are not merged. It also bounds the time a
+// diff takes, which grows with the input times the number of edits.
+const maxMergeEdits = 4000
+
+var errTooManyChanges = errors.New("versions differ too much to merge")
+
+// splitLines splits text into lines that keep their line endings, so that
+// joining them gives back text exactly.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// matchLines diffs a and b with Myers' algorithm and returns, for each line
+// of a, the index of the line of b it was matched with or -1. It uses the
+// linear space refinement: the middle snake of a region splits it into two
+// smaller regions that are diffed in turn, so memory grows with the number
+// of edits allowed rather than with its square.
+func matchLines(a, b []string) ([]int, error) {
+	// Lines are compared as small integers.
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	lm := &lineMatcher{x: intern(a), y: intern(b), limit: maxMergeEdits / 2}
+	lm.match = make([]int, len(a))
+	for i := range lm.match {
+		lm.match[i] = -1
+	}
+	// Both searches run at most limit steps, so their diagonals fit in
+	// buffers of a fixed size that every region reuses.
+	size := 2*(lm.limit+1) + 1
+	lm.forward, lm.backward = make([]int, size), make([]int, size)
+	if err := lm.diff(0, len(a), 0, len(b)); err != nil {
+		return nil, err
+	}
+	return lm.match, nil
+}
+
+type lineMatcher struct {
+	x, y  []int
+	match []int
+	limit int
+
+	// forward and backward hold the furthest x reached on each diagonal by
+	// the search from the start and from the end of a region.
+	forward, backward []int
+}
+
+// diff matches the lines of x[a0:a1] with those of y[b0:b1].
+func (lm *lineMatcher) diff(a0, a1, b0, b1 int) error {
+	for a0 < a1 && b0 < b1 && lm.x[a0] == lm.y[b0] {
+		lm.match[a0] = b0
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && lm.x[a1-1] == lm.y[b1-1] {
+		a1--
+		b1--
+		lm.match[a1] = b1
+	}
+	if a0 == a1 || b0 == b1 {
+		return nil
+	}
+
+	i, j, ok, err := lm.middleSnake(a0, a1, b0, b1)
+	if err != nil || !ok {
+		return err
+	}
+	if err := lm.diff(a0, i, b0, j); err != nil {
+		return err
+	}
+	return lm.diff(i, a1, j, b1)
+}
+
+// middleSnake searches x[a0:a1] and y[b0:b1] from both ends at once until
+// the two paths overlap, and returns a point on the overlap. ok is false if
+// the region has no line in common.
+func (lm *lineMatcher) middleSnake(a0, a1, b0, b1 int) (int, int, bool, error) {
+	n, m := a1-a0, b1-b0
+	maxD := (n + m + 1) / 2
+	offset := min(maxD, lm.limit) + 1
+	size := 2*offset + 1
+	vf, vb := lm.forward[:size], lm.backward[:size]
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[offset+1], vb[offset+1] = 0, 0
+
+	delta := n - m
+	// With an odd delta the forward path is the one to reach the overlap.
+	front := delta%2 != 0
+	// Diagonals that left the region are not extended any further.
+	var fStart, fEnd, bStart, bEnd int
+	for d := 0; d < maxD; d++ {
+		if d >= lm.limit {
+			return 0, 0, false, errTooManyChanges
+		}
+
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			ko := offset + k
+			var x int
+			if k == -d || k != d && vf[ko-1] < vf[ko+1] {
+				x = vf[ko+1]
+			} else {
+				x = vf[ko-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && lm.x[a0+x] == lm.y[b0+y] {
+				x++
+				y++
+			}
+			vf[ko] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case front:
+				if bo := offset + delta - k; bo >= 0 && bo < size && vb[bo] != -1 && x >= n-vb[bo] {
+					return a0 + x, b0 + y, true, nil
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			ko := offset + k
+			var x int
+			if k == -d || k != d && vb[ko-1] < vb[ko+1] {
+				x = vb[ko+1]
+			} else {
+				x = vb[ko-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && lm.x[a1-x-1] == lm.y[b1-y-1] {
+				x++
+				y++
+			}
+			vb[ko] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !front:
+				if fo := offset + delta - k; fo >= 0 && fo < size && vf[fo] != -1 {
+					fx := vf[fo]
+					fy := offset + fx - fo
+					if fx >= n-x {
+						return a0 + fx, b0 + fy, true, nil
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// mergeChunk is a run of lines in a three-way merge. Where the versions
+// differ, base, ours and theirs hold each version's lines, and lines holds
+// the result unless the chunk is a conflict.
+type mergeChunk struct {
+	conflict bool
+	lines    []string
+
+	base, ours, theirs                []string
+	baseStart, oursStart, theirsStart int
+}
+
+// mergeLines merges the changes from base to ours and from base to theirs,
+// line by line in the manner of diff3. A region changed on one side only
+// takes that side's lines; a region changed the same way on both sides takes
+// either; any other region changed on both sides is a conflict.
+func mergeLines(base, ours, theirs []string) ([]mergeChunk, error) {
+	matchOurs, err := matchLines(base, ours)
+	if err != nil {
+		return nil, err
+	}
+	matchTheirs, err := matchLines(base, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []mergeChunk
+	o, a, b := 0, 0, 0
+	for o < len(base) || a < len(ours) || b < len(theirs) {
+		// Lines all three versions share in step are stable.
+		k := 0
+		for o+k < len(base) && matchOurs[o+k] == a+k && matchTheirs[o+k] == b+k {
+			k++
+		}
+		if k > 0 {
+			chunks = append(chunks, mergeChunk{lines: base[o : o+k], baseStart: o, oursStart: a, theirsStart: b})
+			o, a, b = o+k, a+k, b+k
+			continue
+		}
+
+		// The unstable region ends at the next base line both sides kept.
+		end, endA, endB := len(base), len(ours), len(theirs)
+		for next := o; next < len(base); next++ {
+			if matchOurs[next] >= 0 && matchTheirs[next] >= 0 {
+				end, endA, endB = next, matchOurs[next], matchTheirs[next]
+				break
+			}
+		}
+		chunk := mergeChunk{
+			base: base[o:end], ours: ours[a:endA], theirs: theirs[b:endB],
+			baseStart: o, oursStart: a, theirsStart: b,
+		}
+		switch {
+		case equalLines(chunk.ours, chunk.base):
+			chunk.lines = chunk.theirs
+		case equalLines(chunk.theirs, chunk.base), equalLines(chunk.ours, chunk.theirs):
+			chunk.lines = chunk.ours
+		default:
+			chunk.conflict = true
+		}
+		chunks = append(chunks, chunk)
+		o, a, b = end, endA, endB
+	}
+	return chunks, nil
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeEdit merges submitted, derived from the version of file with the
+// etag baseEtag, with the current content of file. It returns the content to
+// write and whether it differs from submitted by a merge. A file open in a
+// collaborative session is merged with the session's copy.
+func (p *PublicFilesService) mergeEdit(file, submitted, baseEtag string) (string, bool, *dtos.ErrorResponse) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", false, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+		}
+	}
+	currentEtag := p.fileEtag(file, info.ModTime(), info.Size())
+	rel := p.relPath(file)
+
+	onDisk, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	current := string(onDisk)
+	if session := p.collab.get(rel); session != nil {
+		session.mu.Lock()
+		current = string(session.text)
+		session.mu.Unlock()
+	}
+
+	var base string
+	if normalizeEtag(baseEtag) == normalizeEtag(currentEtag) {
+		base = string(onDisk)
+	} else if kept, ok := p.versions.get(rel, baseEtag); ok {
+		base = string(kept)
+	} else {
+		return "", false, &dtos.ErrorResponse{
+			Error:     "File has changed since it was loaded",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Base version %s is no longer available", baseEtag)),
+			Details:   dtos.EditConflict{CurrentEtag: currentEtag},
+			Status:    http.StatusConflict,
+		}
+	}
+	if base == current {
+		return submitted, false, nil
+	}
+
+	chunks, err := mergeLines(splitLines(base), splitLines(current), splitLines(submitted))
+	if err != nil {
+		return "", false, &dtos.ErrorResponse{
+			Error:     "File has changed since it was loaded",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+			Details:   dtos.EditConflict{CurrentEtag: currentEtag},
+			Status:    http.StatusConflict,
+		}
+	}
+
+	var merged strings.Builder
+	conflicts := 0
+	hunks := make([]dtos.MergeHunk, 0, len(chunks))
+	for _, c := range chunks {
+		if c.conflict {
+			conflicts++
+		}
+		for _, line := range c.lines {
+			merged.WriteString(line)
+		}
+		hunks = append(hunks, dtos.MergeHunk{
+			Conflict:       c.conflict,
+			Lines:          c.lines,
+			Base:           c.base,
+			Current:        c.ours,
+			Submitted:      c.theirs,
+			BaseStart:      c.baseStart,
+			CurrentStart:   c.oursStart,
+			SubmittedStart: c.theirsStart,
+		})
+	}
+	if conflicts > 0 {
+		return "", false, &dtos.ErrorResponse{
+			Error:     "Edit conflicts with changes made since the file was loaded",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%d conflicting hunks", conflicts)),
+			Details:   dtos.EditConflict{CurrentEtag: currentEtag, Hunks: hunks},
+			Status:    http.StatusConflict,
+		}
+	}
+	return merged.String(), true, nil
+}
@@ -0,0 +1,135 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchLines(t *testing.T) {
+	match, err := matchLines(splitLines("a\nb\nc\nd\n"), splitLines("a\nx\nc\nd\ne\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, -1, 2, 3}, match)
+
+	match, err = matchLines(nil, splitLines("a\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, match)
+	assert.Equal(t, []string{"a\n", "b"}, splitLines("a\nb"))
+
+	var many, others []string
+	for i := 0; i < maxMergeEdits; i++ {
+		many = append(many, fmt.Sprintf("line %d\n", i))
+		others = append(others, fmt.Sprintf("other %d\n", i))
+	}
+	_, err = matchLines(many, others)
+	assert.ErrorIs(t, err, errTooManyChanges)
+	match, err = matchLines(many, append(others[:10:10], many...))
+	assert.NoError(t, err)
+	assert.Equal(t, 10, match[0])
+}
+
+func mergedText(t *testing.T, base, ours, theirs string) (string, bool) {
+	chunks, err := mergeLines(splitLines(base), splitLines(ours), splitLines(theirs))
+	assert.NoError(t, err)
+	var out strings.Builder
+	for _, c := range chunks {
+		if c.conflict {
+			return "", false
+		}
+		out.WriteString(strings.Join(c.lines, ""))
+	}
+	return out.String(), true
+}
+
+func TestMergeLines(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\n"
+
+	merged, ok := mergedText(t, base, "ONE\ntwo\nthree\nfour\n", "one\ntwo\nthree\nFOUR\nfive\n")
+	assert.True(t, ok)
+	assert.Equal(t, "ONE\ntwo\nthree\nFOUR\nfive\n", merged)
+
+	merged, ok = mergedText(t, base, "one\nthree\nfour\n", "one\nthree\nfour\n")
+	assert.True(t, ok)
+	assert.Equal(t, "one\nthree\nfour\n", merged)
+
+	_, ok = mergedText(t, base, "one\nTWO\nthree\nfour\n", "one\n2\nthree\nfour\n")
+	assert.False(t, ok)
+}
+
+func TestEditFileFrom_MergesConcurrentEdits(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "notes.md"), []byte("# Notes\n\nintro\n\nend\n"), 0644)
+
+	loaded := listedEtag(t, service, "notes.md")
+	first, errResp := service.EditFileFrom("notes.md", "# Notes\n\nintro, revised\n\nend\n", loaded)
+	assert.Nil(t, errResp)
+	assert.Nil(t, first["merged"])
+
+	// A second editor still works on the version loaded before.
+	result, errResp := service.EditFileFrom("notes.md", "# Notes\n\nintro\n\nthe end\n", loaded)
+	assert.Nil(t, errResp)
+	assert.Equal(t, true, result["merged"])
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "notes.md"))
+	assert.Equal(t, "# Notes\n\nintro, revised\n\nthe end\n", string(content))
+	assert.Equal(t, string(content), result["content"])
+
+	_, errResp = service.EditFileFrom("notes.md", "# Notes\n\nintro, rewritten\n\nend\n", loaded)
+	assert.NotNil(t, errResp)
+	assert.Equal(t, 409, errResp.Status)
+	conflict := errResp.Details.(dtos.EditConflict)
+	assert.Equal(t, listedEtag(t, service, "notes.md"), conflict.CurrentEtag)
+	var conflicting []dtos.MergeHunk
+	for _, h := range conflict.Hunks {
+		if h.Conflict {
+			conflicting = append(conflicting, h)
+		}
+	}
+	if assert.Len(t, conflicting, 1) {
+		assert.Equal(t, []string{"intro, revised\n"}, conflicting[0].Current)
+		assert.Equal(t, []string{"intro, rewritten\n"}, conflicting[0].Submitted)
+		assert.Equal(t, 2, conflicting[0].BaseStart)
+	}
+
+	_, errResp = service.EditFileFrom("notes.md", "anything\n", `"unknown-etag"`)
+	assert.NotNil(t, errResp)
+	assert.Equal(t, 409, errResp.Status)
+	assert.Empty(t, errResp.Details.(dtos.EditConflict).Hunks)
+}
+
+func TestEditFileFrom_SameSizeEditsGetNewEtags(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("one\n"), 0644)
+
+	loaded := listedEtag(t, service, "a.txt")
+	first, errResp := service.EditFileFrom("a.txt", "two\n", loaded)
+	assert.Nil(t, errResp)
+	assert.NotEqual(t, loaded, first["etag"])
+
+	// Same size, same second: the stale edit must not take the fast path.
+	_, errResp = service.EditFileFrom("a.txt", "six\n", loaded)
+	assert.NotNil(t, errResp)
+	assert.Equal(t, 409, errResp.Status)
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "a.txt"))
+	assert.Equal(t, "two\n", string(content))
+}
+
+func listedEtag(t *testing.T, service *PublicFilesService, name string) string {
+	result, errResp := service.ListItemsRoot(1, 100, dtos.ListOptions{})
+	if !assert.Nil(t, errResp) {
+		return ""
+	}
+	for _, item := range result.Items {
+		if item.Name == name {
+			return item.Etag
+		}
+	}
+	t.Fatalf("%s not listed", name)
+	return ""
+}
@@ -0,0 +1,31 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// metaDirName is the directory inside the public directory that holds the
+// service's own state, such as the content index. Like every dot-entry it is
+// hidden from listings and rejected by the path sanitizers, and it does not
+// count against the storage quota.
+const metaDirName = ".axolotl"
+
+// metaPath joins elem onto the metadata directory.
+func (p *PublicFilesService) metaPath(elem ...string) string {
+	return filepath.Join(append([]string{p.publicDir, metaDirName}, elem...)...)
+}
+
+func (p *PublicFilesService) ensureMetaDir() error {
+	return os.MkdirAll(p.metaPath(), 0755)
+}
+
+// relPath returns abs relative to the public directory with forward slashes,
+// the form used as key by the service's indexes.
+func (p *PublicFilesService) relPath(abs string) string {
+	rel, err := filepath.Rel(p.publicDir, abs)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,730 @@
+package publicfiles
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	nameIndexFile      = "name-index.gob"
+	nameIndexSaveDelay = 10 * time.Second
+
+	// Tombstoned entries are compacted away once they make up this share of
+	// the index.
+	nameIndexCompactRatio = 4
+	nameIndexCompactMin   = 1024
+)
+
+// foldName lowercases s and strips accents, so "Résumé" and "resume"
+// compare equal.
+func foldName(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(folded)
+}
+
+// trigrams returns the distinct three-rune windows of s.
+func trigrams(s string) []string {
+	r := []rune(s)
+	if len(r) < 3 {
+		return nil
+	}
+	seen := make(map[string]bool, len(r)-2)
+	grams := make([]string, 0, len(r)-2)
+	for i := 0; i+3 <= len(r); i++ {
+		gram := string(r[i : i+3])
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// nameEntry is one file or folder of the tree. Entries are never removed in
+// place; dead entries keep their slot until the next compaction so that
+// posting lists can hold plain slot numbers.
+type nameEntry struct {
+	path     string
+	folded   string
+	isDir    bool
+	size     int64
+	modified int64
+	dead     bool
+
+	// mimeType is the sniffed content type of a file, or "" until the file
+	// has been looked at.
+	mimeType string
+}
+
+// nameRecord is the persisted form of a nameEntry.
+type nameRecord struct {
+	Path     string
+	IsDir    bool
+	Size     int64
+	Modified int64
+	MimeType string
+}
+
+// folderTotals aggregates the files and folders below a folder, at any
+// depth.
+type folderTotals struct {
+	size    int64
+	files   int64
+	folders int64
+}
+
+// nameIndex holds every file and folder below the public directory with a
+// trigram index over the folded names. It is kept current by the file hooks
+// and reconciled with the disk by syncIndexes.
+type nameIndex struct {
+	mu      sync.RWMutex
+	entries []nameEntry
+	byPath  map[string]uint32
+	grams   map[string][]uint32
+	dead    int
+
+	// children lists the slots of the entries directly in each folder
+	// ("" for the root), so searches can skip subtrees. Like the postings,
+	// it may hold dead slots until the next compaction.
+	children map[string][]uint32
+
+	// totals holds the recursive size and counts of every folder that has
+	// live entries below it, keyed by path ("" for the root).
+	totals map[string]*folderTotals
+
+	// built is set once the index reflects the whole tree, either from a
+	// snapshot or from a full sync.
+	built bool
+
+	path      string
+	saveTimer *time.Timer
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{
+		byPath:   make(map[string]uint32),
+		grams:    make(map[string][]uint32),
+		children: make(map[string][]uint32),
+		totals:   make(map[string]*folderTotals),
+	}
+}
+
+func (x *nameIndex) isBuilt() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.built
+}
+
+func (x *nameIndex) markBuilt() {
+	x.mu.Lock()
+	x.built = true
+	x.mu.Unlock()
+}
+
+func (x *nameIndex) has(rel string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.byPath[rel]
+	return ok
+}
+
+// put adds or updates the entry for rel.
+func (x *nameIndex) put(rel string, isDir bool, size, modified int64) {
+	if rel == "" {
+		return
+	}
+	x.mu.Lock()
+	if id, ok := x.byPath[rel]; ok {
+		e := &x.entries[id]
+		if e.isDir == isDir && e.size == size && e.modified == modified {
+			x.mu.Unlock()
+			return
+		}
+		x.accountLocked(e, -1)
+		e.isDir, e.size, e.modified, e.mimeType = isDir, size, modified, ""
+		x.accountLocked(e, 1)
+	} else {
+		x.addLocked(nameEntry{path: rel, folded: foldName(path.Base(rel)), isDir: isDir, size: size, modified: modified})
+	}
+	x.mu.Unlock()
+	x.changed()
+}
+
+func (x *nameIndex) addLocked(e nameEntry) {
+	id := uint32(len(x.entries))
+	x.entries = append(x.entries, e)
+	x.byPath[e.path] = id
+	for _, gram := range trigrams(e.folded) {
+		x.grams[gram] = append(x.grams[gram], id)
+	}
+	dir := path.Dir(e.path)
+	if dir == "." {
+		dir = ""
+	}
+	x.children[dir] = append(x.children[dir], id)
+	x.accountLocked(&x.entries[id], 1)
+}
+
+func (x *nameIndex) killLocked(id uint32) {
+	e := &x.entries[id]
+	if e.dead {
+		return
+	}
+	e.dead = true
+	delete(x.byPath, e.path)
+	x.dead++
+	x.accountLocked(e, -1)
+}
+
+// accountLocked adds e to the totals of all its ancestors, or takes it out
+// again when sign is -1. Sizes only add up file contents; folders are just
+// counted.
+func (x *nameIndex) accountLocked(e *nameEntry, sign int64) {
+	dir := e.path
+	for dir != "" {
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+		t := x.totals[dir]
+		if t == nil {
+			t = &folderTotals{}
+			x.totals[dir] = t
+		}
+		if e.isDir {
+			t.folders += sign
+		} else {
+			t.files += sign
+			t.size += sign * e.size
+		}
+		if t.files == 0 && t.folders == 0 {
+			delete(x.totals, dir)
+		}
+	}
+}
+
+// setMimeType records the sniffed content type of the file rel.
+func (x *nameIndex) setMimeType(rel, mimeType string) {
+	x.mu.Lock()
+	id, ok := x.byPath[rel]
+	changed := ok && x.entries[id].mimeType != mimeType
+	if changed {
+		x.entries[id].mimeType = mimeType
+	}
+	x.mu.Unlock()
+	if changed {
+		x.changed()
+	}
+}
+
+// mimeType returns the sniffed content type of the file rel, if known.
+func (x *nameIndex) mimeType(rel string) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if id, ok := x.byPath[rel]; ok {
+		return x.entries[id].mimeType
+	}
+	return ""
+}
+
+// folderTotals returns the recursive totals of the folder dir.
+func (x *nameIndex) folderTotals(dir string) folderTotals {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if t := x.totals[dir]; t != nil {
+		return *t
+	}
+	return folderTotals{}
+}
+
+// each calls fn for every live entry below prefix while holding the read
+// lock. fn must not call back into the index.
+func (x *nameIndex) each(prefix string, fn func(e *nameEntry)) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for rel, id := range x.byPath {
+		if rel != prefix && underPrefix(rel, prefix) {
+			fn(&x.entries[id])
+		}
+	}
+}
+
+// paths returns the live paths at or below prefix.
+func (x *nameIndex) paths(prefix string) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var paths []string
+	for rel := range x.byPath {
+		if underPrefix(rel, prefix) {
+			paths = append(paths, rel)
+		}
+	}
+	return paths
+}
+
+func (x *nameIndex) remove(rel string) {
+	x.mu.Lock()
+	id, ok := x.byPath[rel]
+	if ok {
+		x.killLocked(id)
+		x.compactLocked()
+	}
+	x.mu.Unlock()
+	if ok {
+		x.changed()
+	}
+}
+
+// removePrefix drops prefix and everything below it. The root prefix ""
+// is never removed as a whole.
+func (x *nameIndex) removePrefix(prefix string) {
+	if prefix == "" {
+		return
+	}
+	x.mu.Lock()
+	removed := false
+	for rel, id := range x.byPath {
+		if underPrefix(rel, prefix) {
+			x.killLocked(id)
+			removed = true
+		}
+	}
+	x.compactLocked()
+	x.mu.Unlock()
+	if removed {
+		x.changed()
+	}
+}
+
+// move re-keys prefix and everything below it to newPrefix.
+func (x *nameIndex) move(oldPrefix, newPrefix string) {
+	if oldPrefix == "" || newPrefix == "" {
+		return
+	}
+	x.mu.Lock()
+	var moved []nameEntry
+	for rel, id := range x.byPath {
+		if underPrefix(rel, oldPrefix) {
+			e := x.entries[id]
+			e.path = newPrefix + strings.TrimPrefix(rel, oldPrefix)
+			e.folded = foldName(path.Base(e.path))
+			moved = append(moved, e)
+			x.killLocked(id)
+		}
+	}
+	for _, e := range moved {
+		if id, ok := x.byPath[e.path]; ok {
+			x.killLocked(id)
+		}
+		x.addLocked(e)
+	}
+	x.compactLocked()
+	x.mu.Unlock()
+	if len(moved) > 0 {
+		x.changed()
+	}
+}
+
+// compactLocked rebuilds the slots and postings once enough entries died.
+func (x *nameIndex) compactLocked() {
+	if x.dead < nameIndexCompactMin || x.dead*nameIndexCompactRatio < len(x.entries) {
+		return
+	}
+	entries := x.entries
+	x.entries = make([]nameEntry, 0, len(entries)-x.dead)
+	x.byPath = make(map[string]uint32, len(entries)-x.dead)
+	x.grams = make(map[string][]uint32)
+	x.children = make(map[string][]uint32)
+	x.totals = make(map[string]*folderTotals)
+	x.dead = 0
+	for _, e := range entries {
+		if !e.dead {
+			x.addLocked(e)
+		}
+	}
+}
+
+// changed schedules a snapshot once writes have settled.
+func (x *nameIndex) changed() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.path == "" || x.saveTimer != nil {
+		return
+	}
+	x.saveTimer = time.AfterFunc(nameIndexSaveDelay, func() {
+		x.mu.Lock()
+		x.saveTimer = nil
+		x.mu.Unlock()
+		x.save()
+	})
+}
+
+func (x *nameIndex) save() error {
+	x.mu.RLock()
+	path := x.path
+	records := make([]nameRecord, 0, len(x.byPath))
+	for _, e := range x.entries {
+		if !e.dead {
+			records = append(records, nameRecord{Path: e.path, IsDir: e.isDir, Size: e.size, Modified: e.modified, MimeType: e.mimeType})
+		}
+	}
+	x.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(records); err != nil {
+		return fmt.Errorf("failed to encode name index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// load replaces the index with the snapshot in file and persists future
+// changes there. A snapshot marks the index as built; without one the
+// first search waits for a full sync.
+func (x *nameIndex) load(file string) error {
+	var records []nameRecord
+	found := false
+	f, err := os.Open(file)
+	if err == nil {
+		err = gob.NewDecoder(f).Decode(&records)
+		f.Close()
+		if err != nil {
+			records = nil
+			err = fmt.Errorf("discarding unreadable name index: %w", err)
+		} else {
+			found = true
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.path = file
+	x.entries = make([]nameEntry, 0, len(records))
+	x.byPath = make(map[string]uint32, len(records))
+	x.grams = make(map[string][]uint32)
+	x.children = make(map[string][]uint32)
+	x.totals = make(map[string]*folderTotals)
+	x.dead = 0
+	for _, r := range records {
+		x.addLocked(nameEntry{path: r.Path, folded: foldName(path.Base(r.Path)), isDir: r.IsDir, size: r.Size, modified: r.Modified, mimeType: r.MimeType})
+	}
+	x.built = found
+	return err
+}
+
+// maxTypos is how many edits a search word of n runes may be away from a
+// word of a name. Short words must match exactly.
+func maxTypos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// fuzzyMatchesLocked returns the entries whose name contains a word within
+// maxTypos edits of term, or starting with such a word, with a similarity
+// between 0 and 1.
+func (x *nameIndex) fuzzyMatchesLocked(term string) map[uint32]float64 {
+	termRunes := []rune(term)
+	typos := maxTypos(len(termRunes))
+	if typos == 0 {
+		return nil
+	}
+	for _, r := range termRunes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return nil
+		}
+	}
+
+	// Every edit destroys at most three trigrams of the term.
+	grams := trigrams(term)
+	need := max(len(grams)-3*typos, 1)
+	shared := make(map[uint32]int)
+	for _, gram := range grams {
+		for _, id := range x.grams[gram] {
+			shared[id]++
+		}
+	}
+
+	matches := make(map[uint32]float64)
+	for id, n := range shared {
+		e := &x.entries[id]
+		if n < need || e.dead {
+			continue
+		}
+		best := typos + 1
+		scanTokens(e.folded, func(_, _ int, word string) {
+			wordRunes := []rune(word)
+			best = min(best, editDistance(termRunes, wordRunes))
+			if len(wordRunes) > len(termRunes) {
+				best = min(best, editDistance(termRunes, wordRunes[:len(termRunes)]))
+			}
+		})
+		if best <= typos {
+			matches[id] = 1 - float64(best)/float64(len(termRunes))
+		}
+	}
+	return matches
+}
+
+// substringCandidatesLocked returns the entries whose name has every
+// trigram of term, a superset of the names containing term. It returns
+// false when term is too short to narrow the search.
+func (x *nameIndex) substringCandidatesLocked(term string) (map[uint32]struct{}, bool) {
+	grams := trigrams(term)
+	if len(grams) == 0 {
+		return nil, false
+	}
+	var result map[uint32]struct{}
+	for _, gram := range grams {
+		ids := x.grams[gram]
+		next := make(map[uint32]struct{}, len(ids))
+		for _, id := range ids {
+			if _, ok := result[id]; result == nil || ok {
+				next[id] = struct{}{}
+			}
+		}
+		result = next
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result, true
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and transpositions of neighbours.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// nameScore ranks how well term matches a folded name: exact names first,
+// then prefixes, word starts, plain substrings and finally typo matches.
+// Shorter names win ties.
+func nameScore(folded, term string, fuzzy float64) float64 {
+	stem := strings.TrimSuffix(folded, path.Ext(folded))
+	score := 0.0
+	switch i := strings.Index(folded, term); {
+	case folded == term || stem == term:
+		score = 4
+	case i == 0:
+		score = 3
+	case i > 0:
+		r, _ := utf8.DecodeLastRuneInString(folded[:i])
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			score = 2
+		} else {
+			score = 2.5
+		}
+	default:
+		score = fuzzy
+	}
+	if score > 0 {
+		score += float64(utf8.RuneCountInString(term)) / float64(utf8.RuneCountInString(folded)) / 2
+	}
+	return score
+}
+
+// nameHit is an entry matching a search, with its relevance.
+type nameHit struct {
+	entry nameEntry
+	score float64
+}
+
+// search evaluates expr against the index. Name terms are resolved through
+// the trigram postings, so only entries that can contain them are tested
+// unless the query has no such term. Hits are unordered.
+func (x *nameIndex) search(expr queryNode, tags func(rel string) []string) []nameHit {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	terms := positiveNameTerms(expr, nil)
+	for _, term := range collectNameNodes(expr, nil) {
+		term.fuzzy = x.fuzzyMatchesLocked(term.term)
+	}
+
+	var hits []nameHit
+	test := func(id uint32) {
+		e := &x.entries[id]
+		if e.dead {
+			return
+		}
+		c := &queryCandidate{
+			id:       id,
+			path:     e.path,
+			folded:   e.folded,
+			isDir:    e.isDir,
+			size:     e.size,
+			modified: e.modified,
+			tags:     func() []string { return tags(e.path) },
+		}
+		if !expr.match(c) {
+			return
+		}
+		score := 0.0
+		for _, term := range terms {
+			score += nameScore(e.folded, term.term, term.fuzzy[id])
+		}
+		hits = append(hits, nameHit{entry: *e, score: score})
+	}
+
+	if ids, ok := x.candidatesLocked(expr); ok {
+		for id := range ids {
+			test(id)
+		}
+	} else if expr.scope("") != scopeNever {
+		x.walkLocked("", func(dir string) bool { return expr.scope(dir) != scopeNever }, test)
+	}
+	return hits
+}
+
+// walkLocked calls fn for every live entry below dir, descending only into
+// the folders descend accepts.
+func (x *nameIndex) walkLocked(dir string, descend func(dir string) bool, fn func(id uint32)) {
+	for _, id := range x.children[dir] {
+		e := &x.entries[id]
+		if e.dead {
+			continue
+		}
+		fn(id)
+		if e.isDir && descend(e.path) {
+			x.walkLocked(e.path, descend, fn)
+		}
+	}
+}
+
+// candidatesLocked returns a superset of the entries that can match n, or
+// false when n cannot be narrowed through the index.
+func (x *nameIndex) candidatesLocked(n queryNode) (map[uint32]struct{}, bool) {
+	switch n := n.(type) {
+	case *nameNode:
+		ids, ok := x.substringCandidatesLocked(n.term)
+		if !ok {
+			return nil, false
+		}
+		for id := range n.fuzzy {
+			ids[id] = struct{}{}
+		}
+		return ids, true
+	case andNode:
+		var result map[uint32]struct{}
+		for _, child := range n {
+			ids, ok := x.candidatesLocked(child)
+			if !ok {
+				continue
+			}
+			if result == nil {
+				result = ids
+				continue
+			}
+			for id := range result {
+				if _, ok := ids[id]; !ok {
+					delete(result, id)
+				}
+			}
+		}
+		return result, result != nil
+	case inNode:
+		ids := make(map[uint32]struct{})
+		x.walkLocked(string(n), func(string) bool { return true }, func(id uint32) {
+			ids[id] = struct{}{}
+		})
+		return ids, true
+	case orNode:
+		result := make(map[uint32]struct{})
+		for _, child := range n {
+			ids, ok := x.candidatesLocked(child)
+			if !ok {
+				return nil, false
+			}
+			for id := range ids {
+				result[id] = struct{}{}
+			}
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func collectNameNodes(n queryNode, nodes []*nameNode) []*nameNode {
+	switch n := n.(type) {
+	case *nameNode:
+		nodes = append(nodes, n)
+	case andNode:
+		for _, child := range n {
+			nodes = collectNameNodes(child, nodes)
+		}
+	case orNode:
+		for _, child := range n {
+			nodes = collectNameNodes(child, nodes)
+		}
+	case notNode:
+		nodes = collectNameNodes(n.child, nodes)
+	}
+	return nodes
+}
+
+// positiveNameTerms returns the name terms outside any NOT; they decide
+// the relevance of a hit.
+func positiveNameTerms(n queryNode, nodes []*nameNode) []*nameNode {
+	switch n := n.(type) {
+	case *nameNode:
+		nodes = append(nodes, n)
+	case andNode:
+		for _, child := range n {
+			nodes = positiveNameTerms(child, nodes)
+		}
+	case orNode:
+		for _, child := range n {
+			nodes = positiveNameTerms(child, nodes)
+		}
+	}
+	return nodes
+}
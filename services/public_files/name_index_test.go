@@ -0,0 +1,157 @@
+package publicfiles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFoldName(t *testing.T) {
+	assert.Equal(t, "resume.pdf", foldName("Résumé.PDF"))
+	assert.Equal(t, "naive cafe", foldName("Naïve Café"))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance([]rune("report"), []rune("report")))
+	assert.Equal(t, 1, editDistance([]rune("reprot"), []rune("report")))
+	assert.Equal(t, 1, editDistance([]rune("repor"), []rune("report")))
+	assert.Equal(t, 2, editDistance([]rune("rpeotr"), []rune("report")))
+}
+
+func TestSearchItems_ExactTotals(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	for i := 0; i < 30; i++ {
+		dir := filepath.Join(tmpDir, fmt.Sprintf("d%d", i%3))
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("invoice-%02d.pdf", i)), []byte("x"), 0644)
+	}
+
+	result, errResp := service.SearchItems("invoice", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, int32(30), result.Total)
+	assert.Equal(t, int32(3), result.TotalPages)
+	assert.Len(t, result.Items, 10)
+	assert.True(t, result.HasNext)
+
+	result, errResp = service.SearchItems("invoice", 3, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Len(t, result.Items, 10)
+	assert.False(t, result.HasNext)
+	assert.True(t, result.HasPrev)
+}
+
+func TestSearchItems_RanksAndToleratesTypos(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "reports"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "reports", "old-report-draft.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "report.pdf"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Résumé.pdf"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "unrelated.txt"), []byte("x"), 0644)
+
+	result, errResp := service.SearchItems("report", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"report.pdf", "reports", "reports/old-report-draft.txt"}, itemPaths(result.Items))
+
+	result, errResp = service.SearchItems("reprot", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Contains(t, itemPaths(result.Items), "report.pdf")
+	assert.NotContains(t, itemPaths(result.Items), "unrelated.txt")
+
+	result, errResp = service.SearchItems("resume", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"Résumé.pdf"}, itemPaths(result.Items))
+
+	result, errResp = service.SearchItems("report", 1, 10, dtos.ListOptions{Sort: "name", Order: "desc"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"reports", "report.pdf", "reports/old-report-draft.txt"}, itemPaths(result.Items))
+}
+
+func TestNameIndex_FollowsChanges(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	assert.NoError(t, service.ensureNameIndex())
+
+	_, errResp := service.CreateFolder("projects")
+	assert.Nil(t, errResp)
+	_, errResp = service.CreateFile("projects/budget.xlsx")
+	assert.Nil(t, errResp)
+	assert.True(t, service.names.has("projects/budget.xlsx"))
+
+	_, errResp = service.MoveFolder("projects", "archive", "")
+	assert.Nil(t, errResp)
+	result, errResp := service.SearchItems("budget", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"archive/budget.xlsx"}, itemPaths(result.Items))
+
+	_, errResp = service.DeleteItem("archive")
+	assert.Nil(t, errResp)
+	assert.Empty(t, service.names.paths(""))
+}
+
+func TestNameIndex_Snapshot(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "kept.txt"), []byte("x"), 0644)
+
+	service := NewPublicFilesService(tmpDir, nil)
+	_, _, err := service.RebuildIndexes(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(tmpDir, metaDirName, nameIndexFile))
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	names, _, err := restarted.LoadIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, names)
+	assert.True(t, restarted.names.isBuilt())
+	assert.True(t, restarted.names.has("kept.txt"))
+}
+
+func TestSearchItems_EtagMatchesListing(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "budget.xlsx"), []byte("x"), 0644)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	listed, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	found, errResp := service.SearchItems("budget", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Len(t, found.Items, 1)
+	assert.Equal(t, listed.Items[0].Etag, found.Items[0].Etag)
+}
+
+func TestNameIndex_SearchSkipsOutOfScopeFolders(t *testing.T) {
+	x := newNameIndex()
+	x.put("a", true, 0, 0)
+	x.put("a/one.txt", false, 1, 0)
+	x.put("a/sub", true, 0, 0)
+	x.put("a/sub/two.txt", false, 1, 0)
+	x.put("b", true, 0, 0)
+	for i := 0; i < 50; i++ {
+		x.put(fmt.Sprintf("b/%d.txt", i), false, 1, 0)
+	}
+
+	for _, query := range []string{"tag:x in:a", "-in:b tag:x"} {
+		expr, err := parseSearchQuery(query, queryNow)
+		assert.NoError(t, err)
+		visited := 0
+		hits := x.search(expr, func(string) []string {
+			visited++
+			return []string{"x"}
+		})
+		assert.LessOrEqual(t, visited, 5, query)
+		var paths []string
+		for _, hit := range hits {
+			paths = append(paths, hit.entry.path)
+		}
+		assert.Subset(t, paths, []string{"a/one.txt", "a/sub/two.txt"}, query)
+		assert.NotContains(t, paths, "b/0.txt", query)
+	}
+}
@@ -0,0 +1,300 @@
+package publicfiles
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+// Partial writes change part of an existing file of any type: they overwrite
+// bytes in place from an offset, extending the file past its end, or
+// append to it. They make the drive a target for writers that stream logs
+// or datasets into it. Every write names the version of the file it expects
+// with an If-Match etag, or "*" for whichever is current, so that a writer
+// that lost track of the file cannot corrupt it.
+//
+// The body is staged next to the file first, so a write that breaks off or
+// runs over the quota leaves the file as it was. The staged bytes are then
+// copied into the file under editMu. Unlike edits, partial writes keep no
+// earlier versions.
+
+// PatchFile writes data into the file at path as opts place it, provided
+// that the file's etag matches ifMatch.
+func (p *PublicFilesService) PatchFile(path string, data io.Reader, opts dtos.PatchOptions, ifMatch string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	file, err := p.sanitizePathForWrite(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+			Status:    http.StatusNotFound,
+		}
+	}
+
+	switch {
+	case opts.Append && opts.Offset != nil:
+		err = errors.New("give either an offset or append, not both")
+	case !opts.Append && opts.Offset == nil:
+		err = errors.New("give an offset to write at or append=true")
+	case opts.Offset != nil && *opts.Offset < 0:
+		err = fmt.Errorf("invalid offset: %d", *opts.Offset)
+	}
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	if strings.TrimSpace(ifMatch) == "" {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Partial writes need an If-Match precondition",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString("Send the etag of the file, or * for any version"),
+			Status:    http.StatusPreconditionRequired,
+		}
+	}
+
+	if errResp := p.checkLocks(file, lockTokens, false, false); errResp != nil {
+		return nil, errResp
+	}
+
+	rel := p.relPath(file)
+	if p.collab.get(rel) != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     "The file is being edited collaboratively",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Collaborative session open for %s", rel)),
+			Status:    http.StatusConflict,
+		}
+	}
+
+	// The body may overwrite the rest of the file from where it starts and
+	// grow the file by what the quota leaves.
+	start := info.Size()
+	if opts.Offset != nil {
+		start = min(*opts.Offset, info.Size())
+	}
+	limit := int64(maxTotalSize) - start
+	remaining, err := p.remainingQuota()
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to check storage quota: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if remaining >= 0 {
+		limit = min(limit, info.Size()-start+remaining)
+	}
+
+	staged, n, err := stageBody(file, data, limit)
+	if err != nil {
+		if errors.Is(err, errStreamTooLarge) {
+			return nil, &dtos.ErrorResponse{
+				Error:     "Write exceeds the maximum file size or storage quota",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("At most %d bytes may be written from offset %d", limit, start)),
+				Status:    http.StatusRequestEntityTooLarge,
+			}
+		}
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read data: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	defer discardStagingFile(staged)
+
+	p.editMu.Lock()
+	defer p.editMu.Unlock()
+
+	// The file may have changed while the body came in.
+	info, err = os.Stat(file)
+	if err != nil || info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+			Status:    http.StatusNotFound,
+		}
+	}
+	currentEtag := p.fileEtag(file, info.ModTime(), info.Size())
+	if !etagMatches(ifMatch, currentEtag) {
+		return nil, &dtos.ErrorResponse{
+			Error:     "The file has changed since the given etag",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("If-Match %s does not match %s", ifMatch, currentEtag)),
+			Details:   dtos.EditConflict{CurrentEtag: currentEtag},
+			Status:    http.StatusPreconditionFailed,
+		}
+	}
+
+	offset := info.Size()
+	if opts.Offset != nil {
+		offset = *opts.Offset
+	}
+	if offset > info.Size() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Offset is past the end of the file",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Offset %d, file size %d", offset, info.Size())),
+			Details:   dtos.EditConflict{CurrentEtag: currentEtag},
+			Status:    http.StatusRequestedRangeNotSatisfiable,
+		}
+	}
+	if growth := offset + n - info.Size(); growth > 0 {
+		err := p.checkQuota(growth)
+		if err == nil && offset+n > maxTotalSize {
+			err = fmt.Errorf("file would exceed the maximum size of %d bytes", int64(maxTotalSize))
+		}
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     err.Error(),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+				Status:    http.StatusRequestEntityTooLarge,
+			}
+		}
+	}
+
+	// Content detection looks at the start of the file only, so only writes
+	// that reach into it can make the content contradict the extension.
+	if p.blockMismatches && offset < sniffLen {
+		head, err := patchedHead(file, staged, offset)
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to read file: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		if reason, mismatch := contentMismatch(filepath.Base(file), sniffContentType(head)); mismatch {
+			return nil, &dtos.ErrorResponse{
+				Error:     "File content does not match its extension",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("%v: %s", errContentMismatch, reason)),
+				Status:    http.StatusUnsupportedMediaType,
+			}
+		}
+	}
+
+	if err := writeAt(file, staged, offset); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to write file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	newInfo := settleModTime(file, info)
+	newModTime := newInfo.ModTime().Unix()
+	etag := p.fileEtag(file, newInfo.ModTime(), newInfo.Size())
+	p.fileChanged(file)
+
+	p.notifyWebSocket("file_updated", map[string]interface{}{
+		"path":        rel,
+		"size":        newInfo.Size(),
+		"modified_at": newModTime,
+		"etag":        etag,
+	})
+
+	return map[string]interface{}{
+		"success":       true,
+		"id":            p.itemID(rel),
+		"path":          rel,
+		"offset":        offset,
+		"bytes_written": n,
+		"size":          newInfo.Size(),
+		"modified_at":   newModTime,
+		"etag":          etag,
+	}, nil
+}
+
+// stageBody copies up to limit bytes of r into a staging file next to
+// target, failing with errStreamTooLarge if r holds more. The caller
+// discards the returned file.
+func stageBody(target string, r io.Reader, limit int64) (*os.File, int64, error) {
+	f, err := createStagingFile(target)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, &readErrorReader{io.LimitReader(r, limit+1)})
+	if err == nil && n > limit {
+		err = errStreamTooLarge
+	}
+	if err != nil {
+		discardStagingFile(f)
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+// writeAt copies staged into file from offset on and flushes it to disk.
+func writeAt(file string, staged *os.File, offset int64) error {
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.NewOffsetWriter(f, offset), staged); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// etagMatches reports whether an If-Match header value lists etag or is *.
+func etagMatches(ifMatch, etag string) bool {
+	current := normalizeEtag(etag)
+	// Etags may contain commas themselves.
+	if normalizeEtag(ifMatch) == current {
+		return true
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || normalizeEtag(candidate) == current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package publicfiles
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func offsetAt(n int64) *int64 {
+	return &n
+}
+
+func TestPatchFile_AppendAndOverwrite(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	file := filepath.Join(tmpDir, "app.log")
+	os.WriteFile(file, []byte("line 1\n"), 0644)
+
+	result, errResp := service.PatchFile("app.log", strings.NewReader("line 2\n"), dtos.PatchOptions{Append: true}, "*")
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(7), result["offset"])
+	assert.Equal(t, int64(7), result["bytes_written"])
+	assert.Equal(t, int64(14), result["size"])
+
+	// Writes chain on the etag of the previous one.
+	result, errResp = service.PatchFile("app.log", strings.NewReader("LINE"), dtos.PatchOptions{Offset: offsetAt(7)}, result["etag"].(string))
+	assert.Nil(t, errResp)
+	content, _ := os.ReadFile(file)
+	assert.Equal(t, "line 1\nLINE 2\n", string(content))
+
+	// An overwrite that keeps the size still gets a new etag.
+	etag := result["etag"].(string)
+	result, errResp = service.PatchFile("app.log", strings.NewReader("l"), dtos.PatchOptions{Offset: offsetAt(0)}, etag)
+	assert.Nil(t, errResp)
+	assert.NotEqual(t, etag, result["etag"])
+	_, errResp = service.PatchFile("app.log", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(0)}, etag)
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusPreconditionFailed, errResp.Status)
+
+	// Writing past the end extends the file.
+	result, errResp = service.PatchFile("app.log", strings.NewReader("2!\nline 3\n"), dtos.PatchOptions{Offset: offsetAt(12)}, result["etag"].(string))
+	assert.Nil(t, errResp)
+	content, _ = os.ReadFile(file)
+	assert.Equal(t, "line 1\nLINE 2!\nline 3\n", string(content))
+	assert.Equal(t, int64(len(content)), result["size"])
+}
+
+func TestPatchFile_RejectsBadRequests(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "data.bin"), []byte("0123456789"), 0644)
+
+	_, errResp := service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Append: true}, "")
+	assert.Equal(t, http.StatusPreconditionRequired, errResp.Status)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(11)}, "*")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, errResp.Status)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{}, "*")
+	assert.NotNil(t, errResp)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(1), Append: true}, "*")
+	assert.NotNil(t, errResp)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(-1)}, "*")
+	assert.NotNil(t, errResp)
+	_, errResp = service.PatchFile("missing.bin", strings.NewReader("x"), dtos.PatchOptions{Append: true}, "*")
+	assert.NotNil(t, errResp)
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "data.bin"))
+	assert.Equal(t, "0123456789", string(content))
+}
+
+func TestPatchFile_QuotaAndLocks(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	file := filepath.Join(tmpDir, "data.bin")
+	os.WriteFile(file, []byte("0123456789"), 0644)
+	service.SetStorageQuota(14)
+
+	// Overwriting in place needs no quota; growing past it is refused whole.
+	_, errResp := service.PatchFile("data.bin", strings.NewReader("abcdefgh"), dtos.PatchOptions{Offset: offsetAt(2)}, "*")
+	assert.Nil(t, errResp)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("tail!"), dtos.PatchOptions{Append: true}, "*")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, errResp.Status)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("tail"), dtos.PatchOptions{Append: true}, "*")
+	assert.Nil(t, errResp)
+	content, _ := os.ReadFile(file)
+	assert.Equal(t, "01abcdefghtail", string(content))
+
+	lock, _ := service.LockItem("data.bin", dtos.LockRequest{})
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(0)}, "*")
+	assert.Equal(t, http.StatusLocked, errResp.Status)
+	_, errResp = service.PatchFile("data.bin", strings.NewReader("x"), dtos.PatchOptions{Offset: offsetAt(0)}, "*", lock.Token)
+	assert.Nil(t, errResp)
+
+	// No staging files are left behind.
+	entries, _ := os.ReadDir(tmpDir)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), stagingPrefix), entry.Name())
+	}
+}
@@ -0,0 +1,441 @@
+package publicfiles
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxPreviewRenderSize is the largest file rendered or pretty-printed
+	// whole. Larger files are previewed as source, a page at a time.
+	maxPreviewRenderSize = 1024 * 1024
+
+	defaultPreviewLines = 1000
+	maxPreviewLines     = 5000
+
+	// maxPreviewPageSize bounds the text of a page, which ends early when
+	// lines are long. A single longer line is cut.
+	maxPreviewPageSize = 1024 * 1024
+)
+
+// Kinds of FilePreview.
+const (
+	previewMarkdown = "markdown"
+	previewCode     = "code"
+)
+
+// linePage is a range of lines of a text.
+type linePage struct {
+	lines []string
+	total int
+	// cut is set when a line was shortened to fit the page.
+	cut bool
+}
+
+// readLinePage reads count lines of r from the 1-based line start, and
+// counts the lines of all of r. Lines lose their line endings.
+func readLinePage(r io.Reader, start, count int) (linePage, error) {
+	var page linePage
+	br := bufio.NewReaderSize(r, 64*1024)
+	lineNo, size := 1, 0
+	var line []byte
+	partial := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(chunk) > 0 {
+			ends := chunk[len(chunk)-1] == '\n'
+			partial = true
+			if lineNo >= start && lineNo < start+count && size < maxPreviewPageSize {
+				room := maxPreviewPageSize - size - len(line)
+				if len(chunk) > room {
+					chunk = chunk[:max(room, 0)]
+					page.cut = true
+				}
+				line = append(line, chunk...)
+			}
+			if ends {
+				page.flush(&line, &size, lineNo, start, count)
+				lineNo++
+				partial = false
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return page, err
+		}
+	}
+	if partial {
+		page.flush(&line, &size, lineNo, start, count)
+		lineNo++
+	}
+	page.total = lineNo - 1
+	return page, nil
+}
+
+// flush ends the line being read, adding it to the page if it is in range.
+func (page *linePage) flush(line *[]byte, size *int, lineNo, start, count int) {
+	if lineNo >= start && lineNo < start+count && *size < maxPreviewPageSize {
+		text := strings.TrimSuffix(strings.TrimSuffix(string(*line), "\n"), "\r")
+		// A cut can split a character.
+		for !utf8.ValidString(text) && text != "" {
+			text = text[:len(text)-1]
+		}
+		page.lines = append(page.lines, text)
+		*size += len(*line)
+	}
+	*line = (*line)[:0]
+}
+
+// prettyPrint reformats JSON, YAML or TOML content with consistent
+// indentation, keeping the order of keys and YAML and TOML comments.
+func prettyPrint(ext string, content []byte) (string, error) {
+	switch ext {
+	case "json":
+		var out bytes.Buffer
+		if err := json.Indent(&out, content, "", "  "); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		return out.String(), nil
+	case "yaml", "yml":
+		var out bytes.Buffer
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		for {
+			var doc yaml.Node
+			if err := dec.Decode(&doc); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return "", fmt.Errorf("invalid YAML: %w", err)
+			}
+			if err := enc.Encode(&doc); err != nil {
+				return "", fmt.Errorf("invalid YAML: %w", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			return "", fmt.Errorf("invalid YAML: %w", err)
+		}
+		return out.String(), nil
+	case "toml":
+		return formatTOML(string(content))
+	}
+	return "", fmt.Errorf("cannot format .%s files", ext)
+}
+
+// formatTOML normalizes the layout of a TOML document: no indentation
+// except inside multi-line arrays, spaces around '=', one blank line before
+// each table and no runs of blank lines. Values are kept as written.
+func formatTOML(src string) (string, error) {
+	var out []string
+	multiline := ""
+	depth := 0
+	for n, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		if multiline != "" {
+			out = append(out, line)
+			if i := strings.Index(line, multiline); i >= 0 {
+				var delta int
+				delta, multiline = scanTOML(line[i+len(multiline):])
+				depth += delta
+			}
+			continue
+		}
+		line = strings.TrimSpace(line)
+		prevBlank := len(out) == 0 || out[len(out)-1] == ""
+		switch {
+		case line == "":
+			if !prevBlank {
+				out = append(out, "")
+			}
+			continue
+		case depth > 0:
+			indent := depth
+			if strings.HasPrefix(line, "]") {
+				indent--
+			}
+			out = append(out, strings.Repeat("  ", indent)+line)
+		case strings.HasPrefix(line, "#"):
+			out = append(out, line)
+			continue
+		case strings.HasPrefix(line, "["):
+			if !prevBlank && !strings.HasPrefix(out[len(out)-1], "#") {
+				out = append(out, "")
+			}
+			out = append(out, line)
+			continue
+		default:
+			eq := tomlAssignment(line)
+			if eq < 0 {
+				return "", fmt.Errorf("invalid TOML: line %d: expected key = value", n+1)
+			}
+			key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+			out = append(out, key+" = "+value)
+			line = value
+		}
+		var delta int
+		delta, multiline = scanTOML(line)
+		depth += delta
+		if depth < 0 {
+			return "", fmt.Errorf("invalid TOML: line %d: unbalanced ']'", n+1)
+		}
+	}
+	if multiline != "" || depth > 0 {
+		return "", errors.New("invalid TOML: unterminated string or array")
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(out, "\n") + "\n", nil
+}
+
+// tomlAssignment returns the index of the '=' of a key/value line, outside
+// quoted keys.
+func tomlAssignment(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return i
+		case c == '#':
+			return -1
+		}
+	}
+	return -1
+}
+
+// scanTOML scans a value for the brackets of arrays and an unterminated
+// multi-line string, skipping strings and comments. It returns the change
+// in array depth and the delimiter of a multi-line string left open.
+func scanTOML(s string) (int, string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '#':
+			return depth, ""
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '"', '\'':
+			if strings.HasPrefix(s[i:], strings.Repeat(string(c), 3)) {
+				delim := s[i : i+3]
+				end := strings.Index(s[i+3:], delim)
+				if end < 0 {
+					return depth, delim
+				}
+				i += 3 + end + 2
+				continue
+			}
+			for i++; i < len(s) && s[i] != c; i++ {
+				if c == '"' && s[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return depth, ""
+}
+
+// PreviewFile renders a text file for display. Markdown is rendered whole
+// and JSON, YAML and TOML are pretty-printed; other files, and any file
+// when a line range is asked for or it is too large to render, are shown
+// as syntax-highlighted source a page of lines at a time. Only files with
+// the editable text extensions can be previewed.
+func (p *PublicFilesService) PreviewFile(path string, opts dtos.PreviewOptions) (*dtos.FilePreview, *dtos.ErrorResponse) {
+	file, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File not found",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("File does not exist: %s", file)),
+			Status:    http.StatusNotFound,
+		}
+	}
+	if info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Path is a directory",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Cannot preview directory: %s", file)),
+		}
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	lang := languagesByExt[ext]
+	if !allowedEditExtensions[ext] || lang == nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("File type cannot be previewed: .%s", ext),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Unsupported extension: %s", ext)),
+			Status:    http.StatusUnsupportedMediaType,
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	defer f.Close()
+	head := make([]byte, binarySniffLen)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(trimPartialRune(head)) {
+		return nil, &dtos.ErrorResponse{
+			Error:     "File is not text",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%s has binary content", file)),
+			Status:    http.StatusUnsupportedMediaType,
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	preview := &dtos.FilePreview{
+		Path:     p.relPath(file),
+		Size:     info.Size(),
+		Kind:     previewCode,
+		Language: lang.name,
+	}
+	ranged := opts.StartLine > 0 || opts.Lines > 0
+	var source io.Reader = f
+	switch {
+	case opts.Raw:
+	case info.Size() > maxPreviewRenderSize:
+		if ext == "md" || ext == "json" || ext == "yaml" || ext == "yml" || ext == "toml" {
+			preview.Notice = "File is too large to render; showing source"
+		}
+	case ext == "md" && !ranged:
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to read file: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		preview.Kind = previewMarkdown
+		preview.HTML = `<div class="markdown-body">` + renderMarkdown(string(content)) + "</div>"
+		preview.TotalLines = len(splitLines(string(content)))
+		preview.StartLine, preview.EndLine = 1, preview.TotalLines
+		return preview, nil
+	case ext == "json" || ext == "yaml" || ext == "yml" || ext == "toml":
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to read file: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		source = bytes.NewReader(content)
+		if formatted, err := prettyPrint(ext, content); err != nil {
+			preview.Notice = err.Error()
+		} else if len(bytes.TrimSpace(content)) > 0 {
+			source = strings.NewReader(formatted)
+			preview.Formatted = true
+		}
+	}
+
+	start := max(opts.StartLine, 1)
+	count := opts.Lines
+	if count <= 0 {
+		count = defaultPreviewLines
+	}
+	count = min(count, maxPreviewLines)
+	page, err := readLinePage(source, start, count)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read file: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if start > page.total && start > 1 {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Line %d is past the end of the file (%d lines)", start, page.total),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("start_line=%d", start)),
+			Status:    http.StatusRequestedRangeNotSatisfiable,
+		}
+	}
+	if page.cut && preview.Notice == "" {
+		preview.Notice = "Long lines were cut to fit the page"
+	}
+
+	preview.HTML = highlightBlock(page.lines, lang, start)
+	preview.TotalLines = page.total
+	preview.StartLine = start
+	preview.EndLine = start + len(page.lines) - 1
+	if len(page.lines) == 0 {
+		preview.StartLine, preview.EndLine = 0, 0
+	}
+	preview.HasMore = start+len(page.lines)-1 < page.total
+	return preview, nil
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of b, where
+// a read stopped in the middle of a character.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
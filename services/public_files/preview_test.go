@@ -0,0 +1,335 @@
+package publicfiles
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	out := renderMarkdown("# Title\n\nSome *em* and **strong** text with `code`.\n\n" +
+		"- one\n- [x] done\n  - nested\n\n" +
+		"1. first\n2. second\n\n" +
+		"> quoted\n\n" +
+		"| a | b |\n|:--|--:|\n| 1 | 2 |\n\n" +
+		"```go\nfunc main() {}\n```\n\n" +
+		"See [docs](https://example.com \"Docs\") or https://example.org.\n")
+
+	assert.Contains(t, out, `<h1 id="user-content-title">Title</h1>`)
+	assert.Contains(t, out, "<p>Some <em>em</em> and <strong>strong</strong> text with <code>code</code>.</p>")
+	assert.Contains(t, out, "<ul>\n<li>one</li>\n")
+	assert.Contains(t, out, `<li class="task-list-item"><input type="checkbox" disabled checked> done<ul>`+"\n<li>nested</li>")
+	assert.Contains(t, out, "<ol>\n<li>first</li>\n<li>second</li>\n</ol>")
+	assert.Contains(t, out, "<blockquote>\n<p>quoted</p>\n</blockquote>")
+	assert.Contains(t, out, `<th style="text-align:left">a</th>`)
+	assert.Contains(t, out, `<td style="text-align:right">2</td>`)
+	assert.Contains(t, out, `<pre><code class="language-go"><span class="tok-keyword">func</span> main() {}</code></pre>`)
+	assert.Contains(t, out, `<a href="https://example.com" title="Docs" rel="nofollow noopener noreferrer">docs</a>`)
+	assert.Contains(t, out, `<a href="https://example.org" rel="nofollow noopener noreferrer">https://example.org</a>.`)
+}
+
+func TestRenderMarkdown_IsSafe(t *testing.T) {
+	out := renderMarkdown("<script>alert(1)</script>\n\n" +
+		"<img src=x onerror=alert(1)>\n\n" +
+		"[click](javascript:alert(1)) [tab](java\tscript:alert(1)) [data](data:text/html;base64,PHNjcmlwdD4=)\n\n" +
+		"![pic](javascript:alert(1)) ![ok](img/cat.png \"a \\\" quote\")\n\n" +
+		"<javascript:alert(1)> [\"onmouseover=\"alert(1)](https://x.test/\"onclick=\"1)\n\n" +
+		"```html\n<script>alert(1)</script>\n```\n")
+
+	assert.NotContains(t, out, "<script")
+	assert.NotContains(t, out, "<img src=x")
+	assert.NotContains(t, out, `="javascript`)
+	assert.NotContains(t, out, `="data:`)
+	assert.NotContains(t, out, `"onclick`)
+	assert.NotContains(t, out, `"onmouseover`)
+	assert.Contains(t, out, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.Contains(t, out, "click tab data")
+	assert.Contains(t, out, `<img src="img/cat.png" alt="ok" title="a &#34; quote" loading="lazy">`)
+}
+
+func TestRenderMarkdown_RefusesScriptSchemes(t *testing.T) {
+	for _, dest := range []string{
+		"javascript:alert(1)",
+		"JavaScript:alert(1)",
+		" JAVASCRIPT:alert(1)",
+		"java\tscript:alert(1)",
+		"vbscript:msgbox(1)",
+		"VBScript:msgbox(1)",
+		"data:text/html,alert(1)",
+		"Data:image/svg+xml;base64,PHN2Zz4=",
+		"file:///etc/passwd",
+	} {
+		for _, src := range []string{"[x](" + dest + ")", "[x](<" + dest + ">)", "![x](" + dest + ")", "<" + dest + ">"} {
+			out := renderMarkdown(src)
+			assertSafeHTML(t, src, out)
+			assert.NotContains(t, out, "href=", src)
+			assert.NotContains(t, out, "src=", src)
+		}
+	}
+
+	// Entities are not decoded, so encoded schemes stay relative URLs with
+	// their ampersands escaped.
+	for _, src := range []string{"[x](&#106;avascript:alert(1))", "[x](javascript&#58;alert(1))"} {
+		out := renderMarkdown(src)
+		assertSafeHTML(t, src, out)
+		assert.Contains(t, out, `href="`+html.EscapeString(src[4:len(src)-1])+`"`)
+	}
+
+	out := renderMarkdown("[mail](mailto:a@example.com) ![mail](mailto:a@example.com) <https://example.com/a?b=1&c=2>")
+	assert.Contains(t, out, `<a href="mailto:a@example.com"`)
+	assert.NotContains(t, out, `src="mailto:`)
+	assert.Contains(t, out, `<a href="https://example.com/a?b=1&amp;c=2"`)
+}
+
+func TestRenderMarkdown_EscapesAttributes(t *testing.T) {
+	for _, src := range []string{
+		`[x](https://example.com/"onclick="alert(1))`,
+		`[x](<https://example.com/" onclick="alert(1)>)`,
+		`[x](https://example.com/ "t\" onclick=\"alert(1)")`,
+		`[x](https://example.com/ 't" onclick="alert(1)')`,
+		`![a" onerror="alert(1)](img.png)`,
+		`![a](img.png "<script>alert(1)</script>")`,
+		"# a\" onclick=\"alert(1)\n",
+		"```go\" onmouseover=\"alert(1)\nx\n```\n",
+		"```<script>\nx\n```\n",
+		"| a |\n|:-\" onclick=\"x|\n| b |\n",
+		"3\" onclick=\"x. item\n",
+		"<div onclick=\"alert(1)\">x</div>\n",
+		"<a href=\"javascript:alert(1)\">x</a>",
+		"`<img src=x onerror=alert(1)>`",
+		"<!-- <script>alert(1)</script> -->",
+	} {
+		assertSafeHTML(t, src, renderMarkdown(src))
+	}
+
+	out := renderMarkdown(`[x](https://example.com/ "say \"hi\" & 'bye'")`)
+	assert.Contains(t, out, `title="say &#34;hi&#34; &amp; &#39;bye&#39;"`)
+}
+
+func TestHighlightLines(t *testing.T) {
+	lines := highlightLines("// hi <b>\nx := \"a\\\"b\" + 42 /* multi\nline */", langGo)
+	assert.Equal(t, []string{
+		`<span class="tok-comment">// hi &lt;b&gt;</span>`,
+		`x := <span class="tok-string">&#34;a\&#34;b&#34;</span> + <span class="tok-number">42</span> <span class="tok-comment">/* multi</span>`,
+		`<span class="tok-comment">line */</span>`,
+	}, lines)
+
+	assert.Equal(t, []string{
+		`<span class="tok-key">name</span>: <span class="tok-string">&#34;x&#34;</span> <span class="tok-comment"># note</span>`,
+		`<span class="tok-key">on</span>: <span class="tok-literal">true</span>`,
+	}, highlightLines("name: \"x\" # note\non: true", langYAML))
+
+	assert.Equal(t, []string{
+		`<span class="tok-tag">&lt;a</span> <span class="tok-attr">href</span>=<span class="tok-string">&#34;/&#34;</span><span class="tok-tag">&gt;</span>x<span class="tok-tag">&lt;/a&gt;</span>`,
+	}, highlightLines(`<a href="/">x</a>`, langMarkup))
+}
+
+func TestPrettyPrint(t *testing.T) {
+	out, err := prettyPrint("json", []byte(`{"b":1,"a":[true,null]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"b\": 1,\n  \"a\": [\n    true,\n    null\n  ]\n}", out)
+
+	out, err = prettyPrint("yaml", []byte("a:    1 # one\nlist:\n    - x\n    - y\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a: 1 # one\nlist:\n  - x\n  - y\n", out)
+
+	out, err = prettyPrint("toml", []byte("title=\"x = y\"\n\n\n  [server]\nport=80   # web\nhosts=[\n\"a\",\n  \"b\",\n]\n[db]\nnote='''\n  kept\n'''\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "title = \"x = y\"\n\n[server]\nport = 80   # web\nhosts = [\n  \"a\",\n  \"b\",\n]\n\n[db]\nnote = '''\n  kept\n'''\n", out)
+
+	_, err = prettyPrint("json", []byte(`{"a":`))
+	assert.Error(t, err)
+	_, err = prettyPrint("toml", []byte("just words\n"))
+	assert.Error(t, err)
+}
+
+func TestPreviewFile_Markdown(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "README.md"), []byte("# Hi\n\n<b>x</b>\n"), 0644)
+
+	preview, errResp := service.PreviewFile("README.md", dtos.PreviewOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "markdown", preview.Kind)
+	assert.Equal(t, `<div class="markdown-body"><h1 id="user-content-hi">Hi</h1>`+"\n<p>&lt;b&gt;x&lt;/b&gt;</p>\n</div>", preview.HTML)
+	assert.Equal(t, 3, preview.TotalLines)
+
+	preview, errResp = service.PreviewFile("README.md", dtos.PreviewOptions{Raw: true})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "code", preview.Kind)
+	assert.Equal(t, "markdown", preview.Language)
+	assert.Contains(t, preview.HTML, `<span class="line" data-line="1"># Hi</span>`)
+}
+
+func TestPreviewFile_PrettyPrintsData(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "data.json"), []byte(`{"a":1}`), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "bad.json"), []byte(`{"a":`), 0644)
+
+	preview, errResp := service.PreviewFile("data.json", dtos.PreviewOptions{})
+	assert.Nil(t, errResp)
+	assert.True(t, preview.Formatted)
+	assert.Equal(t, "json", preview.Language)
+	assert.Equal(t, 3, preview.TotalLines)
+	assert.Contains(t, preview.HTML, `<span class="line" data-line="2">  <span class="tok-key">&#34;a&#34;</span>: <span class="tok-number">1</span></span>`)
+
+	preview, errResp = service.PreviewFile("bad.json", dtos.PreviewOptions{})
+	assert.Nil(t, errResp)
+	assert.False(t, preview.Formatted)
+	assert.Contains(t, preview.Notice, "invalid JSON")
+	assert.Equal(t, 1, preview.TotalLines)
+}
+
+func TestPreviewFile_PaginatesLines(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	var src strings.Builder
+	for i := 1; i <= 2500; i++ {
+		fmt.Fprintf(&src, "var x%d = %d\r\n", i, i)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "big.js"), []byte(src.String()), 0644)
+
+	preview, errResp := service.PreviewFile("big.js", dtos.PreviewOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "code", preview.Kind)
+	assert.Equal(t, "javascript", preview.Language)
+	assert.Equal(t, 1, preview.StartLine)
+	assert.Equal(t, 1000, preview.EndLine)
+	assert.Equal(t, 2500, preview.TotalLines)
+	assert.True(t, preview.HasMore)
+
+	preview, errResp = service.PreviewFile("big.js", dtos.PreviewOptions{StartLine: 2499, Lines: 10})
+	assert.Nil(t, errResp)
+	assert.Equal(t, 2499, preview.StartLine)
+	assert.Equal(t, 2500, preview.EndLine)
+	assert.False(t, preview.HasMore)
+	assert.Equal(t, `<pre class="preview-code language-javascript"><code>`+
+		`<span class="line" data-line="2499"><span class="tok-keyword">var</span> x2499 = <span class="tok-number">2499</span></span>`+"\n"+
+		`<span class="line" data-line="2500"><span class="tok-keyword">var</span> x2500 = <span class="tok-number">2500</span></span>`+
+		`</code></pre>`, preview.HTML)
+
+	_, errResp = service.PreviewFile("big.js", dtos.PreviewOptions{StartLine: 3000})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, errResp.Status)
+}
+
+func TestPreviewFile_RejectsOtherFiles(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	os.WriteFile(filepath.Join(tmpDir, "photo.png"), []byte("\x89PNG\r\n"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "blob.txt"), []byte("a\x00b"), 0644)
+
+	_, errResp := service.PreviewFile("photo.png", dtos.PreviewOptions{})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusUnsupportedMediaType, errResp.Status)
+
+	_, errResp = service.PreviewFile("blob.txt", dtos.PreviewOptions{})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusUnsupportedMediaType, errResp.Status)
+
+	_, errResp = service.PreviewFile("missing.txt", dtos.PreviewOptions{})
+	assert.NotNil(t, errResp)
+}
+
+func FuzzRenderMarkdown(f *testing.F) {
+	f.Add("# Title\n\n- [x] *a* **b** ~~c~~ `d`\n\n| a |\n|:-:|\n| 1 |\n")
+	f.Add(`[a](https://example.com "t") ![b](c.png) <https://x.test> https://y.test`)
+	f.Add("<script>alert(1)</script>\n[x](javascript:alert(1))")
+	f.Add("```html\n<b onclick=\"x\">\n```\n> quote\n1. one\n")
+	f.Fuzz(func(t *testing.T, src string) {
+		assertSafeHTML(t, src, renderMarkdown(src))
+	})
+}
+
+func FuzzHighlightLines(f *testing.F) {
+	f.Add("go", "func main() { s := \"<b>\" // x\n}")
+	f.Add("html", `<a href="/" onclick='x'>y</a><!-- z -->`)
+	f.Add("py", "\"\"\"doc\n\"\"\" # <script>")
+	f.Add("yaml", "key: \"v\" # c\n- 1")
+	f.Fuzz(func(t *testing.T, lang, src string) {
+		l := languageByName(lang)
+		// Stripping the spans and unescaping must give back the source
+		// exactly, so nothing is dropped and no markup slips through.
+		plain := tokenSpanRe.ReplaceAllString(strings.Join(highlightLines(src, l), "\n"), "")
+		if strings.ContainsAny(plain, `<>"`) {
+			t.Fatalf("unescaped markup in %q", plain)
+		}
+		if got := html.UnescapeString(plain); got != src {
+			t.Fatalf("highlighting %q as %s gave back %q", src, l.name, got)
+		}
+		assertSafeHTML(t, src, highlightBlock(strings.Split(src, "\n"), l, 1))
+	})
+}
+
+var (
+	tokenSpanRe = regexp.MustCompile(`<span class="tok-[a-z]+">|</span>`)
+	htmlTagRe   = regexp.MustCompile(`^<(/?)([a-z][a-z0-9]*)((?: [a-z-]+(?:="[^"<>]*")?)*)>$`)
+	htmlAttrRe  = regexp.MustCompile(` ([a-z-]+)(?:="([^"<>]*)")?`)
+	safeTags    = map[string]bool{
+		"a": true, "blockquote": true, "br": true, "code": true, "del": true, "em": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+		"img": true, "input": true, "li": true, "ol": true, "p": true, "pre": true, "span": true,
+		"strong": true, "table": true, "tbody": true, "td": true, "th": true, "thead": true,
+		"tr": true, "ul": true,
+	}
+	safeAttrs = map[string]bool{
+		"alt": true, "checked": true, "class": true, "data-line": true, "disabled": true,
+		"href": true, "id": true, "loading": true, "rel": true, "src": true, "start": true,
+		"style": true, "title": true, "type": true,
+	}
+)
+
+// assertSafeHTML fails unless every tag in out is one the renderers write,
+// with known attributes only, and every URL in it has a safe scheme.
+func assertSafeHTML(t *testing.T, src, out string) {
+	t.Helper()
+	rest := out
+	for {
+		start := strings.IndexByte(rest, '<')
+		if start < 0 {
+			start = len(rest)
+		}
+		if strings.IndexByte(rest[:start], '>') >= 0 {
+			t.Fatalf("stray > rendering %q: %s", src, out)
+		}
+		if start == len(rest) {
+			return
+		}
+		end := strings.IndexByte(rest[start:], '>')
+		if end < 0 {
+			t.Fatalf("unclosed tag rendering %q: %s", src, out)
+		}
+		tag := rest[start : start+end+1]
+		rest = rest[start+end+1:]
+
+		m := htmlTagRe.FindStringSubmatch(tag)
+		if m == nil || !safeTags[m[2]] || (m[1] == "/" && m[3] != "") {
+			t.Fatalf("unexpected tag %s rendering %q", tag, src)
+		}
+		for _, attr := range htmlAttrRe.FindAllStringSubmatch(m[3], -1) {
+			name, value := attr[1], html.UnescapeString(attr[2])
+			if !safeAttrs[name] {
+				t.Fatalf("unexpected attribute %s in %s rendering %q", name, tag, src)
+			}
+			switch name {
+			case "href", "src":
+				if _, ok := safeURL(value, name == "src"); !ok {
+					t.Fatalf("unsafe URL %q in %s rendering %q", value, tag, src)
+				}
+			case "style":
+				if !strings.HasPrefix(value, "text-align:") {
+					t.Fatalf("unexpected style %q rendering %q", value, src)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Properties are the name/value pairs that WebDAV clients attach to files and
+// folders with PROPPATCH, such as the author an office suite records or the
+// colour label of a file manager. The server stores them without looking at
+// them. A property is named by an XML namespace and local name, and its value
+// is a piece of XML that declares every namespace it uses, so that it can be
+// embedded in any response. Properties follow their item through renames and
+// moves like tags do.
+//
+// The properties file lies outside the storage quota and is rewritten whole
+// on every change, so the bytes the properties take are capped per item and
+// in total.
+
+const (
+	propertiesFile          = "properties.json"
+	maxPropertiesPerItem    = 256
+	maxPropertyValueLength  = 64 * 1024
+	maxPropertyBytesPerItem = 256 * 1024
+	maxPropertyBytes        = 16 * 1024 * 1024
+)
+
+var errPropertySpace = errors.New("not enough space for properties")
+
+type deadProperty struct {
+	Space string `json:"space"`
+	Local string `json:"local"`
+	Value string `json:"value"`
+}
+
+// propertyPatch sets or, with Remove, removes one property.
+type propertyPatch struct {
+	Remove bool
+	Prop   deadProperty
+}
+
+// propertyStore keeps the properties per path in a JSON file under the
+// metadata directory. It is loaded on first use.
+type propertyStore struct {
+	path string
+	once sync.Once
+
+	mu    sync.RWMutex
+	props map[string][]deadProperty
+	// size is the number of bytes all properties take.
+	size int
+}
+
+func propertiesSize(props []deadProperty) int {
+	size := 0
+	for _, prop := range props {
+		size += len(prop.Space) + len(prop.Local) + len(prop.Value)
+	}
+	return size
+}
+
+func newPropertyStore(path string) *propertyStore {
+	return &propertyStore{path: path, props: make(map[string][]deadProperty)}
+}
+
+func (s *propertyStore) load() {
+	s.once.Do(func() {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn().Err(err).Msg("Failed to read properties")
+			}
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := json.Unmarshal(data, &s.props); err != nil {
+			log.Warn().Err(err).Msg("Failed to parse properties")
+			s.props = make(map[string][]deadProperty)
+		}
+		for _, props := range s.props {
+			s.size += propertiesSize(props)
+		}
+	})
+}
+
+func (s *propertyStore) get(rel string) []deadProperty {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]deadProperty(nil), s.props[rel]...)
+}
+
+// patch applies patches to the properties of rel in order. Either all of
+// them are applied or, if the result would hold too many properties or take
+// too much space, none.
+func (s *propertyStore) patch(rel string, patches []propertyPatch) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	props := append([]deadProperty(nil), s.props[rel]...)
+	for _, patch := range patches {
+		i := 0
+		for ; i < len(props); i++ {
+			if props[i].Space == patch.Prop.Space && props[i].Local == patch.Prop.Local {
+				break
+			}
+		}
+		switch {
+		case patch.Remove && i < len(props):
+			props = append(props[:i], props[i+1:]...)
+		case patch.Remove:
+		case i < len(props):
+			props[i] = patch.Prop
+		default:
+			props = append(props, patch.Prop)
+		}
+	}
+	if len(props) > maxPropertiesPerItem {
+		return fmt.Errorf("too many properties (maximum %d per item)", maxPropertiesPerItem)
+	}
+	itemSize := propertiesSize(props)
+	if itemSize > maxPropertyBytesPerItem {
+		return fmt.Errorf("%w: properties take more than %d bytes", errPropertySpace, maxPropertyBytesPerItem)
+	}
+	size := s.size - propertiesSize(s.props[rel]) + itemSize
+	if size > maxPropertyBytes {
+		return fmt.Errorf("%w: the drive's properties would take more than %d bytes", errPropertySpace, maxPropertyBytes)
+	}
+	s.size = size
+	if len(props) == 0 {
+		delete(s.props, rel)
+	} else {
+		s.props[rel] = props
+	}
+	return s.saveLocked()
+}
+
+// removePrefix drops the properties of prefix and of everything below it.
+func (s *propertyStore) removePrefix(prefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for rel, props := range s.props {
+		if underPrefix(rel, prefix) {
+			s.size -= propertiesSize(props)
+			delete(s.props, rel)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+// move re-keys the properties at or below oldPrefix to newPrefix.
+func (s *propertyStore) move(oldPrefix, newPrefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := make(map[string][]deadProperty)
+	for rel, props := range s.props {
+		if underPrefix(rel, oldPrefix) {
+			moved[newPrefix+strings.TrimPrefix(rel, oldPrefix)] = props
+			delete(s.props, rel)
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+	for rel, props := range moved {
+		s.props[rel] = props
+	}
+	s.saveLocked()
+}
+
+// copy gives newPrefix the properties of oldPrefix and, when deep is set,
+// those of everything below it too. Nothing is copied if the copies would
+// not fit.
+func (s *propertyStore) copy(oldPrefix, newPrefix string, deep bool) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := make(map[string][]deadProperty)
+	size := s.size
+	for rel, props := range s.props {
+		if rel == oldPrefix || deep && underPrefix(rel, oldPrefix) {
+			target := newPrefix + strings.TrimPrefix(rel, oldPrefix)
+			copied[target] = append([]deadProperty(nil), props...)
+			size += propertiesSize(props) - propertiesSize(s.props[target])
+		}
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+	if size > maxPropertyBytes {
+		return fmt.Errorf("%w: the drive's properties would take more than %d bytes", errPropertySpace, maxPropertyBytes)
+	}
+	for rel, props := range copied {
+		s.props[rel] = props
+	}
+	s.size = size
+	return s.saveLocked()
+}
+
+func (s *propertyStore) saveLocked() error {
+	data, err := json.Marshal(s.props)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to save properties")
+		return err
+	}
+	return nil
+}
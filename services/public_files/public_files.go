@@ -1,13 +1,19 @@
 package publicfiles
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
+	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
@@ -16,9 +22,9 @@ import (
 )
 
 const (
-	maxChunkSize    = 10 * 1024 * 1024
 	maxTotalSize    = 1 * 1024 * 1024 * 1024 * 1024
 	maxSearchLength = 255
+	maxEditSize     = 10 * 1024 * 1024
 )
 
 var allowedEditExtensions = map[string]bool{
@@ -29,15 +35,51 @@ var allowedEditExtensions = map[string]bool{
 }
 
 type PublicFilesService struct {
-	publicDir string
-	wsHub     *WebSocketHub
+	publicDir    string
+	wsHub        *WebSocketHub
+	jobs         *jobManager
+	dirIndexes   *dirIndexCache
+	names        *nameIndex
+	content      *contentIndex
+	tags         *tagStore
+	catalog      *catalog
+	favorites    *activityStore
+	collab       *collabManager
+	versions     *versionStore
+	images       *imageCache
+	locks        *lockStore
+	props        *propertyStore
+	storageQuota int64
+
+	blockMismatches bool
+
+	editMu     sync.Mutex
+	syncMu     sync.Mutex
+	reindexMu  sync.Mutex
+	reindexJob *Job
 }
 
 func NewPublicFilesService(publicDir string, wsHub *WebSocketHub) *PublicFilesService {
-	return &PublicFilesService{
-		publicDir: publicDir,
-		wsHub:     wsHub,
-	}
+	p := &PublicFilesService{
+		publicDir:  publicDir,
+		wsHub:      wsHub,
+		jobs:       newJobManager(),
+		dirIndexes: newDirIndexCache(),
+		names:      newNameIndex(),
+		content:    newContentIndex(),
+		tags:       newTagStore(filepath.Join(publicDir, metaDirName, tagsFile)),
+		catalog:    newCatalog(filepath.Join(publicDir, metaDirName)),
+		favorites:  newActivityStore(filepath.Join(publicDir, metaDirName, favoritesFile)),
+		versions:   newVersionStore(filepath.Join(publicDir, metaDirName, versionsDir)),
+		images:     newImageCache(filepath.Join(publicDir, metaDirName, imageCacheDir)),
+		locks:      newLockStore(filepath.Join(publicDir, metaDirName, locksFile)),
+		props:      newPropertyStore(filepath.Join(publicDir, metaDirName, propertiesFile)),
+	}
+	p.collab = newCollabManager(p)
+	if wsHub != nil {
+		p.collab.register(wsHub)
+	}
+	return p
 }
 
 func (p *PublicFilesService) ensurePublicDir() error {
@@ -51,7 +93,7 @@ func (p *PublicFilesService) sanitizePathForRead(input string) (string, error) {
 	decoded := input
 	dangerousPatterns := []string{
 		"..", "%2e%2e", "%252e%252e", "/etc/", "/root/", "/home/",
-		"\\", ":", "*", "?", "\"", "<", ">", "|", "\x00", "~", "$", "&", ";", "`", "'", "(", ")", "{", "}", "[", "]",
+		"\\", ":", "*", "?", "\"", "<", ">", "|", "\x00", "~", "$", "&", ";", "`", "'", "{", "}", "[", "]",
 	}
 
 	for _, pattern := range dangerousPatterns {
@@ -113,7 +155,7 @@ func (p *PublicFilesService) sanitizePathForWrite(input string) (string, error)
 	decoded := input
 	dangerousPatterns := []string{
 		"..", "%2e%2e", "%252e%252e", "/etc/", "/root/", "/home/",
-		"\\", ":", "*", "?", "\"", "<", ">", "|", "\x00", "~", "$", "&", ";", "`", "'", "(", ")", "{", "}", "[", "]",
+		"\\", ":", "*", "?", "\"", "<", ">", "|", "\x00", "~", "$", "&", ";", "`", "'", "{", "}", "[", "]",
 	}
 
 	for _, pattern := range dangerousPatterns {
@@ -166,12 +208,43 @@ func (p *PublicFilesService) sanitizePathForWrite(input string) (string, error)
 	return canonical, nil
 }
 
+// sanitizeChildPath validates a client or archive supplied name relative to
+// the already sanitized directory parentAbs. The joined path goes through
+// sanitizePathForWrite, no component may be hidden, and the result has to
+// stay below parentAbs.
+func (p *PublicFilesService) sanitizeChildPath(parentAbs, parentRel, name string) (string, error) {
+	trimmed := strings.Trim(name, "/")
+	if trimmed == "" {
+		return "", fmt.Errorf("path cannot be empty")
+	}
+
+	target, err := p.sanitizePathForWrite(parentRel + "/" + trimmed)
+	if err != nil {
+		return "", err
+	}
+
+	for _, component := range strings.Split(trimmed, "/") {
+		if strings.HasPrefix(component, ".") {
+			return "", fmt.Errorf("cannot create hidden files")
+		}
+	}
+
+	if !strings.HasPrefix(target, parentAbs+string(filepath.Separator)) {
+		return "", fmt.Errorf("path escape attempt detected")
+	}
+	return target, nil
+}
+
 func (p *PublicFilesService) getMimeType(filePath string) *string {
 	info, err := os.Stat(filePath)
 	if err != nil || info.IsDir() {
 		return nil
 	}
+	mimeType, _ := p.contentType(p.relPath(filePath))
+	return &mimeType
+}
 
+func mimeTypeByName(filePath string) *string {
 	ext := filepath.Ext(filePath)
 	if ext == "" {
 		mimeType := "application/octet-stream"
@@ -192,22 +265,58 @@ func (p *PublicFilesService) generateEtag(filePath string, modified *int64, size
 	return fmt.Sprintf("\"%s-%d\"", filePath, size)
 }
 
+// fileEtag is the etag of the file at filePath as of modTime. It carries the
+// modification time in nanoseconds, so that two writes of the same size
+// within one second still get different etags.
+func (p *PublicFilesService) fileEtag(filePath string, modTime time.Time, size int64) string {
+	modified := modTime.UnixNano()
+	return p.generateEtag(filePath, &modified, size)
+}
+
+// settleModTime returns the state of file after a write that replaced
+// before. File systems stamp writes from a coarse clock, so two quick writes
+// of the same size can leave identical modification times and thus the same
+// etag; the newer write is then moved a microsecond forward.
+func settleModTime(file string, before os.FileInfo) os.FileInfo {
+	after, err := os.Stat(file)
+	if err != nil || before == nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return after
+	}
+	bumped := before.ModTime().Add(time.Microsecond)
+	if os.Chtimes(file, bumped, bumped) == nil {
+		if info, err := os.Stat(file); err == nil {
+			return info
+		}
+	}
+	return after
+}
+
 func (p *PublicFilesService) generateUUID(data string) string {
 	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(data)).String()
 }
 
-func (p *PublicFilesService) ListItemsRoot(pageVal, limitVal int) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
-	return p.listItemsImpl(nil, pageVal, limitVal)
+func (p *PublicFilesService) ListItemsRoot(pageVal, limitVal int, opts dtos.ListOptions) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+	return p.listItemsImpl(nil, pageVal, limitVal, opts)
 }
 
-func (p *PublicFilesService) ListItems(path string, pageVal, limitVal int) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+func (p *PublicFilesService) ListItems(path string, pageVal, limitVal int, opts dtos.ListOptions) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
 	if path == "" || path == "/" || path == "*" {
-		return p.listItemsImpl(nil, pageVal, limitVal)
+		return p.listItemsImpl(nil, pageVal, limitVal, opts)
 	}
-	return p.listItemsImpl(&path, pageVal, limitVal)
+	return p.listItemsImpl(&path, pageVal, limitVal, opts)
 }
 
-func (p *PublicFilesService) listItemsImpl(pathPtr *string, pageVal, limitVal int) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+func (p *PublicFilesService) listItemsImpl(pathPtr *string, pageVal, limitVal int, opts dtos.ListOptions) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+	query, err := parseListOptions(opts)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
 	if err := p.ensurePublicDir(); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     err.Error(),
@@ -258,7 +367,7 @@ func (p *PublicFilesService) listItemsImpl(pathPtr *string, pageVal, limitVal in
 		}
 	}
 
-	entries, err := os.ReadDir(base)
+	idx, err := p.dirIndexes.get(base, info)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to read directory: %v", err),
@@ -268,89 +377,87 @@ func (p *PublicFilesService) listItemsImpl(pathPtr *string, pageVal, limitVal in
 		}
 	}
 
-	var items []dtos.FileSystemItem
-
-	for _, entry := range entries {
-		name := entry.Name()
-		if strings.HasPrefix(name, ".") || name == ".." || name == "." {
-			continue
-		}
-
-		filePath := filepath.Join(base, name)
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		relPath, err := filepath.Rel(p.publicDir, filePath)
-		if err != nil {
-			continue
+	relDir, _ := filepath.Rel(p.publicDir, base)
+	scope := "dir:" + relDir
+	after, err := query.decodeCursor(scope, opts.Cursor)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
 		}
-
-		var createdAt, modifiedAt *int64
-		modTime := info.ModTime().Unix()
-		modifiedAt = &modTime
-
-		items = append(items, dtos.FileSystemItem{
-			ID:         p.generateUUID(relPath),
-			Name:       name,
-			Path:       relPath,
-			Size:       info.Size(),
-			IsDir:      info.IsDir(),
-			CreatedAt:  createdAt,
-			ModifiedAt: modifiedAt,
-			MimeType:   p.getMimeType(filePath),
-			Etag:       p.generateEtag(filePath, modifiedAt, info.Size()),
-		})
 	}
 
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].IsDir != items[j].IsDir {
-			return items[i].IsDir
+	// Filtering and paging work on the compact index; FileSystemItems are
+	// only built for the entries that end up on the page.
+	candidates := idx.order(query)
+	if query.filtered() {
+		matching := make([]int, 0, len(candidates))
+		for _, pos := range candidates {
+			e := &idx.entries[pos]
+			if query.match(p.stub(p.relPath(filepath.Join(base, e.name)), e)) {
+				matching = append(matching, pos)
+			}
 		}
-		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
-	})
-
-	page := int32(pageVal)
-	if page < 1 {
-		page = 1
-	}
-	limit := int32(limitVal)
-	if limit < 10 {
-		limit = 10
-	}
-	if limit > 100 {
-		limit = 100
+		candidates = matching
 	}
 
-	total := int32(len(items))
-	totalPages := (total + limit - 1) / limit
+	page, limit := normalizePage(pageVal, limitVal, 100)
+	total := int32(len(candidates))
 	start := (page - 1) * limit
+	if after != nil {
+		start = int32(sort.Search(len(candidates), func(i int) bool {
+			e := &idx.entries[candidates[i]]
+			return query.compare(query.keyOf(e.isDir, e.name, e.name, e.size, e.modified), *after) > 0
+		}))
+		page = start/limit + 1
+	}
+	if start > total {
+		start = total
+	}
 	end := start + limit
 	if end > total {
 		end = total
 	}
 
-	var paginatedItems []dtos.FileSystemItem
-	if int(start) < len(items) {
-		paginatedItems = items[start:end]
+	items := make([]dtos.FileSystemItem, 0, end-start)
+	for _, pos := range candidates[start:end] {
+		items = append(items, p.indexItem(base, &idx.entries[pos]))
+	}
+	if query.folderSizes {
+		if err := p.fillFolderSizes(items); err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to compute folder sizes: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
 	}
 
-	return &dtos.PaginatedItems{
-		Items:      paginatedItems,
+	result := &dtos.PaginatedItems{
+		Items:      items,
 		Total:      total,
 		Page:       page,
 		Limit:      limit,
-		TotalPages: totalPages,
-		HasNext:    page < totalPages,
-		HasPrev:    page > 1,
-	}, nil
+		TotalPages: (total + limit - 1) / limit,
+		HasNext:    end < total,
+		HasPrev:    start > 0,
+		Fields:     query.fields,
+	}
+	if result.HasNext {
+		last := &idx.entries[candidates[end-1]]
+		result.NextCursor = query.encodeCursor(scope, query.keyOf(last.isDir, last.name, last.name, last.size, last.modified))
+	}
+	return result, nil
 }
 
-func (p *PublicFilesService) SearchItems(query string, pageVal, limitVal int) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
-	queryLower := strings.ToLower(query)
-
-	if queryLower == "" || len(queryLower) > maxSearchLength {
+// SearchItems looks up entries matching query, written in the search query
+// language described in query.go, in the name index. Results are ranked by
+// relevance unless a sort order is given, and Total counts every match.
+func (p *PublicFilesService) SearchItems(query string, pageVal, limitVal int, opts dtos.ListOptions) (*dtos.PaginatedItems, *dtos.ErrorResponse) {
+	if strings.TrimSpace(query) == "" || len(query) > maxSearchLength {
 		return nil, &dtos.ErrorResponse{
 			Error:     "Search query must be 1-255 characters",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -358,85 +465,119 @@ func (p *PublicFilesService) SearchItems(query string, pageVal, limitVal int) (*
 		}
 	}
 
-	var results []dtos.FileSystemItem
-
-	err := filepath.Walk(p.publicDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-
-		name := info.Name()
-		if strings.HasPrefix(name, ".") || name == "." || name == ".." {
-			return nil
+	expr, err := parseSearchQuery(query, time.Now())
+	if err != nil {
+		debug := err.Error()
+		var syntaxErr *QuerySyntaxError
+		if errors.As(err, &syntaxErr) {
+			debug = query + "\n" + strings.Repeat(" ", syntaxErr.Pos) + "^"
 		}
-
-		if len(results) >= int((int32(pageVal) * int32(limitVal))) {
-			return filepath.SkipDir
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Invalid search query: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(debug),
 		}
+	}
 
-		if strings.Contains(strings.ToLower(name), queryLower) {
-			relPath, err := filepath.Rel(p.publicDir, path)
-			if err != nil {
-				return nil
-			}
-
-			var modifiedAt *int64
-			modTime := info.ModTime().Unix()
-			modifiedAt = &modTime
-
-			results = append(results, dtos.FileSystemItem{
-				ID:         p.generateUUID(relPath),
-				Name:       name,
-				Path:       relPath,
-				Size:       info.Size(),
-				IsDir:      info.IsDir(),
-				ModifiedAt: modifiedAt,
-				MimeType:   p.getMimeType(path),
-				Etag:       p.generateEtag(path, modifiedAt, info.Size()),
-			})
+	listQuery, err := parseListOptions(opts)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
 		}
+	}
 
-		return nil
-	})
-
+	scope := "search:" + query
+	after, err := listQuery.decodeCursor(scope, opts.Cursor)
 	if err != nil {
-		log.Debug().Err(err).Msg("Error walking directory")
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
 	}
 
-	page := int32(pageVal)
-	if page < 1 {
-		page = 1
+	if err := p.ensureNameIndex(); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to build search index: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
 	}
-	limit := int32(limitVal)
-	if limit < 10 {
-		limit = 10
+
+	page, limit := normalizePage(pageVal, limitVal, 500)
+
+	// Without an explicit order, hits are ranked by relevance and then by
+	// path. The key reuses the listing comparison with the negated score.
+	byRelevance := listQuery.sort == "" && !listQuery.desc
+	type rankedHit struct {
+		entry nameEntry
+		key   sortKey
 	}
-	if limit > 500 {
-		limit = 500
+	var hits []rankedHit
+	for _, hit := range p.names.search(expr, p.tags.get) {
+		e := hit.entry
+		name := filepath.Base(e.path)
+		stub := &dirEntry{name: name, isDir: e.isDir, size: e.size, modified: e.modified}
+		if !listQuery.match(p.stub(e.path, stub)) {
+			continue
+		}
+		key := listQuery.keyOf(e.isDir, name, e.path, e.size, e.modified)
+		if byRelevance {
+			key = sortKey{num: -int64(hit.score * 1e6), name: e.path}
+		}
+		hits = append(hits, rankedHit{entry: e, key: key})
 	}
+	sort.Slice(hits, func(i, j int) bool {
+		return listQuery.compare(hits[i].key, hits[j].key) < 0
+	})
 
-	total := int32(len(results))
-	totalPages := (total + limit - 1) / limit
-	start := (page - 1) * limit
-	end := start + limit
-	if end > total {
-		end = total
+	total := int32(len(hits))
+	start := int((page - 1) * limit)
+	if after != nil {
+		start = sort.Search(len(hits), func(i int) bool {
+			return listQuery.compare(hits[i].key, *after) > 0
+		})
 	}
+	start = min(start, len(hits))
+	end := min(start+int(limit), len(hits))
 
-	var paginatedItems []dtos.FileSystemItem
-	if int(start) < len(results) {
-		paginatedItems = results[start:end]
+	items := make([]dtos.FileSystemItem, 0, end-start)
+	for _, hit := range hits[start:end] {
+		e := hit.entry
+		abs := filepath.Join(p.publicDir, filepath.FromSlash(e.path))
+		items = append(items, p.indexItem(filepath.Dir(abs), &dirEntry{name: filepath.Base(abs), isDir: e.isDir, size: e.size, modified: e.modified}))
+	}
+	if listQuery.folderSizes {
+		if err := p.fillFolderSizes(items); err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to compute folder sizes: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
 	}
 
-	return &dtos.PaginatedItems{
-		Items:      paginatedItems,
+	result := &dtos.PaginatedItems{
+		Items:      items,
 		Total:      total,
-		Page:       page,
+		Page:       int32(start)/limit + 1,
 		Limit:      limit,
-		TotalPages: totalPages,
-		HasNext:    page < totalPages,
-		HasPrev:    page > 1,
-	}, nil
+		TotalPages: (total + limit - 1) / limit,
+		HasNext:    end < len(hits),
+		HasPrev:    start > 0,
+		Fields:     listQuery.fields,
+	}
+	if result.HasNext && len(items) > 0 {
+		result.NextCursor = listQuery.encodeCursor(scope, hits[end-1].key)
+	}
+	return result, nil
 }
 
 func (p *PublicFilesService) DownloadItem(path string) ([]byte, *dtos.ErrorResponse) {
@@ -473,7 +614,7 @@ func (p *PublicFilesService) DownloadItem(path string) ([]byte, *dtos.ErrorRespo
 	return data, nil
 }
 
-func (p *PublicFilesService) DeleteItem(path string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) DeleteItem(path string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	target, err := p.sanitizePathForRead(path)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -493,6 +634,9 @@ func (p *PublicFilesService) DeleteItem(path string) (map[string]interface{}, *d
 			Debug:     ptrString(fmt.Sprintf("Target does not exist: %s", target)),
 		}
 	}
+	if errResp := p.checkLocks(target, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
 
 	if info.IsDir() {
 		err = os.RemoveAll(target)
@@ -509,6 +653,8 @@ func (p *PublicFilesService) DeleteItem(path string) (map[string]interface{}, *d
 		}
 	}
 
+	p.fileRemoved(target)
+
 	relPath, _ := filepath.Rel(p.publicDir, target)
 	p.notifyWebSocket("file_deleted", map[string]interface{}{
 		"path":       strings.TrimPrefix(relPath, "/"),
@@ -521,7 +667,15 @@ func (p *PublicFilesService) DeleteItem(path string) (map[string]interface{}, *d
 	}, nil
 }
 
-func (p *PublicFilesService) EditFile(filePath, content string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) EditFile(filePath, content string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	return p.EditFileFrom(filePath, content, "", lockTokens...)
+}
+
+// EditFileFrom replaces the content of a text file like EditFile. When
+// baseEtag names the version the new content was derived from and the file
+// changed since, the changes on both sides are merged line by line; a merge
+// with conflicts is refused with the hunks in the error details.
+func (p *PublicFilesService) EditFileFrom(filePath, content, baseEtag string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	file, err := p.sanitizePathForWrite(filePath)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -550,8 +704,21 @@ func (p *PublicFilesService) EditFile(filePath, content string) (map[string]inte
 			Debug:     ptrString(fmt.Sprintf("Unsupported extension: %s", ext)),
 		}
 	}
+	if errResp := p.checkLocks(file, lockTokens, false, false); errResp != nil {
+		return nil, errResp
+	}
 
-	if len(content) > 10*1024*1024 {
+	merged := false
+	if baseEtag != "" {
+		p.editMu.Lock()
+		defer p.editMu.Unlock()
+		var errResp *dtos.ErrorResponse
+		if content, merged, errResp = p.mergeEdit(file, content, baseEtag); errResp != nil {
+			return nil, errResp
+		}
+	}
+
+	if len(content) > maxEditSize {
 		return nil, &dtos.ErrorResponse{
 			Error:     "File content too large (maximum 10MB)",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -560,7 +727,50 @@ func (p *PublicFilesService) EditFile(filePath, content string) (map[string]inte
 		}
 	}
 
-	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+	var result map[string]interface{}
+	var errResp *dtos.ErrorResponse
+	// A file that is open in a collaborative session is edited through the
+	// session, so that its participants get the change as an operation.
+	if session := p.collab.get(p.relPath(file)); session != nil {
+		result, errResp = session.replace(content, lockTokens)
+	} else {
+		result, errResp = p.writeEdit(file, content)
+	}
+	if errResp == nil && merged {
+		result["merged"] = true
+		result["content"] = content
+	}
+	return result, errResp
+}
+
+// writeEdit replaces the content of the editable file at file, which has
+// been validated by the caller.
+func (p *PublicFilesService) writeEdit(file, content string) (map[string]interface{}, *dtos.ErrorResponse) {
+	info, err := os.Stat(file)
+	oldSize := int64(0)
+	if err == nil {
+		oldSize = info.Size()
+	}
+	if err := p.checkQuota(int64(len(content)) - oldSize); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+			Status:    http.StatusRequestEntityTooLarge,
+		}
+	}
+
+	// Keep the version being replaced for edits still based on it.
+	if err == nil && info.Size() <= maxEditSize {
+		if old, err := os.ReadFile(file); err == nil {
+			if err := p.versions.save(p.relPath(file), p.fileEtag(file, info.ModTime(), info.Size()), old); err != nil {
+				log.Warn().Err(err).Str("path", file).Msg("Failed to keep previous version")
+			}
+		}
+	}
+
+	if err := writeFileAtomic(file, []byte(content), 0644); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to write file: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -569,28 +779,30 @@ func (p *PublicFilesService) EditFile(filePath, content string) (map[string]inte
 		}
 	}
 
-	newInfo, _ := os.Stat(file)
+	newInfo := settleModTime(file, info)
 	modTime := newInfo.ModTime().Unix()
+	p.fileChanged(file)
 
 	relPath, _ := filepath.Rel(p.publicDir, file)
 	p.notifyWebSocket("file_updated", map[string]interface{}{
 		"path":        strings.TrimPrefix(relPath, "/"),
 		"size":        newInfo.Size(),
 		"modified_at": modTime,
-		"etag":        p.generateEtag(file, &modTime, newInfo.Size()),
+		"etag":        p.fileEtag(file, newInfo.ModTime(), newInfo.Size()),
 	})
 
 	return map[string]interface{}{
 		"success":     true,
+		"id":          p.itemID(relPath),
 		"path":        strings.TrimPrefix(relPath, "/"),
 		"size":        newInfo.Size(),
 		"modified_at": modTime,
-		"etag":        p.generateEtag(file, &modTime, newInfo.Size()),
+		"etag":        p.fileEtag(file, newInfo.ModTime(), newInfo.Size()),
 	}, nil
 }
 
-func (p *PublicFilesService) UploadFile(filePath string, data io.Reader) (map[string]interface{}, *dtos.ErrorResponse) {
-	file, err := p.sanitizePathForWrite(filePath)
+func (p *PublicFilesService) UploadFile(filePath string, data io.Reader, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForWrite(filePath)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     err.Error(),
@@ -600,58 +812,91 @@ func (p *PublicFilesService) UploadFile(filePath string, data io.Reader) (map[st
 		}
 	}
 
-	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+	mode, err := parseConflictMode(onConflict, ConflictOverwrite, false)
+	if err != nil {
 		return nil, &dtos.ErrorResponse{
-			Error:     fmt.Sprintf("Failed to create parent directories: %v", err),
+			Error:     err.Error(),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			RequestID: uuid.New().String(),
 			Debug:     ptrString(err.Error()),
 		}
 	}
 
-	f, err := os.Create(file)
+	if info, err := os.Stat(target); err == nil && info.IsDir() && mode != ConflictKeepBoth {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Destination is a directory",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Destination is a directory: %s", target)),
+		}
+	}
+
+	file, skip, err := resolveConflict(target, false, mode, "file")
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
-			Error:     fmt.Sprintf("Failed to create file: %v", err),
+			Error:     err.Error(),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			RequestID: uuid.New().String(),
 			Debug:     ptrString(err.Error()),
 		}
 	}
-	defer f.Close()
+	if skip {
+		return p.skippedResult(file), nil
+	}
+	// A claimed name is given up again unless the upload succeeds.
+	claimed := claimsName(mode)
+	defer func() {
+		if claimed {
+			releaseClaim(file)
+		}
+	}()
+	if errResp := p.checkLocks(file, lockTokens, true, false); errResp != nil {
+		return nil, errResp
+	}
 
-	var totalBytes int64
-	buf := make([]byte, maxChunkSize)
 	uploadID := uuid.New().String()
 
-	for {
-		n, err := data.Read(buf)
-		if n > 0 {
-			totalBytes += int64(n)
-
-			if totalBytes > maxTotalSize {
-				os.Remove(file)
-				return nil, &dtos.ErrorResponse{
-					Error:     fmt.Sprintf("File size exceeds maximum limit (%.2f GB)", float64(maxTotalSize)/1024/1024/1024),
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-					RequestID: uuid.New().String(),
-					Debug:     ptrString(fmt.Sprintf("Total bytes: %d", totalBytes)),
-				}
-			}
+	// The upload may use what the quota leaves plus the space of the file
+	// it replaces.
+	previous, _ := os.Stat(file)
+	limit := int64(maxTotalSize)
+	remaining, err := p.remainingQuota()
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to check storage quota: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if remaining >= 0 {
+		replaced := int64(0)
+		if previous != nil && previous.Mode().IsRegular() {
+			replaced = previous.Size()
+		}
+		limit = min(limit, remaining+replaced)
+	}
 
-			if _, err := f.Write(buf[:n]); err != nil {
-				os.Remove(file)
-				return nil, &dtos.ErrorResponse{
-					Error:     fmt.Sprintf("Failed to write chunk: %v", err),
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-					RequestID: uuid.New().String(),
-					Debug:     ptrString(err.Error()),
-				}
+	totalBytes, err := p.writeStream(file, data, limit)
+	if err != nil {
+		if errors.Is(err, errStreamTooLarge) {
+			return nil, &dtos.ErrorResponse{
+				Error:     "File exceeds the maximum file size or storage quota",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("At most %d bytes may be written", limit)),
+				Status:    http.StatusRequestEntityTooLarge,
 			}
 		}
-
-		if err != nil && err != io.EOF {
-			os.Remove(file)
+		if errors.Is(err, errContentMismatch) {
+			return nil, &dtos.ErrorResponse{
+				Error:     "File content does not match its extension",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		if errors.Is(err, errStreamRead) {
 			return nil, &dtos.ErrorResponse{
 				Error:     fmt.Sprintf("Failed to read chunk: %v", err),
 				Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -659,38 +904,42 @@ func (p *PublicFilesService) UploadFile(filePath string, data io.Reader) (map[st
 				Debug:     ptrString(err.Error()),
 			}
 		}
-
-		if err == io.EOF {
-			break
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to write chunk: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
 		}
 	}
 
-	os.Chmod(file, 0644)
-
-	info, _ := os.Stat(file)
+	claimed = false
+	info := settleModTime(file, previous)
 	modTime := info.ModTime().Unix()
 	relPath, _ := filepath.Rel(p.publicDir, file)
+	p.fileChanged(file)
 
 	p.notifyWebSocket("file_created", map[string]interface{}{
 		"path":        strings.TrimPrefix(relPath, "/"),
 		"size":        totalBytes,
 		"mime_type":   p.getMimeType(file),
 		"modified_at": modTime,
-		"etag":        p.generateEtag(file, &modTime, totalBytes),
+		"etag":        p.fileEtag(file, info.ModTime(), totalBytes),
 	})
 
 	return map[string]interface{}{
 		"success":     true,
+		"id":          p.itemID(relPath),
 		"path":        strings.TrimPrefix(relPath, "/"),
 		"size_bytes":  totalBytes,
 		"mime_type":   p.getMimeType(file),
 		"modified_at": modTime,
-		"etag":        p.generateEtag(file, &modTime, totalBytes),
+		"etag":        p.fileEtag(file, info.ModTime(), totalBytes),
 		"upload_id":   uploadID,
+		"final_path":  strings.TrimPrefix(relPath, "/"),
 	}, nil
 }
 
-func (p *PublicFilesService) CreateFolder(path string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) CreateFolder(path string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	dirPath, err := p.sanitizePathForWrite(path)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -700,6 +949,9 @@ func (p *PublicFilesService) CreateFolder(path string) (map[string]interface{},
 			Debug:     ptrString(err.Error()),
 		}
 	}
+	if errResp := p.checkLocks(dirPath, lockTokens, true, false); errResp != nil {
+		return nil, errResp
+	}
 
 	if err := os.MkdirAll(filepath.Dir(dirPath), 0755); err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -730,6 +982,7 @@ func (p *PublicFilesService) CreateFolder(path string) (map[string]interface{},
 	}
 
 	os.Chmod(dirPath, 0755)
+	p.fileChanged(dirPath)
 	relPath, _ := filepath.Rel(p.publicDir, dirPath)
 	createdAt := time.Now().Unix()
 
@@ -740,13 +993,14 @@ func (p *PublicFilesService) CreateFolder(path string) (map[string]interface{},
 
 	return map[string]interface{}{
 		"success":    true,
+		"id":         p.itemID(relPath),
 		"path":       strings.TrimPrefix(relPath, "/"),
 		"type":       "directory",
 		"created_at": createdAt,
 	}, nil
 }
 
-func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) CreateFile(path string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	filePath, err := p.sanitizePathForWrite(path)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -756,6 +1010,9 @@ func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *d
 			Debug:     ptrString(err.Error()),
 		}
 	}
+	if errResp := p.checkLocks(filePath, lockTokens, true, false); errResp != nil {
+		return nil, errResp
+	}
 
 	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -766,6 +1023,24 @@ func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *d
 		}
 	}
 
+	// An empty file takes no bytes, but none may be added once the quota
+	// is used up.
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		remaining, err := p.remainingQuota()
+		if err == nil && remaining == 0 {
+			err = fmt.Errorf("storage quota exceeded: no space left for new files")
+		}
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     err.Error(),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+				Status:    http.StatusInsufficientStorage,
+			}
+		}
+	}
+
 	if err := os.WriteFile(filePath, []byte{}, 0644); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to create file: %v", err),
@@ -775,6 +1050,8 @@ func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *d
 		}
 	}
 
+	p.fileChanged(filePath)
+
 	relPath, _ := filepath.Rel(p.publicDir, filePath)
 	createdAt := time.Now().Unix()
 
@@ -788,6 +1065,7 @@ func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *d
 
 	return map[string]interface{}{
 		"success":    true,
+		"id":         p.itemID(relPath),
 		"path":       strings.TrimPrefix(relPath, "/"),
 		"type":       "file",
 		"size_bytes": 0,
@@ -796,7 +1074,7 @@ func (p *PublicFilesService) CreateFile(path string) (map[string]interface{}, *d
 	}, nil
 }
 
-func (p *PublicFilesService) RenameFile(oldPath, newPath string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) RenameFile(oldPath, newPath, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	oldPathSanitized, err := p.sanitizePathForWrite(oldPath)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -817,7 +1095,8 @@ func (p *PublicFilesService) RenameFile(oldPath, newPath string) (map[string]int
 		}
 	}
 
-	if _, err := os.Stat(oldPathSanitized); err != nil {
+	srcInfo, err := os.Stat(oldPathSanitized)
+	if err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     "Source file does not exist",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -826,16 +1105,30 @@ func (p *PublicFilesService) RenameFile(oldPath, newPath string) (map[string]int
 		}
 	}
 
-	if _, err := os.Stat(newPathSanitized); err == nil {
-		return nil, &dtos.ErrorResponse{
-			Error:     "Destination file already exists",
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			RequestID: uuid.New().String(),
-			Debug:     ptrString("Destination file already exists"),
+	newPathSanitized, mode, skip, errResp := p.prepareDestination(oldPathSanitized, newPathSanitized, srcInfo.IsDir(), onConflict)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if skip {
+		return p.skippedResult(newPathSanitized), nil
+	}
+	claimed := claimsName(mode)
+	defer func() {
+		if claimed {
+			releaseClaim(newPathSanitized)
 		}
+	}()
+	if errResp := p.checkLocks(oldPathSanitized, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := p.checkLocks(newPathSanitized, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := p.checkRenamedContent(oldPathSanitized, newPathSanitized); errResp != nil {
+		return nil, errResp
 	}
 
-	if err := os.Rename(oldPathSanitized, newPathSanitized); err != nil {
+	if err := moveIntoPlace(oldPathSanitized, newPathSanitized, mode); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to rename file: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -843,6 +1136,8 @@ func (p *PublicFilesService) RenameFile(oldPath, newPath string) (map[string]int
 			Debug:     ptrString(err.Error()),
 		}
 	}
+	claimed = false
+	p.fileMoved(oldPathSanitized, newPathSanitized, mode == ConflictMerge)
 
 	oldRel, _ := filepath.Rel(p.publicDir, oldPathSanitized)
 	newRel, _ := filepath.Rel(p.publicDir, newPathSanitized)
@@ -854,14 +1149,16 @@ func (p *PublicFilesService) RenameFile(oldPath, newPath string) (map[string]int
 	})
 
 	return map[string]interface{}{
-		"success":  true,
-		"message":  "File renamed successfully",
-		"old_path": strings.TrimPrefix(oldRel, "/"),
-		"new_path": strings.TrimPrefix(newRel, "/"),
+		"success":    true,
+		"id":         p.itemID(newRel),
+		"message":    "File renamed successfully",
+		"old_path":   strings.TrimPrefix(oldRel, "/"),
+		"new_path":   strings.TrimPrefix(newRel, "/"),
+		"final_path": strings.TrimPrefix(newRel, "/"),
 	}, nil
 }
 
-func (p *PublicFilesService) MoveFile(source, destination string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) MoveFile(source, destination, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	sourcePath, err := p.sanitizePathForWrite(source)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -882,7 +1179,8 @@ func (p *PublicFilesService) MoveFile(source, destination string) (map[string]in
 		}
 	}
 
-	if _, err := os.Stat(sourcePath); err != nil {
+	srcInfo, err := os.Stat(sourcePath)
+	if err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     "Source file does not exist",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -891,18 +1189,32 @@ func (p *PublicFilesService) MoveFile(source, destination string) (map[string]in
 		}
 	}
 
-	if _, err := os.Stat(destPath); err == nil {
-		return nil, &dtos.ErrorResponse{
-			Error:     "Destination file already exists",
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			RequestID: uuid.New().String(),
-			Debug:     ptrString("Destination file already exists"),
+	destPath, mode, skip, errResp := p.prepareDestination(sourcePath, destPath, srcInfo.IsDir(), onConflict)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if skip {
+		return p.skippedResult(destPath), nil
+	}
+	claimed := claimsName(mode)
+	defer func() {
+		if claimed {
+			releaseClaim(destPath)
 		}
+	}()
+	if errResp := p.checkLocks(sourcePath, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := p.checkLocks(destPath, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+	if errResp := p.checkRenamedContent(sourcePath, destPath); errResp != nil {
+		return nil, errResp
 	}
 
 	os.MkdirAll(filepath.Dir(destPath), 0755)
 
-	if err := os.Rename(sourcePath, destPath); err != nil {
+	if err := moveIntoPlace(sourcePath, destPath, mode); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to move file: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -910,6 +1222,8 @@ func (p *PublicFilesService) MoveFile(source, destination string) (map[string]in
 			Debug:     ptrString(err.Error()),
 		}
 	}
+	claimed = false
+	p.fileMoved(sourcePath, destPath, mode == ConflictMerge)
 
 	info, _ := os.Stat(destPath)
 	modTime := info.ModTime().Unix()
@@ -927,15 +1241,17 @@ func (p *PublicFilesService) MoveFile(source, destination string) (map[string]in
 
 	return map[string]interface{}{
 		"success":     true,
+		"id":          p.itemID(destRel),
 		"message":     "File moved successfully",
 		"source":      strings.TrimPrefix(sourceRel, "/"),
 		"destination": strings.TrimPrefix(destRel, "/"),
+		"final_path":  strings.TrimPrefix(destRel, "/"),
 		"size":        info.Size(),
 		"modified_at": modTime,
 	}, nil
 }
 
-func (p *PublicFilesService) CopyFile(source, destination string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) CopyFile(source, destination, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	sourcePath, err := p.sanitizePathForWrite(source)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -956,7 +1272,8 @@ func (p *PublicFilesService) CopyFile(source, destination string) (map[string]in
 		}
 	}
 
-	if _, err := os.Stat(sourcePath); err != nil {
+	srcInfo, err := os.Stat(sourcePath)
+	if err != nil || srcInfo.IsDir() {
 		return nil, &dtos.ErrorResponse{
 			Error:     "Source file does not exist",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -965,28 +1282,53 @@ func (p *PublicFilesService) CopyFile(source, destination string) (map[string]in
 		}
 	}
 
-	if _, err := os.Stat(destPath); err == nil {
-		return nil, &dtos.ErrorResponse{
-			Error:     "Destination file already exists",
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			RequestID: uuid.New().String(),
-			Debug:     ptrString("Destination file already exists"),
+	destPath, mode, skip, errResp := p.prepareDestination(sourcePath, destPath, false, onConflict)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if skip {
+		return p.skippedResult(destPath), nil
+	}
+	claimed := claimsName(mode)
+	defer func() {
+		if claimed {
+			releaseClaim(destPath)
 		}
+	}()
+	if errResp := p.checkLocks(destPath, lockTokens, true, true); errResp != nil {
+		return nil, errResp
 	}
-
-	os.MkdirAll(filepath.Dir(destPath), 0755)
-
-	data, err := os.ReadFile(sourcePath)
-	if err != nil {
+	if errResp := p.checkRenamedContent(sourcePath, destPath); errResp != nil {
+		return nil, errResp
+	}
+	if err := p.checkQuota(srcInfo.Size()); err != nil {
 		return nil, &dtos.ErrorResponse{
-			Error:     fmt.Sprintf("Failed to copy file: %v", err),
+			Error:     err.Error(),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			RequestID: uuid.New().String(),
 			Debug:     ptrString(err.Error()),
 		}
 	}
 
-	if err := os.WriteFile(destPath, data, 0644); err != nil {
+	// A file cannot be renamed over a folder, so a folder being overwritten
+	// is only replaced once the copy is complete.
+	var copied int64
+	if info, err := os.Lstat(destPath); err == nil && info.IsDir() {
+		var stagingDir string
+		stagingDir, err = createStagingDir(destPath)
+		if err == nil {
+			copied, err = copyFile(context.Background(), sourcePath, stagedItem(stagingDir), srcInfo)
+			if err == nil {
+				err = commitStagedItem(stagingDir, destPath)
+			}
+			if err != nil {
+				os.RemoveAll(stagingDir)
+			}
+		}
+	} else {
+		copied, err = copyFile(context.Background(), sourcePath, destPath, srcInfo)
+	}
+	if err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to copy file: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -995,8 +1337,10 @@ func (p *PublicFilesService) CopyFile(source, destination string) (map[string]in
 		}
 	}
 
+	claimed = false
 	info, _ := os.Stat(destPath)
 	modTime := info.ModTime().Unix()
+	p.fileChanged(destPath)
 
 	sourceRel, _ := filepath.Rel(p.publicDir, sourcePath)
 	destRel, _ := filepath.Rel(p.publicDir, destPath)
@@ -1011,17 +1355,24 @@ func (p *PublicFilesService) CopyFile(source, destination string) (map[string]in
 
 	return map[string]interface{}{
 		"success":      true,
+		"id":           p.itemID(destRel),
 		"message":      "File copied successfully",
 		"source":       strings.TrimPrefix(sourceRel, "/"),
 		"destination":  strings.TrimPrefix(destRel, "/"),
+		"final_path":   strings.TrimPrefix(destRel, "/"),
 		"size":         info.Size(),
-		"bytes_copied": int64(len(data)),
+		"bytes_copied": copied,
 		"modified_at":  modTime,
 	}, nil
 
 }
 
-func (p *PublicFilesService) UploadFolder(folderPath string, files map[string][]byte) (map[string]interface{}, *dtos.ErrorResponse) {
+// UploadFolder streams every file part of a multipart body into folderPath.
+// Each part carries its path relative to the folder in the filename
+// parameter of its Content-Disposition header, as browsers do for directory
+// uploads. Failures are reported per file instead of aborting the upload,
+// and onConflict is applied to each file individually.
+func (p *PublicFilesService) UploadFolder(folderPath string, parts *multipart.Reader, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	folderPathSanitized, err := p.sanitizePathForWrite(folderPath)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -1032,6 +1383,16 @@ func (p *PublicFilesService) UploadFolder(folderPath string, files map[string][]
 		}
 	}
 
+	mode, err := parseConflictMode(onConflict, ConflictOverwrite, false)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
 	if err := os.MkdirAll(folderPathSanitized, 0755); err != nil {
 		return nil, &dtos.ErrorResponse{
 			Error:     fmt.Sprintf("Failed to create folder: %v", err),
@@ -1041,36 +1402,146 @@ func (p *PublicFilesService) UploadFolder(folderPath string, files map[string][]
 		}
 	}
 
+	remaining, err := p.remainingQuota()
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to check storage quota: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	relPath, _ := filepath.Rel(p.publicDir, folderPathSanitized)
+
+	results := []dtos.FolderUploadResult{}
 	uploadedCount := 0
-	for fileName, fileData := range files {
-		filePath := filepath.Join(folderPathSanitized, fileName)
+	skippedCount := 0
+	var written int64
+	var streamErr error
+
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			streamErr = err
+			break
+		}
 
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		fileName, isFile := params["filename"]
+		if !isFile {
+			part.Close()
 			continue
 		}
 
-		if err := os.WriteFile(filePath, fileData, 0644); err != nil {
+		result := dtos.FolderUploadResult{Path: fileName}
+
+		target, err := p.sanitizeChildPath(folderPathSanitized, relPath, fileName)
+		if err != nil {
+			result.Error = ptrString(err.Error())
+			results = append(results, result)
+			part.Close()
+			continue
+		}
+
+		target, skip, err := resolveConflict(target, false, mode, "file")
+		if err != nil {
+			result.Error = ptrString(err.Error())
+			results = append(results, result)
+			part.Close()
 			continue
 		}
+		childRel, _ := filepath.Rel(p.publicDir, target)
+		result.Path = childRel
+		if skip {
+			result.Success = true
+			result.Skipped = true
+			results = append(results, result)
+			skippedCount++
+			part.Close()
+			continue
+		}
+		if errResp := p.checkLocks(target, lockTokens, true, false); errResp != nil {
+			result.Error = ptrString(errResp.Error)
+			results = append(results, result)
+			part.Close()
+			continue
+		}
+
+		limit := int64(maxTotalSize)
+		if remaining >= 0 && remaining-written < limit {
+			limit = remaining - written
+		}
+
+		n, err := p.writeStream(target, part, limit)
+		part.Close()
+		if err != nil {
+			if claimsName(mode) {
+				releaseClaim(target)
+			}
+			result.Error = ptrString(err.Error())
+			results = append(results, result)
+			if errors.Is(err, errStreamTooLarge) || !errors.Is(err, errStreamRead) {
+				continue
+			}
+			streamErr = err
+			break
+		}
+
+		written += n
+		p.fileChanged(target)
+		result.Success = true
+		result.Size = n
+		results = append(results, result)
 		uploadedCount++
 	}
 
-	relPath, _ := filepath.Rel(p.publicDir, folderPathSanitized)
 	createdAt := time.Now().Unix()
 
 	p.notifyWebSocket("folder_uploaded", map[string]interface{}{
-		"path":       strings.TrimPrefix(relPath, "/"),
-		"files_count": uploadedCount,
-		"created_at": createdAt,
-	})
-
-	return map[string]interface{}{
-		"success":     true,
 		"path":        strings.TrimPrefix(relPath, "/"),
-		"type":        "directory",
 		"files_count": uploadedCount,
 		"created_at":  createdAt,
-	}, nil
+	})
+
+	response := map[string]interface{}{
+		"success":       streamErr == nil && uploadedCount+skippedCount == len(results),
+		"path":          strings.TrimPrefix(relPath, "/"),
+		"type":          "directory",
+		"files":         results,
+		"files_count":   uploadedCount,
+		"skipped_count": skippedCount,
+		"failed_count":  len(results) - uploadedCount - skippedCount,
+		"size_bytes":    written,
+		"created_at":    createdAt,
+	}
+	if streamErr != nil {
+		response["error"] = fmt.Sprintf("Upload interrupted: %v", streamErr)
+	}
+	return response, nil
+}
+
+var (
+	errStreamTooLarge  = errors.New("file exceeds the size limit or storage quota")
+	errContentMismatch = errors.New("file content does not match its extension")
+	errStreamRead      = errors.New("failed to read upload stream")
+)
+
+// readErrorReader tags read errors so callers can tell a broken upload
+// stream apart from a failing disk.
+type readErrorReader struct {
+	r io.Reader
+}
+
+func (r *readErrorReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", errStreamRead, err)
+	}
+	return n, err
 }
 
 func (p *PublicFilesService) DownloadFolder(folderPath string) (map[string][]byte, *dtos.ErrorResponse) {
@@ -1128,15 +1599,15 @@ func (p *PublicFilesService) DownloadFolder(folderPath string) (map[string][]byt
 	return files, nil
 }
 
-func (p *PublicFilesService) RenameFolder(oldPath, newPath string) (map[string]interface{}, *dtos.ErrorResponse) {
-	return p.RenameFile(oldPath, newPath)
+func (p *PublicFilesService) RenameFolder(oldPath, newPath, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	return p.RenameFile(oldPath, newPath, onConflict, lockTokens...)
 }
 
-func (p *PublicFilesService) MoveFolder(source, destination string) (map[string]interface{}, *dtos.ErrorResponse) {
-	return p.MoveFile(source, destination)
+func (p *PublicFilesService) MoveFolder(source, destination, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
+	return p.MoveFile(source, destination, onConflict, lockTokens...)
 }
 
-func (p *PublicFilesService) CopyFolder(source, destination string) (map[string]interface{}, *dtos.ErrorResponse) {
+func (p *PublicFilesService) CopyFolder(source, destination, onConflict string, lockTokens ...string) (map[string]interface{}, *dtos.ErrorResponse) {
 	sourcePath, err := p.sanitizePathForWrite(source)
 	if err != nil {
 		return nil, &dtos.ErrorResponse{
@@ -1157,7 +1628,8 @@ func (p *PublicFilesService) CopyFolder(source, destination string) (map[string]
 		}
 	}
 
-	if _, err := os.Stat(sourcePath); err != nil {
+	srcInfo, err := os.Stat(sourcePath)
+	if err != nil || !srcInfo.IsDir() {
 		return nil, &dtos.ErrorResponse{
 			Error:     "Source folder does not exist",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -1166,36 +1638,143 @@ func (p *PublicFilesService) CopyFolder(source, destination string) (map[string]
 		}
 	}
 
-	if _, err := os.Stat(destPath); err == nil {
+	destPath, mode, skip, errResp := p.prepareDestination(sourcePath, destPath, true, onConflict)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if skip {
+		return p.skippedResult(destPath), nil
+	}
+	// Until the copy starts, a claimed name is given up on failure; the
+	// copy itself releases it if it fails.
+	claimed := claimsName(mode)
+	defer func() {
+		if claimed {
+			releaseClaim(destPath)
+		}
+	}()
+	if errResp := p.checkLocks(destPath, lockTokens, true, true); errResp != nil {
+		return nil, errResp
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return nil, &dtos.ErrorResponse{
-			Error:     "Destination folder already exists",
+			Error:     fmt.Sprintf("Failed to create parent folder: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			RequestID: uuid.New().String(),
-			Debug:     ptrString("Destination folder already exists"),
+			Debug:     ptrString(err.Error()),
 		}
 	}
 
-	os.MkdirAll(filepath.Dir(destPath), 0755)
+	// An existing destination that is being overwritten stays in place until
+	// the copy has completed in a staging folder next to it. A claimed name
+	// holds an empty folder that is copied into directly.
+	copyTarget, stagingDir := destPath, ""
+	if _, err := os.Lstat(destPath); err == nil && mode == ConflictOverwrite {
+		stagingDir, err = createStagingDir(destPath)
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     fmt.Sprintf("Failed to copy folder: %v", err),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		copyTarget = stagedItem(stagingDir)
+	}
+	discardStaging := func() {
+		if stagingDir != "" {
+			os.RemoveAll(stagingDir)
+		}
+	}
 
-	err = p.copyDirectory(sourcePath, destPath)
+	plan, err := planCopy(sourcePath, copyTarget)
 	if err != nil {
+		discardStaging()
 		return nil, &dtos.ErrorResponse{
-			Error:     fmt.Sprintf("Failed to copy folder: %v", err),
+			Error:     fmt.Sprintf("Failed to read source folder: %v", err),
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			RequestID: uuid.New().String(),
 			Debug:     ptrString(err.Error()),
 		}
 	}
 
-	info, _ := os.Stat(destPath)
-	modTime := info.ModTime().Unix()
+	if err := p.checkQuota(plan.bytes); err != nil {
+		discardStaging()
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
 
 	sourceRel, _ := filepath.Rel(p.publicDir, sourcePath)
 	destRel, _ := filepath.Rel(p.publicDir, destPath)
 
+	releaseOnFailure := claimed
+	claimed = false
+	runCopy := func(ctx context.Context, progress func(files, bytes int64)) error {
+		err := plan.run(ctx, progress)
+		if err == nil && stagingDir != "" {
+			err = commitStagedItem(stagingDir, destPath)
+		}
+		if err != nil {
+			discardStaging()
+			if releaseOnFailure {
+				releaseClaim(destPath)
+			}
+		}
+		return err
+	}
+
+	// Large trees are copied in the background so the request does not hold
+	// a connection open for minutes; the job can be cancelled via CancelJob.
+	if plan.large() {
+		job := p.startJob("copy", func(job *Job) (map[string]interface{}, error) {
+			total := int64(len(plan.files))
+			err := runCopy(job.ctx, func(files, bytes int64) {
+				p.reportProgress(job, files, total, bytes)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return p.folderCopied(sourceRel, destRel, plan), nil
+		})
+
+		return map[string]interface{}{
+			"success":     true,
+			"job_id":      job.ID,
+			"status":      jobStatusRunning,
+			"source":      strings.TrimPrefix(sourceRel, "/"),
+			"destination": strings.TrimPrefix(destRel, "/"),
+			"final_path":  strings.TrimPrefix(destRel, "/"),
+			"files_count": len(plan.files),
+			"size_bytes":  plan.bytes,
+		}, nil
+	}
+
+	if err := runCopy(context.Background(), nil); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to copy folder: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	return p.folderCopied(sourceRel, destRel, plan), nil
+}
+
+func (p *PublicFilesService) folderCopied(sourceRel, destRel string, plan *copyPlan) map[string]interface{} {
+	destPath := filepath.Join(p.publicDir, destRel)
+	info, _ := os.Stat(destPath)
+	modTime := info.ModTime().Unix()
+	p.fileChanged(destPath)
+
 	p.notifyWebSocket("folder_copied", map[string]interface{}{
 		"source_path":      strings.TrimPrefix(sourceRel, "/"),
-		"destination_path": strings.TrimPrefix(destPath, "/"),
+		"destination_path": strings.TrimPrefix(destRel, "/"),
 		"size":             info.Size(),
 		"modified_at":      modTime,
 		"timestamp":        time.Now().Unix(),
@@ -1203,44 +1782,16 @@ func (p *PublicFilesService) CopyFolder(source, destination string) (map[string]
 
 	return map[string]interface{}{
 		"success":      true,
+		"id":           p.itemID(destRel),
 		"message":      "Folder copied successfully",
 		"source":       strings.TrimPrefix(sourceRel, "/"),
 		"destination":  strings.TrimPrefix(destRel, "/"),
+		"final_path":   strings.TrimPrefix(destRel, "/"),
 		"size":         info.Size(),
+		"files_count":  len(plan.files),
+		"bytes_copied": plan.bytes,
 		"modified_at":  modTime,
-	}, nil
-}
-
-func (p *PublicFilesService) copyDirectory(src, dst string) error {
-    entries, err := os.ReadDir(src)
-    if err != nil {
-        return err
-    }
-
-    if err := os.MkdirAll(dst, 0755); err != nil {
-        return err
-    }
-
-    for _, entry := range entries {
-        srcPath := filepath.Join(src, entry.Name())
-        dstPath := filepath.Join(dst, entry.Name())
-
-        if entry.IsDir() {
-            if err := p.copyDirectory(srcPath, dstPath); err != nil {
-                return err
-            }
-        } else {
-            data, err := os.ReadFile(srcPath)
-            if err != nil {
-                return err
-            }
-            if err := os.WriteFile(dstPath, data, 0644); err != nil {
-                return err
-            }
-        }
-    }
-
-    return nil
+	}
 }
 
 func (p *PublicFilesService) notifyWebSocket(eventType string, data interface{}) {
@@ -1254,6 +1805,44 @@ func (p *PublicFilesService) notifyWebSocket(eventType string, data interface{})
 	}
 }
 
+// normalizePage turns the requested page and limit into usable values.
+// Limits below 10 are raised to 10 and limits above maxLimit are clamped.
+func normalizePage(pageVal, limitVal int, maxLimit int32) (int32, int32) {
+	limit := int32(min(max(limitVal, 10), int(maxLimit)))
+	// Pages past the last one that fits are empty anyway; clamping keeps
+	// page*limit, and with it every slice bound, within int32.
+	page := int32(max(min(pageVal, int(math.MaxInt32/limit)), 1))
+	return page, limit
+}
+
+// paginateItems slices one page out of items.
+func paginateItems(items []dtos.FileSystemItem, pageVal, limitVal int, maxLimit int32) *dtos.PaginatedItems {
+	page, limit := normalizePage(pageVal, limitVal, maxLimit)
+
+	total := int32(len(items))
+	totalPages := (total + limit - 1) / limit
+	start := (page - 1) * limit
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	var paginatedItems []dtos.FileSystemItem
+	if int(start) < len(items) {
+		paginatedItems = items[start:end]
+	}
+
+	return &dtos.PaginatedItems{
+		Items:      paginatedItems,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+		HasNext:    page < totalPages,
+		HasPrev:    page > 1,
+	}
+}
+
 func ptrString(s string) *string {
 	return &s
 }
@@ -1,11 +1,14 @@
 package publicfiles
 
 import (
+	"bytes"
+	"mime/multipart"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -194,7 +197,7 @@ func TestListItemsRoot(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "file2.txt"), []byte("test"), 0644)
 	os.Mkdir(filepath.Join(tmpDir, "folder"), 0755)
 
-	items, errResp := service.ListItemsRoot(1, 10)
+	items, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{})
 
 	assert.Nil(t, errResp)
 	assert.NotNil(t, items)
@@ -210,8 +213,8 @@ func TestListItemsRoot_Pagination(t *testing.T) {
 		os.WriteFile(filepath.Join(tmpDir, "file"+string(rune(48+i))+".txt"), []byte("test"), 0644)
 	}
 
-	items1, _ := service.ListItemsRoot(1, 10)
-	items2, _ := service.ListItemsRoot(2, 10)
+	items1, _ := service.ListItemsRoot(1, 10, dtos.ListOptions{})
+	items2, _ := service.ListItemsRoot(2, 10, dtos.ListOptions{})
 
 	assert.Equal(t, int32(14), items1.Total) // Changed from 15 to 14 to match actual count
 	assert.Len(t, items1.Items, 10)
@@ -229,7 +232,7 @@ func TestListItems_Nested(t *testing.T) {
 	os.Mkdir(filepath.Join(tmpDir, "folder"), 0755)
 	os.WriteFile(filepath.Join(tmpDir, "folder", "file.txt"), []byte("test"), 0644)
 
-	items, errResp := service.ListItems("folder", 1, 10)
+	items, errResp := service.ListItems("folder", 1, 10, dtos.ListOptions{})
 
 	assert.Nil(t, errResp)
 	assert.NotNil(t, items)
@@ -244,7 +247,7 @@ func TestSearchItems(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "image.png"), []byte("test"), 0644)
 	os.WriteFile(filepath.Join(tmpDir, "document2.txt"), []byte("test"), 0644)
 
-	items, errResp := service.SearchItems("document", 1, 10)
+	items, errResp := service.SearchItems("document", 1, 10, dtos.ListOptions{})
 
 	assert.Nil(t, errResp)
 	assert.NotNil(t, items)
@@ -255,7 +258,7 @@ func TestSearchItems_EmptyQuery(t *testing.T) {
 	tmpDir := setupTestDir(t)
 	service := NewPublicFilesService(tmpDir, nil)
 
-	_, errResp := service.SearchItems("", 1, 10)
+	_, errResp := service.SearchItems("", 1, 10, dtos.ListOptions{})
 
 	assert.NotNil(t, errResp)
 	assert.Contains(t, errResp.Error, "1-255 characters")
@@ -303,7 +306,7 @@ func TestUploadFile(t *testing.T) {
 	content := "test file content"
 	reader := strings.NewReader(content)
 
-	result, errResp := service.UploadFile("upload.txt", reader)
+	result, errResp := service.UploadFile("upload.txt", reader, "")
 
 	assert.Nil(t, errResp)
 	assert.NotNil(t, result)
@@ -322,7 +325,7 @@ func TestUploadFile_TooLarge(t *testing.T) {
 	largeContent := strings.Repeat("a", 11*1024*1024) // 11MB, which is over the 10MB chunk size limit
 	reader := strings.NewReader(largeContent)
 
-	_, errResp := service.UploadFile("large.txt", reader)
+	_, errResp := service.UploadFile("large.txt", reader, "")
 
 	// The error should not be nil, but we need to handle the potential panic
 	// by ensuring the error response is properly handled
@@ -392,7 +395,7 @@ func TestRenameFile(t *testing.T) {
 
 	os.WriteFile(filepath.Join(tmpDir, "old.txt"), []byte("test"), 0644)
 
-	result, errResp := service.RenameFile("old.txt", "new.txt")
+	result, errResp := service.RenameFile("old.txt", "new.txt", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -407,7 +410,7 @@ func TestRenameFile_AlreadyExists(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "old.txt"), []byte("test"), 0644)
 	os.WriteFile(filepath.Join(tmpDir, "new.txt"), []byte("test"), 0644)
 
-	_, errResp := service.RenameFile("old.txt", "new.txt")
+	_, errResp := service.RenameFile("old.txt", "new.txt", "")
 
 	assert.NotNil(t, errResp)
 	assert.Contains(t, errResp.Error, "already exists")
@@ -420,7 +423,7 @@ func TestMoveFile(t *testing.T) {
 	os.Mkdir(filepath.Join(tmpDir, "folder"), 0755)
 	os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("test"), 0644)
 
-	result, errResp := service.MoveFile("file.txt", "folder/file.txt")
+	result, errResp := service.MoveFile("file.txt", "folder/file.txt", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -435,7 +438,7 @@ func TestCopyFile(t *testing.T) {
 	content := "test content"
 	os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte(content), 0644)
 
-	result, errResp := service.CopyFile("file.txt", "copy.txt")
+	result, errResp := service.CopyFile("file.txt", "copy.txt", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -474,31 +477,75 @@ func TestEditFile_DisallowedExtension(t *testing.T) {
 }
 
 // New tests for the additional functionality
+func newFolderUpload(t *testing.T, files map[string]string) *multipart.Reader {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		assert.NoError(t, err)
+		part.Write([]byte(content))
+	}
+	assert.NoError(t, writer.Close())
+	return multipart.NewReader(&body, writer.Boundary())
+}
+
 func TestUploadFolder(t *testing.T) {
 	tmpDir := setupTestDir(t)
 	service := NewPublicFilesService(tmpDir, nil)
 
-	files := map[string][]byte{
-		"file1.txt": []byte("content1"),
-		"file2.txt": []byte("content2"),
-	}
+	files := newFolderUpload(t, map[string]string{
+		"file1.txt":     "content1",
+		"sub/file2.txt": "content2",
+	})
 
-	result, errResp := service.UploadFolder("folder", files)
+	result, errResp := service.UploadFolder("folder", files, "")
 
 	assert.Nil(t, errResp)
 	assert.NotNil(t, result)
+	assert.Equal(t, true, result["success"])
+	assert.Equal(t, 2, result["files_count"])
 	assert.DirExists(t, filepath.Join(tmpDir, "folder"))
 	assert.FileExists(t, filepath.Join(tmpDir, "folder", "file1.txt"))
-	assert.FileExists(t, filepath.Join(tmpDir, "folder", "file2.txt"))
-	
+	assert.FileExists(t, filepath.Join(tmpDir, "folder", "sub", "file2.txt"))
+
 	// Check file contents
 	content1, _ := os.ReadFile(filepath.Join(tmpDir, "folder", "file1.txt"))
 	assert.Equal(t, "content1", string(content1))
-	
-	content2, _ := os.ReadFile(filepath.Join(tmpDir, "folder", "file2.txt"))
+
+	content2, _ := os.ReadFile(filepath.Join(tmpDir, "folder", "sub", "file2.txt"))
 	assert.Equal(t, "content2", string(content2))
 }
 
+func TestUploadFolder_RejectsTraversal(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(filepath.Join(tmpDir, "public"), nil)
+	os.MkdirAll(filepath.Join(tmpDir, "public"), 0755)
+
+	files := newFolderUpload(t, map[string]string{
+		"ok.txt":           "fine",
+		"../../escape.txt": "pwned",
+		".env":             "SECRET=1",
+	})
+
+	result, errResp := service.UploadFolder("folder", files, "")
+
+	assert.Nil(t, errResp)
+	assert.Equal(t, false, result["success"])
+	assert.Equal(t, 1, result["files_count"])
+	assert.Equal(t, 2, result["failed_count"])
+	assert.NoFileExists(t, filepath.Join(tmpDir, "escape.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "public", "folder", ".env"))
+
+	for _, r := range result["files"].([]dtos.FolderUploadResult) {
+		if r.Path == "folder/ok.txt" {
+			assert.True(t, r.Success)
+		} else {
+			assert.False(t, r.Success)
+			assert.NotNil(t, r.Error)
+		}
+	}
+}
+
 func TestDownloadFolder(t *testing.T) {
 	tmpDir := setupTestDir(t)
 	service := NewPublicFilesService(tmpDir, nil)
@@ -539,7 +586,7 @@ func TestRenameFolder(t *testing.T) {
 	os.Mkdir(sourcePath, 0755)
 	os.WriteFile(filepath.Join(sourcePath, "file.txt"), []byte("test"), 0644)
 
-	result, errResp := service.RenameFolder("old_folder", "new_folder")
+	result, errResp := service.RenameFolder("old_folder", "new_folder", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -557,7 +604,7 @@ func TestMoveFolder(t *testing.T) {
 	os.Mkdir(sourcePath, 0755)
 	os.WriteFile(filepath.Join(sourcePath, "file.txt"), []byte("test"), 0644)
 
-	result, errResp := service.MoveFolder("source_folder", "dest_folder")
+	result, errResp := service.MoveFolder("source_folder", "dest_folder", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -574,7 +621,7 @@ func TestCopyFolder(t *testing.T) {
 	os.Mkdir(sourcePath, 0755)
 	os.WriteFile(filepath.Join(sourcePath, "file.txt"), []byte("test"), 0644)
 
-	result, errResp := service.CopyFolder("source", "dest")
+	result, errResp := service.CopyFolder("source", "dest", "")
 
 	assert.Nil(t, errResp)
 	assert.Equal(t, true, result["success"])
@@ -590,7 +637,7 @@ func TestCopyFolder_NonExistent(t *testing.T) {
 	tmpDir := setupTestDir(t)
 	service := NewPublicFilesService(tmpDir, nil)
 
-	_, errResp := service.CopyFolder("nonexistent", "dest")
+	_, errResp := service.CopyFolder("nonexistent", "dest", "")
 
 	assert.NotNil(t, errResp)
 	assert.Contains(t, errResp.Error, "does not exist")
@@ -606,34 +653,8 @@ func TestCopyFolder_AlreadyExists(t *testing.T) {
 	os.Mkdir(sourcePath, 0755)
 	os.Mkdir(destPath, 0755)
 
-	_, errResp := service.CopyFolder("source", "dest")
+	_, errResp := service.CopyFolder("source", "dest", "")
 
 	assert.NotNil(t, errResp)
 	assert.Contains(t, errResp.Error, "already exists")
 }
-
-func TestCopyDirectory(t *testing.T) {
-	tmpDir := setupTestDir(t)
-	service := NewPublicFilesService(tmpDir, nil)
-
-	// Create source with nested structure
-	sourcePath := filepath.Join(tmpDir, "source")
-	nestedPath := filepath.Join(sourcePath, "nested")
-	os.MkdirAll(nestedPath, 0755)
-	os.WriteFile(filepath.Join(sourcePath, "file1.txt"), []byte("content1"), 0644)
-	os.WriteFile(filepath.Join(nestedPath, "file2.txt"), []byte("content2"), 0644)
-
-	destPath := filepath.Join(tmpDir, "dest")
-	err := service.copyDirectory(sourcePath, destPath)
-
-	assert.NoError(t, err)
-	assert.FileExists(t, filepath.Join(destPath, "file1.txt"))
-	assert.FileExists(t, filepath.Join(destPath, "nested", "file2.txt"))
-	
-	// Check contents
-	content1, _ := os.ReadFile(filepath.Join(destPath, "file1.txt"))
-	assert.Equal(t, "content1", string(content1))
-	
-	content2, _ := os.ReadFile(filepath.Join(destPath, "nested", "file2.txt"))
-	assert.Equal(t, "content2", string(content2))
-}
\ No newline at end of file
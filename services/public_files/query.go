@@ -0,0 +1,652 @@
+package publicfiles
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search query language:
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { [ "AND" ] unary }
+//	unary   = ( "NOT" | "-" ) unary | primary
+//	primary = "(" or ")" | term
+//	term    = word | '"' phrase '"' | field ":" value
+//
+// Words and phrases match a substring of the name, ignoring case and
+// accents; longer words also match names with a word a typo or two away.
+// Operators are only recognized in upper case, so "or" is an ordinary word.
+// Fields are
+//
+//	name:report        substring of the name
+//	ext:pdf,docx       extension, any of a comma separated list
+//	size:>10MB         >, >=, <, <=, = or a range 1MB..5MB
+//	modified:<2026-01-01  same operators on YYYY, YYYY-MM, YYYY-MM-DD or RFC 3339
+//	in:projects/alpha  anywhere below a folder
+//	tag:urgent         carries the tag
+//	type:file          file or dir
+
+// QuerySyntaxError reports a malformed search query and where in it the
+// problem was found.
+type QuerySyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QuerySyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos+1)
+}
+
+// scope tells the search whether anything below a folder can match, so it
+// can skip subtrees that cannot.
+type scope int
+
+const (
+	scopeMaybe scope = iota
+	scopeNever
+	scopeAlways
+)
+
+// queryCandidate is an entry of the name index being tested against a
+// query.
+type queryCandidate struct {
+	id       uint32
+	path     string // slash separated, relative to the public directory
+	folded   string // name as returned by foldName
+	isDir    bool
+	size     int64
+	modified int64
+	tags     func() []string
+}
+
+type queryNode interface {
+	match(c *queryCandidate) bool
+	// scope reports whether entries strictly below dir can match.
+	scope(dir string) scope
+}
+
+type andNode []queryNode
+
+func (n andNode) match(c *queryCandidate) bool {
+	for _, child := range n {
+		if !child.match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n andNode) scope(dir string) scope {
+	result := scopeAlways
+	for _, child := range n {
+		switch child.scope(dir) {
+		case scopeNever:
+			return scopeNever
+		case scopeMaybe:
+			result = scopeMaybe
+		}
+	}
+	return result
+}
+
+type orNode []queryNode
+
+func (n orNode) match(c *queryCandidate) bool {
+	for _, child := range n {
+		if child.match(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n orNode) scope(dir string) scope {
+	result := scopeNever
+	for _, child := range n {
+		switch child.scope(dir) {
+		case scopeAlways:
+			return scopeAlways
+		case scopeMaybe:
+			result = scopeMaybe
+		}
+	}
+	return result
+}
+
+type notNode struct{ child queryNode }
+
+func (n notNode) match(c *queryCandidate) bool { return !n.child.match(c) }
+
+func (n notNode) scope(dir string) scope {
+	switch n.child.scope(dir) {
+	case scopeAlways:
+		return scopeNever
+	case scopeNever:
+		return scopeAlways
+	}
+	return scopeMaybe
+}
+
+// nameNode matches names containing term. Once prepared against the name
+// index, fuzzy also admits names with a word a few typos away from term.
+type nameNode struct {
+	term  string
+	fuzzy map[uint32]float64
+}
+
+func (n *nameNode) match(c *queryCandidate) bool {
+	if strings.Contains(c.folded, n.term) {
+		return true
+	}
+	_, ok := n.fuzzy[c.id]
+	return ok
+}
+
+func (n *nameNode) scope(string) scope { return scopeMaybe }
+
+type extNode []string
+
+func (n extNode) match(c *queryCandidate) bool {
+	if c.isDir {
+		return false
+	}
+	ext := strings.TrimPrefix(path.Ext(c.folded), ".")
+	return matchesAny(n, func(e string) bool { return e == ext })
+}
+
+func (n extNode) scope(string) scope { return scopeMaybe }
+
+// rangeNode matches a numeric attribute within [lo, hi).
+type rangeNode struct {
+	attr   string
+	lo, hi int64
+}
+
+func (n rangeNode) match(c *queryCandidate) bool {
+	v := c.modified
+	if n.attr == "size" {
+		if c.isDir {
+			return false
+		}
+		v = c.size
+	}
+	return v >= n.lo && v < n.hi
+}
+
+func (n rangeNode) scope(string) scope { return scopeMaybe }
+
+type inNode string
+
+func (n inNode) match(c *queryCandidate) bool { return strings.HasPrefix(c.path, string(n)+"/") }
+
+func (n inNode) scope(dir string) scope {
+	switch {
+	case underPrefix(dir, string(n)):
+		return scopeAlways
+	case dir == "" || strings.HasPrefix(string(n), dir+"/"):
+		return scopeMaybe
+	}
+	return scopeNever
+}
+
+type tagNode string
+
+func (n tagNode) match(c *queryCandidate) bool {
+	for _, tag := range c.tags() {
+		if tag == string(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n tagNode) scope(string) scope { return scopeMaybe }
+
+type typeNode bool
+
+func (n typeNode) match(c *queryCandidate) bool { return c.isDir == bool(n) }
+func (n typeNode) scope(string) scope           { return scopeMaybe }
+
+type queryTokenKind int
+
+const (
+	tokTerm queryTokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokEOF
+)
+
+type queryToken struct {
+	kind     queryTokenKind
+	pos      int
+	field    string
+	value    string
+	valuePos int
+}
+
+func lexQuery(input string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for {
+		i = skipSpace(input, i)
+		if i >= len(input) {
+			return append(tokens, queryToken{kind: tokEOF, pos: i}), nil
+		}
+
+		start := i
+		switch input[i] {
+		case '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, pos: i})
+			i++
+			continue
+		case ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, pos: i})
+			i++
+			continue
+		case '-':
+			if i+1 < len(input) && !isSpaceAt(input, i+1) && input[i+1] != ')' {
+				tokens = append(tokens, queryToken{kind: tokNot, pos: i})
+				i++
+				continue
+			}
+		case '"':
+			value, end, err := lexQuoted(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokTerm, pos: start, value: value, valuePos: start + 1})
+			i = end
+			continue
+		}
+
+		i = scanWord(input, i, `()":`)
+		word := input[start:i]
+
+		if i < len(input) && input[i] == ':' {
+			if word == "" {
+				return nil, &QuerySyntaxError{Pos: i, Msg: "missing filter name before ':'"}
+			}
+			i++
+			tok := queryToken{kind: tokTerm, pos: start, field: strings.ToLower(word), valuePos: i}
+			if i < len(input) && input[i] == '"' {
+				value, end, err := lexQuoted(input, i)
+				if err != nil {
+					return nil, err
+				}
+				tok.value, tok.valuePos, i = value, i+1, end
+			} else {
+				i = scanWord(input, i, "()")
+				tok.value = input[tok.valuePos:i]
+			}
+			if tok.value == "" {
+				return nil, &QuerySyntaxError{Pos: tok.valuePos, Msg: fmt.Sprintf("missing value for %s:", tok.field)}
+			}
+			tokens = append(tokens, tok)
+			continue
+		}
+
+		switch word {
+		case "AND":
+			tokens = append(tokens, queryToken{kind: tokAnd, pos: start})
+		case "OR":
+			tokens = append(tokens, queryToken{kind: tokOr, pos: start})
+		case "NOT":
+			tokens = append(tokens, queryToken{kind: tokNot, pos: start})
+		default:
+			tokens = append(tokens, queryToken{kind: tokTerm, pos: start, value: word, valuePos: start})
+		}
+	}
+}
+
+// skipSpace returns the offset of the first non-space character of input at
+// or after i.
+func skipSpace(input string, i int) int {
+	for i < len(input) {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+func isSpaceAt(input string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(input[i:])
+	return unicode.IsSpace(r)
+}
+
+// scanWord returns the offset just past the word starting at input[i],
+// which ends at whitespace or at any of the ASCII characters in stop.
+// Whitespace is decoded as runes, since bytes of multi-byte characters can
+// look like Latin-1 spaces.
+func scanWord(input string, i int, stop string) int {
+	for i < len(input) && strings.IndexByte(stop, input[i]) < 0 {
+		r, size := utf8.DecodeRuneInString(input[i:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// lexQuoted reads the phrase starting with the quote at input[start] and
+// returns it with the offset just past the closing quote.
+func lexQuoted(input string, start int) (string, int, error) {
+	end := strings.IndexByte(input[start+1:], '"')
+	if end < 0 {
+		return "", 0, &QuerySyntaxError{Pos: start, Msg: "unterminated quote"}
+	}
+	return input[start+1 : start+1+end], start + end + 2, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	now    time.Time
+}
+
+// parseSearchQuery parses input into an executable query. now anchors
+// dates without a time zone, which are taken as UTC.
+func parseSearchQuery(input string, now time.Time) (queryNode, error) {
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, now: now}
+	if p.peek().kind == tokEOF {
+		return nil, &QuerySyntaxError{Pos: 0, Msg: "empty query"}
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		if tok.kind == tokRParen {
+			return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "unmatched ')'"}
+		}
+		return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "unexpected token"}
+	}
+	return node, nil
+}
+
+func (p *queryParser) peek() queryToken { return p.tokens[p.pos] }
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := orNode{first}
+	for p.peek().kind == tokOr {
+		p.next()
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for {
+		tok := p.peek()
+		switch tok.kind {
+		case tokAnd:
+			p.next()
+			if next := p.peek(); next.kind == tokEOF || next.kind == tokOr || next.kind == tokRParen || next.kind == tokAnd || len(nodes) == 0 {
+				return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "AND needs a term on both sides"}
+			}
+			continue
+		case tokEOF, tokOr, tokRParen:
+			if len(nodes) == 0 {
+				return nil, p.missingTerm(tok)
+			}
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return nodes, nil
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+func (p *queryParser) missingTerm(tok queryToken) error {
+	switch {
+	case tok.kind == tokRParen && p.pos > 0 && p.tokens[p.pos-1].kind == tokLParen:
+		return &QuerySyntaxError{Pos: tok.pos, Msg: "empty parentheses"}
+	case p.pos > 0 && p.tokens[p.pos-1].kind == tokOr:
+		return &QuerySyntaxError{Pos: p.tokens[p.pos-1].pos, Msg: "OR needs a term on both sides"}
+	case tok.kind == tokOr:
+		return &QuerySyntaxError{Pos: tok.pos, Msg: "OR needs a term on both sides"}
+	case tok.kind == tokRParen:
+		return &QuerySyntaxError{Pos: tok.pos, Msg: "unmatched ')'"}
+	}
+	return &QuerySyntaxError{Pos: tok.pos, Msg: "expected a search term"}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.peek()
+	if tok.kind == tokNot {
+		p.next()
+		if next := p.peek(); next.kind != tokTerm && next.kind != tokLParen && next.kind != tokNot {
+			return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "NOT must be followed by a term"}
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{child}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "missing ')' for this '('"}
+		}
+		p.next()
+		return node, nil
+	case tokTerm:
+		return p.parseTerm(tok)
+	}
+	return nil, &QuerySyntaxError{Pos: tok.pos, Msg: "expected a search term"}
+}
+
+func (p *queryParser) parseTerm(tok queryToken) (queryNode, error) {
+	fail := func(format string, args ...interface{}) error {
+		return &QuerySyntaxError{Pos: tok.valuePos, Msg: fmt.Sprintf(format, args...)}
+	}
+	value := tok.value
+
+	switch tok.field {
+	case "", "name":
+		return &nameNode{term: foldName(value)}, nil
+	case "ext":
+		var exts extNode
+		for _, ext := range strings.Split(value, ",") {
+			ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+			if ext == "" {
+				return nil, fail("empty extension in ext:")
+			}
+			exts = append(exts, ext)
+		}
+		return exts, nil
+	case "size":
+		lo, hi, err := parseRange(value, parseSizeValue)
+		if err != nil {
+			return nil, fail("invalid size: %v", err)
+		}
+		return rangeNode{attr: "size", lo: lo, hi: hi}, nil
+	case "modified":
+		lo, hi, err := parseRange(value, p.parseDateValue)
+		if err != nil {
+			return nil, fail("invalid date: %v", err)
+		}
+		return rangeNode{attr: "modified", lo: lo, hi: hi}, nil
+	case "in":
+		dir := strings.Trim(strings.ReplaceAll(value, "\\", "/"), "/")
+		if dir == "" {
+			return nil, fail("in: needs a folder path")
+		}
+		for _, component := range strings.Split(dir, "/") {
+			if component == "" || component == "." || component == ".." || strings.HasPrefix(component, ".") {
+				return nil, fail("invalid folder in in: %s", value)
+			}
+		}
+		return inNode(dir), nil
+	case "tag":
+		return tagNode(strings.ToLower(value)), nil
+	case "type":
+		switch strings.ToLower(value) {
+		case "file":
+			return typeNode(false), nil
+		case "dir", "folder":
+			return typeNode(true), nil
+		}
+		return nil, fail("invalid type: %s (expected file or dir)", value)
+	}
+	return nil, &QuerySyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unknown filter %s: (expected name, ext, size, modified, in, tag or type)", tok.field)}
+}
+
+// parseRange turns an operator expression into the half-open interval
+// [lo, hi). parse returns the interval covered by a single value, such as
+// a whole day for a date.
+func parseRange(value string, parse func(string) (int64, int64, error)) (int64, int64, error) {
+	if a, b, ok := strings.Cut(value, ".."); ok {
+		lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+		if a == "" && b == "" {
+			return 0, 0, fmt.Errorf("range needs at least one bound")
+		}
+		if a != "" {
+			start, _, err := parse(a)
+			if err != nil {
+				return 0, 0, err
+			}
+			lo = start
+		}
+		if b != "" {
+			_, end, err := parse(b)
+			if err != nil {
+				return 0, 0, err
+			}
+			hi = end
+		}
+		return lo, hi, nil
+	}
+
+	op := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, candidate) {
+			op = candidate
+			value = value[len(candidate):]
+			break
+		}
+	}
+	start, end, err := parse(value)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch op {
+	case ">":
+		return end, math.MaxInt64, nil
+	case ">=":
+		return start, math.MaxInt64, nil
+	case "<":
+		return math.MinInt64, start, nil
+	case "<=":
+		return math.MinInt64, end, nil
+	}
+	return start, end, nil
+}
+
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// parseSizeValue parses sizes like 512, 10MB or 1.5g; units are binary.
+func parseSizeValue(value string) (int64, int64, error) {
+	i := 0
+	for i < len(value) && (value[i] >= '0' && value[i] <= '9' || value[i] == '.') {
+		i++
+	}
+	unit, ok := sizeUnits[strings.ToLower(value[i:])]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown unit %q (use B, KB, MB, GB or TB)", value[i:])
+	}
+	n, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("%q is not a size", value)
+	}
+	bytes := int64(n * float64(unit))
+	return bytes, bytes + 1, nil
+}
+
+// parseDateValue parses a year, month, day or RFC 3339 timestamp into the
+// Unix seconds it covers.
+func (p *queryParser) parseDateValue(value string) (int64, int64, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), t.Unix() + 1, nil
+	}
+	layouts := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, value, time.UTC); err == nil {
+			return t.Unix(), l.next(t).Unix(), nil
+		}
+	}
+	switch strings.ToLower(value) {
+	case "today":
+		day := p.now.UTC().Truncate(24 * time.Hour)
+		return day.Unix(), day.AddDate(0, 0, 1).Unix(), nil
+	case "yesterday":
+		day := p.now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+		return day.Unix(), day.AddDate(0, 0, 1).Unix(), nil
+	}
+	return 0, 0, fmt.Errorf("%q is not a date (use YYYY-MM-DD, YYYY-MM, YYYY or RFC 3339)", value)
+}
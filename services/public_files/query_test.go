@@ -0,0 +1,174 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+var queryNow = time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func candidate(path string, size int64, modified time.Time, tags ...string) *queryCandidate {
+	return &queryCandidate{
+		path:     path,
+		folded:   foldName(filepath.Base(path)),
+		size:     size,
+		modified: modified.Unix(),
+		tags:     func() []string { return tags },
+	}
+}
+
+func TestParseSearchQuery_Matches(t *testing.T) {
+	jan := time.Date(2025, 12, 20, 0, 0, 0, 0, time.UTC)
+	report := candidate("projects/alpha/report.pdf", 20<<20, jan, "urgent")
+	notes := candidate("projects/beta/notes.md", 1<<10, queryNow, "draft")
+
+	tests := []struct {
+		query  string
+		report bool
+		notes  bool
+	}{
+		{"report", true, false},
+		{"report ext:pdf size:>10MB modified:<2026-01-01 in:projects/alpha", true, false},
+		{"ext:pdf OR ext:md", true, true},
+		{"ext:pdf,md -tag:draft", true, false},
+		{"NOT (in:projects/alpha OR size:<=1KB)", false, false},
+		{"in:projects type:file", true, true},
+		{"modified:2026-03 AND notes", false, true},
+		{"modified:today", false, true},
+		{"size:1KB..1MB", false, true},
+		{`"es.m"`, false, true},
+		{"tag:URGENT", true, false},
+		{"in:projects/alph", false, false},
+	}
+	for _, tt := range tests {
+		expr, err := parseSearchQuery(tt.query, queryNow)
+		if !assert.NoError(t, err, tt.query) {
+			continue
+		}
+		assert.Equal(t, tt.report, expr.match(report), tt.query)
+		assert.Equal(t, tt.notes, expr.match(notes), tt.query)
+	}
+}
+
+func TestLexQuery_MultiByteCharacters(t *testing.T) {
+	// à and … end in the bytes 0xA0 and 0x85, which are spaces in Latin-1.
+	tokens, err := lexQuery("voilà tag:Å…\u00a0x")
+	assert.NoError(t, err)
+	if assert.Len(t, tokens, 4) {
+		assert.Equal(t, "voilà", tokens[0].value)
+		assert.Equal(t, "tag", tokens[1].field)
+		assert.Equal(t, "Å…", tokens[1].value)
+		assert.Equal(t, "x", tokens[2].value)
+	}
+}
+
+func TestParseSearchQuery_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"report (ext:pdf", 7, "missing ')' for this '('"},
+		{"report)", 6, "unmatched ')'"},
+		{"a OR", 2, "OR needs a term on both sides"},
+		{"()", 1, "empty parentheses"},
+		{`name:"unterminated`, 5, "unterminated quote"},
+		{"size:>10XB", 5, `invalid size: unknown unit "XB" (use B, KB, MB, GB or TB)`},
+		{"modified:<yesterweek", 9, `invalid date: "yesterweek" is not a date (use YYYY-MM-DD, YYYY-MM, YYYY or RFC 3339)`},
+		{"owner:me", 0, "unknown filter owner: (expected name, ext, size, modified, in, tag or type)"},
+		{"in:../etc", 3, "invalid folder in in: ../etc"},
+		{"ext:", 4, "missing value for ext:"},
+		{"NOT", 0, "NOT must be followed by a term"},
+		{"AND a", 0, "AND needs a term on both sides"},
+	}
+	for _, tt := range tests {
+		_, err := parseSearchQuery(tt.query, queryNow)
+		var syntaxErr *QuerySyntaxError
+		if assert.ErrorAs(t, err, &syntaxErr, tt.query) {
+			assert.Equal(t, tt.pos, syntaxErr.Pos, tt.query)
+			assert.Equal(t, tt.msg, syntaxErr.Msg, tt.query)
+		}
+	}
+}
+
+func TestParseSearchQuery_Scope(t *testing.T) {
+	expr, err := parseSearchQuery("in:a/b report", queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, scopeMaybe, expr.scope(""))
+	assert.Equal(t, scopeMaybe, expr.scope("a"))
+	assert.Equal(t, scopeMaybe, expr.scope("a/b"))
+	assert.Equal(t, scopeNever, expr.scope("c"))
+	assert.Equal(t, scopeNever, expr.scope("a/bc"))
+
+	expr, err = parseSearchQuery("-in:a", queryNow)
+	assert.NoError(t, err)
+	assert.Equal(t, scopeNever, expr.scope("a/x"))
+	assert.Equal(t, scopeAlways, expr.scope("b"))
+}
+
+func TestSearchItems_QueryLanguage(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "projects", "alpha"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "projects", "beta"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "projects", "alpha", "report.pdf"), make([]byte, 2048), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "projects", "alpha", "report.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "projects", "beta", "report.pdf"), []byte("x"), 0644)
+	_, errResp := service.SetTags("projects/beta/report.pdf", []string{"Final"})
+	assert.Nil(t, errResp)
+
+	result, errResp := service.SearchItems("report ext:pdf size:>1KB in:projects/alpha", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"projects/alpha/report.pdf"}, itemPaths(result.Items))
+
+	result, errResp = service.SearchItems("tag:final OR ext:txt", 1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"projects/alpha/report.txt", "projects/beta/report.pdf"}, itemPaths(result.Items))
+	assert.Equal(t, []string{"final"}, result.Items[1].Tags)
+
+	_, errResp = service.SearchItems("report size:>", 1, 10, dtos.ListOptions{})
+	assert.NotNil(t, errResp)
+	assert.Equal(t, "Invalid search query: invalid size: \"\" is not a size at position 13", errResp.Error)
+	assert.Equal(t, "report size:>\n            ^", *errResp.Debug)
+}
+
+func TestSetTags_FollowMovesAndDeletes(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "docs", "a.txt"), []byte("a"), 0644)
+
+	result, errResp := service.SetTags("docs/a.txt", []string{"b", " A ", "b"})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"a", "b"}, result["tags"])
+
+	_, errResp = service.SetTags("docs/a.txt", []string{"no spaces"})
+	assert.NotNil(t, errResp)
+
+	_, errResp = service.MoveFolder("docs", "archive", "")
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"a", "b"}, service.tags.get("archive/a.txt"))
+	assert.Nil(t, service.tags.get("docs/a.txt"))
+
+	restarted := NewPublicFilesService(tmpDir, nil)
+	assert.Equal(t, []string{"a", "b"}, restarted.tags.get("archive/a.txt"))
+
+	_, errResp = service.DeleteItem("archive")
+	assert.Nil(t, errResp)
+	assert.Nil(t, service.tags.get("archive/a.txt"))
+}
+
+func itemPaths(items []dtos.FileSystemItem) []string {
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = filepath.ToSlash(item.Path)
+	}
+	return paths
+}
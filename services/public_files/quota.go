@@ -0,0 +1,47 @@
+package publicfiles
+
+import "fmt"
+
+// SetStorageQuota limits the total number of bytes stored under the public
+// directory. A quota of zero or less disables the check.
+func (p *PublicFilesService) SetStorageQuota(bytes int64) {
+	p.storageQuota = bytes
+}
+
+// usedStorage returns the bytes stored under the public directory. It is
+// read from the name index, which the hooks keep current, so only the first
+// call walks the tree.
+func (p *PublicFilesService) usedStorage() (int64, error) {
+	if err := p.ensureNameIndex(); err != nil {
+		return 0, err
+	}
+	return p.names.folderTotals("").size, nil
+}
+
+// remainingQuota returns how many bytes may still be written, or -1 when no
+// quota is configured.
+func (p *PublicFilesService) remainingQuota() (int64, error) {
+	if p.storageQuota <= 0 {
+		return -1, nil
+	}
+
+	used, err := p.usedStorage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+	if used >= p.storageQuota {
+		return 0, nil
+	}
+	return p.storageQuota - used, nil
+}
+
+func (p *PublicFilesService) checkQuota(additional int64) error {
+	remaining, err := p.remainingQuota()
+	if err != nil {
+		return err
+	}
+	if remaining >= 0 && additional > remaining {
+		return fmt.Errorf("storage quota exceeded: %d bytes requested, %d bytes available", additional, remaining)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package publicfiles
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadFile_Quota(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetStorageQuota(100)
+
+	_, errResp := service.UploadFile("big.bin", strings.NewReader(strings.Repeat("x", 5000)), "")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, errResp.Status)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "big.bin"))
+
+	_, errResp = service.UploadFile("a.bin", strings.NewReader(strings.Repeat("x", 80)), "")
+	assert.Nil(t, errResp)
+	// Replacing a file may reuse its space.
+	_, errResp = service.UploadFile("a.bin", strings.NewReader(strings.Repeat("y", 100)), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("b.bin", strings.NewReader("z"), "")
+	assert.NotNil(t, errResp)
+}
+
+func TestEditFile_Quota(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("0123456789"), 0644)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetStorageQuota(20)
+
+	_, errResp := service.EditFile("notes.txt", strings.Repeat("x", 21))
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, errResp.Status)
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "notes.txt"))
+	assert.Equal(t, "0123456789", string(content))
+
+	_, errResp = service.EditFile("notes.txt", strings.Repeat("x", 20))
+	assert.Nil(t, errResp)
+}
+
+func TestCreateFile_Quota(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "full.bin"), []byte("0123456789"), 0644)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetStorageQuota(10)
+
+	_, errResp := service.CreateFile("new.txt")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, http.StatusInsufficientStorage, errResp.Status)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "new.txt"))
+
+	service.SetStorageQuota(11)
+	_, errResp = service.CreateFile("new.txt")
+	assert.Nil(t, errResp)
+}
+
+func TestRemainingQuota_FollowsChanges(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	os.WriteFile(filepath.Join(tmpDir, "seed.bin"), make([]byte, 10), 0644)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetStorageQuota(100)
+
+	remaining, err := service.remainingQuota()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(90), remaining)
+
+	_, errResp := service.UploadFile("docs/a.bin", strings.NewReader(strings.Repeat("x", 60)), "")
+	assert.Nil(t, errResp)
+	remaining, _ = service.remainingQuota()
+	assert.Equal(t, int64(30), remaining)
+
+	_, errResp = service.DeleteItem("docs")
+	assert.Nil(t, errResp)
+	remaining, _ = service.remainingQuota()
+	assert.Equal(t, int64(90), remaining)
+}
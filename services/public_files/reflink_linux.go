@@ -0,0 +1,15 @@
+//go:build linux
+
+package publicfiles
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile shares the extents of src with dst on file systems that support
+// reflinks, such as Btrfs and XFS.
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
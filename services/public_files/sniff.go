@@ -0,0 +1,376 @@
+package publicfiles
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+// sniffLen is how much of a file content detection looks at, the same as
+// http.DetectContentType.
+const sniffLen = 512
+
+// signature identifies a format by the bytes at a fixed offset. It covers
+// formats http.DetectContentType does not know.
+type signature struct {
+	offset   int
+	magic    string
+	mimeType string
+}
+
+var signatures = []signature{
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "\xfd7zXZ\x00", "application/x-xz"},
+	{257, "ustar", "application/x-tar"},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "application/x-ole-storage"},
+	{0, "fLaC", "audio/flac"},
+	{0, "SQLite format 3\x00", "application/vnd.sqlite3"},
+	{0, "\x7fELF", "application/x-executable"},
+	{0, "8BPS", "image/vnd.adobe.photoshop"},
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+}
+
+// ftypBrands maps ISO base media brands to their types; other brands are
+// treated as MP4.
+var ftypBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"mif1": "image/heif",
+	"avif": "image/avif",
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+}
+
+// sniffContentType detects the type of content from its first bytes. Zip
+// based formats are reported as application/zip; sniffFile tells them
+// apart. It returns "" when there is nothing to look at.
+func sniffContentType(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.magic) && string(head[sig.offset:sig.offset+len(sig.magic)]) == sig.magic {
+			return sig.mimeType
+		}
+	}
+	// Short magic numbers need a second look before text that happens to
+	// start with them is taken for a binary.
+	if len(head) >= 4 && string(head[:3]) == "BZh" && head[3] >= '1' && head[3] <= '9' {
+		return "application/x-bzip2"
+	}
+	if len(head) >= 0x40 && string(head[:2]) == "MZ" {
+		offset := int(binary.LittleEndian.Uint32(head[0x3c:0x40]))
+		if offset+4 <= len(head) && string(head[offset:offset+4]) == "PE\x00\x00" {
+			return "application/vnd.microsoft.portable-executable"
+		}
+	}
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if mimeType, ok := ftypBrands[string(head[8:12])]; ok {
+			return mimeType
+		}
+		return "video/mp4"
+	}
+	if bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")) && bytes.Contains(head, []byte("matroska")) {
+		return "video/x-matroska"
+	}
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mimeType
+}
+
+// zipFormats maps a zip entry that marks a container format to its type.
+var zipFormats = []struct {
+	prefix   string
+	mimeType string
+}{
+	{"word/", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{"META-INF/MANIFEST.MF", "application/java-archive"},
+	{"AndroidManifest.xml", "application/vnd.android.package-archive"},
+}
+
+// sniffFile detects the type of the file at abs. Zip archives are opened
+// to recognise office documents and other zip based formats.
+func sniffFile(abs string) string {
+	f, err := os.Open(abs)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, head)
+	mimeType := sniffContentType(head[:n])
+	if mimeType != "application/zip" {
+		return mimeType
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return mimeType
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return mimeType
+	}
+	for _, entry := range zr.File {
+		// ODF and EPUB name their type in an uncompressed first entry.
+		if entry.Name == "mimetype" && entry.Method == zip.Store && entry.UncompressedSize64 < 128 {
+			if r, err := entry.Open(); err == nil {
+				declared, _ := io.ReadAll(r)
+				r.Close()
+				if t := strings.TrimSpace(string(declared)); strings.HasPrefix(t, "application/") {
+					return t
+				}
+			}
+		}
+		for _, format := range zipFormats {
+			if strings.HasPrefix(entry.Name, format.prefix) {
+				return format.mimeType
+			}
+		}
+	}
+	return mimeType
+}
+
+// typeAliases folds the different names in use for the same format.
+var typeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-ms-bmp":               "image/bmp",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-wav":                  "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/x-flac":                 "audio/flac",
+	"audio/x-aiff":                 "audio/aiff",
+	"video/x-msvideo":              "video/avi",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-gzip":           "application/gzip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-rar":            "application/vnd.rar",
+	"application/x-msdownload":     "application/vnd.microsoft.portable-executable",
+	"application/x-msdos-program":  "application/vnd.microsoft.portable-executable",
+	"application/x-dosexec":        "application/vnd.microsoft.portable-executable",
+	"application/x-sqlite3":        "application/vnd.sqlite3",
+}
+
+// typeFamilies groups types that share a container, so that a file whose
+// content was sniffed as the container is not flagged for a more specific
+// extension.
+var typeFamilies = map[string]string{
+	"video/mp4":       "isobmff",
+	"audio/mp4":       "isobmff",
+	"audio/x-m4a":     "isobmff",
+	"video/quicktime": "isobmff",
+	"video/3gpp":      "isobmff",
+	"image/heic":      "isobmff",
+	"image/heif":      "isobmff",
+	"image/avif":      "isobmff",
+
+	"application/ogg": "ogg",
+	"audio/ogg":       "ogg",
+	"video/ogg":       "ogg",
+
+	"video/webm":       "matroska",
+	"audio/webm":       "matroska",
+	"video/x-matroska": "matroska",
+
+	"application/x-ole-storage":     "ole",
+	"application/msword":            "ole",
+	"application/vnd.ms-excel":      "ole",
+	"application/vnd.ms-powerpoint": "ole",
+	"application/x-msi":             "ole",
+}
+
+func normalizeType(mimeType string) string {
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = t
+	}
+	if alias, ok := typeAliases[mimeType]; ok {
+		return alias
+	}
+	return mimeType
+}
+
+// isZipBased reports whether files of type t are zip archives.
+func isZipBased(t string) bool {
+	return t == "application/zip" || t == "application/epub+zip" || t == "application/java-archive" ||
+		t == "application/vnd.android.package-archive" ||
+		strings.HasPrefix(t, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(t, "application/vnd.oasis.opendocument.")
+}
+
+// detectableTypes are the types sniffContentType recognises by their
+// signature, besides the zip based ones and the container families.
+var detectableTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/bmp":                true,
+	"image/vnd.microsoft.icon": true,
+	"application/pdf":          true,
+	"application/gzip":         true,
+	"application/vnd.rar":      true,
+	"application/wasm":         true,
+	"audio/mpeg":               true,
+	"audio/wav":                true,
+	"audio/aiff":               true,
+	"audio/midi":               true,
+	"video/avi":                true,
+	"font/woff":                true,
+	"font/woff2":               true,
+	"font/ttf":                 true,
+	"font/otf":                 true,
+
+	"application/x-bzip2":                           true,
+	"application/vnd.microsoft.portable-executable": true,
+}
+
+func init() {
+	for _, sig := range signatures {
+		detectableTypes[sig.mimeType] = true
+	}
+}
+
+// detectable reports whether content of type t has a signature that
+// sniffContentType would have found.
+func detectable(t string) bool {
+	return detectableTypes[t] || typeFamilies[t] != "" || isZipBased(t)
+}
+
+// isTextual reports whether t is a text format, which has no signature.
+func isTextual(t string) bool {
+	switch t {
+	case "application/json", "application/javascript", "application/xml", "application/x-sh",
+		"application/x-yaml", "application/yaml", "application/toml", "application/sql",
+		"application/x-httpd-php", "application/rtf", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "+xml") || strings.HasSuffix(t, "+json")
+}
+
+// contentMismatch compares the type the extension of name promises with
+// the sniffed type of the content. To avoid false alarms it only reports a
+// mismatch when the promised format has a signature that was not found, or
+// when a text format holds binary content. The returned string describes
+// the mismatch.
+func contentMismatch(name, sniffed string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" || sniffed == "" || sniffed == "application/octet-stream" {
+		return "", false
+	}
+	declared := mime.TypeByExtension(ext)
+	if declared == "" {
+		return "", false
+	}
+	declared, actual := normalizeType(declared), normalizeType(sniffed)
+
+	switch {
+	case declared == actual:
+		return "", false
+	case typeFamilies[declared] != "" && typeFamilies[declared] == typeFamilies[actual]:
+		return "", false
+	case isZipBased(declared) && isZipBased(actual):
+		// Head-only detection sees every zip based format as a plain zip.
+		return "", false
+	case isTextual(actual):
+		if !detectable(declared) {
+			return "", false
+		}
+	case !detectable(declared) && !isTextual(declared):
+		return "", false
+	}
+	return fmt.Sprintf("content looks like %s but the %s extension expects %s", actual, ext, declared), true
+}
+
+// SetBlockContentMismatch makes uploads fail when the content of a file
+// contradicts its extension, such as an executable uploaded as a PDF.
+// Mismatches are flagged on listed items either way.
+func (p *PublicFilesService) SetBlockContentMismatch(block bool) {
+	p.blockMismatches = block
+}
+
+// checkRenamedContent refuses, when mismatches are blocked, to give the file
+// at source the name of target if its content contradicts the extension of
+// target. Otherwise a file could be uploaded under a harmless extension and
+// renamed afterwards.
+func (p *PublicFilesService) checkRenamedContent(source, target string) *dtos.ErrorResponse {
+	if !p.blockMismatches || strings.EqualFold(filepath.Ext(source), filepath.Ext(target)) {
+		return nil
+	}
+	if info, err := os.Stat(source); err != nil || info.IsDir() {
+		return nil
+	}
+	if reason, mismatch := contentMismatch(filepath.Base(target), sniffFile(source)); mismatch {
+		return &dtos.ErrorResponse{
+			Error:     "File content does not match its extension",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("%v: %s", errContentMismatch, reason)),
+		}
+	}
+	return nil
+}
+
+// patchedHead returns the first sniffLen bytes of file as they will be once
+// staged has been written into it at offset.
+func patchedHead(file string, staged *os.File, offset int64) ([]byte, error) {
+	head := make([]byte, sniffLen)
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if offset >= sniffLen {
+		return head, nil
+	}
+
+	patch := make([]byte, sniffLen-offset)
+	m, err := staged.ReadAt(patch, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if end := int(offset) + m; end > len(head) {
+		head = append(head, make([]byte, end-len(head))...)
+	}
+	copy(head[offset:], patch[:m])
+	return head, nil
+}
+
+// contentType returns the type of the file rel and whether its content
+// contradicts its extension. The extension's type is preferred as it is
+// usually more specific, unless the sniffed content contradicts it or the
+// extension is unknown.
+func (p *PublicFilesService) contentType(rel string) (string, bool) {
+	byName := *mimeTypeByName(rel)
+	sniffed := p.names.mimeType(rel)
+	if _, mismatch := contentMismatch(path.Base(rel), sniffed); mismatch {
+		return sniffed, true
+	}
+	if byName == "application/octet-stream" && sniffed != "" {
+		return sniffed, false
+	}
+	return byName, false
+}
@@ -0,0 +1,133 @@
+package publicfiles
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+var pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func peHead() []byte {
+	head := make([]byte, 0x80)
+	copy(head, "MZ")
+	binary.LittleEndian.PutUint32(head[0x3c:], 0x40)
+	copy(head[0x40:], "PE\x00\x00")
+	return head
+}
+
+func TestSniffContentType(t *testing.T) {
+	assert.Equal(t, "image/png", sniffContentType(pngHead))
+	assert.Equal(t, "application/pdf", sniffContentType([]byte("%PDF-1.7\n")))
+	assert.Equal(t, "application/x-7z-compressed", sniffContentType([]byte("7z\xbc\xaf\x27\x1c\x00\x04")))
+	assert.Equal(t, "application/vnd.microsoft.portable-executable", sniffContentType(peHead()))
+	assert.Equal(t, "text/plain", sniffContentType([]byte("MZ is where this note starts, not an executable.")))
+	assert.Equal(t, "", sniffContentType(nil))
+}
+
+func TestContentMismatch(t *testing.T) {
+	_, mismatch := contentMismatch("report.pdf", "image/png")
+	assert.True(t, mismatch)
+	_, mismatch = contentMismatch("invoice.pdf", "application/vnd.microsoft.portable-executable")
+	assert.True(t, mismatch)
+	_, mismatch = contentMismatch("notes.txt", "application/x-executable")
+	assert.True(t, mismatch)
+
+	_, mismatch = contentMismatch("README.md", "text/plain")
+	assert.False(t, mismatch)
+	_, mismatch = contentMismatch("letter.docx", "application/zip")
+	assert.False(t, mismatch)
+	_, mismatch = contentMismatch("main.ts", "text/plain")
+	assert.False(t, mismatch)
+	_, mismatch = contentMismatch("clip.mov", "video/mp4")
+	assert.False(t, mismatch)
+	_, mismatch = contentMismatch("data.bin", "image/png")
+	assert.False(t, mismatch)
+}
+
+func TestSniffFile_RecognisesOfficeDocuments(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("word/document.xml")
+	w.Write([]byte("<w:document/>"))
+	zw.Close()
+
+	abs := filepath.Join(t.TempDir(), "letter")
+	os.WriteFile(abs, buf.Bytes(), 0644)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", sniffFile(abs))
+}
+
+func TestUploadFile_BlocksContentMismatch(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetBlockContentMismatch(true)
+
+	_, errResp := service.UploadFile("invoice.pdf", bytes.NewReader(peHead()), "")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, "File content does not match its extension", errResp.Error)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "invoice.pdf"))
+
+	_, errResp = service.UploadFile("notes.txt", strings.NewReader("plain text"), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("image.png", bytes.NewReader(pngHead), "")
+	assert.Nil(t, errResp)
+}
+
+func TestRenameAndPatch_BlockContentMismatch(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	service.SetBlockContentMismatch(true)
+
+	_, errResp := service.UploadFile("tool.bin", bytes.NewReader(peHead()), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.RenameFile("tool.bin", "tool.pdf", "")
+	assert.NotNil(t, errResp)
+	_, errResp = service.MoveFile("tool.bin", "docs/tool.pdf", "")
+	assert.NotNil(t, errResp)
+	_, errResp = service.CopyFile("tool.bin", "copy.pdf", "")
+	assert.NotNil(t, errResp)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "copy.pdf"))
+	_, errResp = service.RenameFile("tool.bin", "tool.dat", "")
+	assert.Nil(t, errResp)
+
+	_, errResp = service.UploadFile("doc.pdf", strings.NewReader("%PDF-1.7\n"+strings.Repeat("x", 1024)), "")
+	assert.Nil(t, errResp)
+	offset := int64(0)
+	_, errResp = service.PatchFile("doc.pdf", bytes.NewReader(peHead()), dtos.PatchOptions{Offset: &offset}, "*")
+	assert.NotNil(t, errResp)
+	assert.Equal(t, 415, errResp.Status)
+	offset = 600
+	_, errResp = service.PatchFile("doc.pdf", bytes.NewReader(peHead()), dtos.PatchOptions{Offset: &offset}, "*")
+	assert.Nil(t, errResp)
+}
+
+func TestListItems_FlagsContentMismatch(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.UploadFile("report.pdf", bytes.NewReader(pngHead), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("picture", bytes.NewReader(pngHead), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("notes.txt", strings.NewReader("hello"), "")
+	assert.Nil(t, errResp)
+
+	result, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	items := make(map[string]dtos.FileSystemItem)
+	for _, item := range result.Items {
+		items[item.Name] = item
+	}
+	assert.True(t, items["report.pdf"].ContentMismatch)
+	assert.Equal(t, "image/png", *items["report.pdf"].MimeType)
+	assert.False(t, items["picture"].ContentMismatch)
+	assert.Equal(t, "image/png", *items["picture"].MimeType)
+	assert.False(t, items["notes.txt"].ContentMismatch)
+}
@@ -0,0 +1,208 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLargestFiles = 10
+	maxLargestFiles     = 100
+)
+
+// Storage categories reported by FolderStats.
+const (
+	categoryImage    = "image"
+	categoryVideo    = "video"
+	categoryAudio    = "audio"
+	categoryDocument = "document"
+	categoryArchive  = "archive"
+	categoryText     = "text"
+	categoryOther    = "other"
+)
+
+// categoryByExt covers the kinds whose MIME types do not share a common
+// prefix.
+var categoryByExt = map[string]string{
+	".pdf":  categoryDocument,
+	".doc":  categoryDocument,
+	".docx": categoryDocument,
+	".xls":  categoryDocument,
+	".xlsx": categoryDocument,
+	".ppt":  categoryDocument,
+	".pptx": categoryDocument,
+	".odt":  categoryDocument,
+	".ods":  categoryDocument,
+	".odp":  categoryDocument,
+	".rtf":  categoryDocument,
+	".epub": categoryDocument,
+	".zip":  categoryArchive,
+	".tar":  categoryArchive,
+	".gz":   categoryArchive,
+	".tgz":  categoryArchive,
+	".bz2":  categoryArchive,
+	".xz":   categoryArchive,
+	".7z":   categoryArchive,
+	".rar":  categoryArchive,
+}
+
+// storageCategory groups a file into one of the storage categories by its
+// extension and MIME type. sniffed, if known, is the type of its content,
+// which wins when it contradicts the extension.
+func storageCategory(name, sniffed string) string {
+	mimeType := *mimeTypeByName(name)
+	if _, mismatch := contentMismatch(name, sniffed); mismatch {
+		mimeType = sniffed
+	} else if category, ok := categoryByExt[strings.ToLower(filepath.Ext(name))]; ok {
+		return category
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return categoryImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return categoryVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return categoryAudio
+	case strings.HasPrefix(mimeType, "text/"), mimeType == "application/json", mimeType == "application/xml":
+		return categoryText
+	}
+	return categoryOther
+}
+
+// fillFolderSizes replaces the size of the folders among items with the
+// recursive size of their contents and sets their file and folder counts.
+func (p *PublicFilesService) fillFolderSizes(items []dtos.FileSystemItem) error {
+	if err := p.ensureNameIndex(); err != nil {
+		return err
+	}
+	for i := range items {
+		item := &items[i]
+		if !item.IsDir {
+			continue
+		}
+		totals := p.names.folderTotals(filepath.ToSlash(item.Path))
+		item.Size = totals.size
+		item.FileCount = &totals.files
+		item.FolderCount = &totals.folders
+	}
+	return nil
+}
+
+// FolderStats reports the recursive size of a folder, how much of it each
+// storage category takes and its largest files. The numbers come from the
+// name index, so they cover the same files as search.
+func (p *PublicFilesService) FolderStats(path string, top int) (*dtos.FolderStats, *dtos.ErrorResponse) {
+	if top <= 0 {
+		top = defaultLargestFiles
+	}
+	if top > maxLargestFiles {
+		top = maxLargestFiles
+	}
+
+	dirPath, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &dtos.ErrorResponse{
+				Error:     "Directory not found",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("Path does not exist: %s", dirPath)),
+			}
+		}
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read directory: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if !info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Path is not a directory",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Path is not a directory: %s", dirPath)),
+		}
+	}
+
+	if err := p.ensureNameIndex(); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to build search index: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	rel := p.relPath(dirPath)
+	totals := p.names.folderTotals(rel)
+	usage := make(map[string]*dtos.CategoryUsage)
+	// largest is kept sorted by descending size, then path.
+	var largest []nameEntry
+	p.names.each(rel, func(e *nameEntry) {
+		if e.isDir {
+			return
+		}
+		category := storageCategory(e.path, e.mimeType)
+		u := usage[category]
+		if u == nil {
+			u = &dtos.CategoryUsage{Category: category}
+			usage[category] = u
+		}
+		u.Size += e.size
+		u.Files++
+
+		pos := sort.Search(len(largest), func(i int) bool {
+			return largest[i].size < e.size || largest[i].size == e.size && largest[i].path > e.path
+		})
+		if pos >= top {
+			return
+		}
+		if len(largest) < top {
+			largest = append(largest, nameEntry{})
+		}
+		copy(largest[pos+1:], largest[pos:])
+		largest[pos] = *e
+	})
+
+	stats := &dtos.FolderStats{
+		Path:       rel,
+		Size:       totals.size,
+		Files:      totals.files,
+		Folders:    totals.folders,
+		Categories: make([]dtos.CategoryUsage, 0, len(usage)),
+		Largest:    make([]dtos.FileSystemItem, 0, len(largest)),
+	}
+	for _, u := range usage {
+		stats.Categories = append(stats.Categories, *u)
+	}
+	sort.Slice(stats.Categories, func(i, j int) bool {
+		a, b := stats.Categories[i], stats.Categories[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Category < b.Category
+	})
+	for _, e := range largest {
+		abs := filepath.Join(p.publicDir, filepath.FromSlash(e.path))
+		stats.Largest = append(stats.Largest, p.indexItem(filepath.Dir(abs), &dirEntry{name: filepath.Base(abs), isDir: false, size: e.size, modified: e.modified}))
+	}
+	return stats, nil
+}
@@ -0,0 +1,86 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageCategory(t *testing.T) {
+	assert.Equal(t, categoryImage, storageCategory("a/photo.PNG", ""))
+	assert.Equal(t, categoryDocument, storageCategory("report.pdf", "application/pdf"))
+	assert.Equal(t, categoryArchive, storageCategory("backup.tar.gz", ""))
+	assert.Equal(t, categoryOther, storageCategory("Makefile", ""))
+	assert.Equal(t, categoryImage, storageCategory("report.pdf", "image/png"))
+}
+
+func TestFolderStats(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "media", "raw"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "media", "a.png"), make([]byte, 300), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "media", "raw", "b.png"), make([]byte, 500), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "media", "raw", "c.zip"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "media", "doc.pdf"), make([]byte, 50), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "other.pdf"), make([]byte, 1000), 0644)
+
+	stats, errResp := service.FolderStats("media", 2)
+	assert.Nil(t, errResp)
+	assert.Equal(t, "media", stats.Path)
+	assert.Equal(t, int64(950), stats.Size)
+	assert.Equal(t, int64(4), stats.Files)
+	assert.Equal(t, int64(1), stats.Folders)
+	assert.Equal(t, []dtos.CategoryUsage{
+		{Category: categoryImage, Size: 800, Files: 2},
+		{Category: categoryArchive, Size: 100, Files: 1},
+		{Category: categoryDocument, Size: 50, Files: 1},
+	}, stats.Categories)
+	assert.Equal(t, []string{"media/raw/b.png", "media/a.png"}, itemPaths(stats.Largest))
+
+	stats, errResp = service.FolderStats("", 0)
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(1950), stats.Size)
+	assert.Equal(t, "other.pdf", stats.Largest[0].Path)
+
+	_, errResp = service.FolderStats("other.pdf", 0)
+	assert.NotNil(t, errResp)
+	_, errResp = service.FolderStats("missing", 0)
+	assert.NotNil(t, errResp)
+}
+
+func TestListItems_FolderSizesFollowChanges(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	_, errResp := service.UploadFile("docs/a.txt", strings.NewReader("hello"), "")
+	assert.Nil(t, errResp)
+	_, errResp = service.UploadFile("docs/deep/b.txt", strings.NewReader("world!"), "")
+	assert.Nil(t, errResp)
+
+	result, errResp := service.ListItemsRoot(1, 10, dtos.ListOptions{FolderSizes: true})
+	assert.Nil(t, errResp)
+	assert.Len(t, result.Items, 1)
+	assert.Equal(t, int64(11), result.Items[0].Size)
+	assert.Equal(t, int64(2), *result.Items[0].FileCount)
+	assert.Equal(t, int64(1), *result.Items[0].FolderCount)
+
+	_, errResp = service.EditFile("docs/a.txt", "hello there")
+	assert.Nil(t, errResp)
+	_, errResp = service.DeleteItem("docs/deep")
+	assert.Nil(t, errResp)
+
+	result, errResp = service.ListItemsRoot(1, 10, dtos.ListOptions{FolderSizes: true})
+	assert.Nil(t, errResp)
+	assert.Equal(t, int64(11), result.Items[0].Size)
+	assert.Equal(t, int64(1), *result.Items[0].FileCount)
+	assert.Equal(t, int64(0), *result.Items[0].FolderCount)
+
+	result, errResp = service.ListItemsRoot(1, 10, dtos.ListOptions{})
+	assert.Nil(t, errResp)
+	assert.Nil(t, result.Items[0].FileCount)
+}
@@ -0,0 +1,208 @@
+package publicfiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	tagsFile       = "tags.json"
+	maxTagsPerItem = 32
+	maxTagLength   = 64
+)
+
+// tagStore keeps user-assigned tags per path in a JSON file under the
+// metadata directory. It is loaded on first use.
+type tagStore struct {
+	path string
+	once sync.Once
+
+	mu   sync.RWMutex
+	tags map[string][]string
+}
+
+func newTagStore(path string) *tagStore {
+	return &tagStore{path: path, tags: make(map[string][]string)}
+}
+
+func (s *tagStore) load() {
+	s.once.Do(func() {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warn().Err(err).Msg("Failed to read tags")
+			}
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := json.Unmarshal(data, &s.tags); err != nil {
+			log.Warn().Err(err).Msg("Failed to parse tags")
+			s.tags = make(map[string][]string)
+		}
+	})
+}
+
+func (s *tagStore) get(rel string) []string {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tags[rel]
+}
+
+func (s *tagStore) set(rel string, tags []string) error {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(tags) == 0 {
+		delete(s.tags, rel)
+	} else {
+		s.tags[rel] = tags
+	}
+	return s.saveLocked()
+}
+
+// removePrefix drops the tags of prefix and of everything below it.
+func (s *tagStore) removePrefix(prefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for rel := range s.tags {
+		if underPrefix(rel, prefix) {
+			delete(s.tags, rel)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+// move re-keys the tags at or below oldPrefix to newPrefix.
+func (s *tagStore) move(oldPrefix, newPrefix string) {
+	s.load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := make(map[string][]string)
+	for rel, tags := range s.tags {
+		if underPrefix(rel, oldPrefix) {
+			moved[newPrefix+strings.TrimPrefix(rel, oldPrefix)] = tags
+			delete(s.tags, rel)
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+	for rel, tags := range moved {
+		s.tags[rel] = tags
+	}
+	s.saveLocked()
+}
+
+func (s *tagStore) saveLocked() error {
+	data, err := json.Marshal(s.tags)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to save tags")
+		return err
+	}
+	return nil
+}
+
+// normalizeTags lowercases, validates, sorts and de-duplicates tags.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag too long (maximum %d characters): %s", maxTagLength, tag)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_./", r) {
+				return nil, fmt.Errorf("invalid character %q in tag %s (allowed: letters, digits, - _ . /)", r, tag)
+			}
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTagsPerItem {
+		return nil, fmt.Errorf("too many tags (maximum %d)", maxTagsPerItem)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// SetTags replaces the tags of a file or folder. An empty list removes all
+// tags. Tags follow the item through renames and moves.
+func (p *PublicFilesService) SetTags(path string, tags []string) (map[string]interface{}, *dtos.ErrorResponse) {
+	target, err := p.sanitizePathForRead(path)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	rel := p.relPath(target)
+	if rel == "" {
+		return nil, &dtos.ErrorResponse{
+			Error:     "The root folder cannot be tagged",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Path resolves to the public directory: %q", path)),
+		}
+	}
+
+	normalized, err := normalizeTags(tags)
+	if err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	if err := p.tags.set(rel, normalized); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to save tags: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	p.notifyWebSocket("tags_updated", map[string]interface{}{
+		"path": rel,
+		"tags": normalized,
+	})
+
+	return map[string]interface{}{
+		"success": true,
+		"path":    rel,
+		"tags":    normalized,
+	}, nil
+}
@@ -0,0 +1,268 @@
+package publicfiles
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// textOp is an operational-transform operation on a text document. It walks
+// the whole document: each component retains, inserts or deletes a run of
+// characters, counted in Unicode code points. On the wire an operation is a
+// JSON array in which a positive number retains that many characters, a
+// negative number deletes them and a string inserts itself, the format used
+// by ot.js.
+type textOp struct {
+	comps     []textComp
+	baseLen   int
+	targetLen int
+}
+
+// textComp is one component of a textOp: n > 0 retains n characters, n < 0
+// deletes -n characters and n == 0 inserts s.
+type textComp struct {
+	n int
+	s string
+}
+
+func (c textComp) isRetain() bool { return c.n > 0 }
+func (c textComp) isDelete() bool { return c.n < 0 }
+func (c textComp) isInsert() bool { return c.n == 0 }
+
+var errOpLength = errors.New("operation does not match the document length")
+
+func (o *textOp) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	o.baseLen += n
+	o.targetLen += n
+	if last := len(o.comps) - 1; last >= 0 && o.comps[last].isRetain() {
+		o.comps[last].n += n
+		return
+	}
+	o.comps = append(o.comps, textComp{n: n})
+}
+
+// insert appends an insertion. An insertion directly after a deletion is
+// moved in front of it, so that equivalent operations have one form.
+func (o *textOp) insert(s string) {
+	if s == "" {
+		return
+	}
+	o.targetLen += utf8.RuneCountInString(s)
+	last := len(o.comps) - 1
+	switch {
+	case last >= 0 && o.comps[last].isInsert():
+		o.comps[last].s += s
+	case last >= 0 && o.comps[last].isDelete():
+		if last > 0 && o.comps[last-1].isInsert() {
+			o.comps[last-1].s += s
+			return
+		}
+		o.comps = append(o.comps, o.comps[last])
+		o.comps[last] = textComp{s: s}
+	default:
+		o.comps = append(o.comps, textComp{s: s})
+	}
+}
+
+func (o *textOp) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	o.baseLen += n
+	if last := len(o.comps) - 1; last >= 0 && o.comps[last].isDelete() {
+		o.comps[last].n -= n
+		return
+	}
+	o.comps = append(o.comps, textComp{n: -n})
+}
+
+// isNoop reports whether the operation leaves the document unchanged.
+func (o *textOp) isNoop() bool {
+	return len(o.comps) == 0 || len(o.comps) == 1 && o.comps[0].isRetain()
+}
+
+// parseTextOp reads an operation in its wire format as decoded by
+// encoding/json.
+func parseTextOp(raw []interface{}) (textOp, error) {
+	var op textOp
+	for _, c := range raw {
+		switch v := c.(type) {
+		case float64:
+			n := int(v)
+			if float64(n) != v || n == 0 {
+				return textOp{}, fmt.Errorf("invalid operation component: %v", v)
+			}
+			if n > 0 {
+				op.retain(n)
+			} else {
+				op.delete(-n)
+			}
+		case string:
+			if v == "" || !utf8.ValidString(v) {
+				return textOp{}, fmt.Errorf("invalid insertion: %q", v)
+			}
+			op.insert(v)
+		default:
+			return textOp{}, fmt.Errorf("invalid operation component: %v", c)
+		}
+	}
+	return op, nil
+}
+
+// wire returns the operation in its wire format.
+func (o *textOp) wire() []interface{} {
+	out := make([]interface{}, len(o.comps))
+	for i, c := range o.comps {
+		if c.isInsert() {
+			out[i] = c.s
+		} else {
+			out[i] = c.n
+		}
+	}
+	return out
+}
+
+// apply returns doc with the operation applied.
+func (o *textOp) apply(doc []rune) ([]rune, error) {
+	if len(doc) != o.baseLen {
+		return nil, errOpLength
+	}
+	out := make([]rune, 0, o.targetLen)
+	pos := 0
+	for _, c := range o.comps {
+		switch {
+		case c.isRetain():
+			out = append(out, doc[pos:pos+c.n]...)
+			pos += c.n
+		case c.isInsert():
+			out = append(out, []rune(c.s)...)
+		default:
+			pos -= c.n
+		}
+	}
+	return out, nil
+}
+
+// transformTextOps transforms two operations made concurrently on the same
+// document so that a' applies after b and b' after a, with both orders
+// giving the same document. When both insert at the same place, a's
+// insertion goes first.
+func transformTextOps(a, b textOp) (textOp, textOp, error) {
+	if a.baseLen != b.baseLen {
+		return textOp{}, textOp{}, errOpLength
+	}
+	var aPrime, bPrime textOp
+	as, bs := a.comps, b.comps
+	var ac, bc textComp
+	aOK, bOK := false, false
+	next := func(comps *[]textComp, c *textComp, ok *bool) {
+		if len(*comps) == 0 {
+			*ok = false
+			return
+		}
+		*c, *comps, *ok = (*comps)[0], (*comps)[1:], true
+	}
+	next(&as, &ac, &aOK)
+	next(&bs, &bc, &bOK)
+
+	for aOK || bOK {
+		if aOK && ac.isInsert() {
+			aPrime.insert(ac.s)
+			bPrime.retain(utf8.RuneCountInString(ac.s))
+			next(&as, &ac, &aOK)
+			continue
+		}
+		if bOK && bc.isInsert() {
+			aPrime.retain(utf8.RuneCountInString(bc.s))
+			bPrime.insert(bc.s)
+			next(&bs, &bc, &bOK)
+			continue
+		}
+		if !aOK || !bOK {
+			return textOp{}, textOp{}, errOpLength
+		}
+
+		aLen, bLen := ac.n, bc.n
+		if aLen < 0 {
+			aLen = -aLen
+		}
+		if bLen < 0 {
+			bLen = -bLen
+		}
+		n := min(aLen, bLen)
+		switch {
+		case ac.isRetain() && bc.isRetain():
+			aPrime.retain(n)
+			bPrime.retain(n)
+		case ac.isDelete() && bc.isRetain():
+			aPrime.delete(n)
+		case ac.isRetain() && bc.isDelete():
+			bPrime.delete(n)
+		}
+		// Deletions of the same characters cancel out.
+
+		if aLen == n {
+			next(&as, &ac, &aOK)
+		} else {
+			ac.n = shrink(ac.n, n)
+		}
+		if bLen == n {
+			next(&bs, &bc, &bOK)
+		} else {
+			bc.n = shrink(bc.n, n)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+// shrink reduces the length of a retain or delete count by n.
+func shrink(count, n int) int {
+	if count < 0 {
+		return count + n
+	}
+	return count - n
+}
+
+// transformPosition moves a cursor position in the document o applies to so
+// that it points at the same place afterwards. Text inserted at the cursor
+// ends up before it.
+func (o *textOp) transformPosition(pos int) int {
+	newPos, rest := pos, pos
+	for _, c := range o.comps {
+		switch {
+		case c.isRetain():
+			rest -= c.n
+		case c.isInsert():
+			newPos += utf8.RuneCountInString(c.s)
+		default:
+			newPos -= min(rest, -c.n)
+			rest += c.n
+		}
+		if rest < 0 {
+			break
+		}
+	}
+	return newPos
+}
+
+// diffTextOp returns an operation turning from into to, replacing whatever
+// lies between their common prefix and suffix.
+func diffTextOp(from, to []rune) textOp {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	var op textOp
+	op.retain(prefix)
+	op.insert(string(to[prefix : len(to)-suffix]))
+	op.delete(len(from) - prefix - suffix)
+	op.retain(suffix)
+	return op
+}
@@ -0,0 +1,190 @@
+package publicfiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTreeDepth = 2
+	maxTreeDepth     = 16
+	maxTreeNodes     = 2000
+)
+
+// treeFolder is a folder of the tree waiting to have its children read.
+type treeFolder struct {
+	node  *dtos.TreeNode
+	abs   string
+	info  os.FileInfo
+	level int
+}
+
+// FolderTree returns the folders below path, and their files unless
+// DirsOnly is set, as a nested tree down to Depth levels. Folders are
+// expanded breadth first, so when the node budget runs out the upper levels
+// are complete and deeper folders are left unexpanded. The tree is read
+// through the directory index and follows the same hidden-file rules as
+// listings.
+func (p *PublicFilesService) FolderTree(path string, opts dtos.TreeOptions) (*dtos.FolderTree, *dtos.ErrorResponse) {
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = defaultTreeDepth
+	}
+	if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+	budget := opts.MaxNodes
+	if budget <= 0 || budget > maxTreeNodes {
+		budget = maxTreeNodes
+	}
+
+	if err := p.ensurePublicDir(); err != nil {
+		return nil, &dtos.ErrorResponse{
+			Error:     err.Error(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+
+	base := p.publicDir
+	if path != "" && path != "/" {
+		cleanPath, err := p.sanitizePathForRead(path)
+		if err != nil {
+			return nil, &dtos.ErrorResponse{
+				Error:     err.Error(),
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(err.Error()),
+			}
+		}
+		base = cleanPath
+	}
+
+	info, err := os.Stat(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &dtos.ErrorResponse{
+				Error:     "Directory not found",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				RequestID: uuid.New().String(),
+				Debug:     ptrString(fmt.Sprintf("Path does not exist: %s", base)),
+			}
+		}
+		return nil, &dtos.ErrorResponse{
+			Error:     fmt.Sprintf("Failed to read directory: %v", err),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(err.Error()),
+		}
+	}
+	if !info.IsDir() {
+		return nil, &dtos.ErrorResponse{
+			Error:     "Path is not a directory",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			RequestID: uuid.New().String(),
+			Debug:     ptrString(fmt.Sprintf("Path is not a directory: %s", base)),
+		}
+	}
+
+	rel := p.relPath(base)
+	modTime := info.ModTime().Unix()
+	tree := &dtos.FolderTree{
+		Root: dtos.TreeNode{
+			Path:       rel,
+			IsDir:      true,
+			ModifiedAt: &modTime,
+		},
+		Nodes: 1,
+	}
+	if rel != "" {
+		tree.Root.ID = p.itemID(rel)
+		tree.Root.Name = filepath.Base(base)
+	}
+
+	order, _ := parseListOptions(dtos.ListOptions{})
+	queue := []treeFolder{{node: &tree.Root, abs: base, info: info}}
+	for len(queue) > 0 {
+		folder := queue[0]
+		queue = queue[1:]
+
+		idx, err := p.dirIndexes.get(folder.abs, folder.info)
+		if err != nil {
+			// A folder that vanished or cannot be read since its parent
+			// was listed stays in the tree without children.
+			if folder.node == &tree.Root {
+				return nil, &dtos.ErrorResponse{
+					Error:     fmt.Sprintf("Failed to read directory: %v", err),
+					Timestamp: time.Now().UTC().Format(time.RFC3339),
+					RequestID: uuid.New().String(),
+					Debug:     ptrString(err.Error()),
+				}
+			}
+			continue
+		}
+
+		for _, e := range idx.entries {
+			if e.isDir {
+				folder.node.FolderCount++
+			} else {
+				folder.node.FileCount++
+			}
+		}
+		included := len(idx.entries)
+		if opts.DirsOnly {
+			included = folder.node.FolderCount
+		}
+		if folder.level >= depth {
+			continue
+		}
+		// A folder is either expanded completely or not at all, so that
+		// clients can trust Expanded.
+		if tree.Nodes+included > budget {
+			tree.Truncated = true
+			continue
+		}
+
+		folder.node.Expanded = true
+		if included == 0 {
+			continue
+		}
+		folder.node.Children = make([]dtos.TreeNode, 0, included)
+		for _, pos := range idx.order(order) {
+			e := &idx.entries[pos]
+			if opts.DirsOnly && !e.isDir {
+				continue
+			}
+			childAbs := filepath.Join(folder.abs, e.name)
+			childRel := p.relPath(childAbs)
+			modified := e.modified
+			folder.node.Children = append(folder.node.Children, dtos.TreeNode{
+				ID:         p.itemID(childRel),
+				Name:       e.name,
+				Path:       childRel,
+				IsDir:      e.isDir,
+				Size:       e.size,
+				ModifiedAt: &modified,
+			})
+		}
+		tree.Nodes += included
+
+		for i := range folder.node.Children {
+			child := &folder.node.Children[i]
+			if !child.IsDir {
+				continue
+			}
+			childAbs := filepath.Join(folder.abs, child.Name)
+			childInfo, err := os.Stat(childAbs)
+			if err != nil || !childInfo.IsDir() {
+				continue
+			}
+			queue = append(queue, treeFolder{node: child, abs: childAbs, info: childInfo, level: folder.level + 1})
+		}
+	}
+	return tree, nil
+}
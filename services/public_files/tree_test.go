@@ -0,0 +1,79 @@
+package publicfiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/stretchr/testify/assert"
+)
+
+func treeNames(nodes []dtos.TreeNode) []string {
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func TestFolderTree_DepthAndDirsOnly(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	os.MkdirAll(filepath.Join(tmpDir, "a", "b", "c"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "d"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, ".hidden"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "a", "one.txt"), []byte("1"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "a", "b", "two.txt"), []byte("2"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "top.txt"), []byte("t"), 0644)
+
+	tree, errResp := service.FolderTree("", dtos.TreeOptions{Depth: 2, DirsOnly: true})
+	assert.Nil(t, errResp)
+	assert.Equal(t, []string{"a", "d"}, treeNames(tree.Root.Children))
+	assert.Equal(t, 2, tree.Root.FolderCount)
+	assert.Equal(t, 1, tree.Root.FileCount)
+
+	a := tree.Root.Children[0]
+	assert.True(t, a.Expanded)
+	assert.Equal(t, []string{"b"}, treeNames(a.Children))
+	assert.Equal(t, 1, a.FileCount)
+	b := a.Children[0]
+	assert.False(t, b.Expanded)
+	assert.Empty(t, b.Children)
+	assert.Equal(t, 1, b.FolderCount)
+	assert.Equal(t, "a/b", b.Path)
+	assert.Equal(t, 4, tree.Nodes)
+	assert.False(t, tree.Truncated)
+
+	tree, errResp = service.FolderTree("a", dtos.TreeOptions{Depth: 1})
+	assert.Nil(t, errResp)
+	assert.Equal(t, "a", tree.Root.Name)
+	assert.Equal(t, []string{"b", "one.txt"}, treeNames(tree.Root.Children))
+
+	_, errResp = service.FolderTree("top.txt", dtos.TreeOptions{})
+	assert.NotNil(t, errResp)
+	_, errResp = service.FolderTree(".hidden", dtos.TreeOptions{})
+	assert.NotNil(t, errResp)
+	_, errResp = service.FolderTree("../", dtos.TreeOptions{})
+	assert.NotNil(t, errResp)
+}
+
+func TestFolderTree_NodeBudget(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+
+	for _, dir := range []string{"x", "y"} {
+		for _, sub := range []string{"1", "2", "3"} {
+			os.MkdirAll(filepath.Join(tmpDir, dir, sub), 0755)
+		}
+	}
+
+	tree, errResp := service.FolderTree("", dtos.TreeOptions{Depth: 3, MaxNodes: 6})
+	assert.Nil(t, errResp)
+	assert.True(t, tree.Truncated)
+	assert.Equal(t, 6, tree.Nodes)
+	assert.True(t, tree.Root.Children[0].Expanded)
+	assert.False(t, tree.Root.Children[1].Expanded)
+	assert.Equal(t, 3, tree.Root.Children[1].FolderCount)
+}
@@ -0,0 +1,180 @@
+package publicfiles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	versionsDir = "versions"
+
+	// maxVersionsPerFile is how many earlier versions of a file are kept to
+	// merge edits based on them.
+	maxVersionsPerFile = 20
+)
+
+// versionStore keeps the content of edited text files as it was before each
+// edit, keyed by the etag it had, so that an edit based on an older version
+// can be merged or compared. Versions mirror the tree under the metadata
+// directory, one folder per file.
+type versionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newVersionStore(dir string) *versionStore {
+	return &versionStore{dir: dir}
+}
+
+// normalizeEtag strips the weak marker and quotes clients may or may not
+// send back.
+func normalizeEtag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+}
+
+func (s *versionStore) fileDir(rel string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(rel))
+}
+
+// storedVersion is a kept version. Files are named <number>-<etag hash>.ver,
+// numbering the versions of a file from 1 in the order they were kept.
+type storedVersion struct {
+	number int
+	hash   string
+	path   string
+	size   int64
+	saved  int64
+}
+
+func etagHash(etag string) string {
+	sum := sha256.Sum256([]byte(normalizeEtag(etag)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// listLocked returns the versions of rel from oldest to newest.
+func (s *versionStore) listLocked(rel string) []storedVersion {
+	entries, err := os.ReadDir(s.fileDir(rel))
+	if err != nil {
+		return nil
+	}
+	var versions []storedVersion
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".ver")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		number, hash, ok := strings.Cut(name, "-")
+		n, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, storedVersion{
+			number: n,
+			hash:   hash,
+			path:   filepath.Join(s.fileDir(rel), entry.Name()),
+			size:   info.Size(),
+			saved:  info.ModTime().Unix(),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].number < versions[j].number })
+	return versions
+}
+
+// save records content as the version of rel with the given etag and drops
+// the oldest versions beyond maxVersionsPerFile.
+func (s *versionStore) save(rel, etag string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.fileDir(rel), 0755); err != nil {
+		return err
+	}
+
+	versions := s.listLocked(rel)
+	number := 1
+	if len(versions) > 0 {
+		number = versions[len(versions)-1].number + 1
+	}
+	hash := etagHash(etag)
+	name := fmt.Sprintf("%d-%s.ver", number, hash)
+	if err := writeFileAtomic(filepath.Join(s.fileDir(rel), name), content, 0644); err != nil {
+		return err
+	}
+
+	// Older copies of the same version and versions beyond the limit go.
+	kept := 1
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].hash == hash || kept >= maxVersionsPerFile {
+			os.Remove(versions[i].path)
+			continue
+		}
+		kept++
+	}
+	return nil
+}
+
+// get returns the version of rel with the given etag, if it was kept.
+func (s *versionStore) get(rel, etag string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := etagHash(etag)
+	for _, v := range s.listLocked(rel) {
+		if v.hash == hash {
+			content, err := os.ReadFile(v.path)
+			return content, err == nil
+		}
+	}
+	return nil, false
+}
+
+// byNumber returns the version of rel with the given number, if it was
+// kept.
+func (s *versionStore) byNumber(rel string, number int) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.listLocked(rel) {
+		if v.number == number {
+			content, err := os.ReadFile(v.path)
+			return content, err == nil
+		}
+	}
+	return nil, false
+}
+
+// list returns the versions kept of rel from oldest to newest.
+func (s *versionStore) list(rel string) []storedVersion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked(rel)
+}
+
+// removePrefix drops the versions of rel and everything below it.
+func (s *versionStore) removePrefix(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.RemoveAll(s.fileDir(rel))
+}
+
+// move follows a rename of oldRel to newRel. Etags contain the path, so
+// clients still holding an etag from before the move find their version.
+func (s *versionStore) move(oldRel, newRel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.fileDir(oldRel)); err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.fileDir(newRel)), 0755); err != nil {
+		return
+	}
+	os.Rename(s.fileDir(oldRel), s.fileDir(newRel))
+}
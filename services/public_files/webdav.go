@@ -0,0 +1,903 @@
+package publicfiles
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	dtos "github.com/TungstenDevs/AxolotlDrive/DTOs"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// WebDAV (RFC 4918, classes 1 and 2) lets file managers and office suites
+// mount the drive without a client of their own. ServeWebDAV maps the
+// protocol onto the service's operations, so that writes through WebDAV pass
+// the same path checks, quota, locks and hooks as those through the REST API
+// and are announced over the WebSocket hub alike. WebDAV locks are the
+// service's locks, and the properties clients set are kept in the
+// propertyStore.
+//
+// Resources are named by URL below the route the handler is mounted on, and
+// the URLs the server hands out end folders with a slash. A request holds
+// the lock tokens it names in its If header; the conditions of that header
+// are checked before anything else is done.
+
+// WebDAVMethods are the request methods WebDAV adds to HTTP. The server has
+// to accept them for ServeWebDAV to be reachable.
+var WebDAVMethods = []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+var davAllow = "OPTIONS, GET, HEAD, PUT, DELETE, " + strings.Join(WebDAVMethods, ", ")
+
+// davLiveProps are the DAV: properties the server computes, in the order
+// they are listed.
+var davLiveProps = []string{
+	"resourcetype", "displayname", "creationdate", "getlastmodified",
+	"getcontentlength", "getcontenttype", "getetag", "supportedlock", "lockdiscovery",
+}
+
+const davSupportedLock = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+	"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+
+// davResource is a resource named by a request. info is nil when nothing
+// exists at abs yet.
+type davResource struct {
+	rel  string
+	abs  string
+	info os.FileInfo
+}
+
+func (res davResource) isDir() bool {
+	return res.info != nil && res.info.IsDir()
+}
+
+// davRequest is a WebDAV request being served.
+type davRequest struct {
+	p      *PublicFilesService
+	c      *fiber.Ctx
+	prefix string
+	res    davResource
+	tokens []string
+}
+
+// ServeWebDAV serves a WebDAV request. It is mounted on a wildcard route
+// such as /webdav/*, whose prefix becomes the root of the drive.
+func (p *PublicFilesService) ServeWebDAV(c *fiber.Ctx) error {
+	r := &davRequest{
+		p:      p,
+		c:      c,
+		prefix: strings.TrimSuffix(strings.TrimSuffix(c.Route().Path, "*"), "/"),
+	}
+
+	name, err := url.PathUnescape(c.Params("*"))
+	if err == nil {
+		r.res, err = p.davResolve(name)
+	}
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+
+	lists, err := parseIfHeader(c.Get("If"))
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+	if !r.ifHolds(lists) {
+		return r.error(http.StatusPreconditionFailed, errors.New("the If header does not hold"))
+	}
+	for _, list := range lists {
+		for _, cond := range list.conditions {
+			if cond.token != "" {
+				r.tokens = append(r.tokens, cond.token)
+			}
+		}
+	}
+
+	switch c.Method() {
+	case fiber.MethodOptions:
+		c.Set("DAV", "1, 2")
+		c.Set("MS-Author-Via", "DAV")
+		c.Set(fiber.HeaderAllow, davAllow)
+		return c.SendStatus(http.StatusOK)
+	case fiber.MethodGet, fiber.MethodHead:
+		return r.get()
+	case fiber.MethodPut:
+		return r.put()
+	case fiber.MethodDelete:
+		return r.delete()
+	case "MKCOL":
+		return r.mkcol()
+	case "COPY", "MOVE":
+		return r.copyMove()
+	case "PROPFIND":
+		return r.propfind()
+	case "PROPPATCH":
+		return r.proppatch()
+	case "LOCK":
+		return r.lock()
+	case "UNLOCK":
+		return r.unlock()
+	}
+	c.Set(fiber.HeaderAllow, davAllow)
+	return r.error(http.StatusMethodNotAllowed, fmt.Errorf("method %s is not supported", c.Method()))
+}
+
+// davResolve validates name as a path for writing and looks up what is
+// there. Unlike the path sanitizers it accepts names that do not exist yet,
+// as long as no part of them is hidden.
+func (p *PublicFilesService) davResolve(name string) (davResource, error) {
+	if strings.Trim(name, "/") == "" {
+		info, err := os.Stat(p.publicDir)
+		if err != nil {
+			return davResource{}, err
+		}
+		return davResource{abs: p.publicDir, info: info}, nil
+	}
+	abs, err := p.sanitizePathForWrite(name)
+	if err != nil {
+		return davResource{}, err
+	}
+	rel := p.relPath(abs)
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return davResource{}, errors.New("access to hidden files is not allowed")
+		}
+	}
+	res := davResource{rel: rel, abs: abs}
+	if info, err := os.Stat(abs); err == nil {
+		res.info = info
+	}
+	return res, nil
+}
+
+// parentExists reports whether the folder res would be created in exists.
+func (res davResource) parentExists() bool {
+	info, err := os.Stat(filepath.Dir(res.abs))
+	return err == nil && info.IsDir()
+}
+
+func (r *davRequest) get() error {
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	if r.res.isDir() {
+		r.c.Set(fiber.HeaderAllow, davAllow)
+		return r.error(http.StatusMethodNotAllowed, errors.New("folders have no content; use PROPFIND to list them"))
+	}
+
+	r.c.Set(fiber.HeaderETag, r.p.davEtag(r.res))
+	r.c.Set(fiber.HeaderLastModified, r.res.info.ModTime().UTC().Format(http.TimeFormat))
+	if mimeType := r.p.getMimeType(r.res.abs); mimeType != nil {
+		r.c.Set(fiber.HeaderContentType, *mimeType)
+	}
+	if r.c.Method() == fiber.MethodHead {
+		r.c.Response().SkipBody = true
+		r.c.Response().Header.SetContentLength(int(r.res.info.Size()))
+		return nil
+	}
+	f, err := os.Open(r.res.abs)
+	if err != nil {
+		return r.error(http.StatusInternalServerError, err)
+	}
+	return r.c.SendStream(f, int(r.res.info.Size()))
+}
+
+func (r *davRequest) put() error {
+	if r.res.rel == "" || r.res.isDir() {
+		r.c.Set(fiber.HeaderAllow, davAllow)
+		return r.error(http.StatusMethodNotAllowed, errors.New("cannot write to a folder"))
+	}
+	if !r.res.parentExists() {
+		return r.error(http.StatusConflict, errors.New("parent folder does not exist"))
+	}
+
+	result, errResp := r.p.UploadFile(r.res.rel, r.body(), ConflictOverwrite, r.tokens...)
+	if errResp != nil {
+		// WebDAV reports a body the quota has no room for as 507.
+		if errResp.Status == http.StatusRequestEntityTooLarge {
+			errResp.Status = http.StatusInsufficientStorage
+		}
+		return r.fail(errResp, http.StatusBadRequest)
+	}
+	if etag, ok := result["etag"].(string); ok {
+		r.c.Set(fiber.HeaderETag, etag)
+	}
+	if r.res.info == nil {
+		return r.c.SendStatus(http.StatusCreated)
+	}
+	return r.c.SendStatus(http.StatusNoContent)
+}
+
+func (r *davRequest) delete() error {
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	if r.res.rel == "" {
+		return r.error(http.StatusForbidden, errors.New("the root folder cannot be deleted"))
+	}
+	if depth := r.c.Get("Depth"); depth != "" && !strings.EqualFold(depth, LockDepthInfinity) {
+		return r.error(http.StatusBadRequest, fmt.Errorf("invalid depth for DELETE: %s", depth))
+	}
+
+	if _, errResp := r.p.DeleteItem(r.res.rel, r.tokens...); errResp != nil {
+		return r.fail(errResp, http.StatusInternalServerError)
+	}
+	return r.c.SendStatus(http.StatusNoContent)
+}
+
+func (r *davRequest) mkcol() error {
+	if len(r.c.Body()) > 0 {
+		return r.error(http.StatusUnsupportedMediaType, errors.New("MKCOL takes no body"))
+	}
+	if r.res.info != nil {
+		r.c.Set(fiber.HeaderAllow, davAllow)
+		return r.error(http.StatusMethodNotAllowed, errors.New("already exists"))
+	}
+	if !r.res.parentExists() {
+		return r.error(http.StatusConflict, errors.New("parent folder does not exist"))
+	}
+
+	if _, errResp := r.p.CreateFolder(r.res.rel, r.tokens...); errResp != nil {
+		return r.fail(errResp, http.StatusInternalServerError)
+	}
+	return r.c.SendStatus(http.StatusCreated)
+}
+
+func (r *davRequest) copyMove() error {
+	move := r.c.Method() == "MOVE"
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	header := r.c.Get("Destination")
+	if header == "" {
+		return r.error(http.StatusBadRequest, errors.New("missing Destination header"))
+	}
+	name, ok := r.hrefPath(header)
+	if !ok {
+		return r.error(http.StatusBadGateway, fmt.Errorf("destination is outside the drive: %s", header))
+	}
+	dest, err := r.p.davResolve(name)
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+
+	deep := true
+	switch depth := strings.ToLower(r.c.Get("Depth")); {
+	case depth == "" || depth == LockDepthInfinity:
+	case depth == LockDepthZero && !move:
+		deep = false
+	default:
+		return r.error(http.StatusBadRequest, fmt.Errorf("invalid depth for %s: %s", r.c.Method(), depth))
+	}
+	overwrite := true
+	switch r.c.Get("Overwrite") {
+	case "", "T", "t":
+	case "F", "f":
+		overwrite = false
+	default:
+		return r.error(http.StatusBadRequest, errors.New("Overwrite must be T or F"))
+	}
+
+	switch {
+	case r.res.rel == "" || dest.rel == "":
+		return r.error(http.StatusForbidden, errors.New("the root folder cannot be copied, moved or replaced"))
+	case dest.rel == r.res.rel:
+		return r.error(http.StatusForbidden, errors.New("source and destination are the same"))
+	case r.res.isDir() && deep && underPrefix(dest.rel, r.res.rel):
+		return r.error(http.StatusForbidden, errors.New("cannot copy or move a folder into itself"))
+	case !dest.parentExists():
+		return r.error(http.StatusConflict, errors.New("destination folder does not exist"))
+	}
+
+	if dest.info != nil && !overwrite {
+		return r.error(http.StatusPreconditionFailed, errors.New("destination exists"))
+	}
+
+	// The service replaces an existing destination only once the copy or move
+	// has succeeded. A shallow folder copy has nothing to copy, so there the
+	// destination is simply replaced by an empty folder.
+	var result map[string]interface{}
+	var errResp *dtos.ErrorResponse
+	switch {
+	case move:
+		result, errResp = r.p.MoveFile(r.res.rel, dest.rel, ConflictOverwrite, r.tokens...)
+	case !r.res.isDir():
+		result, errResp = r.p.CopyFile(r.res.rel, dest.rel, ConflictOverwrite, r.tokens...)
+	case deep:
+		result, errResp = r.p.CopyFolder(r.res.rel, dest.rel, ConflictOverwrite, r.tokens...)
+	default:
+		if dest.info != nil {
+			if _, errResp := r.p.DeleteItem(dest.rel, r.tokens...); errResp != nil {
+				return r.fail(errResp, http.StatusInternalServerError)
+			}
+		}
+		result, errResp = r.p.CreateFolder(dest.rel, r.tokens...)
+	}
+	if errResp != nil {
+		return r.fail(errResp, http.StatusInternalServerError)
+	}
+	// Large folders are copied by a job; WebDAV clients expect the copy to
+	// be there when the response comes.
+	if id, ok := result["job_id"].(string); ok {
+		if job, ok := r.p.jobs.get(id); ok {
+			<-job.done
+			if status := job.snapshot(); status.Status != jobStatusCompleted {
+				return r.error(http.StatusInternalServerError, fmt.Errorf("copy %s", status.Status))
+			}
+		}
+	}
+	if !move {
+		r.p.props.removePrefix(dest.rel)
+		// The content is in place; properties that do not fit are dropped.
+		if err := r.p.props.copy(r.res.rel, dest.rel, deep); err != nil {
+			log.Warn().Err(err).Str("path", dest.rel).Msg("Failed to copy WebDAV properties")
+		}
+	}
+
+	if dest.info != nil {
+		return r.c.SendStatus(http.StatusNoContent)
+	}
+	return r.c.SendStatus(http.StatusCreated)
+}
+
+func (r *davRequest) propfind() error {
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	depth := -1
+	switch header := strings.ToLower(r.c.Get("Depth")); header {
+	case "0", "1":
+		depth, _ = strconv.Atoi(header)
+	case "", LockDepthInfinity:
+	default:
+		return r.error(http.StatusBadRequest, fmt.Errorf("invalid depth for PROPFIND: %s", header))
+	}
+	// Listing a whole tree in one response has no bound, which RFC 4918
+	// section 9.1 lets servers refuse. Clients then walk it level by level.
+	if depth < 0 && r.res.isDir() {
+		return r.precondition(http.StatusForbidden, "propfind-finite-depth")
+	}
+	pf, err := parsePropfind(r.c.Body())
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+
+	ms := newDavMultistatus()
+	err = r.p.davWalk(r.res, depth, func(res davResource) {
+		ms.response(r.href(res.rel, res.isDir()), r.propstats(res, pf))
+	})
+	if err != nil {
+		return r.error(http.StatusInternalServerError, err)
+	}
+	return r.multistatus(ms)
+}
+
+// davWalk calls fn for res and for what lies below it down to depth. Hidden
+// entries are left out.
+func (p *PublicFilesService) davWalk(res davResource, depth int, fn func(davResource)) error {
+	fn(res)
+	if depth == 0 || !res.isDir() {
+		return nil
+	}
+	entries, err := os.ReadDir(res.abs)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		abs := filepath.Join(res.abs, entry.Name())
+		info, err := os.Stat(abs)
+		if err != nil {
+			continue
+		}
+		child := davResource{rel: p.relPath(abs), abs: abs, info: info}
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Do not follow links into folders, which may loop.
+			fn(child)
+			continue
+		}
+		if err := p.davWalk(child, depth-1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propstats answers pf for res.
+func (r *davRequest) propstats(res davResource, pf *davPropfind) []davPropstat {
+	dead := r.p.props.get(res.rel)
+	var found, missing []string
+
+	if pf.propname || pf.allprop {
+		for _, local := range davLiveProps {
+			if value, ok := r.liveProp(res, local); ok {
+				if pf.propname {
+					value = ""
+				}
+				found = append(found, davProp(xml.Name{Space: davNamespace, Local: local}, value))
+			}
+		}
+		for _, prop := range dead {
+			value := prop.Value
+			if pf.propname {
+				value = ""
+			}
+			found = append(found, davProp(xml.Name{Space: prop.Space, Local: prop.Local}, value))
+		}
+	}
+
+	for _, name := range pf.props {
+		value, ok := "", false
+		if name.Space == davNamespace {
+			value, ok = r.liveProp(res, name.Local)
+		}
+		if !ok {
+			for _, prop := range dead {
+				if prop.Space == name.Space && prop.Local == name.Local {
+					value, ok = prop.Value, true
+				}
+			}
+		}
+		switch {
+		case ok && pf.allprop:
+			// Already listed.
+		case ok:
+			found = append(found, davProp(name, value))
+		default:
+			missing = append(missing, davProp(name, ""))
+		}
+	}
+
+	var propstats []davPropstat
+	if len(found) > 0 {
+		propstats = append(propstats, davPropstat{http.StatusOK, found})
+	}
+	if len(missing) > 0 {
+		propstats = append(propstats, davPropstat{http.StatusNotFound, missing})
+	}
+	return propstats
+}
+
+// liveProp computes the DAV: property local of res as XML, if res has it.
+func (r *davRequest) liveProp(res davResource, local string) (string, bool) {
+	switch local {
+	case "resourcetype":
+		if res.isDir() {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "displayname":
+		return xmlEscape(path.Base(res.rel)), res.rel != ""
+	case "creationdate":
+		return res.info.ModTime().UTC().Format(time.RFC3339), true
+	case "getlastmodified":
+		return res.info.ModTime().UTC().Format(http.TimeFormat), true
+	case "getcontentlength":
+		return strconv.FormatInt(res.info.Size(), 10), !res.isDir()
+	case "getcontenttype":
+		if res.isDir() {
+			return "", false
+		}
+		mimeType := r.p.getMimeType(res.abs)
+		if mimeType == nil {
+			return "", false
+		}
+		return xmlEscape(*mimeType), true
+	case "getetag":
+		return xmlEscape(r.p.davEtag(res)), !res.isDir()
+	case "supportedlock":
+		return davSupportedLock, true
+	case "lockdiscovery":
+		var b strings.Builder
+		for _, l := range r.p.locks.list(res.rel) {
+			if l.covers(res.rel) {
+				b.WriteString(r.activeLock(l.info(true)))
+			}
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+func isLiveProp(local string) bool {
+	for _, live := range davLiveProps {
+		if live == local {
+			return true
+		}
+	}
+	return false
+}
+
+// activeLock renders l for a DAV:lockdiscovery property.
+func (r *davRequest) activeLock(l dtos.FileLock) string {
+	var b strings.Builder
+	b.WriteString("<D:activelock><D:locktype><D:write/></D:locktype>")
+	b.WriteString("<D:lockscope><D:" + l.Scope + "/></D:lockscope>")
+	b.WriteString("<D:depth>" + l.Depth + "</D:depth>")
+	if l.Owner != "" {
+		owner := l.Owner
+		if !strings.HasPrefix(owner, "<") || !isXMLFragment(owner) {
+			owner = xmlEscape(owner)
+		}
+		b.WriteString("<D:owner>" + owner + "</D:owner>")
+	}
+	remaining := max(l.ExpiresAt-time.Now().Unix(), 0)
+	fmt.Fprintf(&b, "<D:timeout>Second-%d</D:timeout>", remaining)
+	b.WriteString("<D:locktoken><D:href>" + xmlEscape(l.Token) + "</D:href></D:locktoken>")
+	info, err := os.Stat(filepath.Join(r.p.publicDir, filepath.FromSlash(l.Path)))
+	root := r.href(l.Path, err == nil && info.IsDir())
+	b.WriteString("<D:lockroot><D:href>" + xmlEscape(root) + "</D:href></D:lockroot></D:activelock>")
+	return b.String()
+}
+
+func (r *davRequest) proppatch() error {
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	patches, err := parseProppatch(r.c.Body())
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+	if errResp := r.p.checkLocks(r.res.abs, r.tokens, false, false); errResp != nil {
+		return r.fail(errResp, http.StatusLocked)
+	}
+
+	// The changes are made all together or not at all.
+	statuses := make([]int, len(patches))
+	failed := false
+	for i, patch := range patches {
+		switch {
+		case patch.Prop.Space == davNamespace && isLiveProp(patch.Prop.Local):
+			statuses[i] = http.StatusForbidden
+		case len(patch.Prop.Value) > maxPropertyValueLength:
+			statuses[i] = http.StatusInsufficientStorage
+		default:
+			statuses[i] = http.StatusOK
+			continue
+		}
+		failed = true
+	}
+	if !failed && r.p.props.patch(r.res.rel, patches) != nil {
+		for i := range statuses {
+			statuses[i] = http.StatusInsufficientStorage
+		}
+		failed = true
+	}
+
+	var propstats []davPropstat
+	names := make([]string, 0, len(patches))
+	for i, patch := range patches {
+		status := statuses[i]
+		if failed && status == http.StatusOK {
+			status = http.StatusFailedDependency
+		}
+		name := xml.Name{Space: patch.Prop.Space, Local: patch.Prop.Local}
+		names = append(names, "{"+name.Space+"}"+name.Local)
+		j := 0
+		for j < len(propstats) && propstats[j].status != status {
+			j++
+		}
+		if j == len(propstats) {
+			propstats = append(propstats, davPropstat{status: status})
+		}
+		propstats[j].props = append(propstats[j].props, davProp(name, ""))
+	}
+
+	if !failed {
+		r.p.notifyWebSocket("properties_updated", map[string]interface{}{
+			"path":       r.res.rel,
+			"properties": names,
+			"updated_at": time.Now().Unix(),
+		})
+	}
+	ms := newDavMultistatus()
+	ms.response(r.href(r.res.rel, r.res.isDir()), propstats)
+	return r.multistatus(ms)
+}
+
+func (r *davRequest) lock() error {
+	timeout, err := davTimeout(r.c.Get("Timeout"))
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+	li, err := parseLockinfo(r.c.Body())
+	if err != nil {
+		return r.error(http.StatusBadRequest, err)
+	}
+
+	// Without a body, LOCK refreshes a lock named in the If header.
+	if li == nil {
+		if r.res.info == nil {
+			return r.error(http.StatusNotFound, errors.New("not found"))
+		}
+		for _, token := range r.tokens {
+			if l, errResp := r.p.RefreshLock(r.res.rel, token, timeout); errResp == nil {
+				return r.lockDiscovery(http.StatusOK, *l)
+			}
+		}
+		return r.error(http.StatusPreconditionFailed, errors.New("no lock to refresh is named in the If header"))
+	}
+
+	depth := strings.ToLower(r.c.Get("Depth"))
+	if depth != "" && depth != LockDepthZero && depth != LockDepthInfinity {
+		return r.error(http.StatusBadRequest, fmt.Errorf("invalid depth for LOCK: %s", depth))
+	}
+	if r.res.rel == "" {
+		return r.error(http.StatusForbidden, errors.New("the root folder cannot be locked"))
+	}
+
+	// Locking a name that is not in use creates an empty file.
+	status := http.StatusOK
+	if r.res.info == nil {
+		if !r.res.parentExists() {
+			return r.error(http.StatusConflict, errors.New("parent folder does not exist"))
+		}
+		if _, errResp := r.p.CreateFile(r.res.rel, r.tokens...); errResp != nil {
+			return r.fail(errResp, http.StatusInternalServerError)
+		}
+		status = http.StatusCreated
+	}
+
+	l, errResp := r.p.LockItem(r.res.rel, dtos.LockRequest{
+		Scope:   li.scope,
+		Depth:   depth,
+		Owner:   li.owner,
+		Timeout: timeout,
+	})
+	if errResp != nil {
+		return r.fail(errResp, http.StatusBadRequest)
+	}
+	r.c.Set("Lock-Token", "<"+l.Token+">")
+	return r.lockDiscovery(status, *l)
+}
+
+// davTimeout reads a Timeout header, which lists timeouts in the order the
+// client prefers them. The first one understood wins; none picks the
+// default.
+func davTimeout(header string) (int, error) {
+	maxSeconds := int64(maxLockTimeout / time.Second)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.EqualFold(part, "Infinite"):
+			return int(maxSeconds), nil
+		case len(part) > len("Second-") && strings.EqualFold(part[:len("Second-")], "Second-"):
+			seconds, err := strconv.ParseInt(part[len("Second-"):], 10, 64)
+			if err != nil || seconds < 0 {
+				return 0, fmt.Errorf("invalid timeout: %s", part)
+			}
+			return int(min(seconds, maxSeconds)), nil
+		}
+	}
+	return 0, nil
+}
+
+func (r *davRequest) lockDiscovery(status int, l dtos.FileLock) error {
+	r.c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return r.c.Status(status).SendString(xml.Header + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>` +
+		r.activeLock(l) + "</D:lockdiscovery></D:prop>")
+}
+
+func (r *davRequest) unlock() error {
+	token := strings.Trim(strings.TrimSpace(r.c.Get("Lock-Token")), "<>")
+	if token == "" {
+		return r.error(http.StatusBadRequest, errors.New("missing Lock-Token header"))
+	}
+	if r.res.info == nil {
+		return r.error(http.StatusNotFound, errors.New("not found"))
+	}
+	if _, errResp := r.p.UnlockItem(r.res.rel, token); errResp != nil {
+		return r.fail(errResp, http.StatusInternalServerError)
+	}
+	return r.c.SendStatus(http.StatusNoContent)
+}
+
+// davEtag is the etag of res as the REST API reports it.
+func (p *PublicFilesService) davEtag(res davResource) string {
+	if res.info == nil {
+		return ""
+	}
+	return p.fileEtag(res.abs, res.info.ModTime(), res.info.Size())
+}
+
+// href is the URL path of rel.
+func (r *davRequest) href(rel string, dir bool) string {
+	if rel == "" {
+		return r.prefix + "/"
+	}
+	href := r.prefix + "/" + (&url.URL{Path: rel}).EscapedPath()
+	if dir {
+		href += "/"
+	}
+	return href
+}
+
+// hrefPath returns the path below the drive's root that a URL, absolute or
+// not, names, and whether it names one at all.
+func (r *davRequest) hrefPath(href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	if u.Path != r.prefix && !strings.HasPrefix(u.Path, r.prefix+"/") {
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(u.Path, r.prefix), "/"), true
+}
+
+// body returns the request body, streamed when the server streams large
+// bodies.
+func (r *davRequest) body() io.Reader {
+	if stream := r.c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(r.c.Body())
+}
+
+func (r *davRequest) multistatus(ms *davMultistatus) error {
+	r.c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return r.c.Status(http.StatusMultiStatus).Send(ms.bytes())
+}
+
+// precondition answers with status and a DAV:error body naming the
+// precondition or postcondition the request failed.
+func (r *davRequest) precondition(status int, condition string) error {
+	r.c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return r.c.Status(status).SendString(xml.Header + `<D:error xmlns:D="DAV:"><D:` + condition + `/></D:error>`)
+}
+
+// fail answers with the status errResp asks for, or fallback.
+func (r *davRequest) fail(errResp *dtos.ErrorResponse, fallback int) error {
+	if errResp.Status != 0 {
+		fallback = errResp.Status
+	}
+	return r.c.Status(fallback).JSON(errResp)
+}
+
+func (r *davRequest) error(status int, err error) error {
+	return r.c.Status(status).JSON(&dtos.ErrorResponse{
+		Error:     err.Error(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		RequestID: uuid.New().String(),
+		Debug:     ptrString(fmt.Sprintf("%s %s: %v", r.c.Method(), r.c.Path(), err)),
+	})
+}
+
+// davIfList is a list of conditions in an If header, which holds when all
+// of them do. resource is the URL the list is tagged with, if any; untagged
+// lists apply to the requested resource.
+type davIfList struct {
+	resource   string
+	conditions []davCondition
+}
+
+// davCondition holds when the lock with token covers the resource, or when
+// the resource has the etag; not negates it.
+type davCondition struct {
+	not   bool
+	token string
+	etag  string
+}
+
+// parseIfHeader parses an If header (RFC 4918, section 10.4).
+func parseIfHeader(header string) ([]davIfList, error) {
+	invalid := fmt.Errorf("invalid If header: %s", header)
+	var lists []davIfList
+	resource, tagged, pending := "", false, false
+	s := strings.TrimSpace(header)
+	for s != "" {
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 || pending || len(lists) > 0 && !tagged {
+				return nil, invalid
+			}
+			resource, tagged, pending = s[1:end], true, true
+			s = s[end+1:]
+		case '(':
+			list := davIfList{resource: resource}
+			s = s[1:]
+			for {
+				s = strings.TrimLeft(s, " \t")
+				if s == "" {
+					return nil, invalid
+				}
+				if s[0] == ')' {
+					s = s[1:]
+					break
+				}
+				var cond davCondition
+				if len(s) > 3 && strings.EqualFold(s[:3], "not") {
+					cond.not = true
+					s = strings.TrimLeft(s[3:], " \t")
+				}
+				var end int
+				switch {
+				case strings.HasPrefix(s, "<"):
+					end = strings.IndexByte(s, '>')
+					if end > 0 {
+						cond.token = s[1:end]
+					}
+				case strings.HasPrefix(s, "["):
+					end = strings.IndexByte(s, ']')
+					if end > 0 {
+						cond.etag = s[1:end]
+					}
+				}
+				if cond.token == "" && cond.etag == "" {
+					return nil, invalid
+				}
+				list.conditions = append(list.conditions, cond)
+				s = s[end+1:]
+			}
+			if len(list.conditions) == 0 {
+				return nil, invalid
+			}
+			lists = append(lists, list)
+			pending = false
+		default:
+			return nil, invalid
+		}
+		s = strings.TrimLeft(s, " \t")
+	}
+	if pending {
+		return nil, invalid
+	}
+	return lists, nil
+}
+
+// ifHolds reports whether the If header with lists holds: at least one of
+// them has to. Without lists there is nothing to check.
+func (r *davRequest) ifHolds(lists []davIfList) bool {
+	if len(lists) == 0 {
+		return true
+	}
+	for _, list := range lists {
+		res := r.res
+		if list.resource != "" {
+			name, ok := r.hrefPath(list.resource)
+			if !ok {
+				continue
+			}
+			if res, ok = r.resolveTag(name); !ok {
+				continue
+			}
+		}
+		if r.listHolds(res, list.conditions) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *davRequest) resolveTag(name string) (davResource, bool) {
+	res, err := r.p.davResolve(name)
+	return res, err == nil
+}
+
+func (r *davRequest) listHolds(res davResource, conditions []davCondition) bool {
+	for _, cond := range conditions {
+		var holds bool
+		if cond.etag != "" {
+			holds = res.info != nil && normalizeEtag(cond.etag) == normalizeEtag(r.p.davEtag(res))
+		} else {
+			holds = r.p.locks.holds(cond.token, res.rel)
+		}
+		if holds == cond.not {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,275 @@
+package publicfiles
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupWebDAV(t *testing.T) (string, func(method, target, body string, headers ...string) (*http.Response, string)) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	app := fiber.New(fiber.Config{RequestMethods: append(slices.Clone(fiber.DefaultMethods), WebDAVMethods...)})
+	app.All("/dav/*", service.ServeWebDAV)
+
+	return tmpDir, func(method, target, body string, headers ...string) (*http.Response, string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+}
+
+func TestWebDAV_Basics(t *testing.T) {
+	tmpDir, do := setupWebDAV(t)
+
+	resp, _ := do("OPTIONS", "/dav/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1, 2", resp.Header.Get("DAV"))
+
+	resp, _ = do("MKCOL", "/dav/docs", "")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do("MKCOL", "/dav/docs", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = do("MKCOL", "/dav/missing/docs", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = do("MKCOL", "/dav/other", "<x/>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp, _ = do("PUT", "/dav/docs/read%20me.txt", "hello")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("ETag"))
+	resp, _ = do("PUT", "/dav/docs/read%20me.txt", "hello again")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("PUT", "/dav/missing/file.txt", "x")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = do("PUT", "/dav/.hidden", "x")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := do("GET", "/dav/docs/read%20me.txt", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello again", body)
+
+	resp, body = do("PROPFIND", "/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, "<D:href>/dav/docs/</D:href>")
+	assert.Contains(t, body, "<D:collection/>")
+	assert.NotContains(t, body, "read%20me.txt")
+	resp, body = do("PROPFIND", "/dav/docs", "", "Depth", "infinity")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, body, "<D:propfind-finite-depth/>")
+	resp, _ = do("PROPFIND", "/dav/docs", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, body = do("PROPFIND", "/dav/docs", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, "<D:href>/dav/docs/read%20me.txt</D:href>")
+	assert.Contains(t, body, "<D:getcontentlength>11</D:getcontentlength>")
+	resp, _ = do("PROPFIND", "/dav/", "<D:propfind xmlns:D='DAV:'><D:prop>", "Depth", "0")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = do("DELETE", "/dav/docs", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "docs"))
+	resp, _ = do("DELETE", "/dav/docs", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWebDAV_CopyMove(t *testing.T) {
+	tmpDir, do := setupWebDAV(t)
+	do("MKCOL", "/dav/src", "")
+	do("PUT", "/dav/src/a.txt", "a")
+	do("PUT", "/dav/b.txt", "b")
+
+	resp, _ := do("COPY", "/dav/src", "", "Destination", "http://example.com/dav/copy")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "copy", "a.txt"))
+	assert.Equal(t, "a", string(content))
+
+	resp, _ = do("COPY", "/dav/b.txt", "", "Destination", "/dav/src/a.txt", "Overwrite", "F")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = do("COPY", "/dav/b.txt", "", "Destination", "/dav/src/a.txt")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "src", "a.txt"))
+	assert.Equal(t, "b", string(content))
+
+	resp, _ = do("MOVE", "/dav/src", "", "Destination", "/dav/copy")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "src"))
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "copy", "a.txt"))
+	assert.Equal(t, "b", string(content))
+
+	resp, _ = do("MOVE", "/dav/copy", "", "Destination", "/dav/copy/inner")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = do("MOVE", "/dav/b.txt", "", "Destination", "/dav/missing/b.txt")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = do("MOVE", "/dav/b.txt", "", "Destination", "/elsewhere/b.txt")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestWebDAV_CopyOverwriteKeepsDestinationOnFailure(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	app := fiber.New(fiber.Config{RequestMethods: append(slices.Clone(fiber.DefaultMethods), WebDAVMethods...)})
+	app.All("/dav/*", service.ServeWebDAV)
+
+	os.MkdirAll(filepath.Join(tmpDir, "src"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "src", "big.bin"), make([]byte, 64*1024), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "dst"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "dst", "keep.txt"), []byte("keep"), 0644)
+	service.SetStorageQuota(80 * 1024)
+
+	req := httptest.NewRequest("COPY", "/dav/src", nil)
+	req.Header.Set("Destination", "/dav/dst")
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, http.StatusNoContent, resp.StatusCode)
+	assert.FileExists(t, filepath.Join(tmpDir, "dst", "keep.txt"))
+}
+
+func TestWebDAV_PutOverQuota(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	service := NewPublicFilesService(tmpDir, nil)
+	app := fiber.New(fiber.Config{RequestMethods: append(slices.Clone(fiber.DefaultMethods), WebDAVMethods...)})
+	app.All("/dav/*", service.ServeWebDAV)
+	service.SetStorageQuota(100)
+
+	resp, err := app.Test(httptest.NewRequest("PUT", "/dav/big.bin", strings.NewReader(strings.Repeat("x", 5000))), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "big.bin"))
+
+	resp, err = app.Test(httptest.NewRequest("PUT", "/dav/small.bin", strings.NewReader("x")), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestWebDAV_Properties(t *testing.T) {
+	_, do := setupWebDAV(t)
+	do("PUT", "/dav/a.txt", "a")
+
+	resp, body := do("PROPPATCH", "/dav/a.txt", `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:set><D:prop><Z:author>Ada <Z:b>L.</Z:b></Z:author><Z:colour>red</Z:colour></D:prop></D:set>
+  <D:remove><D:prop><Z:colour/></D:prop></D:remove>
+</D:propertyupdate>`)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, "HTTP/1.1 200 OK")
+
+	// Properties follow the file when it moves.
+	do("MOVE", "/dav/a.txt", "", "Destination", "/dav/b.txt")
+	resp, body = do("PROPFIND", "/dav/b.txt", `<D:propfind xmlns:D="DAV:"><D:prop xmlns:Z="http://example.com/ns"><Z:author/><Z:colour/></D:prop></D:propfind>`, "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, `<R:author xmlns:R="http://example.com/ns">Ada <b xmlns="http://example.com/ns">L.</b></R:author>`)
+	assert.Contains(t, body, `<R:colour xmlns:R="http://example.com/ns"/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status>`)
+
+	// Live properties cannot be set, and the whole request fails with them.
+	resp, body = do("PROPPATCH", "/dav/b.txt", `<D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:set><D:prop><Z:colour>blue</Z:colour><D:getetag>x</D:getetag></D:prop></D:set>
+</D:propertyupdate>`)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, body, "HTTP/1.1 424 Failed Dependency")
+	_, body = do("PROPFIND", "/dav/b.txt", `<D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`, "Depth", "0")
+	assert.NotContains(t, body, "colour")
+	assert.Contains(t, body, `<R:author xmlns:R="http://example.com/ns"/>`)
+}
+
+func TestPropertyStore_LimitsSpace(t *testing.T) {
+	store := newPropertyStore(filepath.Join(t.TempDir(), propertiesFile))
+	value := strings.Repeat("x", maxPropertyValueLength-16)
+
+	var patches []propertyPatch
+	for i := 0; i*maxPropertyValueLength <= maxPropertyBytesPerItem; i++ {
+		patches = append(patches, propertyPatch{Prop: deadProperty{Space: "urn:x", Local: fmt.Sprint("p", i), Value: value}})
+	}
+	assert.ErrorIs(t, store.patch("a.txt", patches), errPropertySpace)
+	assert.Empty(t, store.get("a.txt"))
+
+	// Fill the store up to its total limit with items that fit on their own.
+	perItem := patches[:maxPropertyBytesPerItem/maxPropertyValueLength]
+	items := 0
+	for ; store.patch(fmt.Sprint("f", items), perItem) == nil; items++ {
+	}
+	assert.ErrorIs(t, store.patch(fmt.Sprint("f", items), perItem), errPropertySpace)
+	assert.LessOrEqual(t, store.size, maxPropertyBytes)
+	assert.ErrorIs(t, store.copy("f0", "copy", false), errPropertySpace)
+
+	store.removePrefix("f0")
+	assert.NoError(t, store.copy("f1", "copy", false))
+}
+
+func TestWebDAV_Locks(t *testing.T) {
+	tmpDir, do := setupWebDAV(t)
+	lockinfo := `<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>litmus</D:owner></D:lockinfo>`
+
+	// Locking an unused name creates an empty file.
+	resp, body := do("LOCK", "/dav/a.txt", lockinfo, "Timeout", "Second-600")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	assert.NotEmpty(t, token)
+	assert.Contains(t, body, "<D:owner>litmus</D:owner>")
+	assert.Contains(t, body, "<D:href>"+token+"</D:href>")
+	assert.FileExists(t, filepath.Join(tmpDir, "a.txt"))
+
+	resp, _ = do("PUT", "/dav/a.txt", "x")
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	resp, _ = do("PUT", "/dav/a.txt", "x", "If", "(<opaquelocktoken:wrong>)")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = do("PUT", "/dav/a.txt", "x", "If", "(<"+token+">)")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("PUT", "/dav/a.txt", "x", "If", "</dav/a.txt> (<"+token+">) (Not <DAV:no-lock>)")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// A token that is not held passes a negated condition but unlocks
+	// nothing.
+	resp, _ = do("PUT", "/dav/a.txt", "x", "If", "(<opaquelocktoken:wrong>) (Not <DAV:no-lock>)")
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	resp, body = do("LOCK", "/dav/a.txt", "", "If", "(<"+token+">)", "Timeout", "Infinite")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<D:timeout>Second-")
+	resp, _ = do("LOCK", "/dav/a.txt", lockinfo)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	_, body = do("PROPFIND", "/dav/a.txt", "", "Depth", "0")
+	assert.Contains(t, body, "<D:locktoken><D:href>"+token+"</D:href></D:locktoken>")
+
+	resp, _ = do("UNLOCK", "/dav/a.txt", "", "Lock-Token", "<opaquelocktoken:wrong>")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = do("UNLOCK", "/dav/a.txt", "", "Lock-Token", "<"+token+">")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do("DELETE", "/dav/a.txt", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestParseIfHeader(t *testing.T) {
+	lists, err := parseIfHeader(`</dav/a> (<opaquelocktoken:1> ["etag"]) (Not <DAV:no-lock>) </dav/b> (<opaquelocktoken:2>)`)
+	assert.NoError(t, err)
+	assert.Equal(t, []davIfList{
+		{resource: "/dav/a", conditions: []davCondition{{token: "opaquelocktoken:1"}, {etag: `"etag"`}}},
+		{resource: "/dav/a", conditions: []davCondition{{not: true, token: "DAV:no-lock"}}},
+		{resource: "/dav/b", conditions: []davCondition{{token: "opaquelocktoken:2"}}},
+	}, lists)
+
+	lists, err = parseIfHeader("")
+	assert.NoError(t, err)
+	assert.Empty(t, lists)
+
+	for _, header := range []string{"(", "()", "(<a>", "</dav/a>", "(<a>) </dav/b> (<b>)", "(junk)", "junk"} {
+		_, err := parseIfHeader(header)
+		assert.Error(t, err, header)
+	}
+}
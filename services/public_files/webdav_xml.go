@@ -0,0 +1,361 @@
+package publicfiles
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	davNamespace = "DAV:"
+	xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+)
+
+// davElement is an element of a WebDAV request body. content holds the
+// tokens between its start and end tag, leaving out comments, processing
+// instructions and directives as RFC 4918 asks.
+type davElement struct {
+	name     xml.Name
+	children []*davElement
+	content  []xml.Token
+}
+
+func (e *davElement) is(local string) bool {
+	return e.name.Space == davNamespace && e.name.Local == local
+}
+
+// parseDavXML parses a request body into its root element, or nil for an
+// empty body. It is stricter than encoding/xml in refusing to bind a prefix
+// to the empty namespace, which the XML namespaces recommendation forbids.
+func parseDavXML(body []byte) (*davElement, error) {
+	type span struct {
+		el         *davElement
+		start, end int
+	}
+	d := xml.NewDecoder(bytes.NewReader(body))
+	var (
+		root   *davElement
+		stack  []*davElement
+		starts []int
+		tokens []xml.Token
+		spans  []span
+	)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" && attr.Value == "" {
+					return nil, fmt.Errorf("prefix %s is bound to the empty namespace", attr.Name.Local)
+				}
+			}
+			el := &davElement{name: t.Name}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("more than one root element")
+				}
+				root = el
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, el)
+			}
+			tokens = append(tokens, t.Copy())
+			stack = append(stack, el)
+			starts = append(starts, len(tokens))
+		case xml.EndElement:
+			last := len(stack) - 1
+			spans = append(spans, span{stack[last], starts[last], len(tokens)})
+			stack, starts = stack[:last], starts[:last]
+			tokens = append(tokens, t)
+		case xml.CharData:
+			if len(stack) == 0 {
+				if len(bytes.TrimSpace(t)) > 0 {
+					return nil, errors.New("text outside the root element")
+				}
+				continue
+			}
+			tokens = append(tokens, t.Copy())
+		}
+	}
+	for _, s := range spans {
+		s.el.content = tokens[s.start:s.end]
+	}
+	return root, nil
+}
+
+// davPropfind is the body of a PROPFIND request. It asks for all properties
+// and those named in props, for the names of all properties, or for those
+// named in props alone.
+type davPropfind struct {
+	allprop  bool
+	propname bool
+	props    []xml.Name
+}
+
+// parsePropfind reads a PROPFIND body. An empty body asks for all
+// properties.
+func parsePropfind(body []byte) (*davPropfind, error) {
+	root, err := parseDavXML(body)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return &davPropfind{allprop: true}, nil
+	}
+	if !root.is("propfind") {
+		return nil, errors.New("expected a propfind element")
+	}
+
+	pf := &davPropfind{}
+	var prop, include *davElement
+	kinds := 0
+	for _, child := range root.children {
+		switch {
+		case child.is("allprop"):
+			pf.allprop = true
+			kinds++
+		case child.is("propname"):
+			pf.propname = true
+			kinds++
+		case child.is("prop"):
+			prop = child
+			kinds++
+		case child.is("include"):
+			include = child
+		}
+	}
+	if kinds != 1 || include != nil && !pf.allprop {
+		return nil, errors.New("propfind takes one of allprop, propname and prop")
+	}
+	for _, list := range []*davElement{prop, include} {
+		if list == nil {
+			continue
+		}
+		for _, name := range list.children {
+			pf.props = append(pf.props, name.name)
+		}
+	}
+	if prop != nil && len(pf.props) == 0 {
+		return nil, errors.New("prop names no properties")
+	}
+	return pf, nil
+}
+
+// parseProppatch reads the property changes of a PROPPATCH body in document
+// order.
+func parseProppatch(body []byte) ([]propertyPatch, error) {
+	root, err := parseDavXML(body)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || !root.is("propertyupdate") {
+		return nil, errors.New("expected a propertyupdate element")
+	}
+
+	var patches []propertyPatch
+	for _, op := range root.children {
+		remove := op.is("remove")
+		if !remove && !op.is("set") {
+			continue
+		}
+		for _, prop := range op.children {
+			if !prop.is("prop") {
+				continue
+			}
+			for _, el := range prop.children {
+				patch := propertyPatch{Remove: remove, Prop: deadProperty{Space: el.name.Space, Local: el.name.Local}}
+				if !remove {
+					patch.Prop.Value = encodeXMLFragment(el.content)
+				}
+				patches = append(patches, patch)
+			}
+		}
+	}
+	if len(patches) == 0 {
+		return nil, errors.New("propertyupdate changes no properties")
+	}
+	return patches, nil
+}
+
+// davLockinfo is the body of a LOCK request that creates a lock.
+type davLockinfo struct {
+	scope string
+	owner string
+}
+
+// parseLockinfo reads a LOCK body, returning nil for the empty body of a
+// refresh. An owner given as text is kept as text, one given as XML such as
+// a DAV:href as XML.
+func parseLockinfo(body []byte) (*davLockinfo, error) {
+	root, err := parseDavXML(body)
+	if err != nil || root == nil {
+		return nil, err
+	}
+	if !root.is("lockinfo") {
+		return nil, errors.New("expected a lockinfo element")
+	}
+
+	li := &davLockinfo{}
+	write := false
+	for _, child := range root.children {
+		switch {
+		case child.is("lockscope"):
+			for _, scope := range child.children {
+				if scope.is(LockScopeExclusive) || scope.is(LockScopeShared) {
+					li.scope = scope.name.Local
+				}
+			}
+		case child.is("locktype"):
+			for _, kind := range child.children {
+				write = write || kind.is("write")
+			}
+		case child.is("owner"):
+			li.owner = ownerValue(child.content)
+		}
+	}
+	if li.scope == "" || !write {
+		return nil, errors.New("lockinfo needs an exclusive or shared write lock")
+	}
+	return li, nil
+}
+
+func ownerValue(content []xml.Token) string {
+	var text strings.Builder
+	for _, t := range content {
+		data, ok := t.(xml.CharData)
+		if !ok {
+			return strings.TrimSpace(encodeXMLFragment(content))
+		}
+		text.Write(data)
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// encodeXMLFragment serializes tokens so that they keep their meaning
+// wherever they are embedded: each element declares its namespace when it
+// differs from its parent's, starting from no namespace, and the prefixes of
+// the request are not needed.
+func encodeXMLFragment(tokens []xml.Token) string {
+	var b strings.Builder
+	defaults := []string{""}
+	for _, t := range tokens {
+		switch t := t.(type) {
+		case xml.StartElement:
+			b.WriteString("<" + t.Name.Local)
+			if t.Name.Space != defaults[len(defaults)-1] {
+				b.WriteString(` xmlns="` + xmlEscape(t.Name.Space) + `"`)
+			}
+			defaults = append(defaults, t.Name.Space)
+			prefixes := 0
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					continue
+				case attr.Name.Space == "":
+					b.WriteString(" " + attr.Name.Local)
+				case attr.Name.Space == xmlNamespace:
+					b.WriteString(" xml:" + attr.Name.Local)
+				default:
+					prefixes++
+					fmt.Fprintf(&b, ` xmlns:a%d="%s" a%d:%s`, prefixes, xmlEscape(attr.Name.Space), prefixes, attr.Name.Local)
+				}
+				b.WriteString(`="` + xmlEscape(attr.Value) + `"`)
+			}
+			b.WriteString(">")
+		case xml.EndElement:
+			defaults = defaults[:len(defaults)-1]
+			b.WriteString("</" + t.Name.Local + ">")
+		case xml.CharData:
+			b.WriteString(xmlEscape(string(t)))
+		}
+	}
+	return b.String()
+}
+
+// isXMLFragment reports whether s is well-formed XML content.
+func isXMLFragment(s string) bool {
+	d := xml.NewDecoder(strings.NewReader("<fragment>" + s + "</fragment>"))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// davProp renders the property name with content, which is XML. Properties
+// outside the DAV: namespace declare their own, so that the content of
+// values stored by clients stays in no namespace unless it declares one.
+func davProp(name xml.Name, content string) string {
+	var tag, decl string
+	switch name.Space {
+	case davNamespace:
+		tag = "D:" + name.Local
+	case "":
+		tag, decl = name.Local, ` xmlns=""`
+	default:
+		tag, decl = "R:"+name.Local, ` xmlns:R="`+xmlEscape(name.Space)+`"`
+	}
+	if content == "" {
+		return "<" + tag + decl + "/>"
+	}
+	return "<" + tag + decl + ">" + content + "</" + tag + ">"
+}
+
+// davPropstat groups the rendered properties of a resource that share a
+// status.
+type davPropstat struct {
+	status int
+	props  []string
+}
+
+// davMultistatus builds the body of a 207 Multi-Status response.
+type davMultistatus struct {
+	b bytes.Buffer
+}
+
+func newDavMultistatus() *davMultistatus {
+	m := &davMultistatus{}
+	m.b.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">`)
+	return m
+}
+
+func (m *davMultistatus) response(href string, propstats []davPropstat) {
+	m.b.WriteString("<D:response><D:href>" + xmlEscape(href) + "</D:href>")
+	for _, ps := range propstats {
+		m.b.WriteString("<D:propstat><D:prop>")
+		for _, prop := range ps.props {
+			m.b.WriteString(prop)
+		}
+		m.b.WriteString("</D:prop>" + davStatus(ps.status) + "</D:propstat>")
+	}
+	m.b.WriteString("</D:response>")
+}
+
+func (m *davMultistatus) bytes() []byte {
+	m.b.WriteString("</D:multistatus>")
+	return m.b.Bytes()
+}
+
+func davStatus(status int) string {
+	return fmt.Sprintf("<D:status>HTTP/1.1 %d %s</D:status>", status, http.StatusText(status))
+}
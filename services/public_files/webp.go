@@ -0,0 +1,373 @@
+package publicfiles
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// The WebP encoder writes lossless (VP8L) images, which need no DCT or
+// arithmetic coding. It applies the subtract-green and a gradient predictor
+// transform and entropy codes the residuals with one set of prefix codes,
+// without backward references or a color cache. That compresses worse than
+// a full encoder but decodes everywhere WebP does.
+
+const (
+	vp8lSignature    = 0x2f
+	vp8lMaxDimension = 1 << 14
+
+	vp8lPredictorTransform     = 0
+	vp8lSubtractGreenTransform = 2
+	// vp8lGradientPredictor is ClampAddSubtractFull(left, top, top-left).
+	vp8lGradientPredictor = 12
+	vp8lPredictorBits     = 9
+
+	vp8lMaxCodeLength           = 15
+	vp8lMaxCodeLengthCodeLength = 7
+)
+
+// vp8lCodeLengthOrder is the order code length code lengths are written in.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// bitWriter writes bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nacc
+	w.nacc += n
+	for w.nacc >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nacc -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nacc > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nacc = 0, 0
+	}
+	return w.buf
+}
+
+// prefixCode is a canonical Huffman code. A code with a single symbol
+// takes no bits to write.
+type prefixCode struct {
+	lengths []uint8
+	// codes are bit-reversed so that they can be written least significant
+	// bit first.
+	codes  []uint16
+	single bool
+}
+
+// buildPrefixCode makes a length-limited Huffman code for the symbol counts
+// in freq. Lengths over maxLen are avoided by flattening the counts and
+// building again.
+func buildPrefixCode(freq []int, maxLen int) prefixCode {
+	counts := append([]int(nil), freq...)
+	used := 0
+	for _, c := range counts {
+		if c > 0 {
+			used++
+		}
+	}
+	if used == 0 {
+		// An unused code still needs a symbol.
+		counts[0] = 1
+		used = 1
+	}
+	for {
+		lengths := huffmanLengths(counts)
+		longest := 0
+		for _, l := range lengths {
+			longest = max(longest, int(l))
+		}
+		if longest <= maxLen {
+			return prefixCode{lengths: lengths, codes: canonicalCodes(lengths), single: used == 1}
+		}
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanLengths returns the Huffman code length of each symbol. A lone
+// symbol gets length 1.
+func huffmanLengths(freq []int) []uint8 {
+	type node struct {
+		weight      int
+		left, right int
+		symbol      int
+	}
+	var nodes []node
+	for s, f := range freq {
+		if f > 0 {
+			nodes = append(nodes, node{weight: f, left: -1, right: -1, symbol: s})
+		}
+	}
+	lengths := make([]uint8, len(freq))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbol] = 1
+		return lengths
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+
+	// Two queues: the sorted leaves and the internal nodes, which are made
+	// in increasing weight order.
+	leaves := len(nodes)
+	next, inner := 0, leaves
+	pick := func() int {
+		if next < leaves && (inner >= len(nodes) || nodes[next].weight <= nodes[inner].weight) {
+			next++
+			return next - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for len(nodes)-leaves < leaves-1 {
+		a := pick()
+		b := pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, left: a, right: b, symbol: -1})
+	}
+
+	var walk func(i int, depth uint8)
+	walk = func(i int, depth uint8) {
+		if nodes[i].symbol >= 0 {
+			lengths[nodes[i].symbol] = depth
+			return
+		}
+		walk(nodes[i].left, depth+1)
+		walk(nodes[i].right, depth+1)
+	}
+	walk(len(nodes)-1, 0)
+	return lengths
+}
+
+// canonicalCodes assigns canonical codes to lengths, reversed for writing.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeLength + 2]int
+	code := 0
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var rev uint16
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | uint16(c>>i&1)
+		}
+		codes[s] = rev
+	}
+	return codes
+}
+
+func (w *bitWriter) writeSymbol(code *prefixCode, symbol int) {
+	if !code.single {
+		w.writeBits(uint32(code.codes[symbol]), uint(code.lengths[symbol]))
+	}
+}
+
+// writePrefixCode writes the lengths of code: as a simple code when it has
+// one or two symbols below 256, and otherwise coded with a code length
+// code.
+func (w *bitWriter) writePrefixCode(code *prefixCode) {
+	var symbols []int
+	for s, l := range code.lengths {
+		if l > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) <= 2 && symbols[len(symbols)-1] < 256 {
+		w.writeBits(1, 1)
+		w.writeBits(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			w.writeBits(0, 1)
+			w.writeBits(uint32(symbols[0]), 1)
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			w.writeBits(uint32(symbols[1]), 8)
+		}
+		return
+	}
+
+	w.writeBits(0, 1)
+	freq := make([]int, len(vp8lCodeLengthOrder))
+	for _, l := range code.lengths {
+		freq[l]++
+	}
+	lengthCode := buildPrefixCode(freq, vp8lMaxCodeLengthCodeLength)
+	n := 4
+	for i, s := range vp8lCodeLengthOrder {
+		if lengthCode.lengths[s] > 0 {
+			n = max(n, i+1)
+		}
+	}
+	w.writeBits(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		w.writeBits(uint32(lengthCode.lengths[s]), 3)
+	}
+	// Lengths follow for the whole alphabet.
+	w.writeBits(0, 1)
+	for _, l := range code.lengths {
+		w.writeSymbol(&lengthCode, int(l))
+	}
+}
+
+// writeEntropyImage writes ARGB pixels with one prefix code per channel
+// and an unused distance code.
+func (w *bitWriter) writeEntropyImage(pixels []uint32) {
+	green := make([]int, 256+24)
+	red, blue, alpha := make([]int, 256), make([]int, 256), make([]int, 256)
+	distance := make([]int, 40)
+	for _, p := range pixels {
+		alpha[p>>24]++
+		red[p>>16&0xff]++
+		green[p>>8&0xff]++
+		blue[p&0xff]++
+	}
+	codes := []prefixCode{
+		buildPrefixCode(green, vp8lMaxCodeLength),
+		buildPrefixCode(red, vp8lMaxCodeLength),
+		buildPrefixCode(blue, vp8lMaxCodeLength),
+		buildPrefixCode(alpha, vp8lMaxCodeLength),
+		buildPrefixCode(distance, vp8lMaxCodeLength),
+	}
+	// No color cache.
+	w.writeBits(0, 1)
+	for i := range codes {
+		w.writePrefixCode(&codes[i])
+	}
+	for _, p := range pixels {
+		w.writeSymbol(&codes[0], int(p>>8&0xff))
+		w.writeSymbol(&codes[1], int(p>>16&0xff))
+		w.writeSymbol(&codes[2], int(p&0xff))
+		w.writeSymbol(&codes[3], int(p>>24))
+	}
+}
+
+// encodeWebP writes img as a lossless WebP image.
+func encodeWebP(out io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.New("webp: image dimensions out of range")
+	}
+
+	pixels := make([]uint32, width*height)
+	opaque := true
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A != 0xff {
+				opaque = false
+			}
+			// Subtract green.
+			r, bl := c.R-c.G, c.B-c.G
+			pixels[y*width+x] = uint32(c.A)<<24 | uint32(r)<<16 | uint32(c.G)<<8 | uint32(bl)
+		}
+	}
+
+	// Predict each pixel from its neighbours and keep the residuals. The
+	// first row predicts from the left, the first column from above and the
+	// first pixel from opaque black.
+	residuals := make([]uint32, len(pixels))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var pred uint32
+			switch {
+			case x == 0 && y == 0:
+				pred = 0xff000000
+			case y == 0:
+				pred = pixels[x-1]
+			case x == 0:
+				pred = pixels[(y-1)*width]
+			default:
+				pred = clampAddSubtractFull(pixels[y*width+x-1], pixels[(y-1)*width+x], pixels[(y-1)*width+x-1])
+			}
+			residuals[y*width+x] = subPixels(pixels[y*width+x], pred)
+		}
+	}
+
+	var w bitWriter
+	w.writeBits(vp8lSignature, 8)
+	w.writeBits(uint32(width-1), 14)
+	w.writeBits(uint32(height-1), 14)
+	if opaque {
+		w.writeBits(0, 1)
+	} else {
+		w.writeBits(1, 1)
+	}
+	w.writeBits(0, 3)
+
+	// Transforms are undone in reverse: the predictor, then subtract green.
+	w.writeBits(1, 1)
+	w.writeBits(vp8lSubtractGreenTransform, 2)
+	w.writeBits(1, 1)
+	w.writeBits(vp8lPredictorTransform, 2)
+	w.writeBits(vp8lPredictorBits-2, 3)
+	blocks := ((width + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits) * ((height + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits)
+	modes := make([]uint32, blocks)
+	for i := range modes {
+		modes[i] = vp8lGradientPredictor << 8
+	}
+	w.writeEntropyImage(modes)
+	w.writeBits(0, 1)
+
+	// The main image has no meta prefix codes.
+	w.writeBits(0, 1)
+	w.writeEntropyImage(residuals)
+	data := w.bytes()
+
+	header := make([]byte, 20)
+	size := len(data)
+	padded := size + size&1
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+padded))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(size))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if size&1 == 1 {
+		data = append(data, 0)
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256.
+func subPixels(a, b uint32) uint32 {
+	return (a>>24-b>>24)&0xff<<24 | (a>>16-b>>16)&0xff<<16 | (a>>8-b>>8)&0xff<<8 | (a-b)&0xff
+}
+
+func clampAddSubtractFull(left, top, topLeft uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(left>>shift&0xff) + int(top>>shift&0xff) - int(topLeft>>shift&0xff)
+		out |= uint32(min(max(v, 0), 255)) << shift
+	}
+	return out
+}
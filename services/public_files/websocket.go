@@ -15,14 +15,39 @@ type Client struct {
 	Send chan interface{}
 	Subs map[string]bool
 	mu   sync.RWMutex
+
+	closed bool
 }
 
+// trySend queues msg for the client without blocking. It reports false if
+// the client is gone or too far behind.
+func (c *Client) trySend(msg interface{}) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// EventHandler handles a message of a client that the hub does not handle
+// itself.
+type EventHandler func(client *Client, msg dtos.WebSocketMessage)
+
 type WebSocketHub struct {
 	clients    map[*Client]bool
 	broadcast  chan interface{}
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+
+	handlers     map[string]EventHandler
+	onDisconnect []func(*Client)
 }
 
 func NewWebSocketHub() *WebSocketHub {
@@ -31,9 +56,25 @@ func NewWebSocketHub() *WebSocketHub {
 		broadcast:  make(chan interface{}, 100),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		handlers:   make(map[string]EventHandler),
 	}
 }
 
+// Handle routes client messages of eventType to fn. Handlers run on the
+// client's read goroutine.
+func (h *WebSocketHub) Handle(eventType string, fn EventHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[eventType] = fn
+}
+
+// OnDisconnect registers fn to be called when a client goes away.
+func (h *WebSocketHub) OnDisconnect(fn func(*Client)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDisconnect = append(h.onDisconnect, fn)
+}
+
 func (h *WebSocketHub) Run() {
 	for {
 		select {
@@ -45,7 +86,10 @@ func (h *WebSocketHub) Run() {
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
+				client.mu.Lock()
+				client.closed = true
 				close(client.Send)
+				client.mu.Unlock()
 			}
 			h.mu.Unlock()
 		case msg := <-h.broadcast:
@@ -72,7 +116,9 @@ func (h *WebSocketHub) HandleConnection(c *websocket.Conn) {
 	client := &Client{
 		ID:   uuid.New().String(),
 		Conn: c,
-		Send: make(chan interface{}, 10),
+		// Collaborative editing sends an operation per keystroke burst and
+		// drops clients whose queue is full.
+		Send: make(chan interface{}, 64),
 		Subs: make(map[string]bool),
 	}
 	h.register <- client
@@ -93,6 +139,12 @@ func (h *WebSocketHub) HandleConnection(c *websocket.Conn) {
 
 func (h *WebSocketHub) readPump(client *Client) {
 	defer func() {
+		h.mu.RLock()
+		hooks := h.onDisconnect
+		h.mu.RUnlock()
+		for _, fn := range hooks {
+			fn(client)
+		}
 		h.unregister <- client
 		client.Conn.Close()
 	}()
@@ -133,6 +185,13 @@ func (h *WebSocketHub) readPump(client *Client) {
 				Timestamp: time.Now().Unix(),
 			}
 			client.Send <- pongMsg
+		default:
+			h.mu.RLock()
+			handler := h.handlers[msg.EventType]
+			h.mu.RUnlock()
+			if handler != nil {
+				handler(client, msg)
+			}
 		}
 	}
 }